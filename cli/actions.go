@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/pflag"
+	"github.com/wryfi/shemail/imaputils"
+)
+
+// actionOption is one action option as given on the command line, e.g.
+// {"move", "Archive"} or {"mark-read", ""}.
+type actionOption struct {
+	name  string
+	value string
+}
+
+// actionOptions records find's action options in the order they were given,
+// which pflag does not preserve, so that they can be run as a pipeline in that
+// order.
+type actionOptions struct {
+	given []actionOption
+}
+
+func (options *actionOptions) record(name, value string) {
+	options.given = append(options.given, actionOption{name: name, value: value})
+}
+
+// forget drops every recorded occurrence of name, for a later --flag=false.
+func (options *actionOptions) forget(name string) {
+	options.given = slices.DeleteFunc(options.given, func(option actionOption) bool {
+		return option.name == name
+	})
+}
+
+// boolVar defines a boolean action option on flags.
+func (options *actionOptions) boolVar(flags *pflag.FlagSet, name, shorthand, usage string) {
+	flag := flags.VarPF(&boolAction{name: name, options: options}, name, shorthand, usage)
+	flag.NoOptDefVal = "true"
+}
+
+// stringVar defines an action option taking a value, such as a folder. Each
+// occurrence is a separate action.
+func (options *actionOptions) stringVar(flags *pflag.FlagSet, name, shorthand, usage string) {
+	flags.VarP(&stringAction{name: name, options: options}, name, shorthand, usage)
+}
+
+type boolAction struct {
+	name    string
+	set     bool
+	options *actionOptions
+}
+
+func (action *boolAction) Set(text string) error {
+	value, err := strconv.ParseBool(text)
+	if err != nil {
+		return err
+	}
+	action.set = value
+	if value {
+		action.options.record(action.name, "")
+	} else {
+		action.options.forget(action.name)
+	}
+	return nil
+}
+
+func (action *boolAction) String() string   { return strconv.FormatBool(action.set) }
+func (action *boolAction) Type() string     { return "bool" }
+func (action *boolAction) IsBoolFlag() bool { return true }
+
+type stringAction struct {
+	name    string
+	values  []string
+	options *actionOptions
+}
+
+func (action *stringAction) Set(value string) error {
+	action.values = append(action.values, value)
+	action.options.record(action.name, value)
+	return nil
+}
+
+func (action *stringAction) String() string { return strings.Join(action.values, ",") }
+func (action *stringAction) Type() string   { return "string" }
+
+// flagOptions maps find's flag-changing options to the flag each adds or
+// removes; --add-keyword and --remove-keyword take theirs as the value.
+var flagOptions = map[string]struct {
+	add  bool
+	flag string
+}{
+	"mark-read":      {true, imap.SeenFlag},
+	"mark-unread":    {false, imap.SeenFlag},
+	"flag":           {true, imap.FlaggedFlag},
+	"unflag":         {false, imap.FlaggedFlag},
+	"mark-answered":  {true, imap.AnsweredFlag},
+	"add-keyword":    {true, ""},
+	"remove-keyword": {false, ""},
+}
+
+// buildActions turns the action options, in the order given, into a pipeline.
+// Consecutive flag changes are merged into a single step; keywords are
+// validated here so a typo is caught before searching.
+func buildActions(given []actionOption) ([]imaputils.Action, error) {
+	var actions []imaputils.Action
+	for _, option := range given {
+		switch option.name {
+		case "move":
+			actions = append(actions, imaputils.Action{Kind: imaputils.ActionMove, Folder: option.value})
+			continue
+		case "copy":
+			actions = append(actions, imaputils.Action{Kind: imaputils.ActionCopy, Folder: option.value})
+			continue
+		case "delete":
+			actions = append(actions, imaputils.Action{Kind: imaputils.ActionDelete})
+			continue
+		}
+
+		flagOption, ok := flagOptions[option.name]
+		if !ok {
+			return nil, fmt.Errorf("unknown action --%s", option.name)
+		}
+		flag := flagOption.flag
+		if flag == "" {
+			if err := imaputils.ValidateKeyword(option.value); err != nil {
+				return nil, err
+			}
+			flag = option.value
+		}
+
+		if len(actions) == 0 || actions[len(actions)-1].Kind != imaputils.ActionFlags {
+			actions = append(actions, imaputils.Action{Kind: imaputils.ActionFlags})
+		}
+		change := &actions[len(actions)-1].Flags
+		if slices.Contains(change.Add, flag) || slices.Contains(change.Remove, flag) {
+			return nil, fmt.Errorf("%s is changed more than once in the same step", flag)
+		}
+		if flagOption.add {
+			change.Add = append(change.Add, flag)
+		} else {
+			change.Remove = append(change.Remove, flag)
+		}
+	}
+	return actions, nil
+}
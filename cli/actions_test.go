@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/wryfi/shemail/imaputils"
+)
+
+func parseActions(t *testing.T, args ...string) []actionOption {
+	t.Helper()
+	var options actionOptions
+	flags := pflag.NewFlagSet("find", pflag.ContinueOnError)
+	options.stringVar(flags, "move", "m", "")
+	options.stringVar(flags, "copy", "", "")
+	options.boolVar(flags, "delete", "d", "")
+	for name := range flagOptions {
+		if name == "add-keyword" || name == "remove-keyword" {
+			options.stringVar(flags, name, "", "")
+		} else {
+			options.boolVar(flags, name, "", "")
+		}
+	}
+	assert.NoError(t, flags.Parse(args))
+	return options.given
+}
+
+func TestActionOptionsKeepOrder(t *testing.T) {
+	given := parseActions(t, "--mark-read", "-m", "Archive", "--flag", "--copy", "Backup", "--add-keyword", "review", "-d")
+	assert.Equal(t, []actionOption{
+		{"mark-read", ""},
+		{"move", "Archive"},
+		{"flag", ""},
+		{"copy", "Backup"},
+		{"add-keyword", "review"},
+		{"delete", ""},
+	}, given)
+
+	assert.Equal(t, []actionOption{{"move", "Archive"}}, parseActions(t, "--flag", "--move", "Archive", "--flag=false"))
+}
+
+func TestBuildActions(t *testing.T) {
+	actions, err := buildActions(parseActions(t, "--mark-read", "--add-keyword", "review", "--move", "Archive", "--unflag", "--delete"))
+	assert.NoError(t, err)
+	assert.Equal(t, []imaputils.Action{
+		{Kind: imaputils.ActionFlags, Flags: imaputils.FlagChange{Add: []string{imap.SeenFlag, "review"}}},
+		{Kind: imaputils.ActionMove, Folder: "Archive"},
+		{Kind: imaputils.ActionFlags, Flags: imaputils.FlagChange{Remove: []string{imap.FlaggedFlag}}},
+		{Kind: imaputils.ActionDelete},
+	}, actions)
+
+	actions, err = buildActions(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, actions)
+
+	_, err = buildActions(parseActions(t, "--add-keyword", "review", "--remove-keyword", "review"))
+	assert.ErrorContains(t, err, "changed more than once")
+
+	_, err = buildActions(parseActions(t, "--add-keyword", "\\Seen"))
+	assert.Error(t, err)
+}
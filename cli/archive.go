@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/cobra"
+	"github.com/wryfi/shemail/imaputils"
+	"github.com/wryfi/shemail/util"
+)
+
+// ArchiveCommand generates a command to move the messages of a folder that
+// match find's criteria into folders named after their date, sender domain or
+// mailing list.
+func ArchiveCommand() *cobra.Command {
+	var (
+		search    searchFlags
+		olderThan string
+		into      string
+		assumeYes bool
+	)
+	cmd := &cobra.Command{
+		Use:   "archive <folder>",
+		Short: "move matching messages into folders named after their date, sender or list",
+		Long: `Move the messages of a folder that match find's criteria into destination
+folders rendered from the --into template for each message, e.g.
+"Archive/{{.Year}}/{{.Month}}". The template can use:
+
+  {{.Year}}     year the message was received, e.g. 2024
+  {{.Month}}    month, 01 to 12
+  {{.Day}}      day of the month, 01 to 31
+  {{.Quarter}}  quarter, Q1 to Q4
+  {{.Domain}}   domain of the sender's address, lowercased
+  {{.ListId}}   mailing list identifier from the List-Id header
+
+Use "/" to separate folder levels; destinations are created as needed.
+Without --into, messages go to the account's archive folder: its
+archive_folder setting, or the folder the server marks \Archive.
+Messages for which the template renders an empty folder name (e.g. {{.ListId}}
+for a message that did not come from a list) are left where they are.`,
+		Args: validateFolderArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			account := cmd.Context().Value("account").(imaputils.Account)
+			if into == "" {
+				folder, err := imaputils.FindArchiveFolder(dialer, account)
+				if err != nil {
+					return fmt.Errorf("no --into given and %w", err)
+				}
+				into = folder
+			}
+			archive, err := imaputils.ParseArchiveTemplate(into)
+			if err != nil {
+				return err
+			}
+			searchOpts, err := search.options()
+			if err != nil {
+				return err
+			}
+			if olderThan != "" {
+				cutoff, err := util.AgeCutoff(olderThan, time.Now())
+				if err != nil {
+					return err
+				}
+				searchOpts.EndDate = &cutoff
+			}
+
+			stream, err := messageStream(cmd, account, args[0], search.criteria(searchOpts))
+			if err != nil {
+				return err
+			}
+			messages, err := imaputils.CollectMessages(stream)
+			if err != nil {
+				return fmt.Errorf("error searching folder %s: %w", args[0], err)
+			}
+			messages, err = imaputils.FilterBySubject(messages, searchOpts)
+			if err != nil {
+				return fmt.Errorf("error filtering by subject: %w", err)
+			}
+			if len(messages) == 0 {
+				fmt.Printf("no matching messages in %s\n", args[0])
+				return nil
+			}
+			imaputils.SortMessages(messages, imaputils.SortDate, false)
+
+			if assumeYes {
+				rendered, err := util.RenderMessages(messages)
+				if err != nil {
+					return fmt.Errorf("error rendering messages: %w", err)
+				}
+				fmt.Println(rendered)
+			}
+			targets, proceed, err := resolveActionTargets(messages, "archive into "+into, true, assumeYes, 0)
+			if err != nil || !proceed {
+				return err
+			}
+			return archiveMessages(account, args[0], targets, archive)
+		},
+	}
+	search.register(cmd)
+	cmd.Flags().StringVar(&olderThan, "older-than", "", "find messages received more than this long ago (e.g. 180d, 26w, 6m, 2y)")
+	cmd.Flags().StringVar(&into, "into", "", "destination folder template, e.g. 'Archive/{{.Year}}/{{.Month}}' (default: the account's archive folder)")
+	cmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "skip the interactive picker and archive all matches")
+	cmd.MarkFlagsMutuallyExclusive("older-than", "before")
+	return cmd
+}
+
+// archiveMessages moves messages, which are in folder, to the destinations
+// archive renders for them, with one move per destination, and prints how
+// many went where. Each move is recorded in the journal.
+func archiveMessages(account imaputils.Account, folder string, messages []*imap.Message, archive *imaputils.ArchiveTemplate) error {
+	var listIDs map[uint32]string
+	if archive.NeedsListID() {
+		uids := make([]uint32, len(messages))
+		for index, message := range messages {
+			uids[index] = message.Uid
+		}
+		var err error
+		if listIDs, err = imaputils.FetchListIDs(dialer, account, folder, uids); err != nil {
+			return err
+		}
+	}
+
+	groups, skipped := imaputils.GroupForArchive(archive, folder, messages, listIDs)
+	var archived []imaputils.ArchiveGroup
+	for _, group := range groups {
+		actions := []imaputils.Action{{Kind: imaputils.ActionMove, Folder: group.Folder}}
+		if err := imaputils.RunActions(dialer, account, folder, group.Messages, actions, operationJournal()); err != nil {
+			printArchiveSummary(folder, archived)
+			return err
+		}
+		archived = append(archived, group)
+	}
+
+	printArchiveSummary(folder, archived)
+	if len(skipped) > 0 {
+		fmt.Printf("%d messages left in %s: %q gives them no other folder\n", len(skipped), folder, archive.String())
+	}
+	return nil
+}
+
+// printArchiveSummary prints how many messages were archived from folder into
+// each destination. Under --dry-run the report says what would have moved
+// instead.
+func printArchiveSummary(folder string, groups []imaputils.ArchiveGroup) {
+	if isDryRun() || len(groups) == 0 {
+		return
+	}
+	total := 0
+	for _, group := range groups {
+		fmt.Printf("%6d  %s\n", len(group.Messages), group.Folder)
+		total += len(group.Messages)
+	}
+	fmt.Printf("archived %d messages from %s into %d folders\n", total, folder, len(groups))
+}
@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/cobra"
+	"github.com/wryfi/shemail/imaputils"
+	"github.com/wryfi/shemail/util"
+)
+
+// AttachmentsCommand generates a command to save the attachments of the
+// messages of a folder that match find's criteria to a local directory.
+func AttachmentsCommand() *cobra.Command {
+	var (
+		search searchFlags
+		filter imaputils.AttachmentFilter
+		out    string
+		naming string
+	)
+	cmd := &cobra.Command{
+		Use:   "attachments <folder>",
+		Short: "save the attachments of matching messages to a directory",
+		Long: `Save the attachments of the messages of a folder that match find's criteria
+to --out, choosing them by file name (--name) and content type (--type). Only
+the attachments themselves are downloaded, not whole messages, and messages
+are not marked as read.
+
+Files are named with the --naming template, a "/"-separated path under --out
+that can use:
+
+  {{.Date}}      date the message was received, e.g. 2024-06-30
+  {{.Year}}, {{.Month}}, {{.Day}}
+  {{.Sender}}    address of the sender, lowercased
+  {{.Domain}}    domain of the sender's address
+  {{.Filename}}  original file name; {{.Name}} and {{.Ext}} are its parts
+  {{.UID}}, {{.Part}}  UID of the message and number of the MIME part
+
+Content is saved once: an attachment identical to one already in --out, from
+this or an earlier run, is listed as a duplicate of the existing file. A name
+already taken by other content gets a numbered suffix. Where every file came
+from is kept in ` + imaputils.AttachmentManifestFile + ` in --out.`,
+		Args: validateFolderArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			account := cmd.Context().Value("account").(imaputils.Account)
+			folder := args[0]
+			template, err := imaputils.ParseAttachmentTemplate(naming)
+			if err != nil {
+				return err
+			}
+			if err := filter.Validate(); err != nil {
+				return err
+			}
+			searchOpts, err := search.options()
+			if err != nil {
+				return err
+			}
+
+			stream, err := messageStream(cmd, account, folder, search.criteria(searchOpts))
+			if err != nil {
+				return err
+			}
+			messages, err := imaputils.CollectMessages(stream)
+			if err != nil {
+				return fmt.Errorf("error searching folder %s: %w", folder, err)
+			}
+			messages, err = imaputils.FilterBySubject(messages, searchOpts)
+			if err != nil {
+				return fmt.Errorf("error filtering by subject: %w", err)
+			}
+			if len(messages) == 0 {
+				fmt.Printf("no matching messages in %s\n", folder)
+				return nil
+			}
+			return saveAttachments(account, folder, messages, filter, out, template)
+		},
+	}
+	search.register(cmd)
+	cmd.Flags().StringVar(&out, "out", "", "directory to save attachments in (created if missing)")
+	cmd.Flags().StringArrayVar(&filter.Names, "name", nil, "save only attachments whose file name matches this pattern, e.g. '*.pdf' (repeatable)")
+	cmd.Flags().StringArrayVar(&filter.Types, "type", nil, "save only attachments of this content type, e.g. application/pdf or image/* (repeatable)")
+	cmd.Flags().StringVar(&naming, "naming", imaputils.DefaultAttachmentTemplate, "template for the path of each file under --out")
+	cmd.MarkFlagRequired("out")
+	return cmd
+}
+
+// saveAttachments saves the attachments filter accepts of messages, which are
+// in folder, to the attachment store in dir, and prints what was saved. The
+// manifest is written even if extraction fails part way, so that the files
+// already saved are accounted for. Under --dry-run nothing is written.
+func saveAttachments(account imaputils.Account, folder string, messages []*imap.Message, filter imaputils.AttachmentFilter, dir string, template *imaputils.AttachmentTemplate) error {
+	store, err := imaputils.OpenAttachmentStore(dir, isDryRun())
+	if err != nil {
+		return err
+	}
+	uids := make([]uint32, len(messages))
+	for index, message := range messages {
+		uids[index] = message.Uid
+	}
+
+	var saved []imaputils.SavedAttachment
+	fromMessages := map[uint32]bool{}
+	err = imaputils.ExtractAttachments(dialer, account, folder, uids, filter, func(message *imap.Message, attachment imaputils.Attachment, content []byte) error {
+		entry, added, err := store.Save(template, account.Name, folder, message, attachment, content)
+		if err != nil {
+			return err
+		}
+		fromMessages[message.Uid] = true
+		if added {
+			saved = append(saved, entry)
+		}
+		return nil
+	})
+	err = errors.Join(err, store.Close())
+
+	printAttachmentManifest(saved, len(fromMessages), dir)
+	return err
+}
+
+// printAttachmentManifest lists the attachments saved, from messages
+// messages, to dir. Under --dry-run the dry-run report says what would have
+// been saved instead.
+func printAttachmentManifest(saved []imaputils.SavedAttachment, messages int, dir string) {
+	written, duplicates := 0, 0
+	var size int64
+	rows := make([][]string, 0, len(saved))
+	for _, entry := range saved {
+		file := entry.Path
+		if entry.Duplicate {
+			file = "= " + entry.Path
+			duplicates++
+		} else {
+			written++
+			size += entry.Size
+		}
+		rows = append(rows, []string{file, util.FormatSize(entry.Size), entry.Type,
+			fmt.Sprintf("%d", entry.UID), util.TruncateString(entry.Subject, 50)})
+	}
+	if isDryRun() {
+		if written > 0 {
+			dryRunReport.Record("save ", fmt.Sprintf(" attachments to %s", dir), written)
+		}
+		return
+	}
+	if len(rows) == 0 {
+		fmt.Println("no new attachments to save")
+		return
+	}
+	fmt.Println(util.RenderTable([]string{"File", "Size", "Type", "UID", "Subject"}, rows, 1, 3))
+	fmt.Printf("saved %d attachments (%s) from %d messages to %s", written, util.FormatSize(size), messages, dir)
+	if duplicates > 0 {
+		fmt.Printf("; %d duplicates (marked =) share a file with the same content", duplicates)
+	}
+	fmt.Println()
+}
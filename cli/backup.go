@@ -0,0 +1,169 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/wryfi/shemail/imaputils"
+)
+
+// BackupCommand generates a command to mirror every folder of the account
+// into a local tree of Maildirs.
+func BackupCommand() *cobra.Command {
+	var (
+		to          string
+		keepDeleted bool
+	)
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "mirror every folder into local Maildirs, downloading only what is new",
+		Long: `Mirror every folder into a tree of Maildirs under --to, one directory per
+folder. The first run downloads everything; later runs download only messages
+that are new since, update the flags of those already backed up, and remove
+messages deleted on the server (or keep them, with --keep-deleted).
+
+The backup directory holds a manifest.json with each folder's UIDVALIDITY and
+counts and each message's UID, flags and SHA-256, and a SHA256SUMS file.
+Check a backup with "shemail backup verify DIR" or "sha256sum -c SHA256SUMS".`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			account := cmd.Context().Value("account").(imaputils.Account)
+			return backupAccount(account, to, keepDeleted)
+		},
+	}
+	cmd.Flags().StringVar(&to, "to", "", "directory to keep the backup in (created if missing)")
+	cmd.Flags().BoolVar(&keepDeleted, "keep-deleted", false, "keep the backup of messages that were deleted on the server")
+	cmd.MarkFlagRequired("to")
+	cmd.AddCommand(backupVerify())
+	return cmd
+}
+
+// backupAccount brings the backup in dir up to date with every selectable
+// folder of account. The manifest is saved after each folder, so an
+// interrupted backup resumes where it stopped. Under --dry-run nothing is
+// downloaded or written.
+func backupAccount(account imaputils.Account, dir string, keepDeleted bool) error {
+	manifest, err := imaputils.LoadBackupManifest(dir)
+	if err != nil {
+		return err
+	}
+	if manifest.Account != "" && manifest.Account != account.Name {
+		return fmt.Errorf("%s is a backup of account %s, not %s", dir, manifest.Account, account.Name)
+	}
+	manifest.Account = account.Name
+
+	folders, err := imaputils.ListFoldersWithStatus(dialer, account, false, false)
+	if err != nil {
+		return fmt.Errorf("error listing folders: %w", err)
+	}
+
+	options := imaputils.BackupOptions{KeepDeleted: keepDeleted, DryRun: isDryRun()}
+	onServer := map[string]bool{}
+	for _, folder := range folders {
+		if !folder.Selectable {
+			continue
+		}
+		onServer[folder.Name] = true
+
+		result, err := imaputils.BackupFolder(dialer, account, manifest, folder, options)
+		if options.DryRun {
+			if err != nil {
+				return err
+			}
+			recordBackupDryRun(result, dir)
+			continue
+		}
+		// Keep whatever was downloaded before a failure.
+		if saveErr := manifest.Save(); err == nil {
+			err = saveErr
+		}
+		if err != nil {
+			return err
+		}
+		if summary := backupSummary(result); summary != "" {
+			fmt.Printf("%s: %s\n", folder.Name, summary)
+		}
+	}
+
+	for name := range manifest.Folders {
+		if !onServer[name] {
+			fmt.Printf("%s: no longer on the server; its backup is kept\n", name)
+		}
+	}
+	if !options.DryRun {
+		fmt.Printf("backed up %d messages in %d folders to %s\n", manifest.MessageCount(), len(manifest.Folders), dir)
+	}
+	return nil
+}
+
+// recordBackupDryRun notes in the dry-run report what a backup of one folder
+// would have changed in dir.
+func recordBackupDryRun(result imaputils.BackupResult, dir string) {
+	folder := fmt.Sprintf(" of %s in %s", result.Folder, dir)
+	if result.Downloaded > 0 {
+		dryRunReport.Record("back up ", fmt.Sprintf(" messages from %s to %s", result.Folder, dir), result.Downloaded)
+	}
+	if result.FlagChanges > 0 {
+		dryRunReport.Record("update the flags of ", " backed-up messages"+folder, result.FlagChanges)
+	}
+	if result.Removed > 0 {
+		dryRunReport.Record("remove ", " messages deleted on the server from the backup"+folder, result.Removed)
+	}
+	if result.Retained > 0 {
+		dryRunReport.Record("keep ", " messages deleted on the server in the backup"+folder, result.Retained)
+	}
+}
+
+// backupSummary describes what a backup changed in one folder, or returns ""
+// if nothing changed.
+func backupSummary(result imaputils.BackupResult) string {
+	var parts []string
+	if result.Reset {
+		parts = append(parts, "UIDVALIDITY changed")
+	}
+	if result.Downloaded > 0 {
+		parts = append(parts, fmt.Sprintf("%d new", result.Downloaded))
+	}
+	if result.FlagChanges > 0 {
+		parts = append(parts, fmt.Sprintf("%d flag changes", result.FlagChanges))
+	}
+	if result.Removed > 0 {
+		parts = append(parts, fmt.Sprintf("%d deleted on the server and removed", result.Removed))
+	}
+	if result.Retained > 0 {
+		parts = append(parts, fmt.Sprintf("%d deleted on the server and kept", result.Retained))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func backupVerify() *cobra.Command {
+	return &cobra.Command{
+		Use:         "verify <dir>",
+		Short:       "check the files of a backup against its manifest",
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{noAuthAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manifest, err := imaputils.LoadBackupManifest(args[0])
+			if err != nil {
+				return err
+			}
+			if len(manifest.Folders) == 0 {
+				return fmt.Errorf("no backup found in %s", args[0])
+			}
+			problems, err := manifest.Verify()
+			for _, problem := range problems {
+				fmt.Println(problem)
+			}
+			if err != nil {
+				return err
+			}
+			if len(problems) > 0 {
+				return fmt.Errorf("found %d problems in the backup in %s", len(problems), args[0])
+			}
+
+			fmt.Printf("verified %d messages in %d folders\n", manifest.MessageCount(), len(manifest.Folders))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/cobra"
+	"github.com/wryfi/shemail/config"
+	"github.com/wryfi/shemail/imaputils"
+	"github.com/wryfi/shemail/util"
+)
+
+// envelopeCache returns the on-disk envelope cache under config.CacheDir().
+func envelopeCache() *imaputils.EnvelopeCache {
+	return imaputils.NewEnvelopeCache(config.CacheDir())
+}
+
+// messageStream streams the messages of folder matching criteria, answered
+// from the envelope cache unless --no-cache was given.
+func messageStream(cmd *cobra.Command, account imaputils.Account, folder string, criteria *imap.SearchCriteria) (imaputils.MessageStream, error) {
+	noCache, err := cmd.Flags().GetBool("no-cache")
+	if err != nil {
+		return nil, fmt.Errorf("could not get no-cache flag: %v", err)
+	}
+	if noCache {
+		return imaputils.StreamMessages(dialer, account, folder, criteria, imaputils.DefaultChunkSize), nil
+	}
+	return imaputils.StreamMessagesCached(dialer, account, folder, criteria, imaputils.DefaultChunkSize, envelopeCache()), nil
+}
+
+// CacheCommand generates a command to inspect and clear the local envelope
+// cache. None of its subcommands talk to the server.
+func CacheCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:         "cache",
+		Short:       "manage the local message envelope cache",
+		Annotations: map[string]string{noAuthAnnotation: "true"},
+	}
+	cmd.AddCommand(cacheClear(), cacheStats())
+	return cmd
+}
+
+func cacheClear() *cobra.Command {
+	var all bool
+	cmd := &cobra.Command{
+		Use:         "clear [folder...]",
+		Short:       "delete the cache of the given folders, or of the whole account",
+		Annotations: map[string]string{noAuthAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cache := envelopeCache()
+			if all {
+				if len(args) > 0 {
+					return fmt.Errorf("--all does not take folder arguments")
+				}
+				return cache.ClearAll()
+			}
+
+			account, err := requestedAccount(cmd)
+			if err != nil {
+				return err
+			}
+			return cache.Clear(account.Name, args...)
+		},
+	}
+	cmd.Flags().BoolVar(&all, "all", false, "clear the cache of every account")
+	return cmd
+}
+
+func cacheStats() *cobra.Command {
+	return &cobra.Command{
+		Use:         "stats",
+		Short:       "show what is cached for each account and folder",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{noAuthAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			stats, err := envelopeCache().Stats()
+			if err != nil {
+				return err
+			}
+			if len(stats) == 0 {
+				fmt.Printf("cache at %s is empty\n", config.CacheDir())
+				return nil
+			}
+			fmt.Println(util.RenderCacheStats(stats))
+			return nil
+		},
+	}
+}
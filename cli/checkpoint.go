@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/emersion/go-imap"
+	"github.com/wryfi/shemail/config"
+	"github.com/wryfi/shemail/imaputils"
+)
+
+// checkpointFile is the name of the --since-last-run state file within
+// config.StateDir().
+const checkpointFile = "checkpoints.json"
+
+// sinceLastRun carries a --since-last-run search from before the search (when
+// the folder's position is captured) to after the action (when the checkpoint
+// is advanced).
+type sinceLastRun struct {
+	store    *imaputils.CheckpointStore
+	key      string
+	previous imaputils.Checkpoint
+	current  imaputils.Checkpoint
+	// incremental is false on the first run and after a UIDVALIDITY change,
+	// when the whole folder is scanned.
+	incremental bool
+}
+
+// startSinceLastRun loads the stored checkpoint for folder and captures the
+// folder's current position. The current position is taken before searching,
+// so anything that arrives mid-run is left for the next run rather than
+// skipped.
+func startSinceLastRun(account imaputils.Account, folder, stateKey string) (*sinceLastRun, error) {
+	store, err := imaputils.LoadCheckpoints(filepath.Join(config.StateDir(), checkpointFile))
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := imaputils.FolderCheckpoint(dialer, account, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &sinceLastRun{store: store, key: imaputils.CheckpointKey(account, folder, stateKey), current: current}
+	previous, ok := store.Get(run.key)
+	switch {
+	case !ok:
+		log.Info().Msgf("no checkpoint for %s; scanning the whole folder", run.key)
+	case previous.UidValidity != current.UidValidity:
+		log.Warn().Msgf("UIDVALIDITY of %s changed (%d -> %d); stored checkpoint is void, scanning the whole folder",
+			folder, previous.UidValidity, current.UidValidity)
+	default:
+		run.previous = previous
+		run.incremental = true
+	}
+	return run, nil
+}
+
+// restrict limits criteria to messages that arrived after the checkpoint and
+// by the position captured at the start of the run. On a full scan only the
+// upper bound applies.
+func (run *sinceLastRun) restrict(criteria *imap.SearchCriteria) (*imap.SearchCriteria, error) {
+	return imaputils.SinceCheckpoint(criteria, run.since(), run.current)
+}
+
+// since is the checkpoint the run starts from: the stored one, or the start
+// of the folder on a full scan.
+func (run *sinceLastRun) since() imaputils.Checkpoint {
+	if !run.incremental {
+		return imaputils.Checkpoint{UidValidity: run.current.UidValidity}
+	}
+	return run.previous
+}
+
+// filter drops messages outside the run's UID range, which the server may
+// return when the range is a UID it has not assigned yet.
+func (run *sinceLastRun) filter(messages []*imap.Message) []*imap.Message {
+	return imaputils.FilterUIDRange(messages, run.since().LastUID, run.current.LastUID)
+}
+
+// admits is the single-message form of filter, for streaming consumers.
+func (run *sinceLastRun) admits(message *imap.Message) bool {
+	return message.Uid > run.since().LastUID && message.Uid <= run.current.LastUID
+}
+
+// commit advances the checkpoint to the position captured before the search.
+// Call it only once the run's action has succeeded, so a failed or cancelled
+// run is retried in full next time.
+func (run *sinceLastRun) commit() error {
+	run.store.Set(run.key, run.current)
+	if err := run.store.Save(); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// commitCheckpoint commits run if a --since-last-run search is in progress.
+// A --dry-run leaves the checkpoint where it was.
+func commitCheckpoint(run *sinceLastRun) error {
+	if run == nil || isDryRun() {
+		return nil
+	}
+	return run.commit()
+}
@@ -9,15 +9,18 @@ import (
 
 // Account represents an email account configuration
 type Account struct {
-	Name            string      `yaml:"name"`
-	User            string      `yaml:"user"`
-	Password        SecretValue `yaml:"password"`
-	PasswordCommand string      `yaml:"password_command,omitempty"`
-	Server          string      `yaml:"server"`
-	Port            int         `yaml:"port"`
-	TLS             bool        `yaml:"tls"`
-	Default         bool        `yaml:"default"`
-	Purge           bool        `yaml:"purge"`
+	Name               string      `yaml:"name"`
+	User               string      `yaml:"user"`
+	Password           SecretValue `yaml:"password"`
+	PasswordCommand    string      `yaml:"password_command,omitempty"`
+	Server             string      `yaml:"server"`
+	Port               int         `yaml:"port"`
+	TLS                bool        `yaml:"tls"`
+	Default            bool        `yaml:"default"`
+	Purge              bool        `yaml:"purge"`
+	AllowUnsafeExpunge bool        `yaml:"allow_unsafe_expunge,omitempty"`
+	TrashFolder        string      `yaml:"trash_folder,omitempty"`
+	ArchiveFolder      string      `yaml:"archive_folder,omitempty"`
 }
 
 // Config represents the root configuration structure
@@ -27,7 +30,11 @@ type Config struct {
 		Level  string `yaml:"level"`
 		Pretty bool   `yaml:"pretty"`
 	} `yaml:"log"`
-	Timezone string `yaml:"timezone"`
+	Timezone  string            `yaml:"timezone"`
+	StateDir  string            `yaml:"state_dir,omitempty"`
+	CacheDir  string            `yaml:"cache_dir,omitempty"`
+	RulesFile string            `yaml:"rules_file,omitempty"`
+	Retention []retentionPolicy `yaml:"retention,omitempty"`
 }
 
 // SecretValue is a custom type that obfuscates its value when marshaled to YAML
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/wryfi/shemail/imaputils"
+)
+
+// dialer connects every command to the server: imaputils.SheDialer, or under
+// --dry-run a DryRunDialer around it that never sends a mutating command.
+var dialer imaputils.IMAPDialer = imaputils.SheDialer
+
+// dryRunReport collects what a --dry-run would have done. It is nil unless
+// --dry-run was given.
+var dryRunReport *imaputils.DryRunReport
+
+// configureDryRun switches dialer to a read-only DryRunDialer when --dry-run
+// was given.
+func configureDryRun(cmd *cobra.Command) error {
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return fmt.Errorf("could not get dry-run flag: %v", err)
+	}
+	if dryRun {
+		dryRunReport = imaputils.NewDryRunReport()
+		dialer = imaputils.NewDryRunDialer(imaputils.SheDialer, dryRunReport)
+	}
+	return nil
+}
+
+// isDryRun reports whether this run is a --dry-run, so that local state
+// (checkpoints, the journal) is left alone too.
+func isDryRun() bool {
+	return dryRunReport != nil
+}
+
+// printDryRunReport prints what a --dry-run would have changed.
+func printDryRunReport(cmd *cobra.Command) {
+	if !isDryRun() {
+		return
+	}
+	lines := dryRunReport.Lines()
+	if len(lines) == 0 {
+		fmt.Fprintln(cmd.OutOrStdout(), "dry run: nothing would have been changed")
+		return
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "dry run: nothing was changed; without --dry-run shemail would")
+	for _, line := range lines {
+		fmt.Fprintf(cmd.OutOrStdout(), "  %s\n", line)
+	}
+}
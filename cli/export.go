@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/cobra"
+	"github.com/wryfi/shemail/imaputils"
+	"github.com/wryfi/shemail/mailstore"
+)
+
+// ExportCommand generates a command to save the messages of a folder that
+// match find's criteria to a local mbox file, Maildir or directory of .eml
+// files.
+func ExportCommand() *cobra.Command {
+	var (
+		search searchFlags
+		format string
+		out    string
+	)
+	cmd := &cobra.Command{
+		Use:   "export <folder>",
+		Short: "save matching messages to an mbox file, a Maildir or .eml files",
+		Args:  validateFolderArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			account := cmd.Context().Value("account").(imaputils.Account)
+			exportFormat, err := mailstore.ParseFormat(format)
+			if err != nil {
+				return err
+			}
+			searchOpts, err := search.options()
+			if err != nil {
+				return err
+			}
+
+			stream, err := messageStream(cmd, account, args[0], search.criteria(searchOpts))
+			if err != nil {
+				return err
+			}
+			messages, err := imaputils.CollectMessages(stream)
+			if err != nil {
+				return fmt.Errorf("error searching folder %s: %w", args[0], err)
+			}
+			messages, err = imaputils.FilterBySubject(messages, searchOpts)
+			if err != nil {
+				return fmt.Errorf("error filtering by subject: %w", err)
+			}
+			if len(messages) == 0 {
+				fmt.Printf("no matching messages in %s\n", args[0])
+				return nil
+			}
+
+			return exportMessages(account, args[0], messages, exportFormat, out)
+		},
+	}
+	search.register(cmd)
+	cmd.Flags().StringVar(&format, "format", string(mailstore.FormatMbox), "export format: mbox, maildir or eml")
+	cmd.Flags().StringVar(&out, "out", "", "mbox file, or Maildir or .eml directory, to export to (created if missing; added to if present)")
+	cmd.MarkFlagRequired("out")
+	return cmd
+}
+
+// exportMessages downloads the complete messages, which are in folder, and
+// writes them to path in format. It fails unless every message was exported,
+// so that nothing is deleted after an incomplete backup. A --dry-run only
+// reports what it would have written.
+func exportMessages(account imaputils.Account, folder string, messages []*imap.Message, format mailstore.Format, path string) error {
+	if isDryRun() {
+		dryRunReport.Record("export ", fmt.Sprintf(" messages from %s to %s", folder, path), len(messages))
+		return nil
+	}
+
+	writer, err := mailstore.NewWriter(format, path)
+	if err != nil {
+		return err
+	}
+	uids := make([]uint32, len(messages))
+	for index, message := range messages {
+		uids[index] = message.Uid
+	}
+	count, err := imaputils.ExportMessages(dialer, account, folder, uids, writer)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to export messages to %s: %w", path, err)
+	}
+	if count < len(messages) {
+		return fmt.Errorf("exported only %d of %d messages to %s; the others are no longer in %s", count, len(messages), path, folder)
+	}
+	fmt.Printf("exported %d messages from %s to %s\n", count, folder, path)
+	return nil
+}
@@ -0,0 +1,206 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/wryfi/shemail/config"
+	"github.com/wryfi/shemail/imaputils"
+	"github.com/wryfi/shemail/util"
+)
+
+// RenameFolder generates a command to rename an imap folder.
+func RenameFolder() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "mv <folder> <new-path>",
+		Short: "rename an imap folder, creating the parents of its new path",
+		Long: `Rename a folder, with its subfolders, to a new "/"-separated path, creating
+the parents of the new path that do not exist yet, as mkdir does. Subscriptions
+to the renamed folders follow them.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return fmt.Errorf("you must provide the folder to rename and its new path as positional arguments")
+			}
+			return nil
+		},
+		ValidArgsFunction: completeFolders(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			account := cmd.Context().Value("account").(imaputils.Account)
+			if err := imaputils.RenameFolder(dialer, account, args[0], args[1]); err != nil {
+				return err
+			}
+			if !isDryRun() {
+				fmt.Printf("renamed %s to %s\n", args[0], args[1])
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
+// RemoveFolder generates a command to delete an imap folder.
+func RemoveFolder() *cobra.Command {
+	var (
+		recursive bool
+		force     bool
+		assumeYes bool
+	)
+	cmd := &cobra.Command{
+		Use:   "rmdir <folder>",
+		Short: "delete an imap folder",
+		Long: `Delete a folder. Folders that hold messages are refused unless --force is
+given, in which case their messages are permanently deleted with them, after
+confirmation. Folders with subfolders are refused unless --recursive is given.
+INBOX, the trash folder and folders the server marks for a special use (sent,
+drafts, junk and the like) are never deleted.`,
+		Args:              validateFolderArg,
+		ValidArgsFunction: completeFolders(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			account := cmd.Context().Value("account").(imaputils.Account)
+			folders, err := imaputils.PlanDeleteFolder(dialer, account, args[0], recursive)
+			if err != nil {
+				return err
+			}
+			messages := 0
+			for _, folder := range folders {
+				messages += int(folder.Messages)
+			}
+			if messages > 0 {
+				if !force {
+					return fmt.Errorf("%s holds %d messages; use --force to permanently delete them along with it", args[0], messages)
+				}
+				rows := make([][]string, len(folders))
+				for index, folder := range folders {
+					rows[index] = []string{folder.Name, fmt.Sprintf("%d", folder.Messages)}
+				}
+				fmt.Println(util.RenderTable([]string{"Folder", "Messages"}, rows, 1))
+				prompt := fmt.Sprintf("really delete %d folders and permanently delete the %d messages in them?", len(folders), messages)
+				if !assumeYes && !util.GetConfirmation(prompt) {
+					fmt.Println("operation cancelled")
+					return nil
+				}
+			}
+
+			deleted, err := imaputils.DeleteFolders(dialer, account, folders)
+			for _, folder := range folders[:deleted] {
+				if folder.Messages > 0 {
+					operationJournal().Record(imaputils.JournalEntry{
+						Account:   account.Name,
+						Operation: imaputils.JournalPurge,
+						Source:    folder.Name,
+						Count:     int(folder.Messages),
+					})
+				}
+				if !isDryRun() {
+					fmt.Printf("deleted folder %s\n", folder.Name)
+				}
+			}
+			return err
+		},
+	}
+	cmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "also delete the folder's subfolders")
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "delete folders that hold messages, permanently deleting the messages")
+	cmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "with --force, skip the confirmation prompt")
+	return cmd
+}
+
+// SubscribeFolder generates a command to subscribe to an imap folder.
+func SubscribeFolder() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "subscribe <folder>",
+		Short:             "subscribe to an imap folder, so that mail clients show it",
+		Args:              validateFolderArg,
+		ValidArgsFunction: completeFolders(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			account := cmd.Context().Value("account").(imaputils.Account)
+			subscribed, err := imaputils.SubscribeFolder(dialer, account, args[0])
+			if err != nil {
+				return err
+			}
+			switch {
+			case !subscribed:
+				fmt.Printf("already subscribed to %s\n", args[0])
+			case !isDryRun():
+				fmt.Printf("subscribed to %s\n", args[0])
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
+// UnsubscribeFolder generates a command to unsubscribe from an imap folder.
+func UnsubscribeFolder() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "unsubscribe <folder>",
+		Short:             "unsubscribe from an imap folder, hiding it from mail clients",
+		Args:              validateFolderArg,
+		ValidArgsFunction: completeSubscribedFolders,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			account := cmd.Context().Value("account").(imaputils.Account)
+			unsubscribed, err := imaputils.UnsubscribeFolder(dialer, account, args[0])
+			if err != nil {
+				return err
+			}
+			switch {
+			case !unsubscribed:
+				fmt.Printf("not subscribed to %s\n", args[0])
+			case !isDryRun():
+				fmt.Printf("unsubscribed from %s\n", args[0])
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
+// completeFolders returns a completion function offering the folders of the
+// account for the first count positional arguments, or for all of them if
+// count is 0.
+func completeFolders(count int) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if count > 0 && len(args) >= count {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return folderCompletions(cmd, toComplete, imaputils.ListFolders)
+	}
+}
+
+// completeSubscribedFolders offers the folders the account is subscribed to.
+func completeSubscribedFolders(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return folderCompletions(cmd, toComplete, imaputils.ListSubscribedFolders)
+}
+
+// folderCompletions returns the folders list finds that start with
+// toComplete. Completion skips the root command's PersistentPreRunE, and
+// reads the configuration before --config is parsed, so both are done here;
+// on any error, nothing is offered.
+func folderCompletions(cmd *cobra.Command, toComplete string, list func(imaputils.IMAPDialer, imaputils.Account) ([]string, error)) ([]string, cobra.ShellCompDirective) {
+	if config.CfgFile != "" && viper.ConfigFileUsed() != config.CfgFile {
+		config.InitConfig()
+	}
+	accountRequest, err := cmd.Flags().GetString("account")
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	account, err := loadAccount(cmd, accountRequest)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	folders, err := list(dialer, account)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	var matches []string
+	for _, folder := range folders {
+		if strings.HasPrefix(folder, toComplete) {
+			matches = append(matches, folder)
+		}
+	}
+	return matches, cobra.ShellCompDirectiveNoFileComp
+}
@@ -3,10 +3,13 @@ package cli
 import (
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/emersion/go-imap"
 	"github.com/spf13/cobra"
 	"github.com/wryfi/shemail/imaputils"
+	"github.com/wryfi/shemail/mailstore"
 	"github.com/wryfi/shemail/util"
 	"golang.org/x/term"
 )
@@ -14,8 +17,12 @@ import (
 // ListFolders generates a command to print a list of imap folders on terminal
 func ListFolders() *cobra.Command {
 	var (
-		long  bool
-		dates bool
+		long       bool
+		dates      bool
+		sizes      bool
+		rollup     bool
+		sortBy     string
+		subscribed bool
 	)
 	cmd := &cobra.Command{
 		Use:     "ls",
@@ -24,58 +31,82 @@ func ListFolders() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			account := cmd.Context().Value("account").(imaputils.Account)
 
-			if long || dates {
-				folders, err := imaputils.ListFoldersWithStatus(imaputils.SheDialer, account, dates)
+			var sortField imaputils.FolderSortField
+			if sortBy != "" {
+				field, err := imaputils.ParseFolderSortField(sortBy)
+				if err != nil {
+					return err
+				}
+				sortField = field
+				sizes = sizes || field == imaputils.FolderSortSize
+			}
+
+			if long || dates || sizes || rollup || sortField != "" {
+				folders, err := imaputils.ListFoldersWithStatus(dialer, account, dates, sizes)
 				if err != nil {
 					return fmt.Errorf("Error listing folders: %w", err)
 				}
-				fmt.Println(util.RenderFolders(folders, dates))
+				if rollup {
+					folders = imaputils.RollUpFolders(folders)
+				}
+				if sortField != "" {
+					imaputils.SortFolders(folders, sortField)
+				}
+				fmt.Println(util.RenderFolders(folders, dates, sizes))
+				printQuotaSummary(account)
 				return nil
 			}
 
-			folders, err := imaputils.ListFolders(imaputils.SheDialer, account)
+			list := imaputils.ListFolders
+			if subscribed {
+				list = imaputils.ListSubscribedFolders
+			}
+			folders, err := list(dialer, account)
 			if err != nil {
 				return fmt.Errorf("Error listing folders: %w", err)
 			}
 
+			// Marks would get in the way of scripts reading the names.
+			var uses map[string]imaputils.SpecialUse
+			if term.IsTerminal(int(os.Stdout.Fd())) {
+				found, err := imaputils.SpecialUseFolders(dialer, account)
+				if err != nil {
+					log.Warn().Msgf("%v", err)
+				}
+				uses = imaputils.FoldersByUse(found)
+			}
 			for _, folder := range folders {
-				fmt.Println(folder)
+				fmt.Println(util.FolderLabel(folder, uses[folder]))
 			}
 			return nil
 		},
 	}
-	cmd.Flags().BoolVarP(&long, "long", "l", false, "show message and unread counts per folder")
+	cmd.Flags().BoolVarP(&long, "long", "l", false, "show message and unread counts per folder, and the quota if the server reports it")
 	cmd.Flags().BoolVar(&dates, "dates", false, "also show each folder's message date range (slower; implies -l)")
+	cmd.Flags().BoolVar(&sizes, "sizes", false, "also show each folder's total size (slower without STATUS=SIZE; implies -l)")
+	cmd.Flags().BoolVar(&rollup, "rollup", false, "include the counts, size and dates of every subfolder in its parent's (implies -l)")
+	cmd.Flags().StringVar(&sortBy, "sort", "", "sort folders by name, messages, unread or size (size implies --sizes; implies -l)")
+	cmd.Flags().BoolVar(&subscribed, "subscribed", false, "list only the folders you are subscribed to")
+	for _, flag := range []string{"long", "dates", "sizes", "rollup", "sort"} {
+		cmd.MarkFlagsMutuallyExclusive("subscribed", flag)
+	}
 	return cmd
 }
 
 // SearchFolder generates a command to search a folder for messages based on various criteria
 func SearchFolder() *cobra.Command {
 	var (
-		endDate      string
-		from         string
-		or           bool
-		startDate    string
-		subject      []string
-		to           string
-		notFrom      string
-		notSubject   []string
-		notTo        string
-		unread       bool
-		read         bool
-		moveTo       string
-		deleteFrom   bool
+		search       searchFlags
 		purge        bool
-		largerThan   string
-		smallerThan  string
-		subjectRegex bool
-		markRead     bool
-		markUnread   bool
+		exportPath   string
+		exportFormat string
 		sortBy       string
 		reverse      bool
-		copyTo       string
 		countOnly    bool
 		assumeYes    bool
+		sinceLast    bool
+		stateKey     string
+		actionOpts   actionOptions
 	)
 	cmd := &cobra.Command{
 		Use:     "find <folder>",
@@ -84,28 +115,69 @@ func SearchFolder() *cobra.Command {
 		Args:    validateFolderArg,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			account := cmd.Context().Value("account").(imaputils.Account)
-			searchOpts, err := buildSearchOptions(to, from, subject, notTo, notFrom, notSubject, startDate, endDate, largerThan, smallerThan, read, unread)
+			searchOpts, err := search.options()
 			if err != nil {
-				return fmt.Errorf("error building search options: %v", err)
+				return err
 			}
-			searchOpts.SubjectRegex = subjectRegex
 
 			sortField, err := imaputils.ParseSortField(sortBy)
 			if err != nil {
 				return err
 			}
 
-			var criteria *imap.SearchCriteria
-			if or {
-				criteria = imaputils.BuildORSearchCriteria(searchOpts)
-			} else {
-				criteria = imaputils.BuildSearchCriteria(searchOpts)
+			// The actions run in the order they were given, each on the
+			// messages the previous one left behind; reject impossible
+			// orderings before searching.
+			actions, err := buildActions(actionOpts.given)
+			if err != nil {
+				return err
+			}
+			if err := imaputils.ValidateActions(args[0], actions); err != nil {
+				return err
+			}
+			var format mailstore.Format
+			if exportPath != "" {
+				if format, err = mailstore.ParseFormat(exportFormat); err != nil {
+					return err
+				}
+			}
+
+			criteria := search.criteria(searchOpts)
+
+			var checkpoint *sinceLastRun
+			if sinceLast {
+				checkpoint, err = startSinceLastRun(account, args[0], stateKey)
+				if err != nil {
+					return fmt.Errorf("error reading checkpoint for %s: %w", args[0], err)
+				}
+				if criteria, err = checkpoint.restrict(criteria); err != nil {
+					return err
+				}
+			}
+
+			stream, err := messageStream(cmd, account, args[0], criteria)
+			if err != nil {
+				return err
+			}
+
+			// Counting consumes the matches as they stream in instead of
+			// holding the whole result set, which matters on huge folders.
+			if countOnly {
+				count, err := countMatches(stream, args[0], searchOpts, checkpoint)
+				if err != nil {
+					return err
+				}
+				fmt.Println(count)
+				return commitCheckpoint(checkpoint)
 			}
 
-			messages, err := imaputils.SearchMessages(imaputils.SheDialer, account, args[0], criteria)
+			messages, err := imaputils.CollectMessages(stream)
 			if err != nil {
 				return fmt.Errorf("error searching folder %s: %w", args[0], err)
 			}
+			if checkpoint != nil {
+				messages = checkpoint.filter(messages)
+			}
 
 			// Subject matching is performed client-side against the decoded
 			// subject: server-side SEARCH SUBJECT is backed by a full-text index
@@ -117,30 +189,14 @@ func SearchFolder() *cobra.Command {
 
 			imaputils.SortMessages(messages, sortField, reverse)
 
-			if countOnly {
-				fmt.Println(len(messages))
-				return nil
-			}
-
-			// Determine which action was requested (the flags are mutually
-			// exclusive) and label it for the picker. --purge upgrades delete to
-			// a permanent expunge for this run.
+			// Label the requested actions for the picker. --purge upgrades
+			// delete to a permanent expunge for this run.
 			account.Purge = account.Purge || purge
-			actionLabel := ""
-			switch {
-			case moveTo != "":
-				actionLabel = "move to " + moveTo
-			case copyTo != "":
-				actionLabel = "copy to " + copyTo
-			case markRead:
-				actionLabel = "mark as read"
-			case markUnread:
-				actionLabel = "mark as unread"
-			case deleteFrom:
-				actionLabel = "delete"
-				if account.Purge {
-					actionLabel = "permanently delete"
-				}
+			actionLabel := imaputils.DescribeActions(actions, account.Purge)
+			// --export runs first, so that nothing is changed unless the
+			// messages were saved.
+			if exportPath != "" {
+				actionLabel = strings.TrimSuffix("export to "+exportPath+", then "+actionLabel, ", then ")
 			}
 
 			// A bare listing, or any action with --yes, prints the static table.
@@ -154,81 +210,124 @@ func SearchFolder() *cobra.Command {
 				fmt.Println(rendered)
 			}
 			if actionLabel == "" {
-				return nil
+				return commitCheckpoint(checkpoint)
 			}
 
 			// Copy/move/delete relocate or remove messages, so the picker shows
-			// a final confirm; mark read/unread is trivially reversible.
-			confirmRequired := moveTo != "" || copyTo != "" || deleteFrom
-			targets, proceed, err := resolveActionTargets(messages, actionLabel, confirmRequired, assumeYes)
+			// a final confirm; flag changes are trivially reversible.
+			confirmRequired := slices.ContainsFunc(actions, func(action imaputils.Action) bool {
+				return action.Kind != imaputils.ActionFlags
+			})
+			// A purge without UIDPLUS expunges other messages already flagged
+			// \Deleted too; count them so the picker reports the true number.
+			var plan imaputils.ExpungePlan
+			if account.Purge && len(actions) > 0 && actions[len(actions)-1].Kind == imaputils.ActionDelete {
+				// Messages moved first are not in the end folder yet.
+				purgeFolder := imaputils.EndFolder(args[0], actions)
+				inFolder := messages
+				if purgeFolder != args[0] {
+					inFolder = nil
+				}
+				plan, err = planPurge(account, purgeFolder, inFolder, assumeYes)
+				if err != nil {
+					return err
+				}
+			}
+			targets, proceed, err := resolveActionTargets(messages, actionLabel, confirmRequired, assumeYes, len(plan.Others))
 			if err != nil {
 				return err
 			}
 			if !proceed {
 				return nil
 			}
+			// Confirming in the picker allows the collateral expunge.
+			account.AllowUnsafeExpunge = account.AllowUnsafeExpunge || len(plan.Others) > 0
 
-			switch {
-			case moveTo != "":
-				if err := imaputils.MoveMessages(imaputils.SheDialer, account, targets, args[0], moveTo, 100); err != nil {
-					return fmt.Errorf("failed to move messages to %s: %w", moveTo, err)
-				}
-			case copyTo != "":
-				if err := imaputils.CopyMessages(imaputils.SheDialer, account, targets, args[0], copyTo); err != nil {
-					return fmt.Errorf("failed to copy messages to %s: %w", copyTo, err)
-				}
-			case markRead || markUnread:
-				if err := imaputils.MarkMessages(imaputils.SheDialer, account, targets, args[0], markRead); err != nil {
-					state := "read"
-					if markUnread {
-						state = "unread"
-					}
-					return fmt.Errorf("failed to mark messages as %s: %w", state, err)
-				}
-			case deleteFrom:
-				if err := imaputils.DeleteMessages(imaputils.SheDialer, account, targets, args[0]); err != nil {
-					return fmt.Errorf("failed to delete messages from %s: %w", args[0], err)
+			if exportPath != "" {
+				if err := exportMessages(account, args[0], targets, format, exportPath); err != nil {
+					return err
 				}
 			}
 
-			return nil
+			if err := imaputils.RunActions(dialer, account, args[0], targets, actions, operationJournal()); err != nil {
+				return err
+			}
+
+			return commitCheckpoint(checkpoint)
 		},
 	}
-	cmd.Flags().StringVarP(&to, "to", "t", "", "find messages to this address")
-	cmd.Flags().StringVarP(&from, "from", "f", "", "find messages from this address")
-	cmd.Flags().StringArrayVarP(&subject, "subject", "s", nil, "match subject (repeatable; matches if any matches)")
-	cmd.Flags().StringVar(&notTo, "not-to", "", "exclude messages to this address")
-	cmd.Flags().StringVar(&notFrom, "not-from", "", "exclude messages from this address")
-	cmd.Flags().StringArrayVar(&notSubject, "not-subject", nil, "exclude messages whose subject matches (repeatable; excludes if any matches)")
-	cmd.Flags().BoolVar(&subjectRegex, "subject-regex", false, "treat --subject and --not-subject as regular expressions")
-	cmd.Flags().StringVarP(&startDate, "after", "a", "", "find messages received after date (format: `2006-01-02`)")
-	cmd.Flags().StringVarP(&endDate, "before", "b", "", "find messages received before date (format: `2006-01-02`)")
-	cmd.Flags().StringVar(&largerThan, "larger-than", "", "find messages larger than this size (e.g. 500K, 10M)")
-	cmd.Flags().StringVar(&smallerThan, "smaller-than", "", "find messages smaller than this size (e.g. 500K, 10M)")
-	cmd.Flags().BoolVarP(&unread, "unread", "u", false, "find only unread messages")
-	cmd.Flags().BoolVarP(&read, "read", "r", false, "find only read messages")
-	cmd.Flags().BoolVarP(&or, "or", "o", false, "OR search criteria instead of AND")
-	cmd.Flags().StringVarP(&moveTo, "move", "m", "", "move messages to <folder>")
-	cmd.Flags().StringVar(&copyTo, "copy", "", "copy messages to <folder>")
-	cmd.Flags().BoolVarP(&deleteFrom, "delete", "d", false, "delete messages")
+	search.register(cmd)
+	// Actions run in the order given on the command line.
+	actionOpts.stringVar(cmd.Flags(), "move", "m", "move messages to <folder>")
+	actionOpts.stringVar(cmd.Flags(), "copy", "", "copy messages to <folder>")
+	actionOpts.boolVar(cmd.Flags(), "delete", "d", "delete messages (must be the last action)")
 	cmd.Flags().BoolVarP(&purge, "purge", "p", false, "with --delete, permanently expunge messages instead of moving them to trash")
-	cmd.Flags().BoolVar(&markRead, "mark-read", false, "mark messages as read (\\Seen)")
-	cmd.Flags().BoolVar(&markUnread, "mark-unread", false, "mark messages as unread")
+	cmd.Flags().StringVar(&exportPath, "export", "", "before any other action, save messages to this mbox file, Maildir or .eml directory")
+	cmd.Flags().StringVar(&exportFormat, "export-format", string(mailstore.FormatMbox), "format for --export: mbox, maildir or eml")
+	actionOpts.boolVar(cmd.Flags(), "mark-read", "", "mark messages as read (\\Seen)")
+	actionOpts.boolVar(cmd.Flags(), "mark-unread", "", "mark messages as unread")
+	actionOpts.boolVar(cmd.Flags(), "flag", "", "flag messages (\\Flagged)")
+	actionOpts.boolVar(cmd.Flags(), "unflag", "", "remove the \\Flagged flag from messages")
+	actionOpts.boolVar(cmd.Flags(), "mark-answered", "", "mark messages as answered (\\Answered)")
+	actionOpts.stringVar(cmd.Flags(), "add-keyword", "", "add a keyword (e.g. review) to messages (repeatable)")
+	actionOpts.stringVar(cmd.Flags(), "remove-keyword", "", "remove a keyword from messages (repeatable)")
 	cmd.Flags().StringVar(&sortBy, "sort", "date", "sort by: date, subject, from, to, size, unread")
 	cmd.Flags().BoolVarP(&reverse, "reverse", "R", false, "reverse the sort order")
 	cmd.Flags().BoolVar(&countOnly, "count", false, "print only the number of matching messages")
 	cmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "skip the interactive picker and act on all matches")
-	// --read and --unread are contradictory: requiring both Seen and not-Seen
-	// matches nothing. Reject the combination up front instead of silently
-	// returning zero results.
-	cmd.MarkFlagsMutuallyExclusive("read", "unread")
-	// At most one action per run. Combining them is either nonsensical (move
-	// then delete the same UIDs from a folder they left) or ambiguous in
-	// ordering; run separate passes if you want more than one.
-	cmd.MarkFlagsMutuallyExclusive("move", "copy", "delete", "mark-read", "mark-unread", "count")
+	cmd.Flags().BoolVar(&sinceLast, "since-last-run", false, "only consider messages that arrived since the last successful --since-last-run")
+	cmd.Flags().StringVar(&stateKey, "state-key", "", "with --since-last-run, name of an independent checkpoint for this folder")
+	// Actions can be chained (see buildActions and ValidateActions), but
+	// --count never acts, and a flag cannot be both set and cleared.
+	cmd.MarkFlagsMutuallyExclusive("mark-read", "mark-unread")
+	cmd.MarkFlagsMutuallyExclusive("flag", "unflag")
+	for _, action := range []string{"export", "move", "copy", "delete", "mark-read", "mark-unread", "flag", "unflag", "mark-answered", "add-keyword", "remove-keyword"} {
+		cmd.MarkFlagsMutuallyExclusive("count", action)
+	}
 	return cmd
 }
 
+// countMatches consumes the search results of stream and counts those that
+// pass the client-side subject filter (and the --since-last-run checkpoint, if
+// any), without keeping the messages themselves.
+func countMatches(stream imaputils.MessageStream, folder string, searchOpts imaputils.SearchOptions, checkpoint *sinceLastRun) (int, error) {
+	matchesSubject, err := imaputils.SubjectMatcher(searchOpts)
+	if err != nil {
+		return 0, fmt.Errorf("error filtering by subject: %w", err)
+	}
+
+	count := 0
+	for message, err := range stream {
+		if err != nil {
+			return 0, fmt.Errorf("error searching folder %s: %w", folder, err)
+		}
+		if checkpoint != nil && !checkpoint.admits(message) {
+			continue
+		}
+		if matchesSubject(message) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// planPurge works out what purging messages from folder will really expunge.
+// On a server without UIDPLUS that includes other messages already flagged
+// \Deleted, which the caller must put to the user; with --yes there is nobody
+// to ask, so it is refused unless the account allows unsafe expunges.
+func planPurge(account imaputils.Account, folder string, messages []*imap.Message, assumeYes bool) (imaputils.ExpungePlan, error) {
+	plan, err := imaputils.PlanExpunge(dialer, account, folder, messages)
+	if err != nil {
+		return plan, fmt.Errorf("failed to check what purging %s would remove: %w", folder, err)
+	}
+	if assumeYes {
+		if err := plan.Check(account, "purge"); err != nil {
+			return plan, err
+		}
+	}
+	return plan, nil
+}
+
 // isInteractive reports whether stdin is a terminal, i.e. whether we can prompt
 // the user (run the picker) rather than refusing or hanging.
 func isInteractive() bool {
@@ -240,14 +339,15 @@ func isInteractive() bool {
 // user can deselect messages before acting; in a non-interactive session it
 // refuses rather than act blindly. proceed is false when the caller should stop
 // without acting (the user cancelled, selected nothing, or an error occurred).
-func resolveActionTargets(messages []*imap.Message, actionLabel string, confirmRequired, assumeYes bool) (targets []*imap.Message, proceed bool, err error) {
+// alsoExpunged counts other messages a purge removes along with the targets.
+func resolveActionTargets(messages []*imap.Message, actionLabel string, confirmRequired, assumeYes bool, alsoExpunged int) (targets []*imap.Message, proceed bool, err error) {
 	if assumeYes {
 		return messages, true, nil
 	}
 	if !isInteractive() {
 		return nil, false, fmt.Errorf("refusing to %s %d messages without --yes in a non-interactive session", actionLabel, len(messages))
 	}
-	kept, committed, err := util.SelectMessages(messages, actionLabel, confirmRequired)
+	kept, committed, err := util.SelectMessages(messages, actionLabel, confirmRequired, alsoExpunged)
 	if err != nil {
 		return nil, false, err
 	}
@@ -285,9 +385,14 @@ func CountMessagesBySender() *cobra.Command {
 				return fmt.Errorf("error parsing before date %s: %w", before, err)
 			}
 
-			data, err := imaputils.CountMessagesBySender(imaputils.SheDialer, account, args[0], threshold, startDate, endDate)
+			criteria := imaputils.BuildSearchCriteria(imaputils.SearchOptions{StartDate: startDate, EndDate: endDate})
+			stream, err := messageStream(cmd, account, args[0], criteria)
 			if err != nil {
-				return fmt.Errorf("error counting messages: %w", err)
+				return err
+			}
+			data, err := imaputils.CountSenders(stream, threshold)
+			if err != nil {
+				return fmt.Errorf("error counting messages: error searching folder %s: %w", args[0], err)
 			}
 			fmt.Println(util.RenderSenders(data))
 			return nil
@@ -309,12 +414,12 @@ func EmptyTrash() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			account := cmd.Context().Value("account").(imaputils.Account)
 
-			folder, err := imaputils.FindTrashFolder(imaputils.SheDialer, account)
+			folder, err := imaputils.FindTrashFolder(dialer, account)
 			if err != nil {
 				return fmt.Errorf("failed to find trash folder: %w", err)
 			}
 
-			count, err := imaputils.FolderMessageCount(imaputils.SheDialer, account, folder)
+			count, err := imaputils.FolderMessageCount(dialer, account, folder)
 			if err != nil {
 				return fmt.Errorf("failed to count messages in %s: %w", folder, err)
 			}
@@ -324,10 +429,16 @@ func EmptyTrash() *cobra.Command {
 				return nil
 			}
 
-			deleted, err := imaputils.EmptyFolder(imaputils.SheDialer, account, folder)
+			deleted, err := imaputils.EmptyFolder(dialer, account, folder)
 			if err != nil {
 				return fmt.Errorf("failed to empty %s: %w", folder, err)
 			}
+			operationJournal().Record(imaputils.JournalEntry{
+				Account:   account.Name,
+				Operation: imaputils.JournalPurge,
+				Source:    folder,
+				Count:     deleted,
+			})
 			fmt.Printf("permanently deleted %d messages from %s\n", deleted, folder)
 			return nil
 		},
@@ -350,16 +461,27 @@ func Dedupe() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			account := cmd.Context().Value("account").(imaputils.Account)
 
+			// Stream the folder through a duplicate tracker, which keeps the
+			// oldest copy of each Message-ID and remembers only the UIDs of the
+			// rest; then fetch just the duplicates for display and deletion.
 			criteria := imaputils.BuildSearchCriteria(imaputils.SearchOptions{})
-			messages, err := imaputils.SearchMessages(imaputils.SheDialer, account, args[0], criteria)
+			stream, err := messageStream(cmd, account, args[0], criteria)
 			if err != nil {
-				return fmt.Errorf("error searching folder %s: %w", args[0], err)
+				return err
+			}
+			tracker := imaputils.NewDuplicateTracker()
+			for message, err := range stream {
+				if err != nil {
+					return fmt.Errorf("error searching folder %s: %w", args[0], err)
+				}
+				tracker.Add(message)
 			}
 
-			// Keep the oldest copy of each message: sort oldest-first so the
-			// first occurrence of each Message-ID (the one kept) is the oldest.
-			imaputils.SortMessages(messages, imaputils.SortDate, true)
-			duplicates := imaputils.FindDuplicates(messages)
+			duplicates, err := imaputils.CollectMessages(imaputils.StreamMessagesByUID(dialer, account, args[0], tracker.Duplicates(), imaputils.DefaultChunkSize))
+			if err != nil {
+				return fmt.Errorf("error fetching duplicates from %s: %w", args[0], err)
+			}
+			imaputils.SortMessages(duplicates, imaputils.SortDate, true)
 
 			if len(duplicates) == 0 {
 				fmt.Printf("no duplicate messages found in %s\n", args[0])
@@ -373,14 +495,26 @@ func Dedupe() *cobra.Command {
 			fmt.Println(rendered)
 
 			account.Purge = account.Purge || purge
-			action := "delete"
+			prompt := fmt.Sprintf("really delete %d duplicate messages from %s?", len(duplicates), args[0])
 			if account.Purge {
-				action = "permanently delete"
+				plan, err := planPurge(account, args[0], duplicates, assumeYes)
+				if err != nil {
+					return err
+				}
+				prompt = fmt.Sprintf("really permanently delete %d duplicate messages from %s?", len(duplicates), args[0])
+				if len(plan.Others) > 0 {
+					prompt = fmt.Sprintf("really permanently delete %d duplicate messages from %s, and %d other messages already flagged \\Deleted there (%d in all)?",
+						len(duplicates), args[0], len(plan.Others), plan.Total(len(duplicates)))
+					// Confirming allows the collateral expunge.
+					account.AllowUnsafeExpunge = true
+				}
 			}
-			if assumeYes || util.GetConfirmation(fmt.Sprintf("really %s %d duplicate messages from %s?", action, len(duplicates), args[0])) {
-				if err := imaputils.DeleteMessages(imaputils.SheDialer, account, duplicates, args[0]); err != nil {
+			if assumeYes || util.GetConfirmation(prompt) {
+				transfer, err := imaputils.DeleteMessages(dialer, account, duplicates, args[0])
+				if err != nil {
 					return fmt.Errorf("failed to delete duplicates from %s: %w", args[0], err)
 				}
+				operationJournal().Record(imaputils.NewJournalEntry(account, imaputils.DeleteOperation(transfer), transfer, duplicates))
 			} else {
 				fmt.Println("operation cancelled")
 			}
@@ -403,9 +537,10 @@ func CreateFolder() *cobra.Command {
 			}
 			return nil
 		},
+		ValidArgsFunction: completeFolders(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			account := cmd.Context().Value("account").(imaputils.Account)
-			if err := imaputils.EnsureFolder(imaputils.SheDialer, account, args[0]); err != nil {
+			if err := imaputils.EnsureFolder(dialer, account, args[0]); err != nil {
 				return err
 			}
 			return nil
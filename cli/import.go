@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/wryfi/shemail/config"
+	"github.com/wryfi/shemail/imaputils"
+	"github.com/wryfi/shemail/mailstore"
+)
+
+// importFile is the name of the file within config.StateDir() that records
+// how far unfinished imports have got.
+const importFile = "imports.json"
+
+// ImportCommand generates a command to upload the messages in a local mbox
+// file, Maildir or .eml directory to a folder.
+func ImportCommand() *cobra.Command {
+	var (
+		format  string
+		restart bool
+	)
+	cmd := &cobra.Command{
+		Use:   "import <source> <folder>",
+		Short: "upload the messages in an mbox file, a Maildir or .eml files to a folder",
+		Long: `Upload the messages in an mbox file, a Maildir or a directory of .eml files to
+a folder, which is created if needed. Each message keeps its original date and,
+from mbox Status headers or Maildir file names, its flags. Messages whose
+Message-ID is already in the folder are skipped.
+
+Progress is saved every 100 messages; if an import is interrupted, running the
+same command again resumes where it stopped.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return fmt.Errorf("you must specify a source and a destination folder")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			account := cmd.Context().Value("account").(imaputils.Account)
+			source, folder := args[0], args[1]
+
+			var importFormat mailstore.Format
+			var err error
+			if format == "" {
+				importFormat, err = mailstore.DetectFormat(source)
+			} else {
+				importFormat, err = mailstore.ParseFormat(format)
+			}
+			if err != nil {
+				return err
+			}
+			return importMessages(account, source, folder, importFormat, restart)
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "", "source format: mbox, maildir or eml (default: detected from the source)")
+	cmd.Flags().BoolVar(&restart, "restart", false, "ignore the saved progress of an interrupted import and start from the beginning")
+	return cmd
+}
+
+// importMessages imports source into folder, resuming from the saved progress
+// of an earlier interrupted import unless restart is set. Under --dry-run no
+// progress is saved.
+func importMessages(account imaputils.Account, source, folder string, format mailstore.Format, restart bool) error {
+	absolute, err := filepath.Abs(source)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", source, err)
+	}
+	store, err := imaputils.LoadProgressStore[imaputils.ImportProgress](filepath.Join(config.StateDir(), importFile))
+	if err != nil {
+		return err
+	}
+	key := imaputils.ImportKey(account, absolute, folder)
+
+	state, resuming := store.Get(key)
+	if restart {
+		state, resuming = imaputils.ImportProgress{}, false
+	}
+	if resuming {
+		fmt.Printf("resuming import of %s into %s after %d messages (use --restart to start over)\n",
+			source, folder, state.Imported+state.Duplicates)
+	}
+
+	reader, err := mailstore.OpenReader(format, source, state.Position)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	save := func(state imaputils.ImportProgress) error {
+		if isDryRun() {
+			return nil
+		}
+		store.Set(key, state)
+		return store.Save()
+	}
+	state, err = imaputils.ImportMessages(dialer, account, reader, folder, state, save)
+	if err != nil {
+		return fmt.Errorf("import of %s stopped after %d messages (run the same command to resume): %w",
+			source, state.Imported+state.Duplicates, err)
+	}
+
+	if !isDryRun() {
+		store.Delete(key)
+		if err := store.Save(); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("imported %d messages from %s into %s (%d skipped as already present)\n",
+		state.Imported, source, folder, state.Duplicates)
+	return nil
+}
@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/wryfi/shemail/config"
+	"github.com/wryfi/shemail/imaputils"
+	"github.com/wryfi/shemail/util"
+)
+
+// journalFile is the name of the operation journal within config.StateDir().
+const journalFile = "journal.jsonl"
+
+// operationJournal returns the journal of copies, moves and deletes that
+// history lists and undo reverts. A --dry-run reads it but records nothing.
+func operationJournal() *imaputils.Journal {
+	path := filepath.Join(config.StateDir(), journalFile)
+	if isDryRun() {
+		return imaputils.NewReadOnlyJournal(path)
+	}
+	return imaputils.NewJournal(path)
+}
+
+// HistoryCommand generates a command to list recent operations from the
+// journal. It works offline.
+func HistoryCommand() *cobra.Command {
+	var (
+		limit       int
+		allAccounts bool
+	)
+	cmd := &cobra.Command{
+		Use:         "history",
+		Short:       "list recent copy, move and delete operations that can be undone",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{noAuthAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entries, err := operationJournal().Entries()
+			if err != nil {
+				return err
+			}
+			undone := imaputils.UndoneBy(entries)
+
+			if !allAccounts {
+				account, err := requestedAccount(cmd)
+				if err != nil {
+					return err
+				}
+				var own []imaputils.JournalEntry
+				for _, entry := range entries {
+					if entry.Account == account.Name {
+						own = append(own, entry)
+					}
+				}
+				entries = own
+			}
+			if limit > 0 && len(entries) > limit {
+				entries = entries[len(entries)-limit:]
+			}
+
+			if len(entries) == 0 {
+				fmt.Println("no operations recorded")
+				return nil
+			}
+			fmt.Println(util.RenderHistory(entries, undone))
+			return nil
+		},
+	}
+	cmd.Flags().IntVarP(&limit, "limit", "n", 20, "show at most this many of the most recent operations (0 for all)")
+	cmd.Flags().BoolVar(&allAccounts, "all-accounts", false, "show the operations of every account")
+	return cmd
+}
+
+// UndoCommand generates a command to revert an operation from the journal.
+func UndoCommand() *cobra.Command {
+	var assumeYes bool
+	cmd := &cobra.Command{
+		Use:   "undo [id]",
+		Short: "move messages back to where an operation took them from (default: the most recent operation)",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			account := cmd.Context().Value("account").(imaputils.Account)
+
+			id := 0
+			if len(args) == 1 {
+				parsed, err := strconv.Atoi(args[0])
+				if err != nil || parsed <= 0 {
+					return fmt.Errorf("operation id must be a positive number, not %q", args[0])
+				}
+				id = parsed
+			}
+
+			// Resolve the target first, so the prompt can say what it undoes;
+			// UndoOperation checks it again against the journal.
+			entries, err := operationJournal().Entries()
+			if err != nil {
+				return err
+			}
+			target, err := imaputils.FindUndoTarget(account, entries, id)
+			if err != nil {
+				return err
+			}
+			prompt := fmt.Sprintf("really undo operation %d (%s)?", target.ID, target.Describe())
+			if !assumeYes && !util.GetConfirmation(prompt) {
+				fmt.Println("operation cancelled")
+				return nil
+			}
+
+			result, err := imaputils.UndoOperation(dialer, account, operationJournal(), target.ID)
+			if err != nil {
+				return err
+			}
+			if result.Undone.Operation == imaputils.JournalCopy {
+				fmt.Printf("deleted %d copies from %s\n", result.Restored, result.Undone.Dest)
+			} else {
+				fmt.Printf("moved %d messages from %s back to %s\n", result.Restored, result.Undone.Dest, result.Undone.Source)
+			}
+			if result.Lost > 0 {
+				fmt.Printf("%d messages could not be found in %s and were not restored\n", result.Lost, result.Undone.Dest)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "skip the confirmation prompt")
+	return cmd
+}
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/wryfi/shemail/imaputils"
+	"github.com/wryfi/shemail/util"
+)
+
+// MergeCommand generates a command to move every message of one or more
+// folders into another, then delete the emptied folders.
+func MergeCommand() *cobra.Command {
+	var (
+		dedupe    bool
+		recursive bool
+		purge     bool
+		assumeYes bool
+	)
+	cmd := &cobra.Command{
+		Use:   "merge <source>... <destination>",
+		Short: "move every message of some folders into another, then delete them",
+		Long: `Move every message of the source folders into the destination folder, which is
+created if needed, then delete the emptied source folders. A source folder is
+only deleted once it is verified to be empty and the destination to hold the
+messages moved into it. Folders with subfolders are kept unless --recursive is
+given, which merges each subfolder into the corresponding folder below the
+destination. INBOX, the trash folder and folders the server marks for a
+special use are never merged.
+
+With --dedupe, messages whose Message-ID is already in the destination, or
+on an older message of the sources, are deleted instead of moved: moved to
+the trash, or expunged with --purge.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 2 {
+				return fmt.Errorf("you must provide at least one source folder and the destination folder as positional arguments")
+			}
+			return nil
+		},
+		ValidArgsFunction: completeFolders(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			account := cmd.Context().Value("account").(imaputils.Account)
+			sources, dest := args[:len(args)-1], args[len(args)-1]
+			steps, err := imaputils.PlanMerge(dialer, account, sources, dest, recursive)
+			if err != nil {
+				return err
+			}
+
+			messages := 0
+			rows := make([][]string, len(steps))
+			for index, step := range steps {
+				messages += int(step.Messages)
+				rows[index] = []string{step.Source, step.Dest, fmt.Sprintf("%d", step.Messages)}
+			}
+			fmt.Println(util.RenderTable([]string{"Source", "Destination", "Messages"}, rows, 2))
+			prompt := fmt.Sprintf("really move %d messages from %d folders into %s and delete the folders?", messages, len(steps), dest)
+			if !assumeYes && !util.GetConfirmation(prompt) {
+				fmt.Println("operation cancelled")
+				return nil
+			}
+
+			account.Purge = account.Purge || purge
+			for _, step := range steps {
+				if err := mergeFolder(account, step, dedupe); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&dedupe, "dedupe", false, "delete messages whose Message-ID is already in the destination instead of moving them")
+	cmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "also merge the subfolders of each source into the corresponding folders below the destination")
+	cmd.Flags().BoolVarP(&purge, "purge", "p", false, "with --dedupe, permanently expunge duplicates instead of moving them to trash")
+	cmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "skip the confirmation prompt")
+	return cmd
+}
+
+// mergeFolder merges one folder, journals what it moved and deleted, and
+// deletes the folder once it is verified to be empty.
+func mergeFolder(account imaputils.Account, step imaputils.MergeStep, dedupe bool) error {
+	result, err := imaputils.MergeFolder(dialer, account, step, dedupe)
+	journal := operationJournal()
+	if result.Transfer != nil {
+		journal.Record(imaputils.NewJournalEntry(account, imaputils.JournalMove, result.Transfer, result.Moved))
+	}
+	if result.DuplicateTransfer != nil {
+		journal.Record(imaputils.NewJournalEntry(account, imaputils.DeleteOperation(result.DuplicateTransfer), result.DuplicateTransfer, result.Duplicates))
+	}
+	if err != nil {
+		return fmt.Errorf("merge of %s stopped, and it was kept: %w", step.Source, err)
+	}
+	if !isDryRun() && step.Selectable {
+		fmt.Printf("%s -> %s: moved %d messages", step.Source, step.Dest, len(result.Moved))
+		if dedupe {
+			fmt.Printf(", deleted %d duplicates", len(result.Duplicates))
+		}
+		fmt.Println()
+	}
+
+	if step.Subfolders > 0 {
+		fmt.Printf("kept %s, which has %d subfolders; use --recursive to merge them too\n", step.Source, step.Subfolders)
+		return nil
+	}
+	if _, err := imaputils.DeleteFolders(dialer, account, []imaputils.FolderRemoval{{Name: step.Source}}); err != nil {
+		return err
+	}
+	if !isDryRun() {
+		fmt.Printf("deleted folder %s\n", step.Source)
+	}
+	return nil
+}
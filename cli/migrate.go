@@ -0,0 +1,209 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/wryfi/shemail/config"
+	"github.com/wryfi/shemail/imaputils"
+	"github.com/wryfi/shemail/util"
+)
+
+// migrationFile is the name of the file within config.StateDir() that records
+// how far each migration has got.
+const migrationFile = "migrations.json"
+
+// migration is one folder to migrate.
+type migration struct {
+	source      imaputils.Location
+	destination imaputils.Location
+}
+
+// MigrateCommand generates a command to copy folders from one account to
+// another, which may be on different servers.
+func MigrateCommand() *cobra.Command {
+	var (
+		from, to     string
+		recursive    bool
+		deleteSource bool
+		restart      bool
+		assumeYes    bool
+	)
+	cmd := &cobra.Command{
+		Use:   "migrate --from ACCOUNT:FOLDER --to ACCOUNT:FOLDER",
+		Short: "copy folders to another account, keeping flags and dates",
+		Long: `Copy a folder, or with --recursive a folder and everything below it, from one
+configured account to another, which may be on a different server. Messages are
+downloaded from the source and APPENDed to the destination with their flags and
+original delivery date, and the folder hierarchy is recreated with the
+destination server's delimiter. With --recursive, "ACCOUNT:" (no folder)
+migrates the whole account.
+
+Messages whose Message-ID is already in the destination are skipped. Progress
+is saved as the migration goes, so an interrupted migration resumes where it
+stopped, and running it again later copies only new messages. With
+--delete-source, the source messages found in the destination afterwards are
+deleted, following the source account's deletion strategy.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{ownAccountsAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			source, err := parseLocation(cmd, from)
+			if err != nil {
+				return err
+			}
+			destination, err := parseLocation(cmd, to)
+			if err != nil {
+				return err
+			}
+			if !recursive && (source.Folder == "" || destination.Folder == "") {
+				return fmt.Errorf("--from and --to must name a folder, e.g. icloud:Archive, unless --recursive is given")
+			}
+
+			migrations, err := planMigrations(source, destination, recursive)
+			if err != nil {
+				return err
+			}
+			if len(migrations) == 0 {
+				fmt.Printf("no folders to migrate from %s\n", source)
+				return nil
+			}
+
+			store, err := imaputils.LoadProgressStore[imaputils.MigrationProgress](filepath.Join(config.StateDir(), migrationFile))
+			if err != nil {
+				return err
+			}
+			for _, next := range migrations {
+				state, err := migrateFolder(store, next, restart)
+				if err != nil {
+					return err
+				}
+				if deleteSource {
+					if err := deleteMigrated(next, state.LastUID, assumeYes); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&from, "from", "", "source, as ACCOUNT:FOLDER")
+	cmd.Flags().StringVar(&to, "to", "", "destination, as ACCOUNT:FOLDER (created if missing)")
+	cmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "also migrate every folder below the source folder (the whole account if none is given)")
+	cmd.Flags().BoolVar(&deleteSource, "delete-source", false, "delete the source messages once they are verified to be in the destination")
+	cmd.Flags().BoolVar(&restart, "restart", false, "ignore saved progress and consider every source message again")
+	cmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "skip the confirmation prompt for --delete-source")
+	cmd.MarkFlagRequired("from")
+	cmd.MarkFlagRequired("to")
+	return cmd
+}
+
+// parseLocation parses an ACCOUNT:FOLDER location and loads the account.
+func parseLocation(cmd *cobra.Command, spec string) (imaputils.Location, error) {
+	name, folder, found := strings.Cut(spec, ":")
+	if !found || name == "" {
+		return imaputils.Location{}, fmt.Errorf("invalid location %q: expected ACCOUNT:FOLDER", spec)
+	}
+	account, err := loadAccount(cmd, name)
+	if err != nil {
+		return imaputils.Location{}, fmt.Errorf("%s: %w", spec, err)
+	}
+	return imaputils.Location{Account: account, Folder: folder}, nil
+}
+
+// planMigrations lists the folders to migrate: source to destination, or
+// with recursive every selectable folder in the tree under source, each to
+// the corresponding path under destination.
+func planMigrations(source, destination imaputils.Location, recursive bool) ([]migration, error) {
+	prefix := source.Folder
+	if prefix != "" {
+		native, err := imaputils.ServerFolderName(dialer, source.Account, prefix)
+		if err != nil {
+			return nil, err
+		}
+		prefix = native
+	}
+	if !recursive {
+		source.Folder = prefix
+		return []migration{{source: source, destination: destination}}, nil
+	}
+
+	folders, err := imaputils.ListFoldersWithStatus(dialer, source.Account, false, false)
+	if err != nil {
+		return nil, fmt.Errorf("error listing folders of %s: %w", source.Account.Name, err)
+	}
+	var migrations []migration
+	for _, folder := range folders {
+		if !folder.Selectable {
+			continue
+		}
+		target, ok := imaputils.MigrationTarget(folder, prefix, destination.Folder)
+		if !ok {
+			continue
+		}
+		migrations = append(migrations, migration{
+			source:      imaputils.Location{Account: source.Account, Folder: folder.Name},
+			destination: imaputils.Location{Account: destination.Account, Folder: target},
+		})
+	}
+	return migrations, nil
+}
+
+// migrateFolder runs one migration from its saved progress, which it keeps
+// up to date unless this is a --dry-run.
+func migrateFolder(store *imaputils.ProgressStore[imaputils.MigrationProgress], next migration, restart bool) (imaputils.MigrationProgress, error) {
+	key := imaputils.MigrationKey(next.source, next.destination)
+	state, resuming := store.Get(key)
+	if restart {
+		state, resuming = imaputils.MigrationProgress{}, false
+	}
+	if resuming {
+		log.Info().Msgf("continuing %s -> %s after UID %d", next.source, next.destination, state.LastUID)
+	}
+	copied, duplicates := state.Copied, state.Duplicates
+
+	save := func(state imaputils.MigrationProgress) error {
+		if isDryRun() {
+			return nil
+		}
+		store.Set(key, state)
+		return store.Save()
+	}
+	state, err := imaputils.MigrateFolder(dialer, next.source, next.destination, state, save)
+	if err != nil {
+		return state, fmt.Errorf("migration of %s stopped (run the same command to resume): %w", next.source, err)
+	}
+	fmt.Printf("%s -> %s: copied %d messages (%d already there)\n",
+		next.source, next.destination, state.Copied-copied, state.Duplicates-duplicates)
+	return state, nil
+}
+
+// deleteMigrated deletes the source messages of a migration, up to lastUID,
+// that are verified to be in the destination.
+func deleteMigrated(next migration, lastUID uint32, assumeYes bool) error {
+	verified, unverified, err := imaputils.VerifyMigration(dialer, next.source, next.destination, lastUID)
+	if err != nil {
+		return fmt.Errorf("failed to verify %s -> %s: %w", next.source, next.destination, err)
+	}
+	if unverified > 0 {
+		fmt.Printf("keeping %d messages in %s that could not be found in %s by Message-ID\n", unverified, next.source, next.destination)
+	}
+	if len(verified) == 0 {
+		return nil
+	}
+
+	prompt := fmt.Sprintf("really delete %d migrated messages from %s?", len(verified), next.source)
+	if !assumeYes && !util.GetConfirmation(prompt) {
+		fmt.Println("operation cancelled")
+		return nil
+	}
+	account := next.source.Account
+	transfer, err := imaputils.DeleteMessages(dialer, account, verified, next.source.Folder)
+	if err != nil {
+		return fmt.Errorf("failed to delete migrated messages from %s: %w", next.source, err)
+	}
+	operationJournal().Record(imaputils.NewJournalEntry(account, imaputils.DeleteOperation(transfer), transfer, verified))
+	fmt.Printf("deleted %d migrated messages from %s\n", len(verified), next.source)
+	return nil
+}
@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/wryfi/shemail/imaputils"
+	"github.com/wryfi/shemail/util"
+)
+
+// QuotaCommand generates a command to show how much of the account's quota
+// is in use, failing above a threshold so that cron can warn before the
+// mailbox fills up.
+func QuotaCommand() *cobra.Command {
+	var threshold float64
+	cmd := &cobra.Command{
+		Use:   "quota",
+		Short: "show how close the mailbox is to its storage quota",
+		Long: `Show the quotas that limit the INBOX, where incoming mail bounces once they
+are full: the storage used and the number of messages, against their limits.
+The server must support the QUOTA extension.
+
+The command fails when any resource is used above the threshold, by default
+the quota_threshold setting or 90 percent, so that a cron job can warn before
+the mailbox fills up.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			account := cmd.Context().Value("account").(imaputils.Account)
+			if !cmd.Flags().Changed("threshold") {
+				threshold = viper.GetFloat64("quota_threshold")
+			}
+			if threshold <= 0 || threshold > 100 {
+				return fmt.Errorf("invalid quota threshold %g (expected a percentage above 0, up to 100)", threshold)
+			}
+
+			quotas, err := imaputils.AccountQuota(dialer, account)
+			if errors.Is(err, imaputils.ErrQuotaUnsupported) {
+				return fmt.Errorf("%s does not report quotas (it lacks the QUOTA extension)", account.Server)
+			}
+			if err != nil {
+				return err
+			}
+
+			var rows [][]string
+			for _, quota := range quotas {
+				for _, resource := range quota.Resources {
+					rows = append(rows, []string{quotaRootLabel(quota.Root), resource.Name,
+						formatQuotaAmount(resource.Name, resource.Usage), formatQuotaAmount(resource.Name, resource.Limit),
+						fmt.Sprintf("%.0f%%", resource.Percent())})
+				}
+			}
+			if len(rows) == 0 {
+				fmt.Fprintln(cmd.OutOrStdout(), "no quota limits the INBOX")
+				return nil
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), util.RenderTable([]string{"Root", "Resource", "Used", "Limit", "Use"}, rows, 2, 3, 4))
+
+			if over := quotasOver(quotas, threshold); len(over) > 0 {
+				return fmt.Errorf("quota above the %g%% threshold: %s", threshold, strings.Join(over, "; "))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().Float64Var(&threshold, "threshold", 0, "fail when a resource is used above this percentage (default: the quota_threshold setting, or 90)")
+	return cmd
+}
+
+// quotasOver describes the resources of quotas used above threshold percent.
+func quotasOver(quotas []imaputils.Quota, threshold float64) []string {
+	var over []string
+	for _, quota := range quotas {
+		for _, resource := range quota.Resources {
+			if resource.Limit > 0 && resource.Percent() > threshold {
+				over = append(over, fmt.Sprintf("%s %s at %.0f%%", quotaRootLabel(quota.Root), resource.Name, resource.Percent()))
+			}
+		}
+	}
+	return over
+}
+
+// describeQuota sums up the storage and message resources of quota on one
+// line, e.g. `quota "": 1.2G of 2.0G (60%), 900 of 10000 messages (9%)`.
+func describeQuota(quota imaputils.Quota) string {
+	var parts []string
+	if storage, ok := quota.Resource(imaputils.QuotaStorage); ok {
+		parts = append(parts, fmt.Sprintf("%s of %s (%.0f%%)",
+			formatQuotaAmount(storage.Name, storage.Usage), formatQuotaAmount(storage.Name, storage.Limit), storage.Percent()))
+	}
+	if messages, ok := quota.Resource(imaputils.QuotaMessage); ok {
+		parts = append(parts, fmt.Sprintf("%d of %d messages (%.0f%%)", messages.Usage, messages.Limit, messages.Percent()))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("quota %s: %s", quotaRootLabel(quota.Root), strings.Join(parts, ", "))
+}
+
+// printQuotaSummary prints a line per quota limiting the account, for ls -l.
+// Servers without QUOTA, and failures, only get a debug message, since the
+// listing is what was asked for.
+func printQuotaSummary(account imaputils.Account) {
+	quotas, err := imaputils.AccountQuota(dialer, account)
+	if err != nil {
+		log.Debug().Msgf("no quota summary: %v", err)
+		return
+	}
+	for _, quota := range quotas {
+		if summary := describeQuota(quota); summary != "" {
+			fmt.Println(summary)
+		}
+	}
+}
+
+// formatQuotaAmount renders a usage or limit of the named resource: STORAGE
+// counts units of 1024 octets.
+func formatQuotaAmount(resource string, amount uint64) string {
+	if resource == imaputils.QuotaStorage {
+		return util.FormatSize(amount * 1024)
+	}
+	return strconv.FormatUint(amount, 10)
+}
+
+// quotaRootLabel quotes a quota root name, which is often empty.
+func quotaRootLabel(root string) string {
+	return strconv.Quote(root)
+}
@@ -0,0 +1,22 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wryfi/shemail/imaputils"
+)
+
+func TestDescribeQuota(t *testing.T) {
+	quota := imaputils.Quota{Root: "", Resources: []imaputils.QuotaResource{
+		{Name: imaputils.QuotaStorage, Usage: 471040, Limit: 512000},
+		{Name: imaputils.QuotaMessage, Usage: 900, Limit: 10000},
+	}}
+	assert.Equal(t, `quota "": 460.0M of 500.0M (92%), 900 of 10000 messages (9%)`, describeQuota(quota))
+	assert.Equal(t, "", describeQuota(imaputils.Quota{Root: "other"}))
+
+	assert.Equal(t, []string{`"" STORAGE at 92%`}, quotasOver([]imaputils.Quota{quota}, 90))
+	assert.Empty(t, quotasOver([]imaputils.Quota{quota}, 95))
+	unlimited := imaputils.Quota{Resources: []imaputils.QuotaResource{{Name: imaputils.QuotaMessage, Usage: 5}}}
+	assert.Empty(t, quotasOver([]imaputils.Quota{unlimited}, 1))
+}
@@ -0,0 +1,503 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/wryfi/shemail/imaputils"
+	"github.com/wryfi/shemail/util"
+	"gopkg.in/yaml.v3"
+)
+
+// retentionPolicy is one entry of the retention section of the
+// configuration: how long messages stay in the folders matching some
+// patterns, and what becomes of them after that.
+type retentionPolicy struct {
+	// Account is the name of the account; the default account if empty.
+	Account string `yaml:"account"`
+	// Folders are "/"-separated folder paths, in which * matches any part of
+	// a single level, as in "Lists/*".
+	Folders stringList `yaml:"folders"`
+	// Policy is "delete after AGE", "purge after AGE" or "archive after AGE
+	// into TEMPLATE", with AGE such as 30d and TEMPLATE as archive's --into.
+	Policy string `yaml:"policy"`
+	// Except names the messages to keep however old they are: flagged,
+	// unread, or both.
+	Except stringList `yaml:"except,omitempty"`
+}
+
+// accountName returns the name of the account the policy applies to.
+func (policy retentionPolicy) accountName() string {
+	if policy.Account == "" {
+		return "default"
+	}
+	return policy.Account
+}
+
+// What a retention policy does with the messages it no longer keeps.
+const (
+	retainDelete  = "delete"
+	retainPurge   = "purge"
+	retainArchive = "archive"
+)
+
+// retentionSyntax shows the forms a policy takes, for error messages.
+const retentionSyntax = `"delete after 30d", "purge after 14d" or "archive after 1y into Archive/{{.Year}}"`
+
+// compiledRetention is a retention policy that has been checked and parsed
+// for running.
+type compiledRetention struct {
+	retentionPolicy
+	kind        string
+	cutoff      time.Time
+	into        *imaputils.ArchiveTemplate
+	keepFlagged bool
+	keepUnread  bool
+}
+
+// compileRetention checks policy and parses it, with its age counted back
+// from now.
+func compileRetention(policy retentionPolicy, now time.Time) (compiledRetention, error) {
+	if len(policy.Folders) == 0 {
+		return compiledRetention{}, fmt.Errorf("no folders")
+	}
+	if strings.TrimSpace(policy.Policy) == "" {
+		return compiledRetention{}, fmt.Errorf("no policy; expected %s", retentionSyntax)
+	}
+	for _, pattern := range policy.Folders {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return compiledRetention{}, fmt.Errorf("invalid folder pattern %q: %w", pattern, err)
+		}
+	}
+
+	compiled := compiledRetention{retentionPolicy: policy}
+	kind, rest, _ := strings.Cut(strings.TrimSpace(policy.Policy), " ")
+	rest, found := strings.CutPrefix(strings.TrimSpace(rest), "after ")
+	if !found {
+		return compiledRetention{}, fmt.Errorf("invalid policy %q: expected %s", policy.Policy, retentionSyntax)
+	}
+	age, into, hasInto := strings.Cut(rest, " into ")
+	switch kind {
+	case retainDelete, retainPurge:
+		if hasInto {
+			return compiledRetention{}, fmt.Errorf("invalid policy %q: only archive takes a destination", policy.Policy)
+		}
+	case retainArchive:
+		if !hasInto {
+			return compiledRetention{}, fmt.Errorf("invalid policy %q: archive needs a destination, as in %q", policy.Policy, "archive after 1y into Archive/{{.Year}}")
+		}
+		template, err := imaputils.ParseArchiveTemplate(strings.TrimSpace(into))
+		if err != nil {
+			return compiledRetention{}, err
+		}
+		compiled.into = template
+	default:
+		return compiledRetention{}, fmt.Errorf("invalid policy %q: expected %s", policy.Policy, retentionSyntax)
+	}
+	compiled.kind = kind
+	cutoff, err := util.AgeCutoff(age, now)
+	if err != nil {
+		return compiledRetention{}, err
+	}
+	compiled.cutoff = cutoff
+
+	for _, exception := range policy.Except {
+		switch exception {
+		case "flagged":
+			compiled.keepFlagged = true
+		case "unread":
+			compiled.keepUnread = true
+		default:
+			return compiledRetention{}, fmt.Errorf("invalid exception %q (expected flagged or unread)", exception)
+		}
+	}
+	return compiled, nil
+}
+
+// String describes the policy, e.g. "delete after 30d, except flagged".
+func (policy compiledRetention) String() string {
+	text := strings.Join(strings.Fields(policy.Policy), " ")
+	if len(policy.Except) > 0 {
+		text += ", except " + strings.Join(policy.Except, " and ")
+	}
+	return text
+}
+
+// matches reports whether one of the policy's patterns matches the
+// "/"-separated folder path.
+func (policy compiledRetention) matches(folder string) bool {
+	for _, pattern := range policy.Folders {
+		if strings.EqualFold(pattern, "INBOX") && strings.EqualFold(folder, "INBOX") {
+			return true
+		}
+		if matched, _ := path.Match(pattern, folder); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// keeps reports whether the policy's exceptions keep message however old it
+// is.
+func (policy compiledRetention) keeps(message *imap.Message) bool {
+	return (policy.keepFlagged && slices.Contains(message.Flags, imap.FlaggedFlag)) ||
+		(policy.keepUnread && !slices.Contains(message.Flags, imap.SeenFlag))
+}
+
+// loadRetention reads the retention section of the configuration.
+func loadRetention() ([]retentionPolicy, error) {
+	data, err := yaml.Marshal(viper.Get("retention"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read retention policies: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	// Catch misspelled settings, such as an exception that would go unnoticed.
+	decoder.KnownFields(true)
+	var policies []retentionPolicy
+	if err := decoder.Decode(&policies); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse retention policies: %w", err)
+	}
+	return policies, nil
+}
+
+// loadCompiledRetention loads and compiles the retention policies, failing
+// on any problem so that a broken configuration never applies half its
+// policies.
+func loadCompiledRetention(now time.Time) ([]compiledRetention, error) {
+	policies, err := loadRetention()
+	if err != nil {
+		return nil, err
+	}
+	if len(policies) == 0 {
+		return nil, fmt.Errorf("no retention policies in the configuration")
+	}
+	var (
+		compiled []compiledRetention
+		problems []error
+	)
+	for index, policy := range policies {
+		result, err := compileRetention(policy, now)
+		if err == nil {
+			_, err = getAccount(policy.accountName())
+		}
+		if err != nil {
+			problems = append(problems, fmt.Errorf("retention policy %d: %w", index+1, err))
+			continue
+		}
+		compiled = append(compiled, result)
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("the retention policies have problems:\n%w", errors.Join(problems...))
+	}
+	return compiled, nil
+}
+
+// retentionTarget is a folder and the policy that governs it.
+type retentionTarget struct {
+	policy  compiledRetention
+	account imaputils.Account
+	// folder is the server's name for the folder, and path its
+	// "/"-separated path.
+	folder string
+	path   string
+}
+
+// planRetention matches the selectable folders of each account the policies
+// name against the policies. Each folder is governed by the first policy, in
+// the order of the configuration, that matches it.
+func planRetention(cmd *cobra.Command, policies []compiledRetention) ([]retentionTarget, error) {
+	var names []string
+	for _, policy := range policies {
+		if !slices.Contains(names, policy.accountName()) {
+			names = append(names, policy.accountName())
+		}
+	}
+
+	var targets []retentionTarget
+	for _, name := range names {
+		account, err := loadAccount(cmd, name)
+		if err != nil {
+			return nil, err
+		}
+		folders, err := imaputils.ListFoldersWithStatus(dialer, account, false, false)
+		if err != nil {
+			return nil, fmt.Errorf("error listing folders of %s: %w", account.Name, err)
+		}
+		delimiter, err := imaputils.FolderDelimiter(dialer, account)
+		if err != nil {
+			return nil, err
+		}
+		imaputils.SortFolders(folders, imaputils.FolderSortName)
+		for _, status := range folders {
+			// \Noselect containers, such as [Gmail], hold no messages to expire.
+			if !status.Selectable {
+				continue
+			}
+			folder := status.Name
+			folderPath := strings.ReplaceAll(folder, delimiter, "/")
+			index := slices.IndexFunc(policies, func(policy compiledRetention) bool {
+				return policy.accountName() == name && policy.matches(folderPath)
+			})
+			if index >= 0 {
+				targets = append(targets, retentionTarget{policy: policies[index], account: account, folder: folder, path: folderPath})
+			}
+		}
+	}
+	return targets, nil
+}
+
+// retentionResult is the outcome of applying a policy to one folder.
+type retentionResult struct {
+	Account string `json:"account"`
+	Folder  string `json:"folder"`
+	Policy  string `json:"policy"`
+	// Expired is the number of messages older than the policy allows, and
+	// Kept the number of those its exceptions keep.
+	Expired int `json:"expired"`
+	Kept    int `json:"kept"`
+	// Removed is the number of messages deleted or archived, or that would
+	// be by a report.
+	Removed int    `json:"removed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// expiredMessages returns the messages of target's folder that are older than
+// its policy allows, split into those to remove and those its exceptions
+// keep.
+func expiredMessages(cmd *cobra.Command, target retentionTarget) (remove, kept []*imap.Message, err error) {
+	cutoff := target.policy.cutoff
+	stream, err := messageStream(cmd, target.account, target.folder, imaputils.BuildSearchCriteria(imaputils.SearchOptions{EndDate: &cutoff}))
+	if err != nil {
+		return nil, nil, err
+	}
+	messages, err := imaputils.CollectMessages(stream)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error searching folder %s: %w", target.folder, err)
+	}
+	for _, message := range messages {
+		if target.policy.keeps(message) {
+			kept = append(kept, message)
+		} else {
+			remove = append(remove, message)
+		}
+	}
+	return remove, kept, nil
+}
+
+// archiveGroups groups messages by the folder target's policy archives them
+// into, leaving out those it gives no other folder.
+func archiveGroups(target retentionTarget, messages []*imap.Message) ([]imaputils.ArchiveGroup, error) {
+	var listIDs map[uint32]string
+	if target.policy.into.NeedsListID() && len(messages) > 0 {
+		uids := make([]uint32, len(messages))
+		for index, message := range messages {
+			uids[index] = message.Uid
+		}
+		var err error
+		if listIDs, err = imaputils.FetchListIDs(dialer, target.account, target.folder, uids); err != nil {
+			return nil, err
+		}
+	}
+	groups, _ := imaputils.GroupForArchive(target.policy.into, target.path, messages, listIDs)
+	return groups, nil
+}
+
+// applyRetention evaluates target's policy and, if apply is set, deletes or
+// archives the messages it no longer keeps. Each move and delete is recorded
+// in the journal.
+func applyRetention(cmd *cobra.Command, target retentionTarget, apply bool) retentionResult {
+	result := retentionResult{Account: target.account.Name, Folder: target.path, Policy: target.policy.String()}
+	err := func() error {
+		if target.policy.kind == retainDelete {
+			// Without a trash folder, the delete fails below with why.
+			if trash, err := imaputils.FindTrashFolder(dialer, target.account); err == nil && trash == target.folder {
+				return fmt.Errorf("delete would move messages to the trash folder they are in; use purge")
+			}
+		}
+		remove, kept, err := expiredMessages(cmd, target)
+		if err != nil {
+			return err
+		}
+		result.Expired, result.Kept = len(remove)+len(kept), len(kept)
+		if len(remove) == 0 {
+			return nil
+		}
+
+		if target.policy.kind == retainArchive {
+			groups, err := archiveGroups(target, remove)
+			if err != nil {
+				return err
+			}
+			for _, group := range groups {
+				if apply {
+					actions := []imaputils.Action{{Kind: imaputils.ActionMove, Folder: group.Folder}}
+					if err := imaputils.RunActions(dialer, target.account, target.folder, group.Messages, actions, operationJournal()); err != nil {
+						return err
+					}
+				}
+				result.Removed += len(group.Messages)
+			}
+			return nil
+		}
+
+		if apply {
+			account := target.account
+			account.Purge = account.Purge || target.policy.kind == retainPurge
+			actions := []imaputils.Action{{Kind: imaputils.ActionDelete}}
+			if err := imaputils.RunActions(dialer, account, target.folder, remove, actions, operationJournal()); err != nil {
+				return err
+			}
+		}
+		result.Removed = len(remove)
+		return nil
+	}()
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// retentionReport sums up a run or report of the retention policies; it is
+// also the JSON form of the report.
+type retentionReport struct {
+	DryRun  bool              `json:"dry_run"`
+	Applied bool              `json:"applied"`
+	Removed int               `json:"removed"`
+	Failed  int               `json:"failed"`
+	Results []retentionResult `json:"results"`
+}
+
+func newRetentionReport(results []retentionResult, applied bool) retentionReport {
+	report := retentionReport{DryRun: isDryRun(), Applied: applied, Results: results}
+	if report.Results == nil {
+		report.Results = []retentionResult{}
+	}
+	for _, result := range results {
+		report.Removed += result.Removed
+		if result.Error != "" {
+			report.Failed++
+		}
+	}
+	return report
+}
+
+// print prints the report as a table, or as JSON when output is "json".
+func (report retentionReport) print(cmd *cobra.Command, output string) error {
+	if output == "json" {
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	removed, verb := "Would remove", "would be removed"
+	if report.Applied && !report.DryRun {
+		removed, verb = "Removed", "removed"
+	}
+	rows := make([][]string, len(report.Results))
+	for index, result := range report.Results {
+		status := "ok"
+		if result.Error != "" {
+			status = "failed: " + result.Error
+		}
+		rows[index] = []string{result.Account + ":" + result.Folder, result.Policy,
+			fmt.Sprint(result.Expired), fmt.Sprint(result.Kept), fmt.Sprint(result.Removed), status}
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), util.RenderTable([]string{"Folder", "Policy", "Expired", "Kept", removed, "Result"}, rows, 2, 3, 4))
+	fmt.Fprintf(cmd.OutOrStdout(), "%d messages %s from %d folders", report.Removed, verb, len(report.Results))
+	if report.Failed > 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "; %d failed", report.Failed)
+	}
+	fmt.Fprintln(cmd.OutOrStdout())
+	return nil
+}
+
+// RetentionCommand generates a command to report on and apply the retention
+// policies of the configuration.
+func RetentionCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "retention",
+		Short: "report on and apply the retention policies of the configuration",
+		Long: `Report on and apply the retention policies in the retention section of the
+configuration. Each policy names an account, folder patterns and what becomes
+of messages older than an age: "delete after 30d" deletes them following the
+account's deletion strategy, "purge after 14d" expunges them, and "archive
+after 1y into Archive/{{.Year}}" moves them into folders rendered as archive's
+--into does. Flagged or unread messages can be kept however old they are.
+Each folder is governed by the first policy that matches it.`,
+	}
+	cmd.AddCommand(retentionReportCommand(), retentionRunCommand())
+	return cmd
+}
+
+// runRetention evaluates every policy on the folders it governs, applying
+// them if apply is set, and prints the report.
+func runRetention(cmd *cobra.Command, output string, apply bool) error {
+	if output != "text" && output != "json" {
+		return fmt.Errorf("invalid output %q (expected text or json)", output)
+	}
+	policies, err := loadCompiledRetention(time.Now())
+	if err != nil {
+		return err
+	}
+	targets, err := planRetention(cmd, policies)
+	if err != nil {
+		return err
+	}
+
+	results := make([]retentionResult, len(targets))
+	for index, target := range targets {
+		results[index] = applyRetention(cmd, target, apply)
+	}
+	report := newRetentionReport(results, apply)
+	if err := report.print(cmd, output); err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d folders failed", report.Failed, len(results))
+	}
+	return nil
+}
+
+func retentionReportCommand() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:         "report",
+		Short:       "show what each retention policy would remove, without changing anything",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{ownAccountsAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRetention(cmd, output, false)
+		},
+	}
+	cmd.Flags().StringVar(&output, "output", "text", "report format: text or json")
+	return cmd
+}
+
+func retentionRunCommand() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "apply the retention policies, without prompting, and report what each did",
+		Long: `Apply the retention policies to every folder they match, without prompting,
+and print how many messages each removed. A folder that fails does not stop
+the others, but makes the command fail. Add --dry-run to see what would be
+sent to the server.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{ownAccountsAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRetention(cmd, output, true)
+		},
+	}
+	cmd.Flags().StringVar(&output, "output", "text", "report format: text or json")
+	return cmd
+}
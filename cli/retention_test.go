@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileRetention(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	compiled, err := compileRetention(retentionPolicy{Folders: []string{"Lists/*"}, Policy: "delete  after 30d", Except: []string{"flagged", "unread"}}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, retainDelete, compiled.kind)
+	assert.Equal(t, time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC), compiled.cutoff)
+	assert.True(t, compiled.keepFlagged)
+	assert.True(t, compiled.keepUnread)
+	assert.Equal(t, "delete after 30d, except flagged and unread", compiled.String())
+
+	compiled, err = compileRetention(retentionPolicy{Folders: []string{"INBOX"}, Policy: "archive after 1y into Archive/{{.Year}}"}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, retainArchive, compiled.kind)
+	assert.Equal(t, "Archive/{{.Year}}", compiled.into.String())
+	assert.Equal(t, time.Date(2023, 6, 30, 12, 0, 0, 0, time.UTC), compiled.cutoff)
+
+	tests := []struct {
+		policy retentionPolicy
+		want   string
+	}{
+		{retentionPolicy{Policy: "delete after 30d"}, "no folders"},
+		{retentionPolicy{Folders: []string{"Trash"}}, "no policy"},
+		{retentionPolicy{Folders: []string{"["}, Policy: "delete after 30d"}, "invalid folder pattern"},
+		{retentionPolicy{Folders: []string{"Trash"}, Policy: "expunge after 30d"}, "invalid policy"},
+		{retentionPolicy{Folders: []string{"Trash"}, Policy: "purge 30d"}, "invalid policy"},
+		{retentionPolicy{Folders: []string{"Trash"}, Policy: "purge after 30d into Archive"}, "only archive takes a destination"},
+		{retentionPolicy{Folders: []string{"INBOX"}, Policy: "archive after 1y"}, "archive needs a destination"},
+		{retentionPolicy{Folders: []string{"INBOX"}, Policy: "archive after 1y into Archive/{{.Yaer}}"}, "invalid destination template"},
+		{retentionPolicy{Folders: []string{"Trash"}, Policy: "purge after soon"}, "invalid age"},
+		{retentionPolicy{Folders: []string{"Trash"}, Policy: "purge after 14d", Except: []string{"starred"}}, "invalid exception"},
+	}
+	for _, test := range tests {
+		_, err := compileRetention(test.policy, now)
+		assert.ErrorContains(t, err, test.want, test.policy.Policy)
+	}
+}
+
+func TestRetentionMatches(t *testing.T) {
+	policy := compiledRetention{retentionPolicy: retentionPolicy{Folders: []string{"inbox", "Lists/*"}}, keepFlagged: true}
+	assert.True(t, policy.matches("INBOX"))
+	assert.True(t, policy.matches("Lists/go"))
+	assert.False(t, policy.matches("Lists"))
+	assert.False(t, policy.matches("Lists/go/announce"))
+
+	read := &imap.Message{Flags: []string{imap.SeenFlag}}
+	flagged := &imap.Message{Flags: []string{imap.SeenFlag, imap.FlaggedFlag}}
+	unread := &imap.Message{}
+	assert.False(t, policy.keeps(read))
+	assert.True(t, policy.keeps(flagged))
+	assert.False(t, policy.keeps(unread))
+	policy.keepUnread = true
+	assert.True(t, policy.keeps(unread))
+}
+
+func TestLoadCompiledRetention(t *testing.T) {
+	viper.Set("accounts", []map[string]any{{"name": "work", "default": true}})
+	t.Cleanup(func() {
+		viper.Set("accounts", nil)
+		viper.Set("retention", nil)
+	})
+
+	viper.Set("retention", []map[string]any{
+		{"folders": "Trash", "policy": "purge after 14d"},
+		{"account": "work", "folders": []string{"Lists/*", "Notifications"}, "policy": "delete after 30d", "except": "flagged"},
+	})
+	policies, err := loadCompiledRetention(time.Now())
+	assert.NoError(t, err)
+	if assert.Len(t, policies, 2) {
+		assert.Equal(t, stringList{"Trash"}, policies[0].Folders)
+		assert.Equal(t, "default", policies[0].accountName())
+		assert.Equal(t, stringList{"Lists/*", "Notifications"}, policies[1].Folders)
+		assert.True(t, policies[1].keepFlagged)
+	}
+
+	// A misspelled setting must not silently drop an exception.
+	viper.Set("retention", []map[string]any{{"folders": "Trash", "policy": "purge after 14d", "excpt": "flagged"}})
+	_, err = loadCompiledRetention(time.Now())
+	assert.ErrorContains(t, err, "excpt")
+
+	viper.Set("retention", []map[string]any{
+		{"account": "home", "folders": "Trash", "policy": "purge after 14d"},
+		{"folders": "INBOX", "policy": "archive after 1y"},
+	})
+	_, err = loadCompiledRetention(time.Now())
+	assert.ErrorContains(t, err, `retention policy 1: account "home" not found`)
+	assert.ErrorContains(t, err, "retention policy 2: invalid policy")
+
+	viper.Set("retention", nil)
+	_, err = loadCompiledRetention(time.Now())
+	assert.ErrorContains(t, err, "no retention policies")
+}
@@ -6,7 +6,10 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/wryfi/shemail/config"
 	"github.com/wryfi/shemail/logging"
+	"github.com/wryfi/shemail/progress"
+	"golang.org/x/term"
 	"os"
+	"time"
 )
 
 var log = &logging.Logger
@@ -15,17 +18,44 @@ var log = &logging.Logger
 // should skip account/password resolution.
 const noAuthAnnotation = "shemail_no_auth"
 
+// ownAccountsAnnotation marks commands that name the accounts they connect
+// to in their arguments, rather than using --account, and so resolve them
+// with loadAccount.
+const ownAccountsAnnotation = "shemail_own_accounts"
+
 func init() {
 	cobra.OnInitialize(config.InitConfig)
 }
 
+// progressLogInterval is how often progress is logged when stderr is not a
+// terminal.
+const progressLogInterval = 10 * time.Second
+
+// configureProgress installs the progress reporter: live bars when stderr is a
+// terminal, periodic log lines otherwise, or nothing with --no-progress.
+func configureProgress(cmd *cobra.Command) error {
+	disabled, err := cmd.Flags().GetBool("no-progress")
+	if err != nil {
+		return fmt.Errorf("could not get no-progress flag: %v", err)
+	}
+	switch {
+	case disabled:
+		return nil
+	case term.IsTerminal(int(os.Stderr.Fd())):
+		progress.SetReporter(progress.NewTerminalReporter(os.Stderr))
+	default:
+		progress.SetReporter(progress.NewLogReporter(log, progressLogInterval))
+	}
+	return nil
+}
+
 // skipAuth reports whether a command should skip account/password resolution.
 func skipAuth(cmd *cobra.Command) bool {
 	if cmd.Annotations[noAuthAnnotation] == "true" {
 		return true
 	}
 	switch cmd.Name() {
-	case "completion", "help":
+	case "completion", "help", cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
 		return true
 	}
 	return false
@@ -39,46 +69,80 @@ func SheMailCommand() *cobra.Command {
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			// Commands that don't talk to a mailbox (version, config, the
 			// cobra builtins) need neither an account nor a password.
+			// Completions that do load the account themselves.
 			if skipAuth(cmd) {
 				return nil
 			}
 
+			if err := configureProgress(cmd); err != nil {
+				return err
+			}
+			if err := configureDryRun(cmd); err != nil {
+				return err
+			}
+
+			// Commands that name their accounts in their arguments load
+			// them themselves.
+			if cmd.Annotations[ownAccountsAnnotation] == "true" {
+				return nil
+			}
+
 			accountRequest, err := cmd.Flags().GetString("account")
 			if err != nil {
 				return fmt.Errorf("could not get account name: %v", err)
 			}
 			log.Debug().Msgf("requested account %s", accountRequest)
 
-			account, err := getAccount(accountRequest)
-			if err != nil {
-				return fmt.Errorf("failed to find requested account; check your configuration")
-			}
-
-			password, err := resolvePassword(account)
+			account, err := loadAccount(cmd, accountRequest)
 			if err != nil {
 				return err
 			}
-			account.Password = password
 
 			// Store the account in the command's context for subcommands to access
 			cmd.SetContext(context.WithValue(cmd.Context(), "account", account))
 			return nil
 		},
+		PersistentPostRun: func(cmd *cobra.Command, args []string) {
+			printDryRunReport(cmd)
+		},
 	}
 	command.PersistentFlags().StringP("account", "A", "default", "account identifier")
 	command.PersistentFlags().StringVarP(&config.CfgFile, "config", "c", "", "path to config file")
+	command.PersistentFlags().Bool("no-progress", false, "do not report progress of long-running operations")
+	command.PersistentFlags().Bool("no-cache", false, "fetch everything from the server, bypassing the local envelope cache")
+	command.PersistentFlags().Bool("allow-unsafe-expunge", false, "on servers without UIDPLUS, let moves and purges expunge other messages already flagged \\Deleted")
+	command.PersistentFlags().Bool("dry-run", false, "run without changing anything on the server, then report what would have changed")
 	return command
 }
 
 func Execute(cmd *cobra.Command) error {
 	cmd.SetOut(os.Stdout)
 	cmd.AddCommand(ListFolders())
+	cmd.AddCommand(QuotaCommand())
 	cmd.AddCommand(SearchFolder())
 	cmd.AddCommand(CountMessagesBySender())
 	cmd.AddCommand(CreateFolder())
+	cmd.AddCommand(RenameFolder())
+	cmd.AddCommand(RemoveFolder())
+	cmd.AddCommand(SubscribeFolder())
+	cmd.AddCommand(UnsubscribeFolder())
+	cmd.AddCommand(MergeCommand())
 	cmd.AddCommand(EmptyTrash())
 	cmd.AddCommand(Dedupe())
+	cmd.AddCommand(ExportCommand())
+	cmd.AddCommand(ImportCommand())
+	cmd.AddCommand(BackupCommand())
+	cmd.AddCommand(MigrateCommand())
+	cmd.AddCommand(ArchiveCommand())
+	cmd.AddCommand(AttachmentsCommand())
+	cmd.AddCommand(StripAttachmentsCommand())
+	cmd.AddCommand(RulesCommand())
+	cmd.AddCommand(RetentionCommand())
+	cmd.AddCommand(WatchCommand())
+	cmd.AddCommand(HistoryCommand())
+	cmd.AddCommand(UndoCommand())
 	cmd.AddCommand(VersionCommand())
 	cmd.AddCommand(ConfigurationCommand())
+	cmd.AddCommand(CacheCommand())
 	return cmd.Execute()
 }
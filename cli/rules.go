@@ -0,0 +1,671 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/cobra"
+	"github.com/wryfi/shemail/config"
+	"github.com/wryfi/shemail/imaputils"
+	"github.com/wryfi/shemail/util"
+	"gopkg.in/yaml.v3"
+)
+
+// rulesFile is the layout of the rules file.
+type rulesFile struct {
+	Rules []rule `yaml:"rules"`
+}
+
+// rule is one entry of the rules file: the messages to match in some folders
+// of an account, and the actions to run on them, in order.
+type rule struct {
+	Name string `yaml:"name"`
+	// Account is the name of the account; the default account if empty.
+	Account string       `yaml:"account"`
+	Folders []string     `yaml:"folders"`
+	Match   ruleMatch    `yaml:"match"`
+	Actions []ruleAction `yaml:"actions"`
+	// Purge makes delete permanently expunge, like find's --purge.
+	Purge bool `yaml:"purge"`
+	// StopProcessing keeps the messages the rule acted on from being
+	// considered by later rules.
+	StopProcessing bool `yaml:"stop_processing"`
+}
+
+// ruleMatch holds find's search criteria, with the same meaning as the flags
+// of the same names.
+type ruleMatch struct {
+	To           string     `yaml:"to"`
+	From         string     `yaml:"from"`
+	NotTo        string     `yaml:"not_to"`
+	NotFrom      string     `yaml:"not_from"`
+	Subject      stringList `yaml:"subject"`
+	NotSubject   stringList `yaml:"not_subject"`
+	SubjectRegex bool       `yaml:"subject_regex"`
+	After        string     `yaml:"after"`
+	Before       string     `yaml:"before"`
+	// NewerThan and OlderThan are ages such as "30d", relative to when the
+	// rule runs, in place of After and Before.
+	NewerThan   string `yaml:"newer_than"`
+	OlderThan   string `yaml:"older_than"`
+	LargerThan  string `yaml:"larger_than"`
+	SmallerThan string `yaml:"smaller_than"`
+	Read        bool   `yaml:"read"`
+	Unread      bool   `yaml:"unread"`
+	Or          bool   `yaml:"or"`
+}
+
+// isEmpty reports whether the match has no criteria at all, and so would
+// select every message of the folder.
+func (match ruleMatch) isEmpty() bool {
+	return match.To == "" && match.From == "" && match.NotTo == "" && match.NotFrom == "" &&
+		len(match.Subject) == 0 && len(match.NotSubject) == 0 &&
+		match.After == "" && match.Before == "" && match.NewerThan == "" && match.OlderThan == "" &&
+		match.LargerThan == "" && match.SmallerThan == "" && !match.Read && !match.Unread
+}
+
+// stringList is a list of strings that can also be written as a single
+// string, as in "subject: invoice".
+type stringList []string
+
+func (list *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*list = stringList{node.Value}
+		return nil
+	}
+	var values []string
+	if err := node.Decode(&values); err != nil {
+		return err
+	}
+	*list = values
+	return nil
+}
+
+// ruleAction is one action of a rule, named like find's action options:
+// either just the name, as in "delete" or "mark-read", or a name and its
+// value, as in "move: Archive" or "add-keyword: review".
+type ruleAction actionOption
+
+func (action *ruleAction) UnmarshalYAML(node *yaml.Node) error {
+	switch {
+	case node.Kind == yaml.ScalarNode:
+		*action = ruleAction{name: node.Value}
+		return nil
+	case node.Kind == yaml.MappingNode && len(node.Content) == 2 && node.Content[1].Kind == yaml.ScalarNode:
+		*action = ruleAction{name: node.Content[0].Value, value: node.Content[1].Value}
+		return nil
+	}
+	return fmt.Errorf("line %d: an action is a name, such as delete, or a name and a value, such as move: Archive", node.Line)
+}
+
+// valuedActions are the actions that take a value; the others must not.
+var valuedActions = []string{"move", "copy", "add-keyword", "remove-keyword"}
+
+// compiledRule is a rule whose criteria and actions have been checked and
+// converted for running.
+type compiledRule struct {
+	rule
+	searchOpts imaputils.SearchOptions
+	actions    []imaputils.Action
+}
+
+// accountName returns the name of the account the rule applies to.
+func (rule rule) accountName() string {
+	if rule.Account == "" {
+		return "default"
+	}
+	return rule.Account
+}
+
+// compileRule checks rule and converts its criteria and actions, with ages
+// counted back from now.
+func compileRule(rule rule, now time.Time) (compiledRule, error) {
+	if rule.Name == "" {
+		return compiledRule{}, fmt.Errorf("no name")
+	}
+	if len(rule.Folders) == 0 {
+		return compiledRule{}, fmt.Errorf("no folders")
+	}
+	if len(rule.Actions) == 0 {
+		return compiledRule{}, fmt.Errorf("no actions")
+	}
+
+	match := rule.Match
+	if match.isEmpty() {
+		return compiledRule{}, fmt.Errorf("no match criteria; a rule must not act on every message of a folder")
+	}
+	if match.Read && match.Unread {
+		return compiledRule{}, fmt.Errorf("read and unread cannot both be set")
+	}
+	if match.After != "" && match.NewerThan != "" {
+		return compiledRule{}, fmt.Errorf("after and newer_than cannot both be set")
+	}
+	if match.Before != "" && match.OlderThan != "" {
+		return compiledRule{}, fmt.Errorf("before and older_than cannot both be set")
+	}
+	searchOpts, err := buildSearchOptions(match.To, match.From, match.Subject, match.NotTo, match.NotFrom, match.NotSubject,
+		match.After, match.Before, match.LargerThan, match.SmallerThan, match.Read, match.Unread)
+	if err != nil {
+		return compiledRule{}, err
+	}
+	searchOpts.SubjectRegex = match.SubjectRegex
+	if match.NewerThan != "" {
+		cutoff, err := util.AgeCutoff(match.NewerThan, now)
+		if err != nil {
+			return compiledRule{}, err
+		}
+		searchOpts.StartDate = &cutoff
+	}
+	if match.OlderThan != "" {
+		cutoff, err := util.AgeCutoff(match.OlderThan, now)
+		if err != nil {
+			return compiledRule{}, err
+		}
+		searchOpts.EndDate = &cutoff
+	}
+	if _, err := imaputils.SubjectMatcher(searchOpts); err != nil {
+		return compiledRule{}, err
+	}
+
+	given := make([]actionOption, len(rule.Actions))
+	for index, action := range rule.Actions {
+		if valued := slices.Contains(valuedActions, action.name); valued != (action.value != "") {
+			if valued {
+				return compiledRule{}, fmt.Errorf("action %s needs a value, as in %q", action.name, action.name+": ...")
+			}
+			return compiledRule{}, fmt.Errorf("action %s takes no value", action.name)
+		}
+		given[index] = actionOption(action)
+	}
+	actions, err := buildActions(given)
+	if err != nil {
+		return compiledRule{}, err
+	}
+	for _, folder := range rule.Folders {
+		if err := imaputils.ValidateActions(folder, actions); err != nil {
+			return compiledRule{}, err
+		}
+	}
+	return compiledRule{rule: rule, searchOpts: searchOpts, actions: actions}, nil
+}
+
+// loadRules reads the rules file at path.
+func loadRules(path string) ([]rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	// Catch misspelled criteria, which would otherwise widen the match.
+	decoder.KnownFields(true)
+	var file rulesFile
+	if err := decoder.Decode(&file); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return file.Rules, nil
+}
+
+// compileRules checks every rule, and that rule names are unique and accounts
+// exist. It returns the compiled rules, or every problem found.
+func compileRules(rules []rule, now time.Time) ([]compiledRule, []error) {
+	var (
+		compiled []compiledRule
+		problems []error
+	)
+	seen := map[string]bool{}
+	for index, rule := range rules {
+		label := fmt.Sprintf("rule %d", index+1)
+		if rule.Name != "" {
+			label = fmt.Sprintf("rule %q", rule.Name)
+			if seen[rule.Name] {
+				problems = append(problems, fmt.Errorf("%s: another rule has the same name", label))
+			}
+			seen[rule.Name] = true
+		}
+		result, err := compileRule(rule, now)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", label, err))
+			continue
+		}
+		if _, err := getAccount(rule.accountName()); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", label, err))
+			continue
+		}
+		compiled = append(compiled, result)
+	}
+	return compiled, problems
+}
+
+// loadCompiledRules loads and compiles the rules file at path, failing on any
+// problem so that a broken file never runs half its rules.
+func loadCompiledRules(path string) ([]compiledRule, error) {
+	rules, err := loadRules(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no rules in %s", path)
+	}
+	compiled, problems := compileRules(rules, time.Now())
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s has problems (see shemail rules check):\n%w", path, errors.Join(problems...))
+	}
+	return compiled, nil
+}
+
+// ruleResult is the outcome of running a rule on one folder.
+type ruleResult struct {
+	Rule    string `json:"rule"`
+	Account string `json:"account"`
+	Folder  string `json:"folder"`
+	// Matched is the number of messages the actions ran on.
+	Matched int `json:"matched"`
+	// Claimed is the number of messages that matched but were left alone,
+	// because an earlier rule with stop_processing acted on them.
+	Claimed int    `json:"claimed"`
+	Actions string `json:"actions"`
+	Error   string `json:"error,omitempty"`
+
+	// messages are those the actions ran on.
+	messages []*imap.Message
+}
+
+// rulesRunner runs rules in order, keeping the accounts they use and the
+// messages claimed by rules with stop_processing.
+type rulesRunner struct {
+	cmd      *cobra.Command
+	accounts map[string]imaputils.Account
+	claimed  map[string]bool
+	// only, if not nil, restricts the rules to these UIDs.
+	only *imap.SeqSet
+	// progress, if not nil, records how many actions of each rule have run
+	// on each message, so that watch can try a batch again without
+	// repeating copies and other actions that already succeeded.
+	progress map[uint32]map[string]int
+}
+
+func newRulesRunner(cmd *cobra.Command) *rulesRunner {
+	return &rulesRunner{cmd: cmd, accounts: map[string]imaputils.Account{}, claimed: map[string]bool{}}
+}
+
+// restrict limits the rules run from now on to the messages uids, and
+// forgets the messages claimed so far, for watch to handle each batch of new
+// messages as a separate run. The progress of the rules on uids is kept, so
+// that restricting to the same batch again resumes it.
+func (runner *rulesRunner) restrict(uids []uint32) {
+	runner.only = new(imap.SeqSet)
+	runner.only.AddNum(uids...)
+	runner.claimed = map[string]bool{}
+	progress := map[uint32]map[string]int{}
+	for _, uid := range uids {
+		if done, ok := runner.progress[uid]; ok {
+			progress[uid] = done
+		} else {
+			progress[uid] = map[string]int{}
+		}
+	}
+	runner.progress = progress
+}
+
+// account loads the named account, resolving its password once per run.
+func (runner *rulesRunner) account(name string) (imaputils.Account, error) {
+	if account, ok := runner.accounts[name]; ok {
+		return account, nil
+	}
+	account, err := loadAccount(runner.cmd, name)
+	if err != nil {
+		return imaputils.Account{}, err
+	}
+	runner.accounts[name] = account
+	return account, nil
+}
+
+// claimKey identifies message, in folder of account, for stop_processing. A
+// moved message gets a new UID, so it is known by its Message-ID when it has
+// one.
+func claimKey(account, folder string, message *imap.Message) string {
+	if message.Envelope != nil && strings.TrimSpace(message.Envelope.MessageId) != "" {
+		return account + "\x00" + strings.TrimSpace(message.Envelope.MessageId)
+	}
+	return fmt.Sprintf("%s\x00%s\x00%d", account, folder, message.Uid)
+}
+
+// matches returns the messages of folder that rule matches.
+func (runner *rulesRunner) matches(rule compiledRule, account imaputils.Account, folder string) ([]*imap.Message, error) {
+	criteria := imaputils.BuildSearchCriteria(rule.searchOpts)
+	if rule.Match.Or {
+		criteria = imaputils.BuildORSearchCriteria(rule.searchOpts)
+	}
+	var stream imaputils.MessageStream
+	if runner.only != nil {
+		// A batch of watch is a few new messages known by UID, which the
+		// cache could not answer anyway: go straight to the server.
+		criteria.Uid = runner.only
+		stream = imaputils.StreamMessages(dialer, account, folder, criteria, imaputils.DefaultChunkSize)
+	} else {
+		var err error
+		if stream, err = messageStream(runner.cmd, account, folder, criteria); err != nil {
+			return nil, err
+		}
+	}
+	messages, err := imaputils.CollectMessages(stream)
+	if err != nil {
+		return nil, fmt.Errorf("error searching folder %s: %w", folder, err)
+	}
+	messages, err = imaputils.FilterBySubject(messages, rule.searchOpts)
+	if err != nil {
+		return nil, fmt.Errorf("error filtering by subject: %w", err)
+	}
+	imaputils.SortMessages(messages, imaputils.SortDate, false)
+	return messages, nil
+}
+
+// run runs rule on each of its folders, leaving out messages claimed by
+// earlier rules, and returns a result per folder.
+func (runner *rulesRunner) run(rule compiledRule) []ruleResult {
+	var results []ruleResult
+	for _, folder := range rule.Folders {
+		result := ruleResult{Rule: rule.Name, Account: rule.accountName(), Folder: folder}
+		account, err := runner.account(rule.accountName())
+		if err == nil {
+			account.Purge = account.Purge || rule.Purge
+			result.Account = account.Name
+			result.Actions = imaputils.DescribeActions(rule.actions, account.Purge)
+			err = runner.runFolder(rule, account, folder, &result)
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (runner *rulesRunner) runFolder(rule compiledRule, account imaputils.Account, folder string, result *ruleResult) error {
+	messages, err := runner.matches(rule, account, folder)
+	if err != nil {
+		return err
+	}
+	messages = slices.DeleteFunc(messages, func(message *imap.Message) bool {
+		if runner.claimed[claimKey(account.Name, folder, message)] {
+			result.Claimed++
+			return true
+		}
+		return false
+	})
+	if len(messages) == 0 {
+		return nil
+	}
+
+	if runner.progress == nil {
+		if err := imaputils.RunActions(dialer, account, folder, messages, rule.actions, operationJournal()); err != nil {
+			return err
+		}
+	} else {
+		// Messages the rule already finished with in an earlier attempt are
+		// not acted on again, but still count as matched and claimed.
+		var done []*imap.Message
+		messages = slices.DeleteFunc(messages, func(message *imap.Message) bool {
+			if runner.progress[message.Uid][rule.Name] >= len(rule.actions) {
+				done = append(done, message)
+				return true
+			}
+			return false
+		})
+		if err := runner.resumeActions(rule, account, folder, slices.Clone(messages)); err != nil {
+			return err
+		}
+		messages = append(messages, done...)
+	}
+	result.Matched = len(messages)
+	result.messages = messages
+	runner.claim(rule, account, folder, messages)
+	return nil
+}
+
+// claim marks messages, acted on by rule, as claimed if the rule has
+// stop_processing.
+func (runner *rulesRunner) claim(rule compiledRule, account imaputils.Account, folder string, messages []*imap.Message) {
+	if rule.StopProcessing {
+		for _, message := range messages {
+			runner.claimed[claimKey(account.Name, folder, message)] = true
+		}
+	}
+}
+
+// resumeActions runs the actions of rule on messages, skipping for each
+// message those that already ran on it, and records its progress. Actions
+// run one at a time up to the first move; from there on they run as one
+// pipeline, since the messages leave folder and are not found there again.
+func (runner *rulesRunner) resumeActions(rule compiledRule, account imaputils.Account, folder string, messages []*imap.Message) error {
+	for len(messages) > 0 {
+		start := runner.progress[messages[0].Uid][rule.Name]
+		var batch []*imap.Message
+		messages = slices.DeleteFunc(messages, func(message *imap.Message) bool {
+			if runner.progress[message.Uid][rule.Name] == start {
+				batch = append(batch, message)
+				return true
+			}
+			return false
+		})
+		for start < len(rule.actions) {
+			end := start + 1
+			if rule.actions[start].Kind == imaputils.ActionMove {
+				end = len(rule.actions)
+			}
+			if err := imaputils.RunActions(dialer, account, folder, batch, rule.actions[start:end], operationJournal()); err != nil {
+				return err
+			}
+			start = end
+			for _, message := range batch {
+				runner.progress[message.Uid][rule.Name] = start
+			}
+		}
+	}
+	return nil
+}
+
+// rulesReport sums up the results of "shemail rules run"; it is also the
+// JSON form of the report.
+type rulesReport struct {
+	DryRun  bool         `json:"dry_run"`
+	Matched int          `json:"matched"`
+	Failed  int          `json:"failed"`
+	Results []ruleResult `json:"results"`
+}
+
+func newRulesReport(results []ruleResult) rulesReport {
+	report := rulesReport{DryRun: isDryRun(), Results: results}
+	if report.Results == nil {
+		report.Results = []ruleResult{}
+	}
+	for _, result := range results {
+		report.Matched += result.Matched
+		if result.Error != "" {
+			report.Failed++
+		}
+	}
+	return report
+}
+
+// print prints the report as a table, or as JSON when output is "json".
+func (report rulesReport) print(cmd *cobra.Command, output string) error {
+	if output == "json" {
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	rows := make([][]string, len(report.Results))
+	for index, result := range report.Results {
+		status := "ok"
+		switch {
+		case result.Error != "":
+			status = "failed: " + result.Error
+		case result.Claimed > 0:
+			status = fmt.Sprintf("ok (%d already handled by an earlier rule)", result.Claimed)
+		}
+		rows[index] = []string{result.Rule, result.Account + ":" + result.Folder, fmt.Sprint(result.Matched), result.Actions, status}
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), util.RenderTable([]string{"Rule", "Folder", "Matched", "Actions", "Result"}, rows, 2))
+	fmt.Fprintf(cmd.OutOrStdout(), "%d messages matched in %d folders", report.Matched, len(report.Results))
+	if report.Failed > 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "; %d failed", report.Failed)
+	}
+	fmt.Fprintln(cmd.OutOrStdout())
+	return nil
+}
+
+// RulesCommand generates a command to check, test and run the rules file.
+func RulesCommand() *cobra.Command {
+	var path string
+	cmd := &cobra.Command{
+		Use:   "rules",
+		Short: "check, test and run the cleanup rules in the rules file",
+		Long: `Check, test and run the rules file, a YAML file (by default shemail-rules.yaml
+next to the configuration file, or the rules_file setting) listing cleanup
+rules. Each rule names an account, folders, find's criteria and the actions to
+run on the matches, in order; rules run in the order of the file.`,
+	}
+	cmd.PersistentFlags().StringVar(&path, "file", "", "rules file (default: the rules_file setting, or shemail-rules.yaml next to the config file)")
+	rulesPath := func() string {
+		if path != "" {
+			return path
+		}
+		return config.RulesFile()
+	}
+	cmd.AddCommand(rulesCheck(rulesPath), rulesTest(rulesPath), rulesRun(rulesPath))
+	return cmd
+}
+
+func rulesCheck(rulesPath func() string) *cobra.Command {
+	return &cobra.Command{
+		Use:         "check",
+		Short:       "validate the rules file without connecting to any server",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{noAuthAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := rulesPath()
+			rules, err := loadRules(path)
+			if err != nil {
+				return err
+			}
+			if len(rules) == 0 {
+				return fmt.Errorf("no rules in %s", path)
+			}
+			_, problems := compileRules(rules, time.Now())
+			for _, problem := range problems {
+				fmt.Fprintln(cmd.OutOrStdout(), problem)
+			}
+			if len(problems) > 0 {
+				return fmt.Errorf("found %d problems in %s", len(problems), path)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s: %d rules ok\n", path, len(rules))
+			return nil
+		},
+	}
+}
+
+func rulesTest(rulesPath func() string) *cobra.Command {
+	return &cobra.Command{
+		Use:         "test <rule>",
+		Short:       "list the messages a rule matches, without acting on them",
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{ownAccountsAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rules, err := loadCompiledRules(rulesPath())
+			if err != nil {
+				return err
+			}
+			index := slices.IndexFunc(rules, func(rule compiledRule) bool { return rule.Name == args[0] })
+			if index < 0 {
+				return fmt.Errorf("no rule named %q in %s", args[0], rulesPath())
+			}
+			rule := rules[index]
+
+			runner := newRulesRunner(cmd)
+			account, err := runner.account(rule.accountName())
+			if err != nil {
+				return err
+			}
+			account.Purge = account.Purge || rule.Purge
+			for _, folder := range rule.Folders {
+				messages, err := runner.matches(rule, account, folder)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s:%s: %d messages would be acted on (%s)\n",
+					account.Name, folder, len(messages), imaputils.DescribeActions(rule.actions, account.Purge))
+				if len(messages) == 0 {
+					continue
+				}
+				rendered, err := util.RenderMessages(messages)
+				if err != nil {
+					return fmt.Errorf("error rendering messages: %w", err)
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), rendered)
+			}
+			return nil
+		},
+	}
+}
+
+func rulesRun(rulesPath func() string) *cobra.Command {
+	var (
+		only   []string
+		output string
+	)
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "run the rules, in order, and report what each did",
+		Long: `Run the rules of the rules file in order, without prompting, and print how
+many messages each rule acted on in each folder. Messages acted on by a rule
+with stop_processing are left alone by the rules after it. A rule that fails
+does not stop the others, but makes the command fail.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{ownAccountsAnnotation: "true"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "text" && output != "json" {
+				return fmt.Errorf("invalid output %q (expected text or json)", output)
+			}
+			rules, err := loadCompiledRules(rulesPath())
+			if err != nil {
+				return err
+			}
+			for _, name := range only {
+				if !slices.ContainsFunc(rules, func(rule compiledRule) bool { return rule.Name == name }) {
+					return fmt.Errorf("no rule named %q in %s", name, rulesPath())
+				}
+			}
+
+			runner := newRulesRunner(cmd)
+			var results []ruleResult
+			for _, rule := range rules {
+				if len(only) > 0 && !slices.Contains(only, rule.Name) {
+					continue
+				}
+				results = append(results, runner.run(rule)...)
+			}
+
+			report := newRulesReport(results)
+			if err := report.print(cmd, output); err != nil {
+				return err
+			}
+			if report.Failed > 0 {
+				return fmt.Errorf("%d of %d rule runs failed", report.Failed, len(results))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringArrayVar(&only, "rule", nil, "run only the rule with this name (repeatable)")
+	cmd.Flags().StringVar(&output, "output", "text", "report format: text or json")
+	return cmd
+}
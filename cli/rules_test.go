@@ -0,0 +1,153 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/wryfi/shemail/imaputils"
+)
+
+func writeRules(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "shemail-rules.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadRules(t *testing.T) {
+	path := writeRules(t, `
+rules:
+  - name: notifications
+    account: work
+    folders: [INBOX]
+    match:
+      from: notifications@example.com
+      subject: build
+      older_than: 30d
+    actions:
+      - mark-read
+      - add-keyword: ci
+      - move: Archive/CI
+    stop_processing: true
+`)
+	rules, err := loadRules(path)
+	assert.NoError(t, err)
+	if assert.Len(t, rules, 1) {
+		assert.Equal(t, "notifications", rules[0].Name)
+		assert.Equal(t, "work", rules[0].accountName())
+		assert.Equal(t, stringList{"build"}, rules[0].Match.Subject)
+		assert.Equal(t, []ruleAction{{name: "mark-read"}, {name: "add-keyword", value: "ci"}, {name: "move", value: "Archive/CI"}}, rules[0].Actions)
+		assert.True(t, rules[0].StopProcessing)
+	}
+
+	// A misspelled criterion must not silently widen the match.
+	_, err = loadRules(writeRules(t, "rules:\n  - name: typo\n    match:\n      form: someone\n"))
+	assert.ErrorContains(t, err, "form")
+
+	_, err = loadRules(writeRules(t, "rules:\n  - name: bad\n    actions:\n      - [move, Archive]\n"))
+	assert.ErrorContains(t, err, "an action is a name")
+
+	rules, err = loadRules(writeRules(t, ""))
+	assert.NoError(t, err)
+	assert.Empty(t, rules)
+}
+
+func TestCompileRule(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	valid := rule{
+		Name:    "old newsletters",
+		Folders: []string{"INBOX"},
+		Match:   ruleMatch{From: "news@example.com", OlderThan: "30d", Read: true},
+		Actions: []ruleAction{{name: "move", value: "Newsletters"}},
+	}
+
+	compiled, err := compileRule(valid, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "news@example.com", *compiled.searchOpts.From)
+	assert.Equal(t, time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC), *compiled.searchOpts.EndDate)
+	assert.True(t, *compiled.searchOpts.Seen)
+	assert.Equal(t, []imaputils.Action{{Kind: imaputils.ActionMove, Folder: "Newsletters"}}, compiled.actions)
+
+	tests := []struct {
+		name   string
+		change func(rule *rule)
+		want   string
+	}{
+		{"no name", func(rule *rule) { rule.Name = "" }, "no name"},
+		{"no folders", func(rule *rule) { rule.Folders = nil }, "no folders"},
+		{"no actions", func(rule *rule) { rule.Actions = nil }, "no actions"},
+		{"no criteria", func(rule *rule) { rule.Match = ruleMatch{Or: true} }, "no match criteria"},
+		{"read and unread", func(rule *rule) { rule.Match.Unread = true }, "cannot both be set"},
+		{"before and older_than", func(rule *rule) { rule.Match.Before = "2024-01-01" }, "cannot both be set"},
+		{"bad age", func(rule *rule) { rule.Match.OlderThan = "30" }, "invalid age"},
+		{"bad regex", func(rule *rule) { rule.Match.Subject, rule.Match.SubjectRegex = stringList{"("}, true }, "error parsing regexp"},
+		{"unknown action", func(rule *rule) { rule.Actions = []ruleAction{{name: "shred"}} }, "unknown action"},
+		{"missing value", func(rule *rule) { rule.Actions = []ruleAction{{name: "move"}} }, "needs a value"},
+		{"unexpected value", func(rule *rule) { rule.Actions = []ruleAction{{name: "delete", value: "now"}} }, "takes no value"},
+		{"delete not last", func(rule *rule) {
+			rule.Actions = []ruleAction{{name: "delete"}, {name: "mark-read"}}
+		}, "delete must be the last action"},
+		{"move into its own folder", func(rule *rule) { rule.Actions = []ruleAction{{name: "move", value: "INBOX"}} }, "already there"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broken := valid
+			broken.Match.Subject = nil
+			tt.change(&broken)
+			_, err := compileRule(broken, now)
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
+
+func TestCompileRules(t *testing.T) {
+	viper.Set("accounts", []map[string]any{{"name": "work", "default": true}})
+	t.Cleanup(func() { viper.Set("accounts", nil) })
+
+	base := rule{
+		Folders: []string{"INBOX"},
+		Match:   ruleMatch{Unread: true},
+		Actions: []ruleAction{{name: "flag"}},
+	}
+	first, duplicate, unknownAccount, broken := base, base, base, base
+	first.Name, duplicate.Name, unknownAccount.Name, broken.Name = "one", "one", "two", "three"
+	unknownAccount.Account = "personal"
+	broken.Folders = nil
+
+	compiled, problems := compileRules([]rule{first, duplicate, unknownAccount, broken}, time.Now())
+	assert.Len(t, compiled, 2)
+	if assert.Len(t, problems, 3) {
+		assert.ErrorContains(t, problems[0], `rule "one": another rule has the same name`)
+		assert.ErrorContains(t, problems[1], `rule "two": account "personal" not found`)
+		assert.ErrorContains(t, problems[2], `rule "three": no folders`)
+	}
+}
+
+func TestClaimKey(t *testing.T) {
+	withID := &imap.Message{Uid: 7, Envelope: &imap.Envelope{MessageId: "<a@example.com>"}}
+	withoutID := &imap.Message{Uid: 7, Envelope: &imap.Envelope{}}
+
+	// A message keeps its Message-ID when a rule moves it to another folder.
+	assert.Equal(t, claimKey("work", "INBOX", withID), claimKey("work", "Archive", withID))
+	assert.NotEqual(t, claimKey("work", "INBOX", withID), claimKey("home", "INBOX", withID))
+	assert.NotEqual(t, claimKey("work", "INBOX", withoutID), claimKey("work", "Archive", withoutID))
+}
+
+func TestRestrictKeepsProgress(t *testing.T) {
+	runner := newRulesRunner(nil)
+	runner.restrict([]uint32{1, 2})
+	runner.progress[1]["archive"] = 1
+	runner.claimed["key"] = true
+
+	// Trying the same batch again resumes it; other batches start afresh.
+	runner.restrict([]uint32{1, 2})
+	assert.Equal(t, map[uint32]map[string]int{1: {"archive": 1}, 2: {}}, runner.progress)
+	assert.Empty(t, runner.claimed)
+	runner.restrict([]uint32{3})
+	assert.Equal(t, map[uint32]map[string]int{3: {}}, runner.progress)
+}
@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/cobra"
+	"github.com/wryfi/shemail/imaputils"
+)
+
+// searchFlags are the message selection options of find, shared with the
+// commands that act on the same kind of selection, such as export.
+type searchFlags struct {
+	endDate      string
+	from         string
+	or           bool
+	startDate    string
+	subject      []string
+	to           string
+	notFrom      string
+	notSubject   []string
+	notTo        string
+	unread       bool
+	read         bool
+	largerThan   string
+	smallerThan  string
+	subjectRegex bool
+}
+
+// register defines the search options on cmd.
+func (search *searchFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&search.to, "to", "t", "", "find messages to this address")
+	cmd.Flags().StringVarP(&search.from, "from", "f", "", "find messages from this address")
+	cmd.Flags().StringArrayVarP(&search.subject, "subject", "s", nil, "match subject (repeatable; matches if any matches)")
+	cmd.Flags().StringVar(&search.notTo, "not-to", "", "exclude messages to this address")
+	cmd.Flags().StringVar(&search.notFrom, "not-from", "", "exclude messages from this address")
+	cmd.Flags().StringArrayVar(&search.notSubject, "not-subject", nil, "exclude messages whose subject matches (repeatable; excludes if any matches)")
+	cmd.Flags().BoolVar(&search.subjectRegex, "subject-regex", false, "treat --subject and --not-subject as regular expressions")
+	cmd.Flags().StringVarP(&search.startDate, "after", "a", "", "find messages received after date (format: `2006-01-02`)")
+	cmd.Flags().StringVarP(&search.endDate, "before", "b", "", "find messages received before date (format: `2006-01-02`)")
+	cmd.Flags().StringVar(&search.largerThan, "larger-than", "", "find messages larger than this size (e.g. 500K, 10M)")
+	cmd.Flags().StringVar(&search.smallerThan, "smaller-than", "", "find messages smaller than this size (e.g. 500K, 10M)")
+	cmd.Flags().BoolVarP(&search.unread, "unread", "u", false, "find only unread messages")
+	cmd.Flags().BoolVarP(&search.read, "read", "r", false, "find only read messages")
+	cmd.Flags().BoolVarP(&search.or, "or", "o", false, "OR search criteria instead of AND")
+	// --read and --unread are contradictory: requiring both Seen and not-Seen
+	// matches nothing. Reject the combination up front instead of silently
+	// returning zero results.
+	cmd.MarkFlagsMutuallyExclusive("read", "unread")
+}
+
+// options builds the search options the flags describe.
+func (search *searchFlags) options() (imaputils.SearchOptions, error) {
+	searchOpts, err := buildSearchOptions(search.to, search.from, search.subject, search.notTo, search.notFrom, search.notSubject,
+		search.startDate, search.endDate, search.largerThan, search.smallerThan, search.read, search.unread)
+	if err != nil {
+		return imaputils.SearchOptions{}, fmt.Errorf("error building search options: %v", err)
+	}
+	searchOpts.SubjectRegex = search.subjectRegex
+	return searchOpts, nil
+}
+
+// criteria builds the server-side search criteria for searchOpts, combined
+// with OR when --or was given.
+func (search *searchFlags) criteria(searchOpts imaputils.SearchOptions) *imap.SearchCriteria {
+	if search.or {
+		return imaputils.BuildORSearchCriteria(searchOpts)
+	}
+	return imaputils.BuildSearchCriteria(searchOpts)
+}
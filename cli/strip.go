@@ -0,0 +1,213 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/cobra"
+	"github.com/wryfi/shemail/imaputils"
+	"github.com/wryfi/shemail/util"
+)
+
+// StripAttachmentsCommand generates a command to replace the attachments of
+// the messages of a folder that match find's criteria with short text stubs.
+func StripAttachmentsCommand() *cobra.Command {
+	var (
+		search    searchFlags
+		filter    imaputils.AttachmentFilter
+		extracted string
+		apply     bool
+		assumeYes bool
+		purge     bool
+	)
+	cmd := &cobra.Command{
+		Use:   "strip-attachments <folder>",
+		Short: "replace the attachments of matching messages with text stubs to save space",
+		Long: `Replace the attachments of the messages of a folder that match find's criteria,
+chosen with --name and --type as for "shemail attachments", with a short text
+part giving the file name, type and size of what was removed. The text and
+all other parts of the messages are kept as they are.
+
+Without --apply nothing is changed: the messages that would be stripped are
+listed with the space it would reclaim. With --apply each message is rebuilt,
+APPENDed to the folder with its original flags and date, and the original is
+deleted like find's --delete: moved to the trash, or expunged with --purge.
+
+With --extracted, stubs also name the file each attachment was saved as by an
+earlier "shemail attachments --out DIR".`,
+		Args: validateFolderArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			account := cmd.Context().Value("account").(imaputils.Account)
+			folder := args[0]
+			if err := filter.Validate(); err != nil {
+				return err
+			}
+			searchOpts, err := search.options()
+			if err != nil {
+				return err
+			}
+			var store *imaputils.AttachmentStore
+			if extracted != "" {
+				// Read only: the store is never written here.
+				if store, err = imaputils.OpenAttachmentStore(extracted, true); err != nil {
+					return err
+				}
+			}
+
+			stream, err := messageStream(cmd, account, folder, search.criteria(searchOpts))
+			if err != nil {
+				return err
+			}
+			messages, err := imaputils.CollectMessages(stream)
+			if err != nil {
+				return fmt.Errorf("error searching folder %s: %w", folder, err)
+			}
+			messages, err = imaputils.FilterBySubject(messages, searchOpts)
+			if err != nil {
+				return fmt.Errorf("error filtering by subject: %w", err)
+			}
+			uids := make([]uint32, len(messages))
+			for index, message := range messages {
+				uids[index] = message.Uid
+			}
+			plans, err := imaputils.PlanStrip(dialer, account, folder, uids, filter)
+			if err != nil {
+				return err
+			}
+			if len(plans) == 0 {
+				fmt.Printf("no matching messages with attachments in %s\n", folder)
+				return nil
+			}
+			printStripPlans(plans)
+			if !apply {
+				fmt.Println("nothing was changed; run again with --apply to strip these attachments")
+				return nil
+			}
+
+			planned := make([]*imap.Message, len(plans))
+			for index, plan := range plans {
+				planned[index] = plan.Message
+			}
+			account.Purge = account.Purge || purge
+			var expunge imaputils.ExpungePlan
+			if account.Purge {
+				if expunge, err = planPurge(account, folder, planned, assumeYes); err != nil {
+					return err
+				}
+			}
+			targets, proceed, err := resolveActionTargets(planned, "strip attachments from", true, assumeYes, len(expunge.Others))
+			if err != nil || !proceed {
+				return err
+			}
+			account.AllowUnsafeExpunge = account.AllowUnsafeExpunge || len(expunge.Others) > 0
+			return stripAttachments(account, folder, selectedPlans(plans, targets), store)
+		},
+	}
+	search.register(cmd)
+	cmd.Flags().StringArrayVar(&filter.Names, "name", nil, "strip only attachments whose file name matches this pattern, e.g. '*.pdf' (repeatable)")
+	cmd.Flags().StringArrayVar(&filter.Types, "type", nil, "strip only attachments of this content type, e.g. application/pdf or image/* (repeatable)")
+	cmd.Flags().StringVar(&extracted, "extracted", "", "directory the attachments were saved to with \"shemail attachments\", to name the saved files in the stubs")
+	cmd.Flags().BoolVar(&apply, "apply", false, "strip the attachments; without it, only show what would be stripped")
+	cmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "with --apply, skip the interactive picker and strip all matches")
+	cmd.Flags().BoolVarP(&purge, "purge", "p", false, "permanently expunge the original messages instead of moving them to trash")
+	return cmd
+}
+
+// printStripPlans lists the messages to strip, and the space stripping them
+// would reclaim.
+func printStripPlans(plans []imaputils.StripPlan) {
+	rows := make([][]string, 0, len(plans))
+	attachments := 0
+	var reclaim int64
+	for _, plan := range plans {
+		names := make([]string, len(plan.Attachments))
+		for index, attachment := range plan.Attachments {
+			names[index] = attachment.Filename
+		}
+		from := ""
+		subject := ""
+		if envelope := plan.Message.Envelope; envelope != nil {
+			subject = envelope.Subject
+			if len(envelope.From) > 0 {
+				from = envelope.From[0].Address()
+			}
+		}
+		rows = append(rows, []string{plan.Message.InternalDate.Local().Format("2006-01-02"), util.TruncateString(from, 30),
+			util.TruncateString(subject, 40), util.TruncateString(strings.Join(names, ", "), 40),
+			util.FormatSize(plan.Message.Size), util.FormatSize(plan.Reclaim())})
+		attachments += len(plan.Attachments)
+		reclaim += plan.Reclaim()
+	}
+	fmt.Println(util.RenderTable([]string{"Date", "From", "Subject", "Attachments", "Size", "Reclaims"}, rows, 4, 5))
+	fmt.Printf("%d attachments in %d messages; stripping them would reclaim about %s\n", attachments, len(plans), util.FormatSize(reclaim))
+}
+
+// selectedPlans returns the plans for the messages in targets.
+func selectedPlans(plans []imaputils.StripPlan, targets []*imap.Message) []imaputils.StripPlan {
+	selected := map[uint32]bool{}
+	for _, message := range targets {
+		selected[message.Uid] = true
+	}
+	var kept []imaputils.StripPlan
+	for _, plan := range plans {
+		if selected[plan.Message.Uid] {
+			kept = append(kept, plan)
+		}
+	}
+	return kept
+}
+
+// stripAttachments appends a stripped copy of each planned message of folder,
+// then deletes the originals that were replaced, even if stripping stopped
+// part way, so that no message is left twice.
+func stripAttachments(account imaputils.Account, folder string, plans []imaputils.StripPlan, store *imaputils.AttachmentStore) error {
+	result, err := imaputils.StripAttachments(dialer, account, folder, plans, attachmentStub(account, folder, store))
+	if result.Skipped > 0 {
+		fmt.Printf("%d messages were left as they are; see the warnings above\n", result.Skipped)
+	}
+	if len(result.Replaced) == 0 {
+		return err
+	}
+	if deleteErr := imaputils.RunActions(dialer, account, folder, result.Replaced, []imaputils.Action{{Kind: imaputils.ActionDelete}}, operationJournal()); deleteErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to delete the originals of stripped messages, which are now in %s twice: %w", folder, deleteErr))
+	}
+
+	if !isDryRun() {
+		fmt.Printf("stripped %d attachments from %d messages in %s, reclaiming %s", result.Attachments, len(result.Replaced), folder,
+			util.FormatSize(max(result.Before-result.After, 0)))
+		if !account.Purge {
+			fmt.Print(" once the trash is emptied")
+		}
+		fmt.Println()
+	}
+	return err
+}
+
+// attachmentStub returns the text that replaces each stripped attachment of a
+// message in folder of account, naming the file it was saved as if store
+// has it.
+func attachmentStub(account imaputils.Account, folder string, store *imaputils.AttachmentStore) func(message *imap.Message, attachment imaputils.Attachment) string {
+	today := time.Now().Format("2006-01-02")
+	return func(message *imap.Message, attachment imaputils.Attachment) string {
+		var stub strings.Builder
+		fmt.Fprintf(&stub, "This attachment was removed on %s to save space.\n\n", today)
+		fmt.Fprintf(&stub, "File: %s\n", attachment.Filename)
+		fmt.Fprintf(&stub, "Type: %s\n", attachment.MIMEType)
+		fmt.Fprintf(&stub, "Size: about %s\n", util.FormatSize(attachment.DecodedSize()))
+		if store != nil && message.Envelope != nil {
+			saved, ok := store.Find(account.Name, folder, message.Envelope.MessageId, attachment.PartName())
+			if ok {
+				path := filepath.Join(store.Dir(), saved.Path)
+				if absolute, err := filepath.Abs(path); err == nil {
+					path = absolute
+				}
+				fmt.Fprintf(&stub, "Saved as: %s\n", path)
+			}
+		}
+		return stub.String()
+	}
+}
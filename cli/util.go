@@ -49,6 +49,42 @@ func getAccount(identifier string) (imaputils.Account, error) {
 	return imaputils.Account{}, fmt.Errorf("account %q not found", identifier)
 }
 
+// requestedAccount looks up the account named by --account, for commands
+// that skip the usual account resolution because they work offline.
+func requestedAccount(cmd *cobra.Command) (imaputils.Account, error) {
+	accountRequest, err := cmd.Flags().GetString("account")
+	if err != nil {
+		return imaputils.Account{}, fmt.Errorf("could not get account name: %v", err)
+	}
+	account, err := getAccount(accountRequest)
+	if err != nil {
+		return imaputils.Account{}, fmt.Errorf("failed to find requested account; check your configuration")
+	}
+	return account, nil
+}
+
+// loadAccount looks up the account named identifier and resolves its
+// password, applying --allow-unsafe-expunge.
+func loadAccount(cmd *cobra.Command, identifier string) (imaputils.Account, error) {
+	account, err := getAccount(identifier)
+	if err != nil {
+		return imaputils.Account{}, fmt.Errorf("failed to find requested account; check your configuration")
+	}
+
+	password, err := resolvePassword(account)
+	if err != nil {
+		return imaputils.Account{}, err
+	}
+	account.Password = password
+
+	allowUnsafeExpunge, err := cmd.Flags().GetBool("allow-unsafe-expunge")
+	if err != nil {
+		return imaputils.Account{}, fmt.Errorf("could not get allow-unsafe-expunge flag: %v", err)
+	}
+	account.AllowUnsafeExpunge = account.AllowUnsafeExpunge || allowUnsafeExpunge
+	return account, nil
+}
+
 // resolvePassword determines an account's password using, in order of
 // precedence: the SHEMAIL_<NAME>_PASSWORD environment variable, a literal
 // password from configuration, or the first line of output from the account's
@@ -0,0 +1,229 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/wryfi/shemail/config"
+	"github.com/wryfi/shemail/imaputils"
+)
+
+// WatchCommand generates a command that waits for new mail in a folder and
+// runs find's actions, or the rules of the rules file, on each message as it
+// arrives.
+func WatchCommand() *cobra.Command {
+	var (
+		search       searchFlags
+		actionOpts   actionOptions
+		purge        bool
+		useRules     bool
+		rulesPath    string
+		pollInterval time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "watch <folder>",
+		Short: "act on new messages in a folder as they arrive, using IMAP IDLE",
+		Long: `Wait for new messages in a folder and act on each as it arrives, like a
+server-side filter: either with find's criteria and actions, or, with --rules,
+with the rules of the rules file that apply to this account and folder.
+Messages already in the folder are left alone.
+
+watch holds a connection in IMAP IDLE (re-issued every 25 minutes, before
+servers drop idle clients), or polls every --poll-interval on servers without
+IDLE. It reconnects after a lost connection, handling whatever arrived in the
+meantime, logs what it decides for every message, and exits cleanly on SIGINT
+or SIGTERM, so it can run as a systemd service. New messages a rule fails on
+are tried again a few times, without repeating the actions that succeeded,
+and then skipped with an error in the log.`,
+		Args: validateFolderArg,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			account := cmd.Context().Value("account").(imaputils.Account)
+			folder := args[0]
+			if pollInterval <= 0 {
+				return fmt.Errorf("--poll-interval must be positive")
+			}
+
+			var loadRules func() ([]compiledRule, error)
+			if useRules {
+				var conflicting []string
+				own := cmd.LocalNonPersistentFlags()
+				cmd.Flags().Visit(func(flag *pflag.Flag) {
+					if own.Lookup(flag.Name) != nil && !slices.Contains([]string{"rules", "rules-file", "poll-interval"}, flag.Name) {
+						conflicting = append(conflicting, "--"+flag.Name)
+					}
+				})
+				if len(conflicting) > 0 {
+					return fmt.Errorf("--rules cannot be combined with %v; put the criteria and actions in a rule", conflicting)
+				}
+				if rulesPath == "" {
+					rulesPath = config.RulesFile()
+				}
+				loadRules = func() ([]compiledRule, error) {
+					return watchedRules(rulesPath, account, folder)
+				}
+			} else {
+				rule, err := watchRule(&search, actionOpts.given, account, folder, purge)
+				if err != nil {
+					return err
+				}
+				loadRules = func() ([]compiledRule, error) {
+					return []compiledRule{rule}, nil
+				}
+			}
+			// Check the rules before waiting for the first message.
+			rules, err := loadRules()
+			if err != nil {
+				return err
+			}
+			if len(rules) == 0 {
+				return fmt.Errorf("no rule in %s applies to %s:%s", rulesPath, account.Name, folder)
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			runner := newRulesRunner(cmd)
+			runner.accounts[account.Name] = account
+			handle := func(uids []uint32) error {
+				// Reload each time, so that ages such as older_than stay
+				// relative to now.
+				rules, err := loadRules()
+				if err != nil {
+					return err
+				}
+				return handleNewMessages(runner, rules, folder, uids)
+			}
+
+			options := imaputils.DefaultWatchOptions()
+			options.PollInterval = pollInterval
+			log.Log().Msgf("watching %s:%s for new messages", account.Name, folder)
+			if err := imaputils.WatchFolder(ctx, dialer, account, folder, options, handle); err != nil {
+				return fmt.Errorf("failed to watch %s: %w", folder, err)
+			}
+			log.Log().Msgf("stopped watching %s:%s", account.Name, folder)
+			return nil
+		},
+	}
+	search.register(cmd)
+	actionOpts.stringVar(cmd.Flags(), "move", "m", "move new messages to <folder>")
+	actionOpts.stringVar(cmd.Flags(), "copy", "", "copy new messages to <folder>")
+	actionOpts.boolVar(cmd.Flags(), "delete", "d", "delete new messages (must be the last action)")
+	cmd.Flags().BoolVarP(&purge, "purge", "p", false, "with --delete, permanently expunge messages instead of moving them to trash")
+	actionOpts.boolVar(cmd.Flags(), "mark-read", "", "mark new messages as read (\\Seen)")
+	actionOpts.boolVar(cmd.Flags(), "mark-unread", "", "mark new messages as unread")
+	actionOpts.boolVar(cmd.Flags(), "flag", "", "flag new messages (\\Flagged)")
+	actionOpts.boolVar(cmd.Flags(), "unflag", "", "remove the \\Flagged flag from new messages")
+	actionOpts.boolVar(cmd.Flags(), "mark-answered", "", "mark new messages as answered (\\Answered)")
+	actionOpts.stringVar(cmd.Flags(), "add-keyword", "", "add a keyword (e.g. review) to new messages (repeatable)")
+	actionOpts.stringVar(cmd.Flags(), "remove-keyword", "", "remove a keyword from new messages (repeatable)")
+	cmd.Flags().BoolVar(&useRules, "rules", false, "apply the rules of the rules file that name this account and folder, instead of criteria and actions")
+	cmd.Flags().StringVar(&rulesPath, "rules-file", "", "with --rules, the rules file (default: the rules_file setting, or shemail-rules.yaml next to the config file)")
+	cmd.Flags().DurationVar(&pollInterval, "poll-interval", time.Minute, "how often to check for new messages on servers without IDLE")
+	cmd.MarkFlagsMutuallyExclusive("mark-read", "mark-unread")
+	cmd.MarkFlagsMutuallyExclusive("flag", "unflag")
+	return cmd
+}
+
+// watchRule turns find-style criteria and actions into a rule for folder.
+func watchRule(search *searchFlags, given []actionOption, account imaputils.Account, folder string, purge bool) (compiledRule, error) {
+	searchOpts, err := search.options()
+	if err != nil {
+		return compiledRule{}, err
+	}
+	if _, err := imaputils.SubjectMatcher(searchOpts); err != nil {
+		return compiledRule{}, err
+	}
+	actions, err := buildActions(given)
+	if err != nil {
+		return compiledRule{}, err
+	}
+	if len(actions) == 0 {
+		return compiledRule{}, fmt.Errorf("no actions given; pass actions such as --move, or use --rules")
+	}
+	if err := imaputils.ValidateActions(folder, actions); err != nil {
+		return compiledRule{}, err
+	}
+	return compiledRule{
+		rule: rule{
+			Name:    "watch",
+			Account: account.Name,
+			Folders: []string{folder},
+			Match:   ruleMatch{Or: search.or},
+			Purge:   purge,
+		},
+		searchOpts: searchOpts,
+		actions:    actions,
+	}, nil
+}
+
+// watchedRules loads the rules file and returns, in order, the rules that
+// apply to folder of account, restricted to that folder.
+func watchedRules(path string, account imaputils.Account, folder string) ([]compiledRule, error) {
+	rules, err := loadCompiledRules(path)
+	if err != nil {
+		return nil, err
+	}
+	var watched []compiledRule
+	for _, rule := range rules {
+		ruleAccount, err := getAccount(rule.accountName())
+		if err != nil {
+			return nil, err
+		}
+		if ruleAccount.Name == account.Name && slices.Contains(rule.Folders, folder) {
+			rule.Folders = []string{folder}
+			watched = append(watched, rule)
+		}
+	}
+	return watched, nil
+}
+
+// handleNewMessages runs rules on the new messages uids of folder and logs
+// what was decided for each message. It fails if any rule failed, so that
+// the messages are tried again; actions that already ran on a message are
+// not repeated.
+func handleNewMessages(runner *rulesRunner, rules []compiledRule, folder string, uids []uint32) error {
+	log.Log().Msgf("%d new messages in %s", len(uids), folder)
+	runner.restrict(uids)
+
+	decided := map[uint32]bool{}
+	var failures []error
+	for _, rule := range rules {
+		for _, result := range runner.run(rule) {
+			if result.Error != "" {
+				log.Error().Msgf("rule %s failed in %s: %s", rule.Name, folder, result.Error)
+				failures = append(failures, fmt.Errorf("rule %s: %s", rule.Name, result.Error))
+				continue
+			}
+			for _, message := range result.messages {
+				log.Log().Msgf("%s: %s: rule %s: %s", folder, describeMessage(message), rule.Name, result.Actions)
+				decided[message.Uid] = true
+			}
+		}
+	}
+	for _, uid := range uids {
+		if !decided[uid] {
+			log.Log().Msgf("%s: UID %d: no rule matched; left alone", folder, uid)
+		}
+	}
+	return errors.Join(failures...)
+}
+
+// describeMessage identifies message in the log by UID, sender and subject.
+func describeMessage(message *imap.Message) string {
+	if message.Envelope == nil {
+		return fmt.Sprintf("UID %d", message.Uid)
+	}
+	from := ""
+	if len(message.Envelope.From) > 0 {
+		from = message.Envelope.From[0].Address()
+	}
+	return fmt.Sprintf("UID %d from %s %q", message.Uid, from, message.Envelope.Subject)
+}
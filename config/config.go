@@ -5,6 +5,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 	"github.com/wryfi/shemail/logging"
+	"os"
 	"path/filepath"
 	"strings"
 )
@@ -26,12 +27,53 @@ func GetHome() string {
 	return home
 }
 
+// StateDir returns the directory where shemail keeps small pieces of local
+// state between runs (e.g. --since-last-run checkpoints): the state_dir setting
+// if configured, otherwise $XDG_STATE_HOME/shemail, falling back to
+// ~/.local/state/shemail.
+func StateDir() string {
+	if dir := viper.GetString("state_dir"); dir != "" {
+		return dir
+	}
+	if xdg := os.Getenv("XDG_STATE_HOME"); xdg != "" {
+		return filepath.Join(xdg, "shemail")
+	}
+	return filepath.Join(GetHome(), ".local", "state", "shemail")
+}
+
+// CacheDir returns the directory where shemail caches data fetched from the
+// server, which can always be refetched: the cache_dir setting if configured,
+// otherwise $XDG_CACHE_HOME/shemail, falling back to ~/.cache/shemail.
+func CacheDir() string {
+	if dir := viper.GetString("cache_dir"); dir != "" {
+		return dir
+	}
+	if xdg := os.Getenv("XDG_CACHE_HOME"); xdg != "" {
+		return filepath.Join(xdg, "shemail")
+	}
+	return filepath.Join(GetHome(), ".cache", "shemail")
+}
+
+// RulesFile returns the path of the rules file that "shemail rules" runs: the
+// rules_file setting if configured, otherwise shemail-rules.yaml next to the
+// configuration file in use, falling back to ~/.local/etc/shemail-rules.yaml.
+func RulesFile() string {
+	if path := viper.GetString("rules_file"); path != "" {
+		return path
+	}
+	if used := viper.ConfigFileUsed(); used != "" {
+		return filepath.Join(filepath.Dir(used), "shemail-rules.yaml")
+	}
+	return filepath.Join(GetHome(), ".local", "etc", "shemail-rules.yaml")
+}
+
 // setDefaults sets default values for configuration keys. All configuration
 // values for the application should be defined here.
 func setDefaults() {
 	viper.SetDefault("log.level", "warn")
 	viper.SetDefault("log.pretty", false)
 	viper.SetDefault("timezone", "America/Los_Angeles")
+	viper.SetDefault("quota_threshold", 90)
 }
 
 // InitConfig initializes the viper configuration by reading the defaults set
@@ -0,0 +1,159 @@
+package imaputils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// ActionKind identifies what an Action does to the messages it is given.
+type ActionKind int
+
+const (
+	// ActionFlags stores a FlagChange on the messages in place.
+	ActionFlags ActionKind = iota
+	// ActionCopy copies the messages to Folder, leaving them where they are.
+	ActionCopy
+	// ActionMove moves the messages to Folder; later actions follow them there.
+	ActionMove
+	// ActionDelete deletes the messages according to the account's deletion
+	// strategy. Nothing can follow it.
+	ActionDelete
+)
+
+// Action is one step of a pipeline run over the same set of messages.
+type Action struct {
+	Kind   ActionKind
+	Folder string     // destination of ActionCopy and ActionMove
+	Flags  FlagChange // for ActionFlags
+}
+
+// Label describes the action for prompts, e.g. "move to Archive". purge
+// selects the wording of deletes.
+func (action Action) Label(purge bool) string {
+	switch action.Kind {
+	case ActionCopy:
+		return "copy to " + action.Folder
+	case ActionMove:
+		return "move to " + action.Folder
+	case ActionDelete:
+		if purge {
+			return "permanently delete"
+		}
+		return "delete"
+	default:
+		return action.Flags.String()
+	}
+}
+
+// DescribeActions labels a pipeline, e.g. "mark as read, then move to
+// Archive".
+func DescribeActions(actions []Action, purge bool) string {
+	labels := make([]string, len(actions))
+	for index, action := range actions {
+		labels[index] = action.Label(purge)
+	}
+	return strings.Join(labels, ", then ")
+}
+
+// ValidateActions rejects pipelines that cannot be run as given starting in
+// folder: a delete anywhere but last (the messages are gone after it), a copy
+// or move without a destination, a move into the folder the messages are
+// already in, and empty flag changes.
+func ValidateActions(folder string, actions []Action) error {
+	current := folder
+	for index, action := range actions {
+		switch action.Kind {
+		case ActionDelete:
+			if index != len(actions)-1 {
+				return fmt.Errorf("delete must be the last action: nothing can act on messages after they are deleted (got %q)", DescribeActions(actions, false))
+			}
+		case ActionCopy, ActionMove:
+			if action.Folder == "" {
+				return fmt.Errorf("%s needs a destination folder", action.Label(false))
+			}
+			if action.Kind == ActionMove {
+				if action.Folder == current {
+					return fmt.Errorf("cannot move messages to %s: they are already there at that point", action.Folder)
+				}
+				current = action.Folder
+			}
+		case ActionFlags:
+			if action.Flags.IsEmpty() {
+				return fmt.Errorf("empty flag change")
+			}
+		}
+	}
+	return nil
+}
+
+// EndFolder returns the folder messages starting out in folder are in after
+// actions have moved them, i.e. where a final delete acts.
+func EndFolder(folder string, actions []Action) string {
+	for _, action := range actions {
+		if action.Kind == ActionMove {
+			folder = action.Folder
+		}
+	}
+	return folder
+}
+
+// RunActions applies actions in order to messages, which start out in folder.
+// Flag changes, copies and deletes act on the messages where they currently
+// are; a move relocates them, and subsequent actions act on the moved copies,
+// found by the UIDs the server reported via COPYUID (or by Message-ID when it
+// lacks UIDPLUS). Messages that cannot be followed after a move are skipped by
+// later actions, with a warning. Copies, moves and deletes are recorded in
+// journal, if it is not nil, so that they can be undone.
+func RunActions(dialer IMAPDialer, account Account, folder string, messages []*imap.Message, actions []Action, journal *Journal) error {
+	if err := ValidateActions(folder, actions); err != nil {
+		return err
+	}
+
+	current := folder
+	for index, action := range actions {
+		if len(messages) == 0 {
+			log.Warn().Msgf("no messages left to %s", action.Label(account.Purge))
+			return nil
+		}
+
+		switch action.Kind {
+		case ActionFlags:
+			if err := StoreFlags(dialer, account, messages, current, action.Flags); err != nil {
+				return fmt.Errorf("failed to %s: %w", action.Label(account.Purge), err)
+			}
+		case ActionCopy:
+			transfer, err := CopyMessages(dialer, account, messages, current, action.Folder)
+			if err != nil {
+				return fmt.Errorf("failed to copy messages to %s: %w", action.Folder, err)
+			}
+			journal.Record(NewJournalEntry(account, JournalCopy, transfer, messages))
+		case ActionMove:
+			transfer, err := MoveMessages(dialer, account, messages, current, action.Folder, 100)
+			if err != nil {
+				return fmt.Errorf("failed to move messages to %s: %w", action.Folder, err)
+			}
+			journal.Record(NewJournalEntry(account, JournalMove, transfer, messages))
+			current = action.Folder
+			if index == len(actions)-1 {
+				break
+			}
+			followed, lost, err := transfer.Follow(dialer, account, messages)
+			if err != nil {
+				return fmt.Errorf("failed to find moved messages in %s: %w", action.Folder, err)
+			}
+			if lost > 0 {
+				log.Warn().Msgf("could not find %d moved messages in %s; later actions will skip them", lost, action.Folder)
+			}
+			messages = followed
+		case ActionDelete:
+			transfer, err := DeleteMessages(dialer, account, messages, current)
+			if err != nil {
+				return fmt.Errorf("failed to delete messages from %s: %w", current, err)
+			}
+			journal.Record(NewJournalEntry(account, DeleteOperation(transfer), transfer, messages))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package imaputils
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestValidateActions(t *testing.T) {
+	markRead := Action{Kind: ActionFlags, Flags: FlagChange{Add: []string{imap.SeenFlag}}}
+	tests := []struct {
+		name    string
+		actions []Action
+		wantErr string
+	}{
+		{"no actions", nil, ""},
+		{"flags then move then delete", []Action{markRead, {Kind: ActionMove, Folder: "Archive"}, {Kind: ActionDelete}}, ""},
+		{"copy then delete", []Action{{Kind: ActionCopy, Folder: "Backup"}, {Kind: ActionDelete}}, ""},
+		{"delete not last", []Action{{Kind: ActionDelete}, {Kind: ActionMove, Folder: "Archive"}}, "delete must be the last action"},
+		{"move into the same folder", []Action{{Kind: ActionMove, Folder: "INBOX"}}, "already there"},
+		{"move back and forth", []Action{{Kind: ActionMove, Folder: "Archive"}, {Kind: ActionMove, Folder: "Archive"}}, "already there"},
+		{"copy without folder", []Action{{Kind: ActionCopy}}, "needs a destination folder"},
+		{"empty flag change", []Action{{Kind: ActionFlags}}, "empty flag change"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateActions("INBOX", tt.actions)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEndFolder(t *testing.T) {
+	assert.Equal(t, "INBOX", EndFolder("INBOX", []Action{{Kind: ActionCopy, Folder: "Backup"}, {Kind: ActionDelete}}))
+	assert.Equal(t, "Later", EndFolder("INBOX", []Action{{Kind: ActionMove, Folder: "Archive"}, {Kind: ActionMove, Folder: "Later"}}))
+}
+
+func TestDescribeActions(t *testing.T) {
+	actions := []Action{
+		{Kind: ActionFlags, Flags: FlagChange{Add: []string{imap.SeenFlag, "review"}}},
+		{Kind: ActionMove, Folder: "Archive"},
+		{Kind: ActionDelete},
+	}
+	assert.Equal(t, "mark as read, add keyword review, then move to Archive, then delete", DescribeActions(actions, false))
+	assert.Equal(t, "permanently delete", DescribeActions(actions[2:], true))
+	assert.Equal(t, "", DescribeActions(nil, false))
+}
+
+func TestRunActionsFollowsMovedMessages(t *testing.T) {
+	client := &MockIMAPClientMove{}
+	dialer := &MockIMAPDialerMove{}
+	dialer.On("Dial", mock.Anything).Return(client, nil)
+	client.On("Login", mock.Anything, mock.Anything).Return(nil)
+	client.On("Logout").Return(nil)
+	client.On("Select", "INBOX", false).Return(&imap.MailboxStatus{Name: "INBOX"}, nil)
+	client.On("Select", "Archive", false).Return(&imap.MailboxStatus{Name: "Archive"}, nil)
+	client.On("List", "", "", mock.Anything).Return(
+		func(ch chan *imap.MailboxInfo) { ch <- &imap.MailboxInfo{Delimiter: "/"} }, nil)
+	client.On("List", "", "Archive", mock.Anything).Return(
+		func(ch chan *imap.MailboxInfo) { ch <- &imap.MailboxInfo{Name: "Archive"} }, nil)
+
+	// Marked read in INBOX under their original UIDs...
+	client.On("UidStore", mock.MatchedBy(func(seqSet *imap.SeqSet) bool {
+		return seqSet.String() == "1:2"
+	}), imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.SeenFlag}, (chan *imap.Message)(nil)).Return(nil, nil).Once()
+	client.On("Capability").Return(map[string]bool{"MOVE": true}, nil)
+	client.On("UidMove", mock.Anything, "Archive").Return(nil, &CopyUID{UidValidity: 7, Source: []uint32{1, 2}, Dest: []uint32{101, 102}}).Once()
+	client.On("UidFetch", mock.Anything, []imap.FetchItem{imap.FetchUid}, mock.Anything).Return(
+		func(ch chan *imap.Message) {}, nil).Once()
+	// ...then flagged in Archive under the UIDs COPYUID reported.
+	client.On("UidStore", mock.MatchedBy(func(seqSet *imap.SeqSet) bool {
+		return seqSet.String() == "101:102"
+	}), imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.FlaggedFlag}, (chan *imap.Message)(nil)).Return(nil, nil).Once()
+
+	actions := []Action{
+		{Kind: ActionFlags, Flags: FlagChange{Add: []string{imap.SeenFlag}}},
+		{Kind: ActionMove, Folder: "Archive"},
+		{Kind: ActionFlags, Flags: FlagChange{Add: []string{imap.FlaggedFlag}}},
+	}
+	err := RunActions(dialer, Account{}, "INBOX", []*imap.Message{{Uid: 1}, {Uid: 2}}, actions, nil)
+	assert.NoError(t, err)
+	client.AssertExpectations(t)
+}
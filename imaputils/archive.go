@@ -0,0 +1,187 @@
+package imaputils
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/emersion/go-imap"
+)
+
+// listIDSection is BODY.PEEK[HEADER.FIELDS (List-Id)]: just the List-Id
+// header, fetched without setting \Seen.
+var listIDSection = &imap.BodySectionName{
+	BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier, Fields: []string{"List-Id"}},
+	Peek:         true,
+}
+
+// ArchiveFields are the values an archive destination template can use, e.g.
+// "Archive/{{.Year}}/{{.Month}}" or "Lists/{{.ListId}}".
+type ArchiveFields struct {
+	Year    string // e.g. "2024"
+	Month   string // "01" to "12"
+	Day     string // "01" to "31"
+	Quarter string // "Q1" to "Q4"
+	// Domain is the lowercased domain of the sender's address.
+	Domain string
+	// ListId is the identifier of the mailing list the message came from,
+	// e.g. "dev.lists.example.com", or "" if it has no List-Id header.
+	ListId string
+}
+
+// NewArchiveFields describes message for an archive template. Dates are the
+// message's INTERNALDATE in local time, as find's --before and --after use.
+// A "/" in a value would start a new folder level, so it is replaced with "_".
+func NewArchiveFields(message *imap.Message, listID string) ArchiveFields {
+	date := message.InternalDate.Local()
+	fields := ArchiveFields{
+		Year:    date.Format("2006"),
+		Month:   date.Format("01"),
+		Day:     date.Format("02"),
+		Quarter: fmt.Sprintf("Q%d", (int(date.Month())+2)/3),
+		ListId:  strings.ReplaceAll(listID, "/", "_"),
+	}
+	if message.Envelope != nil && len(message.Envelope.From) > 0 {
+		fields.Domain = strings.ReplaceAll(strings.ToLower(message.Envelope.From[0].HostName), "/", "_")
+	}
+	return fields
+}
+
+// ArchiveTemplate renders the destination folder of each archived message.
+type ArchiveTemplate struct {
+	text     string
+	template *template.Template
+}
+
+// ParseArchiveTemplate parses a destination template, a "/"-separated folder
+// path using the fields of ArchiveFields. Unknown fields are rejected here
+// rather than when the first message is rendered.
+func ParseArchiveTemplate(text string) (*ArchiveTemplate, error) {
+	parsed, err := template.New("archive").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination template %q: %w", text, err)
+	}
+	sample := ArchiveFields{Year: "2006", Month: "01", Day: "02", Quarter: "Q1", Domain: "example.com", ListId: "list.example.com"}
+	if err := parsed.Execute(&bytes.Buffer{}, sample); err != nil {
+		return nil, fmt.Errorf("invalid destination template %q: %w", text, err)
+	}
+	return &ArchiveTemplate{text: text, template: parsed}, nil
+}
+
+func (archive *ArchiveTemplate) String() string {
+	return archive.text
+}
+
+// NeedsListID reports whether the template uses ListId, which has to be
+// fetched separately with FetchListIDs.
+func (archive *ArchiveTemplate) NeedsListID() bool {
+	return strings.Contains(archive.text, "ListId")
+}
+
+// Folder renders the destination of message. It fails if the result has an
+// empty level, as when the template uses a field the message lacks.
+func (archive *ArchiveTemplate) Folder(message *imap.Message, listID string) (string, error) {
+	var rendered strings.Builder
+	if err := archive.template.Execute(&rendered, NewArchiveFields(message, listID)); err != nil {
+		return "", err
+	}
+	folder := rendered.String()
+	if slices.ContainsFunc(strings.Split(folder, "/"), func(level string) bool { return strings.TrimSpace(level) == "" }) {
+		return "", fmt.Errorf("%q has an empty folder name", folder)
+	}
+	return folder, nil
+}
+
+// ArchiveGroup is a set of messages bound for the same destination folder.
+type ArchiveGroup struct {
+	Folder   string
+	Messages []*imap.Message
+}
+
+// GroupForArchive renders the destination of each message of folder and
+// groups the messages by it, in order of destination, so that each
+// destination takes a single move. listIDs holds the List-Ids from
+// FetchListIDs, if the template needs them. Messages the template cannot
+// place, and those whose destination is folder itself, are returned
+// separately as skipped.
+func GroupForArchive(archive *ArchiveTemplate, folder string, messages []*imap.Message, listIDs map[uint32]string) (groups []ArchiveGroup, skipped []*imap.Message) {
+	byFolder := map[string]*ArchiveGroup{}
+	for _, message := range messages {
+		destination, err := archive.Folder(message, listIDs[message.Uid])
+		if err != nil || destination == folder {
+			if err != nil {
+				log.Debug().Msgf("not archiving message %d: %v", message.Uid, err)
+			}
+			skipped = append(skipped, message)
+			continue
+		}
+		group, ok := byFolder[destination]
+		if !ok {
+			group = &ArchiveGroup{Folder: destination}
+			byFolder[destination] = group
+		}
+		group.Messages = append(group.Messages, message)
+	}
+
+	for _, group := range byFolder {
+		groups = append(groups, *group)
+	}
+	slices.SortFunc(groups, func(a, b ArchiveGroup) int { return strings.Compare(a.Folder, b.Folder) })
+	return groups, skipped
+}
+
+// FetchListIDs returns the List-Id of each of the messages uids of folder
+// that has one, keyed by UID. Only the header is fetched, read-only.
+func FetchListIDs(dialer IMAPDialer, account Account, folder string, uids []uint32) (map[uint32]string, error) {
+	listIDs := map[uint32]string{}
+	if len(uids) == 0 {
+		return listIDs, nil
+	}
+	imapClient, err := connectToMailbox(dialer, account, folder, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to mailbox: %w", err)
+	}
+	defer imapClient.Logout()
+
+	items := []imap.FetchItem{imap.FetchUid, listIDSection.FetchItem()}
+	var fetchErr error
+	streamUIDItems(imapClient, "reading List-Id headers in "+folder, uids, items, DefaultChunkSize, func(message *imap.Message, err error) bool {
+		if err != nil {
+			fetchErr = err
+			return false
+		}
+		if literal := message.GetBody(listIDSection); literal != nil {
+			if listID := parseListID(literal); listID != "" {
+				listIDs[message.Uid] = listID
+			}
+		}
+		return true
+	})
+	if fetchErr != nil {
+		return nil, fmt.Errorf("failed to fetch List-Id headers from %s: %w", folder, fetchErr)
+	}
+	return listIDs, nil
+}
+
+// parseListID extracts the list identifier from a List-Id header (RFC 2919),
+// which is the part in angle brackets after an optional description, e.g.
+// "dev.lists.example.com" from `"Developers" <dev.lists.example.com>`. It
+// returns "" if there is no List-Id.
+func parseListID(header imap.Literal) string {
+	// The fetched section ends with the blank line that closes the header,
+	// which is all net/mail needs to parse it.
+	message, err := mail.ReadMessage(header)
+	if err != nil {
+		return ""
+	}
+	value := strings.TrimSpace(message.Header.Get("List-Id"))
+	if start := strings.LastIndex(value, "<"); start >= 0 {
+		if end := strings.Index(value[start:], ">"); end > 0 {
+			value = value[start+1 : start+end]
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(value))
+}
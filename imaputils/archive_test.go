@@ -0,0 +1,120 @@
+package imaputils
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+)
+
+func archiveMessage(uid uint32, date time.Time, host string) *imap.Message {
+	message := &imap.Message{Uid: uid, InternalDate: date, Envelope: &imap.Envelope{}}
+	if host != "" {
+		message.Envelope.From = []*imap.Address{{MailboxName: "someone", HostName: host}}
+	}
+	return message
+}
+
+func TestParseArchiveTemplate(t *testing.T) {
+	for _, text := range []string{"Archive/{{.Year}}/{{.Month}}", "Lists/{{.ListId}}", "{{.Domain}}/{{.Year}}-{{.Quarter}}", "Archive"} {
+		_, err := ParseArchiveTemplate(text)
+		assert.NoError(t, err, text)
+	}
+	for _, text := range []string{"Archive/{{.Year", "Archive/{{.Weekday}}"} {
+		_, err := ParseArchiveTemplate(text)
+		assert.Error(t, err, text)
+	}
+}
+
+func TestArchiveTemplateFolder(t *testing.T) {
+	date := time.Date(2023, 11, 7, 12, 0, 0, 0, time.Local)
+	message := archiveMessage(1, date, "Mail.Example.COM")
+
+	tests := []struct {
+		name    string
+		text    string
+		listID  string
+		want    string
+		wantErr bool
+	}{
+		{"date", "Archive/{{.Year}}/{{.Month}}/{{.Day}}", "", "Archive/2023/11/07", false},
+		{"quarter", "Archive/{{.Year}}-{{.Quarter}}", "", "Archive/2023-Q4", false},
+		{"domain", "Senders/{{.Domain}}", "", "Senders/mail.example.com", false},
+		{"list", "Lists/{{.ListId}}", "dev.lists.example.com", "Lists/dev.lists.example.com", false},
+		{"slash in a value", "Lists/{{.ListId}}", "a/b", "Lists/a_b", false},
+		{"missing list", "Lists/{{.ListId}}", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive, err := ParseArchiveTemplate(tt.text)
+			assert.NoError(t, err)
+			got, err := archive.Folder(message, tt.listID)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestArchiveTemplateNeedsListID(t *testing.T) {
+	archive, _ := ParseArchiveTemplate("Lists/{{.ListId}}")
+	assert.True(t, archive.NeedsListID())
+	archive, _ = ParseArchiveTemplate("Archive/{{.Year}}")
+	assert.False(t, archive.NeedsListID())
+}
+
+func TestGroupForArchive(t *testing.T) {
+	january := time.Date(2023, 1, 15, 12, 0, 0, 0, time.Local)
+	march := time.Date(2023, 3, 1, 12, 0, 0, 0, time.Local)
+	messages := []*imap.Message{
+		archiveMessage(1, march, "example.com"),
+		archiveMessage(2, january, "example.com"),
+		archiveMessage(3, march, "example.org"),
+		archiveMessage(4, january, ""),
+	}
+
+	archive, _ := ParseArchiveTemplate("Archive/{{.Year}}/{{.Month}}")
+	groups, skipped := GroupForArchive(archive, "INBOX", messages, nil)
+	assert.Empty(t, skipped)
+	if assert.Len(t, groups, 2) {
+		assert.Equal(t, "Archive/2023/01", groups[0].Folder)
+		assert.Equal(t, []*imap.Message{messages[1], messages[3]}, groups[0].Messages)
+		assert.Equal(t, "Archive/2023/03", groups[1].Folder)
+		assert.Equal(t, []*imap.Message{messages[0], messages[2]}, groups[1].Messages)
+	}
+
+	// A message without a sender has no domain, and messages already in their
+	// destination stay put.
+	archive, _ = ParseArchiveTemplate("{{.Domain}}")
+	groups, skipped = GroupForArchive(archive, "example.org", messages, nil)
+	assert.Equal(t, []*imap.Message{messages[2], messages[3]}, skipped)
+	if assert.Len(t, groups, 1) {
+		assert.Equal(t, "example.com", groups[0].Folder)
+		assert.Len(t, groups[0].Messages, 2)
+	}
+
+	archive, _ = ParseArchiveTemplate("Lists/{{.ListId}}")
+	groups, skipped = GroupForArchive(archive, "INBOX", messages, map[uint32]string{1: "a.example.com", 3: "a.example.com"})
+	assert.Len(t, skipped, 2)
+	if assert.Len(t, groups, 1) {
+		assert.Equal(t, "Lists/a.example.com", groups[0].Folder)
+		assert.Equal(t, []*imap.Message{messages[0], messages[2]}, groups[0].Messages)
+	}
+}
+
+func TestParseListID(t *testing.T) {
+	tests := map[string]string{
+		"List-Id: \"Developers\" <dev.lists.example.com>\r\n\r\n":      "dev.lists.example.com",
+		"List-Id: <Announce.Example.ORG>\r\n\r\n":                      "announce.example.org",
+		"LIST-ID: Folded description\r\n <folded.example.com>\r\n\r\n": "folded.example.com",
+		"\r\n": "",
+	}
+	for header, want := range tests {
+		assert.Equal(t, want, parseListID(bytes.NewBufferString(header)), header)
+	}
+}
@@ -0,0 +1,518 @@
+package imaputils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// AttachmentManifestFile is the name of the list of saved attachments within
+// an attachments directory.
+const AttachmentManifestFile = "shemail-attachments.json"
+
+// DefaultAttachmentTemplate names saved attachments after the date and sender
+// of their message and their original file name.
+const DefaultAttachmentTemplate = "{{.Date}}_{{.Sender}}_{{.Filename}}"
+
+// Attachment is a part of a message that was sent as a file.
+type Attachment struct {
+	// Part is the IMAP part path, e.g. [2 1] for part 2.1.
+	Part []int
+	// Filename is the file name the sender gave, or one made up from the part
+	// and type if there is none.
+	Filename string
+	// MIMEType is the lowercased content type, e.g. "application/pdf".
+	MIMEType string
+	// Encoding is the lowercased Content-Transfer-Encoding, e.g. "base64".
+	Encoding string
+	// Size is the size of the part as sent, i.e. still encoded.
+	Size uint32
+}
+
+// PartName returns the IMAP name of the part, e.g. "2.1".
+func (attachment Attachment) PartName() string {
+	levels := make([]string, len(attachment.Part))
+	for index, level := range attachment.Part {
+		levels[index] = strconv.Itoa(level)
+	}
+	return strings.Join(levels, ".")
+}
+
+// section is BODY.PEEK[part]: the part's content, fetched without setting
+// \Seen.
+func (attachment Attachment) section() *imap.BodySectionName {
+	return &imap.BodySectionName{BodyPartName: imap.BodyPartName{Path: attachment.Part}, Peek: true}
+}
+
+// FindAttachments returns the attachments of a message with the given
+// BODYSTRUCTURE, in order: the parts that are not multipart containers and
+// either have a file name or are marked "Content-Disposition: attachment".
+// Attached messages count as one attachment each.
+func FindAttachments(structure *imap.BodyStructure) []Attachment {
+	var attachments []Attachment
+	if structure == nil {
+		return attachments
+	}
+	structure.Walk(func(part []int, body *imap.BodyStructure) bool {
+		if strings.EqualFold(body.MIMEType, "multipart") {
+			return true
+		}
+		filename, _ := body.Filename()
+		if filename == "" && !strings.EqualFold(body.Disposition, "attachment") {
+			return false
+		}
+		attachment := Attachment{
+			Part:     part,
+			MIMEType: strings.ToLower(body.MIMEType + "/" + body.MIMESubType),
+			Encoding: strings.ToLower(body.Encoding),
+			Size:     body.Size,
+		}
+		attachment.Filename = attachmentFilename(filename, attachment)
+		attachments = append(attachments, attachment)
+		return false
+	})
+	return attachments
+}
+
+// attachmentFilename makes filename safe to use as the name of a file, or
+// makes one up from the part name and type of attachment if it is unusable.
+func attachmentFilename(filename string, attachment Attachment) string {
+	filename = cleanPathPart(strings.TrimSpace(filename))
+	if strings.Trim(filename, ".") != "" {
+		return filename
+	}
+	extension := ""
+	if attachment.MIMEType == "message/rfc822" {
+		extension = ".eml"
+	} else if extensions, _ := mime.ExtensionsByType(attachment.MIMEType); len(extensions) > 0 {
+		extension = extensions[0]
+	}
+	return "part-" + attachment.PartName() + extension
+}
+
+// cleanPathPart replaces path separators of any platform and control
+// characters in value with "_", so that it stays within one level of a path.
+func cleanPathPart(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < ' ' || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// AttachmentFilter chooses attachments by file name and type. An empty filter
+// accepts every attachment.
+type AttachmentFilter struct {
+	// Names are shell patterns, e.g. "*.pdf", matched against the file name
+	// regardless of case; an attachment matching any of them is accepted.
+	Names []string
+	// Types are content types, e.g. "application/pdf", or whole families,
+	// e.g. "image/*" or "image"; an attachment of any of them is accepted.
+	Types []string
+}
+
+// Validate reports the first malformed name pattern.
+func (filter AttachmentFilter) Validate() error {
+	for _, pattern := range filter.Names {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid attachment name pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether attachment has one of the names and one of the
+// types of the filter.
+func (filter AttachmentFilter) Matches(attachment Attachment) bool {
+	if len(filter.Names) > 0 && !slices.ContainsFunc(filter.Names, func(pattern string) bool {
+		matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(attachment.Filename))
+		return matched
+	}) {
+		return false
+	}
+	if len(filter.Types) > 0 && !slices.ContainsFunc(filter.Types, func(wanted string) bool {
+		wanted = strings.ToLower(strings.TrimSuffix(wanted, "/*"))
+		return attachment.MIMEType == wanted || strings.HasPrefix(attachment.MIMEType, wanted+"/")
+	}) {
+		return false
+	}
+	return true
+}
+
+// ExtractAttachments reads the BODYSTRUCTURE of the messages uids of folder
+// and, for every attachment filter accepts, downloads only that part, decodes
+// it and passes it to save, message by message in order of UID. The messages
+// passed to save carry their envelope and INTERNALDATE. Extraction stops at
+// the first error from save.
+func ExtractAttachments(dialer IMAPDialer, account Account, folder string, uids []uint32, filter AttachmentFilter, save func(message *imap.Message, attachment Attachment, content []byte) error) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	imapClient, err := connectToMailbox(dialer, account, folder, true)
+	if err != nil {
+		return fmt.Errorf("failed to connect to mailbox: %w", err)
+	}
+	defer imapClient.Logout()
+
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope, imap.FetchInternalDate, imap.FetchBodyStructure}
+	var extractErr error
+	streamUIDItems(imapClient, "extracting attachments from "+folder, uids, items, DefaultChunkSize, func(message *imap.Message, err error) bool {
+		if err != nil {
+			extractErr = err
+			return false
+		}
+		var wanted []Attachment
+		for _, attachment := range FindAttachments(message.BodyStructure) {
+			if filter.Matches(attachment) {
+				wanted = append(wanted, attachment)
+			}
+		}
+		if len(wanted) == 0 {
+			return true
+		}
+		contents, err := fetchAttachments(imapClient, message.Uid, wanted)
+		if err != nil {
+			extractErr = err
+			return false
+		}
+		for index, attachment := range wanted {
+			if err := save(message, attachment, contents[index]); err != nil {
+				extractErr = err
+				return false
+			}
+		}
+		return true
+	})
+	return extractErr
+}
+
+// fetchAttachments downloads and decodes the given attachments of the message
+// uid on the selected client, in a single UID FETCH.
+func fetchAttachments(imapClient IMAPClient, uid uint32, attachments []Attachment) ([][]byte, error) {
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uid)
+	items := []imap.FetchItem{imap.FetchUid}
+	for _, attachment := range attachments {
+		items = append(items, attachment.section().FetchItem())
+	}
+
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- imapClient.UidFetch(seqSet, items, messages)
+	}()
+	// A part can come in a separate response; keep each as it arrives, as
+	// the literals are only readable once.
+	contents := make([][]byte, len(attachments))
+	found := make([]bool, len(attachments))
+	var decodeErr error
+	for message := range messages {
+		for index, attachment := range attachments {
+			literal := message.GetBody(attachment.section())
+			if literal == nil || found[index] {
+				continue
+			}
+			found[index] = true
+			if contents[index], decodeErr = decodeAttachment(literal, attachment.Encoding); decodeErr != nil {
+				decodeErr = fmt.Errorf("failed to decode part %s of message %d: %w", attachment.PartName(), uid, decodeErr)
+			}
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to fetch attachments of message %d: %w", uid, err)
+	}
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	for index, attachment := range attachments {
+		if !found[index] {
+			return nil, fmt.Errorf("server did not return part %s of message %d", attachment.PartName(), uid)
+		}
+	}
+	return contents, nil
+}
+
+// decodeAttachment undoes the Content-Transfer-Encoding of a part.
+func decodeAttachment(content io.Reader, encoding string) ([]byte, error) {
+	switch strings.ToLower(encoding) {
+	case "base64":
+		// The decoder skips the line breaks base64 bodies are wrapped with.
+		return io.ReadAll(base64.NewDecoder(base64.StdEncoding, content))
+	case "quoted-printable":
+		return io.ReadAll(quotedprintable.NewReader(content))
+	default:
+		return io.ReadAll(content)
+	}
+}
+
+// AttachmentFields are the values an attachment naming template can use, e.g.
+// "{{.Year}}/{{.Sender}}/{{.Filename}}".
+type AttachmentFields struct {
+	Date  string // date the message was received, e.g. "2024-06-30"
+	Year  string
+	Month string // "01" to "12"
+	Day   string // "01" to "31"
+	// Sender is the lowercased address of the sender, Domain its domain.
+	Sender string
+	Domain string
+	// Filename is the original file name; Name is Filename without its
+	// extension, and Ext the extension, including its dot.
+	Filename string
+	Name     string
+	Ext      string
+	UID      uint32
+	Part     string // e.g. "2.1"
+}
+
+// NewAttachmentFields describes attachment of message for a naming template.
+// Path separators ("/", or "\" on Windows) and control characters in a value,
+// which comes from the sender, are replaced with "_".
+func NewAttachmentFields(message *imap.Message, attachment Attachment) AttachmentFields {
+	date := message.InternalDate.Local()
+	extension := filepath.Ext(attachment.Filename)
+	fields := AttachmentFields{
+		Date:     date.Format("2006-01-02"),
+		Year:     date.Format("2006"),
+		Month:    date.Format("01"),
+		Day:      date.Format("02"),
+		Filename: cleanPathPart(attachment.Filename),
+		Name:     cleanPathPart(strings.TrimSuffix(attachment.Filename, extension)),
+		Ext:      cleanPathPart(extension),
+		UID:      message.Uid,
+		Part:     attachment.PartName(),
+	}
+	if message.Envelope != nil && len(message.Envelope.From) > 0 {
+		from := message.Envelope.From[0]
+		fields.Sender = cleanPathPart(strings.ToLower(from.Address()))
+		fields.Domain = cleanPathPart(strings.ToLower(from.HostName))
+	}
+	return fields
+}
+
+// AttachmentTemplate renders the path, relative to the output directory, of
+// each saved attachment.
+type AttachmentTemplate struct {
+	text     string
+	template *template.Template
+}
+
+// ParseAttachmentTemplate parses a naming template, a "/"-separated path using
+// the fields of AttachmentFields. Unknown fields are rejected here rather than
+// when the first attachment is saved.
+func ParseAttachmentTemplate(text string) (*AttachmentTemplate, error) {
+	parsed, err := template.New("attachment").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid naming template %q: %w", text, err)
+	}
+	sample := AttachmentFields{Date: "2006-01-02", Year: "2006", Month: "01", Day: "02", Sender: "sender@example.com",
+		Domain: "example.com", Filename: "report.pdf", Name: "report", Ext: ".pdf", UID: 1, Part: "2"}
+	if err := parsed.Execute(&bytes.Buffer{}, sample); err != nil {
+		return nil, fmt.Errorf("invalid naming template %q: %w", text, err)
+	}
+	return &AttachmentTemplate{text: text, template: parsed}, nil
+}
+
+func (naming *AttachmentTemplate) String() string {
+	return naming.text
+}
+
+// Path renders the path of attachment of message, relative to the output
+// directory. It fails if the result has an empty level, or would leave the
+// output directory.
+func (naming *AttachmentTemplate) Path(message *imap.Message, attachment Attachment) (string, error) {
+	var rendered strings.Builder
+	if err := naming.template.Execute(&rendered, NewAttachmentFields(message, attachment)); err != nil {
+		return "", err
+	}
+	levels := strings.Split(rendered.String(), "/")
+	for _, level := range levels {
+		if trimmed := strings.TrimSpace(level); trimmed == "" || trimmed == "." || trimmed == ".." {
+			return "", fmt.Errorf("%q is not a usable file name", rendered.String())
+		}
+	}
+	path := filepath.Join(levels...)
+	if !filepath.IsLocal(path) {
+		return "", fmt.Errorf("%q is not a usable file name: it is outside the output directory", rendered.String())
+	}
+	return path, nil
+}
+
+// SavedAttachment is one attachment in an attachments directory.
+type SavedAttachment struct {
+	// Path is where the content is, relative to the directory. Duplicates
+	// point to the file that was saved first with the same content.
+	Path      string    `json:"path"`
+	Duplicate bool      `json:"duplicate,omitempty"`
+	SHA256    string    `json:"sha256"`
+	Size      int64     `json:"size"`
+	Type      string    `json:"type"`
+	Filename  string    `json:"filename"`
+	Account   string    `json:"account"`
+	Folder    string    `json:"folder"`
+	UID       uint32    `json:"uid"`
+	Part      string    `json:"part"`
+	MessageID string    `json:"message_id,omitempty"`
+	From      string    `json:"from,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	Date      time.Time `json:"date"`
+}
+
+// AttachmentStore is a directory of attachments saved from messages, with a
+// manifest of where each came from. Content is stored once: an attachment
+// whose SHA-256 is already in the store is recorded as a duplicate of the
+// existing file, including across runs.
+type AttachmentStore struct {
+	dir         string
+	dryRun      bool
+	Attachments []SavedAttachment `json:"attachments"`
+	byHash      map[string]string
+}
+
+// OpenAttachmentStore reads the manifest of the attachments in dir; a missing
+// manifest yields an empty store. With dryRun, the store works out what it
+// would save but writes nothing.
+func OpenAttachmentStore(dir string, dryRun bool) (*AttachmentStore, error) {
+	store := &AttachmentStore{dir: dir, dryRun: dryRun, byHash: map[string]string{}}
+	path := filepath.Join(dir, AttachmentManifestFile)
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read attachment manifest %s: %w", path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, store); err != nil {
+			return nil, fmt.Errorf("failed to parse attachment manifest %s: %w", path, err)
+		}
+	}
+	for _, saved := range store.Attachments {
+		if !saved.Duplicate {
+			store.byHash[saved.SHA256] = saved.Path
+		}
+	}
+	return store, nil
+}
+
+// Dir returns the directory of the store.
+func (store *AttachmentStore) Dir() string {
+	return store.dir
+}
+
+// Find returns the saved attachment part of the message with messageID in
+// folder of account, if there is one.
+func (store *AttachmentStore) Find(account, folder, messageID, part string) (SavedAttachment, bool) {
+	for _, saved := range store.Attachments {
+		if saved.Account == account && saved.Folder == folder && saved.MessageID != "" && saved.MessageID == messageID && saved.Part == part {
+			return saved, true
+		}
+	}
+	return SavedAttachment{}, false
+}
+
+// Save stores content, attachment of message in folder of account, under the
+// path naming renders for it, unless the same content is already stored. A
+// name already taken by other content gets a numbered suffix. It returns the
+// new manifest entry, and false if this very attachment was already saved.
+func (store *AttachmentStore) Save(naming *AttachmentTemplate, account, folder string, message *imap.Message, attachment Attachment, content []byte) (SavedAttachment, bool, error) {
+	sum := sha256.Sum256(content)
+	saved := SavedAttachment{
+		SHA256:   hex.EncodeToString(sum[:]),
+		Size:     int64(len(content)),
+		Type:     attachment.MIMEType,
+		Filename: attachment.Filename,
+		Account:  account,
+		Folder:   folder,
+		UID:      message.Uid,
+		Part:     attachment.PartName(),
+		Date:     message.InternalDate,
+	}
+	if message.Envelope != nil {
+		saved.MessageID = message.Envelope.MessageId
+		saved.Subject = message.Envelope.Subject
+		if len(message.Envelope.From) > 0 {
+			saved.From = message.Envelope.From[0].Address()
+		}
+	}
+	if previous, ok := store.Find(account, folder, saved.MessageID, saved.Part); ok && previous.SHA256 == saved.SHA256 {
+		return previous, false, nil
+	}
+
+	if existing, ok := store.byHash[saved.SHA256]; ok {
+		saved.Path, saved.Duplicate = existing, true
+		store.Attachments = append(store.Attachments, saved)
+		return saved, true, nil
+	}
+	name, err := naming.Path(message, attachment)
+	if err != nil {
+		return SavedAttachment{}, false, fmt.Errorf("cannot name part %s of message %d: %w", saved.Part, message.Uid, err)
+	}
+	if saved.Path, err = store.write(name, content); err != nil {
+		return SavedAttachment{}, false, err
+	}
+	store.byHash[saved.SHA256] = saved.Path
+	store.Attachments = append(store.Attachments, saved)
+	return saved, true, nil
+}
+
+// write creates a file for content at name, or at name with "-2", "-3" and
+// so on before its extension if name is taken, and returns the name used.
+func (store *AttachmentStore) write(name string, content []byte) (string, error) {
+	extension := filepath.Ext(name)
+	candidate := name
+	for number := 2; store.taken(candidate); number++ {
+		candidate = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, extension), number, extension)
+	}
+	if store.dryRun {
+		return candidate, nil
+	}
+	path := filepath.Join(store.dir, candidate)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", fmt.Errorf("failed to create directory for %s: %w", candidate, err)
+	}
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return candidate, nil
+}
+
+// taken reports whether a saved attachment, or any other file, is at name.
+func (store *AttachmentStore) taken(name string) bool {
+	for _, path := range store.byHash {
+		if path == name {
+			return true
+		}
+	}
+	_, err := os.Lstat(filepath.Join(store.dir, name))
+	return err == nil
+}
+
+// Close writes the manifest, unless the store is a dry run.
+func (store *AttachmentStore) Close() error {
+	if store.dryRun {
+		return nil
+	}
+	if err := os.MkdirAll(store.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create attachments directory: %w", err)
+	}
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode attachment manifest: %w", err)
+	}
+	return replaceFile(filepath.Join(store.dir, AttachmentManifestFile), data)
+}
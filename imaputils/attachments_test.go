@@ -0,0 +1,248 @@
+package imaputils
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+)
+
+// attachmentStructure is a message with a text body, an HTML alternative, an
+// attached PDF, an inline photo and an attached file without a name.
+func attachmentStructure() *imap.BodyStructure {
+	return &imap.BodyStructure{
+		MIMEType: "multipart", MIMESubType: "mixed",
+		Parts: []*imap.BodyStructure{
+			{MIMEType: "multipart", MIMESubType: "alternative", Parts: []*imap.BodyStructure{
+				{MIMEType: "text", MIMESubType: "plain", Encoding: "7bit", Size: 100},
+				{MIMEType: "text", MIMESubType: "html", Encoding: "quoted-printable", Size: 300},
+			}},
+			{MIMEType: "application", MIMESubType: "pdf", Encoding: "base64", Size: 4000,
+				Disposition: "attachment", DispositionParams: map[string]string{"filename": "Report Q1.pdf"}},
+			{MIMEType: "image", MIMESubType: "JPEG", Encoding: "BASE64", Size: 9000,
+				Disposition: "inline", Params: map[string]string{"name": "../photo.jpg"}},
+			{MIMEType: "message", MIMESubType: "rfc822", Encoding: "7bit", Size: 500, Disposition: "attachment"},
+		},
+	}
+}
+
+func TestFindAttachments(t *testing.T) {
+	assert.Equal(t, []Attachment{
+		{Part: []int{2}, Filename: "Report Q1.pdf", MIMEType: "application/pdf", Encoding: "base64", Size: 4000},
+		{Part: []int{3}, Filename: ".._photo.jpg", MIMEType: "image/jpeg", Encoding: "base64", Size: 9000},
+		{Part: []int{4}, Filename: "part-4.eml", MIMEType: "message/rfc822", Encoding: "7bit", Size: 500},
+	}, FindAttachments(attachmentStructure()))
+
+	// A message that is a single file is part 1.
+	single := &imap.BodyStructure{MIMEType: "application", MIMESubType: "pdf", Params: map[string]string{"name": "scan.pdf"}}
+	if attachments := FindAttachments(single); assert.Len(t, attachments, 1) {
+		assert.Equal(t, "1", attachments[0].PartName())
+	}
+
+	plain := &imap.BodyStructure{MIMEType: "text", MIMESubType: "plain"}
+	assert.Empty(t, FindAttachments(plain))
+	assert.Empty(t, FindAttachments(nil))
+}
+
+func TestAttachmentFilter(t *testing.T) {
+	pdf := Attachment{Filename: "Report Q1.PDF", MIMEType: "application/pdf"}
+	photo := Attachment{Filename: "photo.jpg", MIMEType: "image/jpeg"}
+
+	tests := []struct {
+		name   string
+		filter AttachmentFilter
+		want   []bool
+	}{
+		{"everything", AttachmentFilter{}, []bool{true, true}},
+		{"name ignores case", AttachmentFilter{Names: []string{"*.pdf"}}, []bool{true, false}},
+		{"exact type", AttachmentFilter{Types: []string{"image/jpeg"}}, []bool{false, true}},
+		{"type family", AttachmentFilter{Types: []string{"image/*"}}, []bool{false, true}},
+		{"bare family", AttachmentFilter{Types: []string{"application"}}, []bool{true, false}},
+		{"any of the types", AttachmentFilter{Types: []string{"application/pdf", "image/*"}}, []bool{true, true}},
+		{"name and type", AttachmentFilter{Names: []string{"*.jpg"}, Types: []string{"application/pdf"}}, []bool{false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, []bool{tt.filter.Matches(pdf), tt.filter.Matches(photo)})
+		})
+	}
+
+	assert.NoError(t, AttachmentFilter{Names: []string{"*.pdf"}}.Validate())
+	assert.Error(t, AttachmentFilter{Names: []string{"[pdf"}}.Validate())
+}
+
+func TestDecodeAttachment(t *testing.T) {
+	decoded, err := decodeAttachment(strings.NewReader("aGVsbG8g\r\nd29ybGQ=\r\n"), "base64")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(decoded))
+
+	decoded, err = decodeAttachment(strings.NewReader("caf=C3=A9 au=\r\n lait"), "Quoted-Printable")
+	assert.NoError(t, err)
+	assert.Equal(t, "café au lait", string(decoded))
+
+	decoded, err = decodeAttachment(strings.NewReader("as is"), "7bit")
+	assert.NoError(t, err)
+	assert.Equal(t, "as is", string(decoded))
+
+	_, err = decodeAttachment(strings.NewReader("not base64!"), "base64")
+	assert.Error(t, err)
+}
+
+// attachmentClient answers UID FETCH with the given contents of parts,
+// keyed by part name.
+type attachmentClient struct {
+	IMAPClient
+	parts map[string]string
+}
+
+func (c *attachmentClient) UidFetch(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error {
+	defer close(ch)
+	message := &imap.Message{Uid: 7, Body: map[*imap.BodySectionName]imap.Literal{}}
+	for _, item := range items {
+		section, err := imap.ParseBodySectionName(item)
+		if err != nil {
+			continue
+		}
+		name := Attachment{Part: section.Path}.PartName()
+		if content, ok := c.parts[name]; ok {
+			// Responses name the section without .PEEK.
+			section.Peek = false
+			message.Body[section] = bytes.NewBufferString(content)
+		}
+	}
+	ch <- message
+	return nil
+}
+
+func TestFetchAttachments(t *testing.T) {
+	imapClient := &attachmentClient{parts: map[string]string{"2": "JVBERi0=", "3.1": "caf=C3=A9"}}
+	contents, err := fetchAttachments(imapClient, 7, []Attachment{
+		{Part: []int{2}, Encoding: "base64"},
+		{Part: []int{3, 1}, Encoding: "quoted-printable"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("%PDF-"), []byte("café")}, contents)
+
+	_, err = fetchAttachments(imapClient, 7, []Attachment{{Part: []int{4}}})
+	assert.ErrorContains(t, err, "did not return part 4")
+}
+
+func TestAttachmentTemplatePath(t *testing.T) {
+	message := &imap.Message{
+		Uid:          12,
+		InternalDate: time.Date(2023, 11, 7, 12, 0, 0, 0, time.Local),
+		Envelope:     &imap.Envelope{From: []*imap.Address{{MailboxName: "Billing", HostName: "Example.COM"}}},
+	}
+	attachment := Attachment{Part: []int{2}, Filename: "invoice.tar.gz"}
+
+	tests := []struct {
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{DefaultAttachmentTemplate, "2023-11-07_billing@example.com_invoice.tar.gz", false},
+		{"{{.Year}}/{{.Domain}}/{{.Name}}-{{.UID}}.{{.Part}}{{.Ext}}", filepath.Join("2023", "example.com", "invoice.tar-12.2.gz"), false},
+		{"{{.Year}}/../{{.Filename}}", "", true},
+		{"{{.Year}}//{{.Filename}}", "", true},
+	}
+	for _, tt := range tests {
+		naming, err := ParseAttachmentTemplate(tt.text)
+		if !assert.NoError(t, err, tt.text) {
+			continue
+		}
+		path, err := naming.Path(message, attachment)
+		if tt.wantErr {
+			assert.Error(t, err, tt.text)
+			continue
+		}
+		assert.NoError(t, err, tt.text)
+		assert.Equal(t, tt.want, path)
+	}
+
+	// The sender chooses its address; separators of any platform and control
+	// characters in it must not reach the path.
+	hostile := &imap.Message{
+		Uid:          13,
+		InternalDate: time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local),
+		Envelope:     &imap.Envelope{From: []*imap.Address{{MailboxName: `\..\..\..\x`, HostName: "evil\n/..\\.."}}},
+	}
+	naming, err := ParseAttachmentTemplate(DefaultAttachmentTemplate)
+	assert.NoError(t, err)
+	path, err := naming.Path(hostile, Attachment{Part: []int{2}, Filename: `..\..\run.bat`})
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-01-01_"+`_.._.._.._x@evil__.._..`+"_.._.._run.bat", path)
+	assert.True(t, filepath.IsLocal(path))
+	naming, err = ParseAttachmentTemplate("{{.Domain}}/{{.Filename}}")
+	assert.NoError(t, err)
+	path, err = naming.Path(hostile, attachment)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("evil__.._..", "invoice.tar.gz"), path)
+
+	_, err = ParseAttachmentTemplate("{{.Sender}}/{{.Weekday}}")
+	assert.Error(t, err)
+}
+
+func TestAttachmentStore(t *testing.T) {
+	dir := t.TempDir()
+	naming, err := ParseAttachmentTemplate("{{.Filename}}")
+	assert.NoError(t, err)
+	message := func(uid uint32, messageID string) *imap.Message {
+		return &imap.Message{Uid: uid, InternalDate: time.Now(), Envelope: &imap.Envelope{MessageId: messageID, Subject: "files"}}
+	}
+	report := Attachment{Part: []int{2}, Filename: "report.pdf", MIMEType: "application/pdf"}
+
+	store, err := OpenAttachmentStore(dir, false)
+	assert.NoError(t, err)
+	first, added, err := store.Save(naming, "work", "INBOX", message(1, "<1@example.com>"), report, []byte("first"))
+	assert.NoError(t, err)
+	assert.True(t, added)
+	assert.Equal(t, "report.pdf", first.Path)
+
+	// Same content from another message: not written again.
+	copied, added, err := store.Save(naming, "work", "INBOX", message(2, "<2@example.com>"), report, []byte("first"))
+	assert.NoError(t, err)
+	assert.True(t, added)
+	assert.True(t, copied.Duplicate)
+	assert.Equal(t, "report.pdf", copied.Path)
+
+	// Other content under the same name gets a suffix.
+	other, _, err := store.Save(naming, "work", "INBOX", message(3, "<3@example.com>"), report, []byte("second"))
+	assert.NoError(t, err)
+	assert.Equal(t, "report-2.pdf", other.Path)
+	assert.NoError(t, store.Close())
+
+	content, err := os.ReadFile(filepath.Join(dir, "report-2.pdf"))
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(content))
+
+	// A later run remembers what was saved.
+	store, err = OpenAttachmentStore(dir, false)
+	assert.NoError(t, err)
+	assert.Len(t, store.Attachments, 3)
+	_, added, err = store.Save(naming, "work", "INBOX", message(1, "<1@example.com>"), report, []byte("first"))
+	assert.NoError(t, err)
+	assert.False(t, added)
+	again, added, err := store.Save(naming, "work", "Archive", message(9, "<9@example.com>"), report, []byte("second"))
+	assert.NoError(t, err)
+	assert.True(t, added)
+	assert.Equal(t, "report-2.pdf", again.Path)
+	saved, ok := store.Find("work", "INBOX", "<3@example.com>", "2")
+	assert.True(t, ok)
+	assert.Equal(t, "report-2.pdf", saved.Path)
+
+	// A dry run writes nothing.
+	dryRunDir := t.TempDir()
+	store, err = OpenAttachmentStore(dryRunDir, true)
+	assert.NoError(t, err)
+	_, _, err = store.Save(naming, "work", "INBOX", message(1, "<1@example.com>"), report, []byte("first"))
+	assert.NoError(t, err)
+	assert.NoError(t, store.Close())
+	entries, err := os.ReadDir(dryRunDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,510 @@
+package imaputils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/wryfi/shemail/mailstore"
+)
+
+const (
+	// BackupManifestFile is the name of the manifest within a backup
+	// directory.
+	BackupManifestFile = "manifest.json"
+	// BackupChecksumFile is the name of the checksum list within a backup
+	// directory, in the format `sha256sum --check` reads.
+	BackupChecksumFile = "SHA256SUMS"
+)
+
+// backupSaveInterval is how many downloaded messages go by between saves of
+// the manifest within a folder, so that an interrupted backup loses little.
+const backupSaveInterval = 1000
+
+// BackupManifest describes a local backup of an account: one Maildir per
+// folder, and for every message its UID, flags and checksum. It is also the
+// bookkeeping that makes later backups incremental.
+type BackupManifest struct {
+	dir     string
+	Account string                   `json:"account"`
+	Updated time.Time                `json:"updated"`
+	Folders map[string]*FolderBackup `json:"folders"`
+}
+
+// FolderBackup is the backup of one folder.
+type FolderBackup struct {
+	// Path is the folder's Maildir, relative to the backup directory.
+	Path string `json:"path"`
+	// UidValidity is the UIDVALIDITY the UIDs in Files were issued under.
+	UidValidity uint32 `json:"uid_validity"`
+	// Messages is how many messages were in the folder on the server at the
+	// last backup; Retained is how many more are kept locally after being
+	// deleted on the server.
+	Messages int          `json:"messages"`
+	Retained int          `json:"retained"`
+	Updated  time.Time    `json:"updated"`
+	Files    []BackupFile `json:"files"`
+}
+
+// BackupFile is one message in a folder's backup.
+type BackupFile struct {
+	// Name is the file name in the Maildir's cur directory.
+	Name   string   `json:"name"`
+	UID    uint32   `json:"uid"`
+	Flags  []string `json:"flags,omitempty"`
+	Size   int64    `json:"size"`
+	SHA256 string   `json:"sha256"`
+	// DeletedOnServer is when the message was found to be gone from the
+	// server; it is zero while the message is still there.
+	DeletedOnServer time.Time `json:"deleted_on_server,omitzero"`
+}
+
+// LoadBackupManifest reads the manifest of the backup in dir. A missing
+// manifest is not an error: it yields an empty one, for a first backup.
+func LoadBackupManifest(dir string) (*BackupManifest, error) {
+	manifest := &BackupManifest{dir: dir, Folders: map[string]*FolderBackup{}}
+
+	path := filepath.Join(dir, BackupManifestFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup manifest %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse backup manifest %s: %w", path, err)
+	}
+	if manifest.Folders == nil {
+		manifest.Folders = map[string]*FolderBackup{}
+	}
+	return manifest, nil
+}
+
+// Save writes the manifest and the checksum list. Each is written to a
+// temporary name and renamed into place, so an interrupted save leaves the
+// previous version intact.
+func (manifest *BackupManifest) Save() error {
+	if err := os.MkdirAll(manifest.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	manifest.Updated = time.Now()
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode backup manifest: %w", err)
+	}
+	if err := replaceFile(filepath.Join(manifest.dir, BackupManifestFile), data); err != nil {
+		return err
+	}
+
+	var sums strings.Builder
+	for _, name := range manifest.folderNames() {
+		folder := manifest.Folders[name]
+		for _, file := range folder.Files {
+			fmt.Fprintf(&sums, "%s  %s\n", file.SHA256, filepath.ToSlash(filepath.Join(folder.Path, "cur", file.Name)))
+		}
+	}
+	return replaceFile(filepath.Join(manifest.dir, BackupChecksumFile), []byte(sums.String()))
+}
+
+// replaceFile writes data to path via a temporary file.
+func replaceFile(path string, data []byte) error {
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// MessageCount returns how many messages the backup holds, including those
+// kept after being deleted on the server.
+func (manifest *BackupManifest) MessageCount() int {
+	count := 0
+	for _, folder := range manifest.Folders {
+		count += len(folder.Files)
+	}
+	return count
+}
+
+func (manifest *BackupManifest) folderNames() []string {
+	names := make([]string, 0, len(manifest.Folders))
+	for name := range manifest.Folders {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Verify checks the backup against its manifest and describes every problem
+// found: messages whose file is missing or no longer matches its checksum,
+// and files in the folders' Maildirs that the manifest does not know about
+// (e.g. left by an interrupted backup).
+func (manifest *BackupManifest) Verify() ([]string, error) {
+	var problems []string
+	for _, name := range manifest.folderNames() {
+		folder := manifest.Folders[name]
+		maildir := filepath.Join(manifest.dir, folder.Path)
+		known := map[string]bool{}
+		for _, file := range folder.Files {
+			known[file.Name] = true
+			path := filepath.Join(maildir, "cur", file.Name)
+			sum, _, err := fileChecksum(path)
+			if errors.Is(err, os.ErrNotExist) {
+				problems = append(problems, fmt.Sprintf("%s: missing %s", name, path))
+				continue
+			}
+			if err != nil {
+				return problems, err
+			}
+			if sum != file.SHA256 {
+				problems = append(problems, fmt.Sprintf("%s: checksum mismatch for %s", name, path))
+			}
+		}
+
+		for _, sub := range []string{"cur", "new"} {
+			entries, err := os.ReadDir(filepath.Join(maildir, sub))
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return problems, fmt.Errorf("failed to read %s: %w", maildir, err)
+			}
+			for _, entry := range entries {
+				if entry.Type().IsRegular() && (sub != "cur" || !known[entry.Name()]) {
+					problems = append(problems, fmt.Sprintf("%s: %s is not in the manifest", name, filepath.Join(maildir, sub, entry.Name())))
+				}
+			}
+		}
+	}
+	return problems, nil
+}
+
+// fileChecksum returns the hex SHA-256 and the size of the file at path.
+func fileChecksum(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// backupFolderPath maps a folder name to a relative path with one directory
+// per level of the folder hierarchy. Each level is escaped so that it is a
+// single valid path element and cannot clash with a Maildir's own cur, new
+// and tmp directories.
+func backupFolderPath(name, delimiter string) string {
+	parts := []string{name}
+	if delimiter != "" {
+		parts = strings.Split(name, delimiter)
+	}
+	for index, part := range parts {
+		escaped := url.PathEscape(part)
+		switch escaped {
+		case "":
+			escaped = "%"
+		case ".", "..", "cur", "new", "tmp":
+			escaped = fmt.Sprintf("%%%02X%s", escaped[0], escaped[1:])
+		}
+		parts[index] = escaped
+	}
+	return filepath.Join(parts...)
+}
+
+// BackupOptions controls BackupFolder.
+type BackupOptions struct {
+	// KeepDeleted keeps the local copy of messages that were deleted on the
+	// server, instead of removing it.
+	KeepDeleted bool
+	// DryRun works out what would change without downloading anything or
+	// touching the backup.
+	DryRun bool
+}
+
+// BackupResult counts what BackupFolder did to one folder's backup.
+type BackupResult struct {
+	Folder      string
+	Downloaded  int
+	FlagChanges int
+	// Removed and Retained count the messages deleted on the server since
+	// the last backup whose local copies were removed and kept, respectively.
+	Removed  int
+	Retained int
+	// Reset is set when the folder's UIDVALIDITY changed, which voids every
+	// stored UID: messages are then matched by checksum instead.
+	Reset bool
+}
+
+// BackupFolder brings the backup of folder in manifest up to date, without
+// saving the manifest at the end. The folder is selected read-only and
+// downloads use BODY.PEEK[], so backing up never changes the mailbox.
+//
+// Only messages whose UIDs are not in the backup yet are downloaded. The
+// flags of those already there are compared with the server's, and their
+// files renamed when they have changed. Messages deleted on the server are
+// removed from the backup, or kept and marked as deleted with
+// options.KeepDeleted; a download that matches the checksum of such a kept
+// message revives it instead of storing a second copy.
+func BackupFolder(dialer IMAPDialer, account Account, manifest *BackupManifest, folder FolderStatus, options BackupOptions) (BackupResult, error) {
+	result := BackupResult{Folder: folder.Name}
+	entry := manifest.Folders[folder.Name]
+	if entry == nil {
+		entry = &FolderBackup{Path: backupFolderPath(folder.Name, folder.Delimiter)}
+		if !options.DryRun {
+			manifest.Folders[folder.Name] = entry
+		}
+	}
+	maildir := filepath.Join(manifest.dir, entry.Path)
+	if !options.DryRun {
+		if err := mailstore.CreateMaildir(maildir); err != nil {
+			return result, err
+		}
+	}
+
+	imapClient, status, err := selectMailbox(dialer, account, folder.Name, true)
+	if err != nil {
+		return result, fmt.Errorf("failed to select %s: %w", folder.Name, err)
+	}
+	defer imapClient.Logout()
+
+	if entry.UidValidity != 0 && entry.UidValidity != status.UidValidity {
+		log.Warn().Msgf("UIDVALIDITY of %s changed (%d -> %d); matching its backup by checksum",
+			folder.Name, entry.UidValidity, status.UidValidity)
+		result.Reset = true
+	}
+
+	serverFlags, err := backupServerFlags(imapClient, folder.Name)
+	if err != nil {
+		return result, err
+	}
+
+	// Reconcile the messages already backed up with the server. Those that
+	// are gone are only noted for now: downloads can still revive them, and
+	// the manifest, which is saved while downloading, must not record them
+	// as deleted before the folder is done.
+	now := time.Now()
+	stored := map[uint32]bool{}
+	gone := map[int]bool{}
+	for index := range entry.Files {
+		file := &entry.Files[index]
+		if !file.DeletedOnServer.IsZero() {
+			continue
+		}
+		flags, ok := serverFlags[file.UID]
+		if !ok || result.Reset {
+			gone[index] = true
+			continue
+		}
+		stored[file.UID] = true
+		if slices.Equal(file.Flags, flags) {
+			continue
+		}
+		result.FlagChanges++
+		if err := setBackupFlags(maildir, file, flags, options.DryRun); err != nil {
+			return result, err
+		}
+	}
+
+	var missing []uint32
+	for uid := range serverFlags {
+		if !stored[uid] {
+			missing = append(missing, uid)
+		}
+	}
+	slices.Sort(missing)
+
+	// Kept copies of deleted messages, and those gone in this run, by
+	// checksum, to revive rather than duplicate.
+	revivable := map[string]int{}
+	for index, file := range entry.Files {
+		if gone[index] || !file.DeletedOnServer.IsZero() {
+			revivable[file.SHA256] = index
+		}
+	}
+	if options.DryRun {
+		result.Downloaded = len(missing)
+	} else {
+		revived, err := downloadBackup(imapClient, manifest, entry, maildir, folder.Name, missing, revivable, &result)
+		if err != nil {
+			return result, err
+		}
+		for index := range revived {
+			delete(gone, index)
+		}
+	}
+
+	// Whatever is still gone was not re-downloaded.
+	var removed []string
+	for index := range gone {
+		file := &entry.Files[index]
+		if options.KeepDeleted {
+			result.Retained++
+			if !options.DryRun {
+				file.DeletedOnServer = now
+			}
+			continue
+		}
+		result.Removed++
+		removed = append(removed, file.Name)
+	}
+	if !options.DryRun {
+		for _, name := range removed {
+			if err := os.Remove(filepath.Join(maildir, "cur", name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return result, fmt.Errorf("failed to remove %s from the backup: %w", name, err)
+			}
+		}
+		if !options.KeepDeleted {
+			kept := entry.Files[:0]
+			for index, file := range entry.Files {
+				if !gone[index] {
+					kept = append(kept, file)
+				}
+			}
+			entry.Files = kept
+		}
+		entry.UidValidity = status.UidValidity
+		entry.Messages = len(serverFlags)
+		entry.Retained = len(entry.Files) - len(serverFlags)
+		entry.Updated = now
+	}
+	return result, nil
+}
+
+// backupServerFlags returns the flags of every message in the selected
+// folder, by UID.
+func backupServerFlags(imapClient IMAPClient, folder string) (map[uint32][]string, error) {
+	uids, err := findMessageUIDs(imapClient, imap.NewSearchCriteria())
+	if err != nil {
+		return nil, err
+	}
+	flags := make(map[uint32][]string, len(uids))
+	var fetchErr error
+	streamUIDItems(imapClient, "checking "+folder, uids, []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, DefaultChunkSize,
+		func(message *imap.Message, err error) bool {
+			if err != nil {
+				fetchErr = err
+				return false
+			}
+			flags[message.Uid] = backupFlags(message.Flags)
+			return true
+		})
+	if fetchErr != nil {
+		return nil, fmt.Errorf("failed to fetch flags in %s: %w", folder, fetchErr)
+	}
+	return flags, nil
+}
+
+// backupFlags returns flags, sorted and without \Recent, which describes the
+// session rather than the message.
+func backupFlags(flags []string) []string {
+	kept := slices.DeleteFunc(slices.Clone(flags), func(flag string) bool { return flag == imap.RecentFlag })
+	slices.Sort(kept)
+	return kept
+}
+
+// setBackupFlags records flags on a backed-up message, renaming its file to
+// match unless dryRun is set.
+func setBackupFlags(maildir string, file *BackupFile, flags []string, dryRun bool) error {
+	if slices.Equal(file.Flags, flags) || dryRun {
+		return nil
+	}
+	name, err := mailstore.SetMaildirFlags(maildir, file.Name, flags)
+	if err != nil {
+		return err
+	}
+	file.Name = name
+	file.Flags = flags
+	return nil
+}
+
+// downloadBackup downloads the messages uids of the selected folder into its
+// backup, saving the manifest every backupSaveInterval messages. A download
+// with the checksum of a file in deleted revives that file instead, and is
+// taken out of deleted. It returns the indexes in entry.Files of the revived
+// files.
+func downloadBackup(imapClient IMAPClient, manifest *BackupManifest, entry *FolderBackup, maildir, folder string, uids []uint32, deleted map[string]int, result *BackupResult) (map[int]bool, error) {
+	writer, err := mailstore.NewWriter(mailstore.FormatMaildir, maildir)
+	if err != nil {
+		return nil, err
+	}
+	defer writer.Close()
+
+	revived := map[int]bool{}
+	var downloadErr error
+	streamUIDItems(imapClient, "downloading "+folder, uids, rawFetchItems(), RawChunkSize, func(message *imap.Message, err error) bool {
+		if err == nil {
+			err = backupMessage(entry, maildir, message, writer, deleted, revived)
+		}
+		if err != nil {
+			downloadErr = err
+			return false
+		}
+		result.Downloaded++
+		if result.Downloaded%backupSaveInterval == 0 {
+			if err := manifest.Save(); err != nil {
+				downloadErr = err
+				return false
+			}
+		}
+		return true
+	})
+	if downloadErr != nil {
+		return nil, fmt.Errorf("failed to back up %s: %w", folder, downloadErr)
+	}
+	return revived, nil
+}
+
+// backupMessage writes one downloaded message to the backup and records it in
+// entry, or revives the kept copy of a deleted message with the same
+// checksum, adding its index to revived.
+func backupMessage(entry *FolderBackup, maildir string, message *imap.Message, writer mailstore.Writer, deleted map[string]int, revived map[int]bool) error {
+	stored, err := StoreMessage(message)
+	if err != nil {
+		return err
+	}
+	flags := backupFlags(stored.Flags)
+	stored.Flags = flags
+	name, err := writer.Write(stored)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(maildir, "cur", name)
+	sum, size, err := fileChecksum(path)
+	if err != nil {
+		return fmt.Errorf("failed to checksum %s: %w", path, err)
+	}
+
+	if index, ok := deleted[sum]; ok {
+		delete(deleted, sum)
+		revived[index] = true
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove duplicate %s: %w", path, err)
+		}
+		file := &entry.Files[index]
+		file.UID = message.Uid
+		file.DeletedOnServer = time.Time{}
+		return setBackupFlags(maildir, file, flags, false)
+	}
+
+	entry.Files = append(entry.Files, BackupFile{Name: name, UID: message.Uid, Flags: flags, Size: size, SHA256: sum})
+	return nil
+}
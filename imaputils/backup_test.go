@@ -0,0 +1,188 @@
+package imaputils
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// backupServerMessage is a message on the mock server of TestBackupFolder.
+type backupServerMessage struct {
+	uid   uint32
+	flags []string
+	body  string
+}
+
+// backupDialer returns a dialer for a server whose INBOX holds messages under
+// validity, and which sends the complete messages download when asked.
+func backupDialer(validity uint32, messages, download []backupServerMessage) *MockIMAPDialerMove {
+	return failingBackupDialer(validity, messages, download, nil)
+}
+
+// failingBackupDialer is backupDialer for a server whose download fails with
+// downloadErr after sending download.
+func failingBackupDialer(validity uint32, messages, download []backupServerMessage, downloadErr error) *MockIMAPDialerMove {
+	bodySection, _ := imap.ParseBodySectionName("BODY[]")
+	client := &MockIMAPClientMove{}
+	dialer := &MockIMAPDialerMove{}
+	dialer.On("Dial", mock.Anything).Return(client, nil)
+	client.On("Login", mock.Anything, mock.Anything).Return(nil)
+	client.On("Logout").Return(nil)
+	client.On("Select", "INBOX", true).Return(&imap.MailboxStatus{UidValidity: validity}, nil)
+
+	var uids []uint32
+	for _, message := range messages {
+		uids = append(uids, message.uid)
+	}
+	client.On("UidSearch", mock.Anything).Return(uids, nil)
+	client.On("UidFetch", mock.Anything, []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, mock.Anything).Return(
+		func(ch chan *imap.Message) {
+			for _, message := range messages {
+				ch <- &imap.Message{Uid: message.uid, Flags: message.flags}
+			}
+		}, nil)
+	client.On("UidFetch", mock.Anything, rawFetchItems(), mock.Anything).Return(
+		func(ch chan *imap.Message) {
+			for _, message := range download {
+				ch <- &imap.Message{
+					Uid:          message.uid,
+					Flags:        message.flags,
+					InternalDate: time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC),
+					Body:         map[*imap.BodySectionName]imap.Literal{bodySection: bytes.NewBufferString(message.body)},
+				}
+			}
+		}, downloadErr)
+	return dialer
+}
+
+func TestBackupFolder(t *testing.T) {
+	dir := t.TempDir()
+	inbox := FolderStatus{Name: "INBOX", Delimiter: "/", Selectable: true}
+	one := backupServerMessage{1, nil, "Subject: one\r\n\r\n"}
+	two := backupServerMessage{2, []string{imap.SeenFlag}, "Subject: two\r\n\r\n"}
+	three := backupServerMessage{3, nil, "Subject: three\r\n\r\n"}
+
+	// The first backup downloads everything.
+	manifest, err := LoadBackupManifest(dir)
+	assert.NoError(t, err)
+	result, err := BackupFolder(backupDialer(7, []backupServerMessage{one, two}, []backupServerMessage{one, two}), Account{}, manifest, inbox, BackupOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, BackupResult{Folder: "INBOX", Downloaded: 2}, result)
+	assert.NoError(t, manifest.Save())
+
+	sums, err := os.ReadFile(filepath.Join(dir, BackupChecksumFile))
+	assert.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(sums)), "\n"), 2)
+	assert.Contains(t, string(sums), "  INBOX/cur/")
+	problems, err := manifest.Verify()
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	// The next one downloads only the new message, renames the file of one
+	// whose flags changed and keeps the one deleted on the server.
+	manifest, err = LoadBackupManifest(dir)
+	assert.NoError(t, err)
+	seenOne := backupServerMessage{1, []string{imap.SeenFlag}, one.body}
+	result, err = BackupFolder(backupDialer(7, []backupServerMessage{seenOne, three}, []backupServerMessage{three}), Account{}, manifest, inbox, BackupOptions{KeepDeleted: true})
+	assert.NoError(t, err)
+	assert.Equal(t, BackupResult{Folder: "INBOX", Downloaded: 1, FlagChanges: 1, Retained: 1}, result)
+	folder := manifest.Folders["INBOX"]
+	assert.Equal(t, 2, folder.Messages)
+	assert.Equal(t, 1, folder.Retained)
+	if assert.Len(t, folder.Files, 3) {
+		assert.True(t, strings.HasSuffix(folder.Files[0].Name, ":2,S"), "the flag change renames the file")
+		assert.False(t, folder.Files[1].DeletedOnServer.IsZero())
+	}
+	assert.NoError(t, manifest.Save())
+	problems, err = manifest.Verify()
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	// After a UIDVALIDITY change, a download matching a backed-up message
+	// reuses it; message three is gone and, without KeepDeleted, removed.
+	manifest, err = LoadBackupManifest(dir)
+	assert.NoError(t, err)
+	renumbered := backupServerMessage{10, []string{imap.SeenFlag}, one.body}
+	result, err = BackupFolder(backupDialer(8, []backupServerMessage{renumbered}, []backupServerMessage{renumbered}), Account{}, manifest, inbox, BackupOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, BackupResult{Folder: "INBOX", Downloaded: 1, Removed: 1, Reset: true}, result)
+	folder = manifest.Folders["INBOX"]
+	assert.Equal(t, uint32(8), folder.UidValidity)
+	if assert.Len(t, folder.Files, 2, "no second copy of message one; message two was kept by the previous run") {
+		assert.Equal(t, uint32(10), folder.Files[0].UID)
+		assert.True(t, folder.Files[0].DeletedOnServer.IsZero())
+	}
+	assert.NoError(t, manifest.Save())
+	problems, err = manifest.Verify()
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	// Verify notices damage.
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "INBOX", "cur", folder.Files[0].Name), []byte("changed"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "INBOX", "new", "stray"), []byte("Subject: stray\n\n"), 0o600))
+	problems, err = manifest.Verify()
+	assert.NoError(t, err)
+	assert.Len(t, problems, 2)
+	assert.True(t, slices.ContainsFunc(problems, func(problem string) bool { return strings.Contains(problem, "checksum mismatch") }))
+}
+
+func TestBackupFolderFailedDownload(t *testing.T) {
+	dir := t.TempDir()
+	inbox := FolderStatus{Name: "INBOX", Delimiter: "/", Selectable: true}
+	one := backupServerMessage{1, nil, "Subject: one\r\n\r\n"}
+	two := backupServerMessage{2, nil, "Subject: two\r\n\r\n"}
+	manifest, err := LoadBackupManifest(dir)
+	assert.NoError(t, err)
+	_, err = BackupFolder(backupDialer(7, []backupServerMessage{one}, []backupServerMessage{one}), Account{}, manifest, inbox, BackupOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, manifest.Save())
+
+	// Message one is gone and the download of two fails: the manifest, saved
+	// after the failure as the backup command does, must not mark one deleted.
+	_, err = BackupFolder(failingBackupDialer(7, []backupServerMessage{two}, nil, errors.New("connection reset")), Account{}, manifest, inbox, BackupOptions{})
+	assert.ErrorContains(t, err, "connection reset")
+	assert.NoError(t, manifest.Save())
+	manifest, err = LoadBackupManifest(dir)
+	assert.NoError(t, err)
+	if assert.Len(t, manifest.Folders["INBOX"].Files, 1) {
+		assert.True(t, manifest.Folders["INBOX"].Files[0].DeletedOnServer.IsZero())
+	}
+
+	// So the next run still removes it, rather than counting it as kept.
+	result, err := BackupFolder(backupDialer(7, []backupServerMessage{two}, []backupServerMessage{two}), Account{}, manifest, inbox, BackupOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, BackupResult{Folder: "INBOX", Downloaded: 1, Removed: 1}, result)
+	if assert.Len(t, manifest.Folders["INBOX"].Files, 1) {
+		assert.Equal(t, uint32(2), manifest.Folders["INBOX"].Files[0].UID)
+	}
+}
+
+func TestBackupFolderDryRun(t *testing.T) {
+	dir := t.TempDir()
+	manifest, err := LoadBackupManifest(dir)
+	assert.NoError(t, err)
+	messages := []backupServerMessage{{1, nil, "Subject: one\r\n\r\n"}}
+	result, err := BackupFolder(backupDialer(7, messages, nil), Account{}, manifest, FolderStatus{Name: "INBOX"}, BackupOptions{DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Downloaded)
+	assert.Empty(t, manifest.Folders)
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "a dry run writes nothing")
+}
+
+func TestBackupFolderPath(t *testing.T) {
+	assert.Equal(t, filepath.Join("Archive", "2020"), backupFolderPath("Archive.2020", "."))
+	assert.Equal(t, filepath.Join("Projects", "%63ur"), backupFolderPath("Projects/cur", "/"))
+	assert.Equal(t, filepath.Join("%2E.", "a%2Fb"), backupFolderPath("..|a/b", "|"))
+	assert.Equal(t, "INBOX", backupFolderPath("INBOX", ""))
+}
@@ -0,0 +1,662 @@
+package imaputils
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/wryfi/shemail/progress"
+)
+
+// cacheExtension is the file extension of the files of a folder's cache.
+const cacheExtension = ".gob"
+
+// cacheMetaFile holds a folder's folderMeta, next to its buckets.
+const cacheMetaFile = "folder" + cacheExtension
+
+// cacheBucketSize is how many consecutive UIDs share a file of a folder's
+// cache. Only the bucket being read or written is held in memory, so a folder
+// of any size is streamed from cache with about one bucket and one chunk of
+// messages in memory.
+const cacheBucketSize = 1000
+
+// EnvelopeCache is an on-disk cache of the message metadata that searches
+// fetch (envelope, flags, size and internal date), so that repeated queries
+// against the same folder only fetch what changed since the last run. It holds
+// one directory per account and folder, valid for a single UIDVALIDITY.
+//
+// The cache is filled on demand: a folder's cache only ever holds messages that
+// some search has matched, so a narrow query on a huge folder stays cheap.
+type EnvelopeCache struct {
+	dir string
+}
+
+// NewEnvelopeCache returns a cache rooted at dir (see config.CacheDir).
+func NewEnvelopeCache(dir string) *EnvelopeCache {
+	return &EnvelopeCache{dir: dir}
+}
+
+// CachedMessage is the cached metadata of one message.
+type CachedMessage struct {
+	Envelope     *imap.Envelope
+	Flags        []string
+	Size         uint32
+	InternalDate time.Time
+}
+
+// folderMeta is what a folder's cache records about the folder itself. Its
+// messages are kept apart, in buckets (see cacheBucketSize), keyed by UID,
+// which is only meaningful under UidValidity.
+type folderMeta struct {
+	UidValidity uint32
+	// HighestModSeq is the folder's HIGHESTMODSEQ when the flags were last
+	// brought up to date; zero when the server lacks CONDSTORE.
+	HighestModSeq uint64
+	Updated       time.Time
+}
+
+// CacheStats describes one cached folder.
+type CacheStats struct {
+	Account     string
+	Folder      string
+	UidValidity uint32
+	Messages    int
+	Bytes       int64
+	Updated     time.Time
+}
+
+// accountDir returns the directory holding account's cached folders. Names
+// are escaped so that hierarchy delimiters in folder names cannot escape it.
+func (cache *EnvelopeCache) accountDir(account string) string {
+	return filepath.Join(cache.dir, url.PathEscape(account))
+}
+
+// folderDir returns the directory holding a folder's cache: its folderMeta in
+// cacheMetaFile and its messages in one file per bucket.
+func (cache *EnvelopeCache) folderDir(account, folder string) string {
+	return filepath.Join(cache.accountDir(account), url.PathEscape(folder))
+}
+
+// legacyFolderPath is where a folder's cache was kept when it was a single
+// file, which had to be read and written whole.
+func (cache *EnvelopeCache) legacyFolderPath(account, folder string) string {
+	return cache.folderDir(account, folder) + cacheExtension
+}
+
+func readCacheFile(path string, value any) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := gob.NewDecoder(file).Decode(value); err != nil {
+		return fmt.Errorf("failed to decode cache file %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeCacheFile writes value to path, via a temporary file renamed into place
+// so a concurrent or interrupted run never sees a partial file.
+func writeCacheFile(path string, value any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create cache file: %w", err)
+	}
+	defer os.Remove(temp.Name())
+
+	if err := gob.NewEncoder(temp).Encode(value); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to encode cache file %s: %w", path, err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace cache file: %w", err)
+	}
+	return nil
+}
+
+// folderCache is one folder's cache, opened for a run. At most one bucket of
+// its messages is held in memory at a time: reaching a UID in another bucket
+// first writes the loaded one back if it changed.
+type folderCache struct {
+	dir  string
+	meta folderMeta
+
+	// index is the number of the loaded bucket, when messages is not nil;
+	// dirty is set once messages differ from the bucket's file.
+	index    uint32
+	messages map[uint32]CachedMessage
+	dirty    bool
+
+	// refreshFlags is set when the flags of cached matches must be refetched
+	// as they are served; modSeq is the HIGHESTMODSEQ to record once a run has
+	// brought every cached message's flags up to date.
+	refreshFlags bool
+	modSeq       uint64
+}
+
+// open returns a folder's cache, with none of its buckets loaded yet. A cache
+// left in the old single-file format is removed; it is refilled on demand.
+func (cache *EnvelopeCache) open(account, folder string) *folderCache {
+	if err := os.Remove(cache.legacyFolderPath(account, folder)); err == nil {
+		log.Debug().Msgf("removed the old-format cache of %s", folder)
+	}
+	return openFolderCache(cache.folderDir(account, folder))
+}
+
+// openFolderCache reads the folderMeta in dir. A missing one yields an empty
+// cache; so does an unreadable one, which is logged and will be overwritten.
+// Either way the UIDVALIDITY check then discards whatever else is in dir.
+func openFolderCache(dir string) *folderCache {
+	cached := &folderCache{dir: dir}
+	path := filepath.Join(dir, cacheMetaFile)
+	err := readCacheFile(path, &cached.meta)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warn().Err(err).Msgf("ignoring unreadable cache file %s", path)
+		cached.meta = folderMeta{}
+	}
+	return cached
+}
+
+func (cached *folderCache) bucketPath(index uint32) string {
+	return filepath.Join(cached.dir, strconv.FormatUint(uint64(index), 10)+cacheExtension)
+}
+
+// buckets returns the numbers of the buckets on disk, in order.
+func (cached *folderCache) buckets() ([]uint32, error) {
+	entries, err := os.ReadDir(cached.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+	var indexes []uint32
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), cacheExtension)
+		if !ok {
+			continue
+		}
+		// cacheMetaFile is the one name that is not a number.
+		if index, err := strconv.ParseUint(name, 10, 32); err == nil {
+			indexes = append(indexes, uint32(index))
+		}
+	}
+	slices.Sort(indexes)
+	return indexes, nil
+}
+
+// bucket returns the bucket holding uid, loading it if another one is loaded.
+// A missing bucket is empty; so is an unreadable one, which is logged and will
+// be overwritten once it changes.
+func (cached *folderCache) bucket(uid uint32) (map[uint32]CachedMessage, error) {
+	index := uid / cacheBucketSize
+	if cached.messages != nil && cached.index == index {
+		return cached.messages, nil
+	}
+	if err := cached.flush(); err != nil {
+		return nil, err
+	}
+
+	messages := map[uint32]CachedMessage{}
+	path := cached.bucketPath(index)
+	if err := readCacheFile(path, &messages); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Warn().Err(err).Msgf("ignoring unreadable cache file %s", path)
+		messages = map[uint32]CachedMessage{}
+	}
+	cached.index, cached.messages, cached.dirty = index, messages, false
+	return messages, nil
+}
+
+func (cached *folderCache) get(uid uint32) (CachedMessage, bool, error) {
+	messages, err := cached.bucket(uid)
+	if err != nil {
+		return CachedMessage{}, false, err
+	}
+	message, ok := messages[uid]
+	return message, ok, nil
+}
+
+func (cached *folderCache) put(uid uint32, message CachedMessage) error {
+	messages, err := cached.bucket(uid)
+	if err != nil {
+		return err
+	}
+	messages[uid] = message
+	cached.dirty = true
+	return nil
+}
+
+// flush writes the loaded bucket back if it changed, or removes its file if
+// it is now empty.
+func (cached *folderCache) flush() error {
+	if !cached.dirty {
+		return nil
+	}
+	path := cached.bucketPath(cached.index)
+	if len(cached.messages) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove cache file: %w", err)
+		}
+	} else if err := writeCacheFile(path, cached.messages); err != nil {
+		return err
+	}
+	cached.dirty = false
+	return nil
+}
+
+// close writes the loaded bucket back, then the folderMeta.
+func (cached *folderCache) close() error {
+	if err := cached.flush(); err != nil {
+		return err
+	}
+	cached.meta.Updated = time.Now()
+	return writeCacheFile(filepath.Join(cached.dir, cacheMetaFile), &cached.meta)
+}
+
+// reset discards everything cached for the folder, which now has uidValidity.
+func (cached *folderCache) reset(uidValidity uint32) error {
+	if err := os.RemoveAll(cached.dir); err != nil {
+		return fmt.Errorf("failed to discard cache: %w", err)
+	}
+	cached.meta = folderMeta{UidValidity: uidValidity}
+	cached.messages, cached.dirty = nil, false
+	return nil
+}
+
+// Clear deletes the cache of the given folders of account, or of all of its
+// folders when none are given.
+func (cache *EnvelopeCache) Clear(account string, folders ...string) error {
+	if len(folders) == 0 {
+		if err := os.RemoveAll(cache.accountDir(account)); err != nil {
+			return fmt.Errorf("failed to clear cache for account %s: %w", account, err)
+		}
+		return nil
+	}
+	for _, folder := range folders {
+		if err := os.RemoveAll(cache.folderDir(account, folder)); err != nil {
+			return fmt.Errorf("failed to clear cache for %s: %w", folder, err)
+		}
+		err := os.Remove(cache.legacyFolderPath(account, folder))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to clear cache for %s: %w", folder, err)
+		}
+	}
+	return nil
+}
+
+// ClearAll deletes the cache of every account.
+func (cache *EnvelopeCache) ClearAll() error {
+	if err := os.RemoveAll(cache.dir); err != nil {
+		return fmt.Errorf("failed to clear cache: %w", err)
+	}
+	return nil
+}
+
+// Stats describes every cached folder, sorted by account and folder. Buckets
+// are read one at a time to count their messages.
+func (cache *EnvelopeCache) Stats() ([]CacheStats, error) {
+	accounts, err := os.ReadDir(cache.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory %s: %w", cache.dir, err)
+	}
+
+	var stats []CacheStats
+	for _, accountEntry := range accounts {
+		if !accountEntry.IsDir() {
+			continue
+		}
+		account, _ := url.PathUnescape(accountEntry.Name())
+		folders, err := os.ReadDir(cache.accountDir(account))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cache directory %s: %w", cache.dir, err)
+		}
+		for _, folderEntry := range folders {
+			if !folderEntry.IsDir() {
+				continue
+			}
+			folder, _ := url.PathUnescape(folderEntry.Name())
+			folderStats, err := openFolderCache(cache.folderDir(account, folder)).stats()
+			if err != nil {
+				return nil, err
+			}
+			folderStats.Account, folderStats.Folder = account, folder
+			stats = append(stats, folderStats)
+		}
+	}
+	return stats, nil
+}
+
+// stats describes the folder's cache, leaving Account and Folder unset.
+func (cached *folderCache) stats() (CacheStats, error) {
+	stats := CacheStats{UidValidity: cached.meta.UidValidity, Updated: cached.meta.Updated}
+	if info, err := os.Stat(filepath.Join(cached.dir, cacheMetaFile)); err == nil {
+		stats.Bytes += info.Size()
+	}
+	indexes, err := cached.buckets()
+	if err != nil {
+		return stats, err
+	}
+	for _, index := range indexes {
+		messages, err := cached.bucket(index * cacheBucketSize)
+		if err != nil {
+			return stats, err
+		}
+		stats.Messages += len(messages)
+		if info, err := os.Stat(cached.bucketPath(index)); err == nil {
+			stats.Bytes += info.Size()
+		}
+	}
+	return stats, nil
+}
+
+// StreamMessagesCached is StreamMessages answered from cache. The search itself
+// still runs on the server, but only the metadata of matches the cache lacks
+// is fetched; everything else is served from disk. Before answering, the
+// folder's cache is brought up to date:
+//
+//   - if the folder's UIDVALIDITY changed, the cache is discarded;
+//   - flags are refreshed, with a single CHANGEDSINCE fetch when the server
+//     supports CONDSTORE, or else by refetching the flags of the cached
+//     matches of each chunk before it is yielded.
+//
+// Messages that have been expunged are never served, since the search no
+// longer matches them; they are dropped from disk by the next search that
+// matches the whole folder.
+//
+// Matches are then yielded chunkSize at a time, as StreamMessages does: the
+// ones of each chunk that are not cached are fetched just before the chunk is
+// yielded, so the consumer sees the first messages without waiting for the
+// rest. The cache is read and written a bucket of cacheBucketSize UIDs at a
+// time, so a large folder is never held in memory whole. What was fetched is
+// kept when the stream ends, including when the consumer stops early or a
+// fetch fails.
+func StreamMessagesCached(dialer IMAPDialer, account Account, mailbox string, criteria *imap.SearchCriteria, chunkSize int, cache *EnvelopeCache) MessageStream {
+	return func(yield func(*imap.Message, error) bool) {
+		imapClient, err := getImapClient(dialer, account)
+		if err != nil {
+			yield(nil, fmt.Errorf("failed to connect to mailbox: %w", err))
+			return
+		}
+		defer imapClient.Logout()
+
+		uids, cached, err := syncFolderCache(imapClient, account, mailbox, criteria, cache)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		if chunkSize <= 0 {
+			chunkSize = DefaultChunkSize
+		}
+		log.Debug().Msgf("%s: %d matches", mailbox, len(uids))
+
+		task := progress.Start("fetching "+mailbox, "messages", len(uids))
+		defer task.Done()
+
+		for chunk := range slices.Chunk(uids, chunkSize) {
+			messages, err := cached.fetchChunk(imapClient, chunk, task)
+			if err != nil {
+				// Keep what was fetched before the failure.
+				if closeErr := cached.close(); closeErr != nil {
+					err = errors.Join(err, closeErr)
+				}
+				yield(nil, err)
+				return
+			}
+			for _, message := range messages {
+				if !yield(message, nil) {
+					if err := cached.close(); err != nil {
+						log.Warn().Err(err).Msgf("failed to save cache of %s", mailbox)
+					}
+					return
+				}
+			}
+		}
+
+		if matchesEverything(criteria) {
+			if err := cached.dropExpunged(uids); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+		cached.meta.HighestModSeq = cached.modSeq
+		if err := cached.close(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// syncFolderCache selects mailbox, opens its cache and brings it up to date as
+// StreamMessagesCached describes, and returns the UIDs matching criteria, in
+// order, along with the cache. Matches are left for the caller to read from
+// the cache or fetch, chunk by chunk.
+func syncFolderCache(imapClient IMAPClient, account Account, mailbox string, criteria *imap.SearchCriteria, cache *EnvelopeCache) ([]uint32, *folderCache, error) {
+	caps, err := imapClient.Capability()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get capabilities: %w", err)
+	}
+	condstore := caps["CONDSTORE"]
+
+	// HIGHESTMODSEQ is read before anything is fetched, so changes made while
+	// we sync are picked up again next time rather than missed.
+	var modSeq uint64
+	if condstore {
+		status, err := imapClient.Status(mailbox, []imap.StatusItem{statusHighestModSeq})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get status for folder %s: %w", mailbox, err)
+		}
+		modSeq = parseModSeq(status.Items[statusHighestModSeq])
+	}
+
+	status, err := imapClient.Select(mailbox, true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to select folder: %w", err)
+	}
+
+	cached := cache.open(account.Name, mailbox)
+	if cached.meta.UidValidity != status.UidValidity {
+		if cached.meta.UidValidity != 0 {
+			log.Info().Msgf("UIDVALIDITY of %s changed (%d -> %d); discarding its cache", mailbox, cached.meta.UidValidity, status.UidValidity)
+		}
+		if err := cached.reset(status.UidValidity); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	uids, err := findMessageUIDs(imapClient, criteria)
+	if err != nil {
+		return nil, nil, err
+	}
+	slices.Sort(uids)
+
+	if condstore && modSeq > 0 && cached.meta.HighestModSeq > 0 {
+		if modSeq != cached.meta.HighestModSeq {
+			if err := cached.refreshChangedFlags(imapClient, cached.meta.HighestModSeq); err != nil {
+				return nil, nil, err
+			}
+		}
+		cached.meta.HighestModSeq, cached.modSeq = modSeq, modSeq
+		return uids, cached, nil
+	}
+
+	// Only the matches get their flags refreshed, so HIGHESTMODSEQ can be
+	// recorded, once the run completes, only if the matches are every cached
+	// message: when the search matches the whole folder, or nothing was cached
+	// before.
+	cached.refreshFlags = true
+	cached.meta.HighestModSeq = 0
+	if condstore {
+		indexes, err := cached.buckets()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(indexes) == 0 || matchesEverything(criteria) {
+			cached.modSeq = modSeq
+		}
+	}
+	return uids, cached, nil
+}
+
+// fetchChunk returns the messages of chunk in order: from the cache where it
+// has them, with their flags refetched if refreshFlags is set, and otherwise
+// fetched from the server and cached. Messages expunged since the search are
+// left out. Each message is counted on task.
+func (cached *folderCache) fetchChunk(imapClient IMAPClient, chunk []uint32, task progress.Task) ([]*imap.Message, error) {
+	found := make(map[uint32]CachedMessage, len(chunk))
+	var known, missing []uint32
+	for _, uid := range chunk {
+		message, ok, err := cached.get(uid)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			found[uid] = message
+			known = append(known, uid)
+		} else {
+			missing = append(missing, uid)
+		}
+	}
+
+	if cached.refreshFlags && len(known) > 0 {
+		updates, err := fetchItemsByUID(imapClient, known, []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, task)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch flags: %w", err)
+		}
+		for _, update := range updates {
+			message, ok := found[update.Uid]
+			if !ok {
+				continue
+			}
+			message.Flags = update.Flags
+			found[update.Uid] = message
+			if err := cached.put(update.Uid, message); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		task.Add(len(known))
+	}
+
+	if len(missing) > 0 {
+		messages, err := fetchItemsByUID(imapClient, missing, getFetchItems(), task)
+		if err != nil {
+			return nil, err
+		}
+		for _, message := range messages {
+			entry := CachedMessage{
+				Envelope:     message.Envelope,
+				Flags:        message.Flags,
+				Size:         message.Size,
+				InternalDate: message.InternalDate,
+			}
+			found[message.Uid] = entry
+			if err := cached.put(message.Uid, entry); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	messages := make([]*imap.Message, 0, len(found))
+	for _, uid := range chunk {
+		if message, ok := found[uid]; ok {
+			messages = append(messages, message.toMessage(uid))
+		}
+	}
+	return messages, nil
+}
+
+// dropExpunged removes every cached message whose UID is not in existing,
+// which must hold every UID in the folder, in order.
+func (cached *folderCache) dropExpunged(existing []uint32) error {
+	indexes, err := cached.buckets()
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		messages, err := cached.bucket(index * cacheBucketSize)
+		if err != nil {
+			return err
+		}
+		for uid := range messages {
+			if _, ok := slices.BinarySearch(existing, uid); !ok {
+				delete(messages, uid)
+				cached.dirty = true
+			}
+		}
+	}
+	return nil
+}
+
+// refreshChangedFlags updates the flags of every cached message whose
+// mod-sequence is above modSeq, in a single CONDSTORE fetch.
+func (cached *folderCache) refreshChangedFlags(imapClient IMAPClient, modSeq uint64) error {
+	seqSet := new(imap.SeqSet)
+	seqSet.AddRange(1, 0)
+
+	messages := make(chan *imap.Message)
+	done := make(chan error, 1)
+	go func() {
+		done <- imapClient.UidFetchChangedSince(seqSet, []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, modSeq, messages)
+	}()
+	var err error
+	for message := range messages {
+		if err == nil {
+			err = cached.updateFlags(message)
+		}
+	}
+	if fetchErr := <-done; fetchErr != nil {
+		return fmt.Errorf("failed to fetch changed flags: %w", fetchErr)
+	}
+	return err
+}
+
+// updateFlags applies the flags of a FETCH response to the cached message, if
+// it is cached.
+func (cached *folderCache) updateFlags(message *imap.Message) error {
+	entry, ok, err := cached.get(message.Uid)
+	if err != nil || !ok {
+		return err
+	}
+	entry.Flags = message.Flags
+	return cached.put(message.Uid, entry)
+}
+
+// matchesEverything reports whether criteria match every message in a folder.
+func matchesEverything(criteria *imap.SearchCriteria) bool {
+	return criteria.SeqNum == nil && criteria.Uid == nil &&
+		criteria.Since.IsZero() && criteria.Before.IsZero() &&
+		criteria.SentSince.IsZero() && criteria.SentBefore.IsZero() &&
+		len(criteria.Header) == 0 && len(criteria.Body) == 0 && len(criteria.Text) == 0 &&
+		len(criteria.WithFlags) == 0 && len(criteria.WithoutFlags) == 0 &&
+		criteria.Larger == 0 && criteria.Smaller == 0 &&
+		len(criteria.Not) == 0 && len(criteria.Or) == 0
+}
+
+// toMessage rebuilds the message as a search would have fetched it.
+func (message CachedMessage) toMessage(uid uint32) *imap.Message {
+	return &imap.Message{
+		Uid:          uid,
+		Envelope:     message.Envelope,
+		Flags:        message.Flags,
+		Size:         message.Size,
+		InternalDate: message.InternalDate,
+	}
+}
@@ -0,0 +1,266 @@
+package imaputils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// cacheTestClient is a MockIMAPClientSearch that reports a fixed folder status
+// from STATUS and SELECT.
+type cacheTestClient struct {
+	MockIMAPClientSearch
+	status *imap.MailboxStatus
+}
+
+func (c *cacheTestClient) Status(name string, items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	return c.status, nil
+}
+
+func (c *cacheTestClient) Select(name string, readOnly bool) (*imap.MailboxStatus, error) {
+	return c.status, nil
+}
+
+// isAllCriteria matches the empty criteria used to list every UID.
+var isAllCriteria = mock.MatchedBy(func(criteria *imap.SearchCriteria) bool {
+	return criteria.Uid == nil && len(criteria.WithoutFlags) == 0
+})
+
+// isUnreadCriteria matches the criteria the tests search with.
+var isUnreadCriteria = mock.MatchedBy(func(criteria *imap.SearchCriteria) bool {
+	return len(criteria.WithoutFlags) == 1
+})
+
+func unreadCriteria() *imap.SearchCriteria {
+	return &imap.SearchCriteria{WithoutFlags: []string{imap.SeenFlag}}
+}
+
+func newCacheTestClient(uidValidity uint32, caps map[string]bool, modSeq string) (*cacheTestClient, *MockIMAPDialerSearch) {
+	status := &imap.MailboxStatus{UidValidity: uidValidity, Items: map[imap.StatusItem]interface{}{}}
+	if modSeq != "" {
+		status.Items[statusHighestModSeq] = modSeq
+	}
+	client := &cacheTestClient{status: status}
+	client.On("Capability").Return(caps, nil)
+	client.On("Logout").Return(nil)
+	dialer := &MockIMAPDialerSearch{}
+	dialer.On("Dial", mock.Anything).Return(client, nil)
+	return client, dialer
+}
+
+func envelopeMessage(uid uint32, subject string, flags ...string) *imap.Message {
+	return &imap.Message{Uid: uid, Envelope: &imap.Envelope{Subject: subject}, Flags: flags}
+}
+
+func subjects(t *testing.T, stream MessageStream) []string {
+	messages, err := CollectMessages(stream)
+	assert.NoError(t, err)
+	var subjects []string
+	for _, message := range messages {
+		subjects = append(subjects, message.Envelope.Subject)
+	}
+	return subjects
+}
+
+func TestStreamMessagesCached(t *testing.T) {
+	cache := NewEnvelopeCache(t.TempDir())
+	account := Account{Name: "work"}
+	caps := map[string]bool{"IMAP4rev1": true}
+
+	// First run: nothing is cached, so every match is fetched.
+	client, dialer := newCacheTestClient(7, caps, "")
+	client.On("UidSearch", isUnreadCriteria).Return([]uint32{1, 2}, nil)
+	client.On("UidFetch", seqSetIs("1:2"), mock.Anything, mock.Anything).
+		Return([]*imap.Message{envelopeMessage(1, "one"), envelopeMessage(2, "two")}, nil).Once()
+
+	assert.Equal(t, []string{"one", "two"}, subjects(t, StreamMessagesCached(dialer, account, "INBOX", unreadCriteria(), 10, cache)))
+
+	// Second run: message 1 was expunged and 3 arrived. Only 3's envelope is
+	// fetched; 2 comes from the cache after a flag refresh.
+	client, dialer = newCacheTestClient(7, caps, "")
+	client.On("UidSearch", isUnreadCriteria).Return([]uint32{3, 2}, nil)
+	client.On("UidFetch", seqSetIs("2"), []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, mock.Anything).
+		Return([]*imap.Message{{Uid: 2, Flags: []string{imap.FlaggedFlag}}}, nil).Once()
+	client.On("UidFetch", seqSetIs("3"), mock.Anything, mock.Anything).
+		Return([]*imap.Message{envelopeMessage(3, "three")}, nil).Once()
+
+	messages, err := CollectMessages(StreamMessagesCached(dialer, account, "INBOX", unreadCriteria(), 10, cache))
+	assert.NoError(t, err)
+	assert.Len(t, messages, 2)
+	assert.Equal(t, "two", messages[0].Envelope.Subject)
+	assert.Equal(t, []string{imap.FlaggedFlag}, messages[0].Flags, "flags are refreshed")
+	assert.Equal(t, "three", messages[1].Envelope.Subject)
+	client.AssertExpectations(t)
+	client.AssertNotCalled(t, "UidSearch", isAllCriteria)
+
+	stats, err := cache.Stats()
+	assert.NoError(t, err)
+	assert.Len(t, stats, 1)
+	assert.Equal(t, CacheStats{Account: "work", Folder: "INBOX", UidValidity: 7, Messages: 3, Bytes: stats[0].Bytes, Updated: stats[0].Updated}, stats[0],
+		"the expunged message stays on disk until a search matches the whole folder")
+
+	// Third run: a search of the whole folder is answered from the cache and
+	// drops the expunged message.
+	client, dialer = newCacheTestClient(7, caps, "")
+	client.On("UidSearch", isAllCriteria).Return([]uint32{2, 3}, nil)
+	client.On("UidFetch", seqSetIs("2:3"), []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, mock.Anything).
+		Return([]*imap.Message{{Uid: 2}, {Uid: 3}}, nil).Once()
+
+	assert.Equal(t, []string{"two", "three"}, subjects(t, StreamMessagesCached(dialer, account, "INBOX", imap.NewSearchCriteria(), 10, cache)))
+	client.AssertExpectations(t)
+
+	stats, err = cache.Stats()
+	assert.NoError(t, err)
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, 2, stats[0].Messages)
+	}
+}
+
+func TestStreamMessagesCachedBuckets(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewEnvelopeCache(dir)
+	account := Account{Name: "work"}
+	caps := map[string]bool{"IMAP4rev1": true}
+
+	client, dialer := newCacheTestClient(7, caps, "")
+	client.On("UidSearch", isUnreadCriteria).Return([]uint32{1, cacheBucketSize + 1, 3*cacheBucketSize + 1}, nil)
+	client.On("UidFetch", mock.Anything, mock.Anything, mock.Anything).
+		Return([]*imap.Message{envelopeMessage(1, "one"), envelopeMessage(cacheBucketSize+1, "two"), envelopeMessage(3*cacheBucketSize+1, "three")}, nil).Once()
+	assert.Equal(t, []string{"one", "two", "three"}, subjects(t, StreamMessagesCached(dialer, account, "INBOX", unreadCriteria(), 10, cache)))
+
+	for _, name := range []string{"folder.gob", "0.gob", "1.gob", "3.gob"} {
+		_, err := os.Stat(filepath.Join(dir, "work", "INBOX", name))
+		assert.NoError(t, err, name)
+	}
+
+	// The second run is answered from the buckets.
+	client, dialer = newCacheTestClient(7, caps, "")
+	client.On("UidSearch", isUnreadCriteria).Return([]uint32{1, cacheBucketSize + 1, 3*cacheBucketSize + 1}, nil)
+	client.On("UidFetch", mock.Anything, []imap.FetchItem{imap.FetchUid, imap.FetchFlags}, mock.Anything).
+		Return([]*imap.Message{{Uid: 1}, {Uid: cacheBucketSize + 1}, {Uid: 3*cacheBucketSize + 1}}, nil).Once()
+	assert.Equal(t, []string{"one", "two", "three"}, subjects(t, StreamMessagesCached(dialer, account, "INBOX", unreadCriteria(), 10, cache)))
+	client.AssertExpectations(t)
+}
+
+func TestStreamMessagesCachedRemovesLegacyFile(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewEnvelopeCache(dir)
+	legacy := filepath.Join(dir, "work", "INBOX.gob")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(legacy), 0o700))
+	assert.NoError(t, os.WriteFile(legacy, []byte("old"), 0o600))
+
+	client, dialer := newCacheTestClient(7, map[string]bool{"IMAP4rev1": true}, "")
+	client.On("UidSearch", isUnreadCriteria).Return([]uint32{}, nil)
+	subjects(t, StreamMessagesCached(dialer, Account{Name: "work"}, "INBOX", unreadCriteria(), 10, cache))
+
+	_, err := os.Stat(legacy)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestStreamMessagesCachedDiscardsOnUidValidityChange(t *testing.T) {
+	cache := NewEnvelopeCache(t.TempDir())
+	account := Account{Name: "work"}
+	caps := map[string]bool{"IMAP4rev1": true}
+
+	client, dialer := newCacheTestClient(7, caps, "")
+	client.On("UidSearch", isUnreadCriteria).Return([]uint32{1}, nil)
+	client.On("UidFetch", seqSetIs("1"), mock.Anything, mock.Anything).Return([]*imap.Message{envelopeMessage(1, "old")}, nil)
+	subjects(t, StreamMessagesCached(dialer, account, "INBOX", unreadCriteria(), 10, cache))
+
+	// UID 1 now names a different message; the cached "old" must not be served.
+	client, dialer = newCacheTestClient(8, caps, "")
+	client.On("UidSearch", isUnreadCriteria).Return([]uint32{1}, nil)
+	client.On("UidFetch", seqSetIs("1"), mock.Anything, mock.Anything).Return([]*imap.Message{envelopeMessage(1, "new")}, nil).Once()
+
+	assert.Equal(t, []string{"new"}, subjects(t, StreamMessagesCached(dialer, account, "INBOX", unreadCriteria(), 10, cache)))
+	client.AssertNotCalled(t, "UidSearch", isAllCriteria)
+}
+
+func TestStreamMessagesCachedChunks(t *testing.T) {
+	cache := NewEnvelopeCache(t.TempDir())
+	account := Account{Name: "work"}
+	caps := map[string]bool{"IMAP4rev1": true}
+
+	// Each chunk is fetched only once the previous one has been consumed, and
+	// what was fetched is cached even though the consumer stops early.
+	client, dialer := newCacheTestClient(7, caps, "")
+	client.On("UidSearch", isUnreadCriteria).Return([]uint32{1, 2, 3}, nil)
+	client.On("UidFetch", seqSetIs("1"), mock.Anything, mock.Anything).
+		Return([]*imap.Message{envelopeMessage(1, "one")}, nil).Once()
+	for message, err := range StreamMessagesCached(dialer, account, "INBOX", unreadCriteria(), 1, cache) {
+		assert.NoError(t, err)
+		assert.Equal(t, "one", message.Envelope.Subject)
+		break
+	}
+	client.AssertExpectations(t)
+
+	stats, err := cache.Stats()
+	assert.NoError(t, err)
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, 1, stats[0].Messages)
+	}
+}
+
+func TestStreamMessagesCachedCondstore(t *testing.T) {
+	cache := NewEnvelopeCache(t.TempDir())
+	account := Account{Name: "work"}
+	caps := map[string]bool{"IMAP4rev1": true, "CONDSTORE": true}
+
+	client, dialer := newCacheTestClient(7, caps, "100")
+	client.On("UidSearch", isUnreadCriteria).Return([]uint32{1, 2}, nil)
+	client.On("UidFetch", seqSetIs("1:2"), mock.Anything, mock.Anything).
+		Return([]*imap.Message{envelopeMessage(1, "one"), envelopeMessage(2, "two")}, nil)
+	subjects(t, StreamMessagesCached(dialer, account, "INBOX", unreadCriteria(), 10, cache))
+
+	// Unchanged HIGHESTMODSEQ: no flag fetch at all.
+	client, dialer = newCacheTestClient(7, caps, "100")
+	client.On("UidSearch", mock.Anything).Return([]uint32{1, 2}, nil)
+	assert.Equal(t, []string{"one", "two"}, subjects(t, StreamMessagesCached(dialer, account, "INBOX", unreadCriteria(), 10, cache)))
+	client.AssertNotCalled(t, "UidFetch", mock.Anything, mock.Anything, mock.Anything)
+	client.AssertNotCalled(t, "UidFetchChangedSince", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// HIGHESTMODSEQ moved: one CHANGEDSINCE fetch from the cached value.
+	client, dialer = newCacheTestClient(7, caps, "105")
+	client.On("UidSearch", mock.Anything).Return([]uint32{1, 2}, nil)
+	client.On("UidFetchChangedSince", seqSetIs("1:*"), mock.Anything, uint64(100), mock.Anything).
+		Return([]*imap.Message{{Uid: 2, Flags: []string{imap.AnsweredFlag}}}, nil).Once()
+
+	messages, err := CollectMessages(StreamMessagesCached(dialer, account, "INBOX", unreadCriteria(), 10, cache))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{imap.AnsweredFlag}, messages[1].Flags)
+	client.AssertExpectations(t)
+	client.AssertNotCalled(t, "UidFetch", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestEnvelopeCacheClear(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewEnvelopeCache(dir)
+	for _, path := range [][2]string{{"work", "INBOX"}, {"work", "Lists/Go"}, {"home", "INBOX"}} {
+		folder := cache.open(path[0], path[1])
+		assert.NoError(t, folder.reset(1))
+		assert.NoError(t, folder.put(1, CachedMessage{Size: 10}))
+		assert.NoError(t, folder.close())
+	}
+
+	_, err := os.Stat(filepath.Join(dir, "work", "Lists%2FGo", "folder.gob"))
+	assert.NoError(t, err, "hierarchy delimiters are escaped")
+
+	assert.NoError(t, cache.Clear("work", "INBOX", "Missing"))
+	stats, err := cache.Stats()
+	assert.NoError(t, err)
+	assert.Len(t, stats, 2)
+	assert.Equal(t, "Lists/Go", stats[1].Folder)
+
+	assert.NoError(t, cache.Clear("work"))
+	stats, _ = cache.Stats()
+	assert.Len(t, stats, 1)
+
+	assert.NoError(t, cache.ClearAll())
+	stats, err = cache.Stats()
+	assert.NoError(t, err)
+	assert.Empty(t, stats, "a missing cache directory is empty, not an error")
+}
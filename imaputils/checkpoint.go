@@ -0,0 +1,191 @@
+package imaputils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/emersion/go-imap"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// statusHighestModSeq is the CONDSTORE (RFC 7162) STATUS item go-imap does not
+// define itself.
+const statusHighestModSeq imap.StatusItem = "HIGHESTMODSEQ"
+
+// Checkpoint records how far through a folder a run has processed. UIDs are
+// only meaningful together with the UIDVALIDITY they were issued under: if the
+// server reports a different UIDVALIDITY, every stored UID is void.
+type Checkpoint struct {
+	UidValidity uint32 `json:"uid_validity"`
+	// LastUID is the highest UID that has been processed; messages above it
+	// are new.
+	LastUID uint32 `json:"last_uid"`
+	// HighestModSeq is recorded when the server supports CONDSTORE, and is
+	// zero otherwise.
+	HighestModSeq uint64    `json:"highest_modseq,omitempty"`
+	Updated       time.Time `json:"updated"`
+}
+
+// CheckpointStore is the on-disk collection of checkpoints, keyed by
+// CheckpointKey. It is a single small JSON file, rewritten atomically on Save.
+type CheckpointStore struct {
+	path        string
+	Checkpoints map[string]Checkpoint `json:"checkpoints"`
+}
+
+// CheckpointKey builds the store key for an account and folder. stateKey lets
+// several independent jobs (e.g. two cron entries with different criteria)
+// keep separate checkpoints for the same folder; it may be empty.
+func CheckpointKey(account Account, folder, stateKey string) string {
+	key := account.Name + "/" + folder
+	if stateKey != "" {
+		key += "#" + stateKey
+	}
+	return key
+}
+
+// LoadCheckpoints reads the checkpoint store at path. A missing file is not an
+// error: it yields an empty store that will be created on the first Save.
+func LoadCheckpoints(path string) (*CheckpointStore, error) {
+	store := &CheckpointStore{path: path, Checkpoints: map[string]Checkpoint{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint file %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint file %s: %w", path, err)
+	}
+	if store.Checkpoints == nil {
+		store.Checkpoints = map[string]Checkpoint{}
+	}
+	return store, nil
+}
+
+// Get returns the checkpoint stored under key, if any.
+func (store *CheckpointStore) Get(key string) (Checkpoint, bool) {
+	checkpoint, ok := store.Checkpoints[key]
+	return checkpoint, ok
+}
+
+// Set records a checkpoint under key. It is not persisted until Save.
+func (store *CheckpointStore) Set(key string, checkpoint Checkpoint) {
+	store.Checkpoints[key] = checkpoint
+}
+
+// Save writes the store back to disk. The file is written to a temporary name
+// and renamed into place, so an interrupted run never leaves a truncated store
+// that would silently reset every checkpoint.
+func (store *CheckpointStore) Save() error {
+	if err := os.MkdirAll(filepath.Dir(store.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoints: %w", err)
+	}
+
+	temp := store.path + ".tmp"
+	if err := os.WriteFile(temp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write checkpoint file: %w", err)
+	}
+	if err := os.Rename(temp, store.path); err != nil {
+		return fmt.Errorf("failed to replace checkpoint file: %w", err)
+	}
+	return nil
+}
+
+// FolderCheckpoint returns the folder's current position via IMAP STATUS:
+// its UIDVALIDITY, the highest UID assigned so far (UIDNEXT-1), and, when the
+// server advertises CONDSTORE, its HIGHESTMODSEQ. Nothing is selected.
+func FolderCheckpoint(dialer IMAPDialer, account Account, folder string) (Checkpoint, error) {
+	imapClient, err := getImapClient(dialer, account)
+	if err != nil {
+		return Checkpoint{}, fmt.Errorf("failed to initialize imap client: %w", err)
+	}
+	defer imapClient.Logout()
+
+	caps, err := imapClient.Capability()
+	if err != nil {
+		return Checkpoint{}, fmt.Errorf("failed to get capabilities: %w", err)
+	}
+
+	items := []imap.StatusItem{imap.StatusUidValidity, imap.StatusUidNext}
+	if caps["CONDSTORE"] {
+		items = append(items, statusHighestModSeq)
+	}
+
+	status, err := imapClient.Status(folder, items)
+	if err != nil {
+		return Checkpoint{}, fmt.Errorf("failed to get status for folder %s: %w", folder, err)
+	}
+
+	checkpoint := Checkpoint{UidValidity: status.UidValidity, Updated: time.Now()}
+	if status.UidNext > 0 {
+		checkpoint.LastUID = status.UidNext - 1
+	}
+	if raw, ok := status.Items[statusHighestModSeq]; ok {
+		checkpoint.HighestModSeq = parseModSeq(raw)
+	}
+	return checkpoint, nil
+}
+
+// parseModSeq converts a raw STATUS value to a mod-sequence. Mod-sequences are
+// 63-bit, so large values arrive as strings rather than go-imap's uint32.
+func parseModSeq(raw interface{}) uint64 {
+	switch value := raw.(type) {
+	case uint32:
+		return uint64(value)
+	case string:
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return 0
+		}
+		return parsed
+	}
+	return 0
+}
+
+// SinceCheckpoint restricts criteria to the messages that arrived after
+// previous and by current: UIDs above previous.LastUID, up to and including
+// current.LastUID. Messages arriving after current was taken are left for the
+// next run. Pass a previous with only current's UidValidity for a full scan,
+// which still stops at current. It fails if previous is from another
+// UIDVALIDITY, and if criteria already restrict UIDs, which a single UID key
+// cannot intersect with. The original criteria are not modified.
+func SinceCheckpoint(criteria *imap.SearchCriteria, previous, current Checkpoint) (*imap.SearchCriteria, error) {
+	if previous.UidValidity != current.UidValidity {
+		return nil, fmt.Errorf("checkpoint is for UIDVALIDITY %d, but the folder is at %d", previous.UidValidity, current.UidValidity)
+	}
+	if criteria.Uid != nil {
+		return nil, fmt.Errorf("cannot restrict a search by UID to the messages since a checkpoint")
+	}
+
+	restricted := *criteria
+	uids := new(imap.SeqSet)
+	// With nothing new the range is a UID that does not exist yet, or that
+	// FilterUIDRange drops if it arrives mid-run; "n:*" would always include
+	// the highest UID in the folder even when it is below n.
+	uids.AddRange(previous.LastUID+1, max(previous.LastUID+1, current.LastUID))
+	restricted.Uid = uids
+	return &restricted, nil
+}
+
+// FilterUIDRange returns the messages whose UID is greater than after and at
+// most upTo.
+func FilterUIDRange(messages []*imap.Message, after, upTo uint32) []*imap.Message {
+	filtered := make([]*imap.Message, 0, len(messages))
+	for _, message := range messages {
+		if message.Uid > after && message.Uid <= upTo {
+			filtered = append(filtered, message)
+		}
+	}
+	return filtered
+}
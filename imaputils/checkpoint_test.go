@@ -0,0 +1,157 @@
+package imaputils
+
+import (
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "checkpoints.json")
+
+	t.Run("missing file yields an empty store", func(t *testing.T) {
+		store, err := LoadCheckpoints(path)
+		assert.NoError(t, err)
+		_, ok := store.Get("work/INBOX")
+		assert.False(t, ok)
+	})
+
+	t.Run("round trips through disk", func(t *testing.T) {
+		store, err := LoadCheckpoints(path)
+		assert.NoError(t, err)
+		store.Set("work/INBOX", Checkpoint{UidValidity: 7, LastUID: 42, HighestModSeq: 9000})
+		assert.NoError(t, store.Save())
+
+		reloaded, err := LoadCheckpoints(path)
+		assert.NoError(t, err)
+		checkpoint, ok := reloaded.Get("work/INBOX")
+		assert.True(t, ok)
+		assert.Equal(t, uint32(7), checkpoint.UidValidity)
+		assert.Equal(t, uint32(42), checkpoint.LastUID)
+		assert.Equal(t, uint64(9000), checkpoint.HighestModSeq)
+
+		_, err = os.Stat(path + ".tmp")
+		assert.True(t, os.IsNotExist(err), "temporary file should be renamed away")
+	})
+
+	t.Run("corrupt file is an error", func(t *testing.T) {
+		corrupt := filepath.Join(t.TempDir(), "checkpoints.json")
+		assert.NoError(t, os.WriteFile(corrupt, []byte("{not json"), 0o600))
+		_, err := LoadCheckpoints(corrupt)
+		assert.Error(t, err)
+	})
+}
+
+func TestCheckpointKey(t *testing.T) {
+	account := Account{Name: "work"}
+	assert.Equal(t, "work/INBOX", CheckpointKey(account, "INBOX", ""))
+	assert.Equal(t, "work/INBOX#newsletters", CheckpointKey(account, "INBOX", "newsletters"))
+}
+
+func TestFolderCheckpoint(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities map[string]bool
+		status       *imap.MailboxStatus
+		expected     Checkpoint
+		wantModSeq   bool
+	}{
+		{
+			name:         "without CONDSTORE",
+			capabilities: map[string]bool{"IMAP4rev1": true},
+			status:       &imap.MailboxStatus{UidValidity: 3, UidNext: 101},
+			expected:     Checkpoint{UidValidity: 3, LastUID: 100},
+		},
+		{
+			name:         "with CONDSTORE",
+			capabilities: map[string]bool{"CONDSTORE": true},
+			status: &imap.MailboxStatus{
+				UidValidity: 3,
+				UidNext:     101,
+				Items:       map[imap.StatusItem]interface{}{statusHighestModSeq: "12345678901"},
+			},
+			expected:   Checkpoint{UidValidity: 3, LastUID: 100, HighestModSeq: 12345678901},
+			wantModSeq: true,
+		},
+		{
+			name:         "empty folder",
+			capabilities: map[string]bool{},
+			status:       &imap.MailboxStatus{UidValidity: 3},
+			expected:     Checkpoint{UidValidity: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requested []imap.StatusItem
+			client := &MockIMAPClientListFolders{
+				capabilityFunc: func() (map[string]bool, error) { return tt.capabilities, nil },
+				statusFunc: func(name string, items []imap.StatusItem) (*imap.MailboxStatus, error) {
+					requested = items
+					return tt.status, nil
+				},
+			}
+
+			checkpoint, err := FolderCheckpoint(&MockDialerListFolders{client: client}, Account{}, "INBOX")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected.UidValidity, checkpoint.UidValidity)
+			assert.Equal(t, tt.expected.LastUID, checkpoint.LastUID)
+			assert.Equal(t, tt.expected.HighestModSeq, checkpoint.HighestModSeq)
+			assert.Equal(t, tt.wantModSeq, containsStatusItem(requested, statusHighestModSeq))
+			assert.Equal(t, 1, client.logoutCalls)
+		})
+	}
+}
+
+func containsStatusItem(items []imap.StatusItem, target imap.StatusItem) bool {
+	for _, item := range items {
+		if item == target {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSinceCheckpoint(t *testing.T) {
+	criteria := BuildSearchCriteria(SearchOptions{})
+
+	t.Run("same UIDVALIDITY restricts to the UIDs since the checkpoint", func(t *testing.T) {
+		restricted, err := SinceCheckpoint(criteria, Checkpoint{UidValidity: 5, LastUID: 40}, Checkpoint{UidValidity: 5, LastUID: 50})
+		assert.NoError(t, err)
+		assert.Equal(t, "41:50", restricted.Uid.String())
+		assert.Nil(t, criteria.Uid, "original criteria must not be modified")
+	})
+
+	t.Run("a full scan stops at the current position", func(t *testing.T) {
+		restricted, err := SinceCheckpoint(criteria, Checkpoint{UidValidity: 5}, Checkpoint{UidValidity: 5, LastUID: 50})
+		assert.NoError(t, err)
+		assert.Equal(t, "1:50", restricted.Uid.String())
+	})
+
+	t.Run("nothing new searches a UID not yet assigned", func(t *testing.T) {
+		restricted, err := SinceCheckpoint(criteria, Checkpoint{UidValidity: 5, LastUID: 50}, Checkpoint{UidValidity: 5, LastUID: 50})
+		assert.NoError(t, err)
+		assert.Equal(t, "51", restricted.Uid.String())
+	})
+
+	t.Run("changed UIDVALIDITY is refused", func(t *testing.T) {
+		_, err := SinceCheckpoint(criteria, Checkpoint{UidValidity: 5, LastUID: 40}, Checkpoint{UidValidity: 6, LastUID: 3})
+		assert.ErrorContains(t, err, "UIDVALIDITY")
+	})
+
+	t.Run("a UID restriction is refused rather than replaced", func(t *testing.T) {
+		byUID := *criteria
+		byUID.Uid = new(imap.SeqSet)
+		byUID.Uid.AddNum(7)
+		_, err := SinceCheckpoint(&byUID, Checkpoint{UidValidity: 5, LastUID: 40}, Checkpoint{UidValidity: 5, LastUID: 50})
+		assert.ErrorContains(t, err, "by UID")
+	})
+}
+
+func TestFilterUIDRange(t *testing.T) {
+	messages := []*imap.Message{{Uid: 10}, {Uid: 41}, {Uid: 42}, {Uid: 51}}
+	filtered := FilterUIDRange(messages, 40, 50)
+	assert.Equal(t, []*imap.Message{{Uid: 41}, {Uid: 42}}, filtered)
+}
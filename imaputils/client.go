@@ -2,10 +2,14 @@ package imaputils
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
 	"github.com/wryfi/shemail/logging"
+	"time"
 )
 
 var log = &logging.Logger
@@ -23,35 +27,99 @@ type Account struct {
 	TLS             bool
 	Purge           bool
 	Default         bool
+	// AllowUnsafeExpunge lets a purge, or a move on a server without MOVE,
+	// use a plain EXPUNGE when the server lacks UIDPLUS, even though it will
+	// also remove other messages already flagged \Deleted in the folder.
+	AllowUnsafeExpunge bool `mapstructure:"allow_unsafe_expunge"`
+	// TrashFolder and ArchiveFolder, "/"-separated folder paths, override the
+	// folders the server marks \Trash and \Archive.
+	TrashFolder   string `mapstructure:"trash_folder"`
+	ArchiveFolder string `mapstructure:"archive_folder"`
 }
 
 // IMAPClient defines the minimal interface for IMAP client operations
 type IMAPClient interface {
+	Append(mbox string, flags []string, date time.Time, msg imap.Literal) error
 	Capability() (map[string]bool, error)
 	Create(name string) error
+	Delete(name string) error
 	Expunge(ch chan uint32) error
 	Fetch(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error
 	GetClient() *client.Client
+	GetQuota(root string) (Quota, error)
+	GetQuotaRoot(mailbox string) (roots []string, quotas []Quota, err error)
+	Idle(stop <-chan struct{}, opts *client.IdleOptions) error
 	List(ref string, name string, ch chan *imap.MailboxInfo) error
 	Login(username string, password string) error
 	Logout() error
+	Lsub(ref string, name string, ch chan *imap.MailboxInfo) error
+	Rename(existingName, newName string) error
 	Select(name string, readOnly bool) (*imap.MailboxStatus, error)
 	Status(name string, items []imap.StatusItem) (*imap.MailboxStatus, error)
-	UidCopy(seqset *imap.SeqSet, dest string) error
+	Subscribe(name string) error
+	UidCopy(seqset *imap.SeqSet, dest string) (*CopyUID, error)
+	UidExpunge(seqSet *imap.SeqSet) error
 	UidFetch(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error
-	UidMove(seqSet *imap.SeqSet, mailbox string) error
+	UidFetchChangedSince(seqset *imap.SeqSet, items []imap.FetchItem, modSeq uint64, ch chan *imap.Message) error
+	UidMove(seqSet *imap.SeqSet, mailbox string) (*CopyUID, error)
 	UidSearch(criteria *imap.SearchCriteria) (uids []uint32, err error)
 	UidStore(seqSet *imap.SeqSet, item imap.StoreItem, flags []interface{}, ch chan *imap.Message) error
+	Unsubscribe(name string) error
+	Updates() <-chan client.Update
 }
 
+// ErrMoveUnsupported is returned by UidMove on servers without the MOVE
+// extension (RFC 6851).
+var ErrMoveUnsupported = errors.New("server does not support MOVE")
+
+// updatesBuffer is how many unsolicited updates a ShemailClient holds for a
+// reader of Updates before dropping new ones.
+const updatesBuffer = 16
+
 // ShemailClient represents the concrete implementation of the IMAPClient
 type ShemailClient struct {
-	Client *client.Client
+	Client  *client.Client
+	updates chan client.Update
+}
+
+// newShemailClient wraps a newly dialled connection. go-imap's reader
+// goroutine reads Client.Updates without synchronisation, so it is set here,
+// once, before any command is sent, and never changed; forwardUpdates passes
+// what arrives on to Updates for the life of the connection.
+func newShemailClient(c *client.Client) *ShemailClient {
+	received := make(chan client.Update)
+	c.Updates = received
+	shemailClient := &ShemailClient{Client: c, updates: make(chan client.Update, updatesBuffer)}
+	go shemailClient.forwardUpdates(received)
+	return shemailClient
+}
+
+// forwardUpdates passes the updates go-imap sends to received on to
+// c.updates until the connection ends. An update that finds c.updates full
+// is dropped rather than holding up go-imap's reader, and with it every
+// command: the updates already waiting tell whoever reads them that
+// something changed.
+func (c *ShemailClient) forwardUpdates(received <-chan client.Update) {
+	for {
+		select {
+		case update := <-received:
+			select {
+			case c.updates <- update:
+			default:
+			}
+		case <-c.Client.LoggedOut():
+			return
+		}
+	}
 }
 
 // Ensure ShemailClient implements IMAPClient interface
 var _ IMAPClient = &ShemailClient{}
 
+func (c *ShemailClient) Append(mbox string, flags []string, date time.Time, msg imap.Literal) error {
+	return c.Client.Append(mbox, flags, date, msg)
+}
+
 func (c *ShemailClient) Capability() (map[string]bool, error) {
 	return c.Client.Capability()
 }
@@ -60,6 +128,10 @@ func (c *ShemailClient) Create(name string) error {
 	return c.Client.Create(name)
 }
 
+func (c *ShemailClient) Delete(name string) error {
+	return c.Client.Delete(name)
+}
+
 func (c *ShemailClient) Expunge(ch chan uint32) error {
 	return c.Client.Expunge(ch)
 }
@@ -72,6 +144,59 @@ func (c *ShemailClient) GetClient() *client.Client {
 	return c.Client
 }
 
+// GetQuota returns the quota of a quota root (QUOTA, RFC 9208), or
+// ErrQuotaUnsupported if the server lacks the extension.
+func (c *ShemailClient) GetQuota(root string) (Quota, error) {
+	handler, err := c.quotaCommand(&getQuota{root: root})
+	if err != nil {
+		return Quota{}, err
+	}
+	for _, quota := range handler.quotas {
+		if quota.Root == root {
+			return quota, nil
+		}
+	}
+	return Quota{Root: root}, nil
+}
+
+// GetQuotaRoot returns the quota roots of mailbox, and the quotas of those
+// the server reported along with them (QUOTA, RFC 9208), or
+// ErrQuotaUnsupported if the server lacks the extension.
+func (c *ShemailClient) GetQuotaRoot(mailbox string) ([]string, []Quota, error) {
+	handler, err := c.quotaCommand(&getQuotaRoot{mailbox: mailbox})
+	if err != nil {
+		return nil, nil, err
+	}
+	return handler.roots, handler.quotas, nil
+}
+
+// quotaCommand runs a QUOTA command and returns what the server responded.
+func (c *ShemailClient) quotaCommand(cmd imap.Commander) (*quotaHandler, error) {
+	supported, err := c.Client.Support("QUOTA")
+	if err != nil {
+		return nil, err
+	}
+	if !supported {
+		return nil, ErrQuotaUnsupported
+	}
+
+	handler := &quotaHandler{}
+	status, err := c.Client.Execute(cmd, handler)
+	if err != nil {
+		return nil, err
+	}
+	if err := status.Err(); err != nil {
+		return nil, err
+	}
+	return handler, nil
+}
+
+// Idle sends IDLE (or, if the server lacks it, polls with NOOP) until stop
+// is closed. The server's unsolicited updates arrive on Updates.
+func (c *ShemailClient) Idle(stop <-chan struct{}, opts *client.IdleOptions) error {
+	return c.Client.Idle(stop, opts)
+}
+
 func (c *ShemailClient) List(ref string, name string, ch chan *imap.MailboxInfo) error {
 	return c.Client.List(ref, name, ch)
 }
@@ -84,6 +209,14 @@ func (c *ShemailClient) Logout() error {
 	return c.Client.Logout()
 }
 
+func (c *ShemailClient) Lsub(ref string, name string, ch chan *imap.MailboxInfo) error {
+	return c.Client.Lsub(ref, name, ch)
+}
+
+func (c *ShemailClient) Rename(existingName, newName string) error {
+	return c.Client.Rename(existingName, newName)
+}
+
 func (c *ShemailClient) Select(name string, readOnly bool) (*imap.MailboxStatus, error) {
 	return c.Client.Select(name, readOnly)
 }
@@ -92,16 +225,94 @@ func (c *ShemailClient) Status(name string, items []imap.StatusItem) (*imap.Mail
 	return c.Client.Status(name, items)
 }
 
-func (c *ShemailClient) UidCopy(seqset *imap.SeqSet, dest string) error {
-	return c.Client.UidCopy(seqset, dest)
+func (c *ShemailClient) Subscribe(name string) error {
+	return c.Client.Subscribe(name)
+}
+
+// UidCopy copies messages to dest, returning the server's COPYUID mapping of
+// source to destination UIDs, or nil if the server does not support UIDPLUS.
+func (c *ShemailClient) UidCopy(seqset *imap.SeqSet, dest string) (*CopyUID, error) {
+	if c.Client.State() != imap.SelectedState {
+		return nil, client.ErrNoMailboxSelected
+	}
+
+	cmd := &commands.Uid{Cmd: &commands.Copy{SeqSet: seqset, Mailbox: dest}}
+	status, err := c.Client.Execute(cmd, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := status.Err(); err != nil {
+		return nil, err
+	}
+	return parseCopyUID(status), nil
 }
 
 func (c *ShemailClient) UidFetch(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error {
 	return c.Client.UidFetch(seqset, items, ch)
 }
 
-func (c *ShemailClient) UidMove(seqSet *imap.SeqSet, mailbox string) error {
-	return c.Client.UidMove(seqSet, mailbox)
+// UidFetchChangedSince is UidFetch restricted to messages whose mod-sequence
+// is above modSeq. The server must support CONDSTORE.
+func (c *ShemailClient) UidFetchChangedSince(seqset *imap.SeqSet, items []imap.FetchItem, modSeq uint64, ch chan *imap.Message) error {
+	defer close(ch)
+
+	if c.Client.State() != imap.SelectedState {
+		return client.ErrNoMailboxSelected
+	}
+
+	cmd := &commands.Uid{Cmd: &changedSinceFetch{Fetch: commands.Fetch{SeqSet: seqset, Items: items}, modSeq: modSeq}}
+	status, err := c.Client.Execute(cmd, &responses.Fetch{Messages: ch, SeqSet: seqset, Uid: true})
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// UidExpunge permanently removes the given messages, which must already be
+// flagged \Deleted, and no others (UIDPLUS, RFC 4315). Unlike Expunge, it
+// leaves alone messages that something else flagged \Deleted.
+func (c *ShemailClient) UidExpunge(seqSet *imap.SeqSet) error {
+	if c.Client.State() != imap.SelectedState {
+		return client.ErrNoMailboxSelected
+	}
+
+	status, err := c.Client.Execute(&commands.Uid{Cmd: &uidExpunge{seqSet: seqSet}}, nil)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// UidMove moves messages to mailbox, returning the server's COPYUID mapping of
+// source to destination UIDs, or nil if the server does not support UIDPLUS.
+// It fails on servers without MOVE rather than falling back to go-imap's
+// COPY/STORE/EXPUNGE emulation, whose plain EXPUNGE also removes unrelated
+// messages; MoveMessages chooses a safe fallback itself.
+func (c *ShemailClient) UidMove(seqSet *imap.SeqSet, mailbox string) (*CopyUID, error) {
+	if c.Client.State() != imap.SelectedState {
+		return nil, client.ErrNoMailboxSelected
+	}
+	supported, err := c.Client.Support("MOVE")
+	if err != nil {
+		return nil, err
+	}
+	if !supported {
+		return nil, ErrMoveUnsupported
+	}
+
+	handler := &copyUIDHandler{}
+	cmd := &commands.Uid{Cmd: &commands.Move{SeqSet: seqSet, Mailbox: mailbox}}
+	status, err := c.Client.Execute(cmd, handler)
+	if err != nil {
+		return nil, err
+	}
+	if err := status.Err(); err != nil {
+		return nil, err
+	}
+	if copyUID := parseCopyUID(status); copyUID != nil {
+		return copyUID, nil
+	}
+	return handler.copyUID, nil
 }
 
 func (c *ShemailClient) UidSearch(criteria *imap.SearchCriteria) (uids []uint32, err error) {
@@ -112,6 +323,16 @@ func (c *ShemailClient) UidStore(seqSet *imap.SeqSet, item imap.StoreItem, flags
 	return c.Client.UidStore(seqSet, item, flags, ch)
 }
 
+func (c *ShemailClient) Unsubscribe(name string) error {
+	return c.Client.Unsubscribe(name)
+}
+
+// Updates returns the server's unsolicited updates, such as new message
+// counts, received at any time on the connection.
+func (c *ShemailClient) Updates() <-chan client.Update {
+	return c.updates
+}
+
 // IMAPDialer defines the interface for establishing an IMAP connection
 type IMAPDialer interface {
 	Dial(address string) (IMAPClient, error)
@@ -129,7 +350,7 @@ func (d *SheMailDialer) Dial(address string) (IMAPClient, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &ShemailClient{Client: c}, nil
+	return newShemailClient(c), nil
 }
 
 func (d *SheMailDialer) DialTLS(address string, config *tls.Config) (IMAPClient, error) {
@@ -137,7 +358,7 @@ func (d *SheMailDialer) DialTLS(address string, config *tls.Config) (IMAPClient,
 	if err != nil {
 		return nil, err
 	}
-	return &ShemailClient{Client: c}, nil
+	return newShemailClient(c), nil
 }
 
 // getImapClient returns an authenticated IMAP client for the given account
@@ -164,19 +385,26 @@ func getImapClient(dialer IMAPDialer, account Account) (IMAPClient, error) {
 
 // connectToMailbox returns an authenticated IMAP client for the given account and folder
 func connectToMailbox(dialer IMAPDialer, account Account, folder string, readOnly bool) (IMAPClient, error) {
+	imapClient, _, err := selectMailbox(dialer, account, folder, readOnly)
+	return imapClient, err
+}
+
+// selectMailbox is connectToMailbox for callers that also need the selected
+// folder's status, such as its UIDVALIDITY. The status may be nil.
+func selectMailbox(dialer IMAPDialer, account Account, folder string, readOnly bool) (IMAPClient, *imap.MailboxStatus, error) {
 	// Use getImapClient to establish the connection and authenticate
 	imapClient, err := getImapClient(dialer, account)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get IMAP client: %w", err)
+		return nil, nil, fmt.Errorf("failed to get IMAP client: %w", err)
 	}
 
 	// Select the specified folder
-	_, err = imapClient.Select(folder, readOnly)
+	status, err := imapClient.Select(folder, readOnly)
 	if err != nil {
-		return nil, fmt.Errorf("failed to select folder: %w", err)
+		return nil, nil, fmt.Errorf("failed to select folder: %w", err)
 	}
 
-	return imapClient, nil
+	return imapClient, status, nil
 }
 
 var SheClient = &ShemailClient{}
@@ -0,0 +1,46 @@
+package imaputils
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveUpdates plays an IMAP server on conn that answers every command with
+// OK, after sending count unsolicited updates.
+func serveUpdates(conn net.Conn, count int) {
+	fmt.Fprint(conn, "* OK [CAPABILITY IMAP4rev1] ready\r\n")
+	lines := bufio.NewReader(conn)
+	for {
+		line, err := lines.ReadString('\n')
+		if err != nil {
+			return
+		}
+		for range count {
+			fmt.Fprint(conn, "* OK still here\r\n")
+		}
+		fmt.Fprintf(conn, "%s OK done\r\n", strings.Fields(line)[0])
+	}
+}
+
+func TestShemailClientUpdates(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	go serveUpdates(serverConn, updatesBuffer*2)
+
+	c, err := client.New(clientConn)
+	if !assert.NoError(t, err) {
+		return
+	}
+	imapClient := newShemailClient(c)
+
+	// More updates than Updates holds, with nobody reading them, must not
+	// hold up the connection.
+	assert.NoError(t, c.Noop())
+	assert.Len(t, imapClient.Updates(), updatesBuffer)
+}
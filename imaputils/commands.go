@@ -0,0 +1,134 @@
+package imaputils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+)
+
+// changedSinceFetch is a FETCH carrying the CONDSTORE (RFC 7162) CHANGEDSINCE
+// modifier, which go-imap does not support itself: the server only returns
+// messages whose mod-sequence is above modSeq.
+type changedSinceFetch struct {
+	commands.Fetch
+	modSeq uint64
+}
+
+func (cmd *changedSinceFetch) Command() *imap.Command {
+	command := cmd.Fetch.Command()
+	modifier := []interface{}{imap.RawString("CHANGEDSINCE"), imap.RawString(strconv.FormatUint(cmd.modSeq, 10))}
+	command.Arguments = append(command.Arguments, modifier)
+	return command
+}
+
+// uidExpunge is the argument of the UIDPLUS (RFC 4315) UID EXPUNGE command,
+// to be wrapped in commands.Uid.
+type uidExpunge struct {
+	seqSet *imap.SeqSet
+}
+
+func (cmd *uidExpunge) Command() *imap.Command {
+	return &imap.Command{Name: "EXPUNGE", Arguments: []interface{}{cmd.seqSet}}
+}
+
+// codeCopyUID is the UIDPLUS (RFC 4315) response code reporting where copied
+// or moved messages ended up.
+const codeCopyUID imap.StatusRespCode = "COPYUID"
+
+// CopyUID is the content of a COPYUID response code: the destination's
+// UIDVALIDITY, and the source UIDs with the UIDs of their copies in the
+// destination, pairwise in the same order.
+type CopyUID struct {
+	UidValidity uint32
+	Source      []uint32
+	Dest        []uint32
+}
+
+// parseCopyUID extracts the COPYUID from a status response, returning nil if
+// it carries none (e.g. the server lacks UIDPLUS) or it is malformed.
+func parseCopyUID(status *imap.StatusResp) *CopyUID {
+	if status == nil || status.Code != codeCopyUID || len(status.Arguments) < 3 {
+		return nil
+	}
+	uidValidity, err := imap.ParseNumber(status.Arguments[0])
+	if err != nil {
+		return nil
+	}
+	source, err := parseUIDSet(status.Arguments[1])
+	if err != nil {
+		return nil
+	}
+	dest, err := parseUIDSet(status.Arguments[2])
+	if err != nil || len(source) != len(dest) {
+		return nil
+	}
+	return &CopyUID{UidValidity: uidValidity, Source: source, Dest: dest}
+}
+
+// parseNumber64 parses a number field that may not fit in the 32 bits
+// imap.ParseNumber allows, such as a quota usage or a folder size.
+func parseNumber64(field interface{}) (uint64, error) {
+	text, ok := field.(string)
+	if !ok {
+		return 0, fmt.Errorf("not a number: %v", field)
+	}
+	return strconv.ParseUint(text, 10, 64)
+}
+
+// parseUIDSet expands a uid-set such as "4,7:9" into its UIDs, keeping the
+// order the server listed them in, which is what pairs source and destination
+// UIDs in COPYUID. Ranges are expanded in ascending order.
+func parseUIDSet(field interface{}) ([]uint32, error) {
+	text, ok := field.(string)
+	if !ok {
+		return nil, fmt.Errorf("uid-set is not an atom")
+	}
+	var uids []uint32
+	for _, part := range strings.Split(text, ",") {
+		first, last, isRange := strings.Cut(part, ":")
+		start, err := imap.ParseNumber(first)
+		if err != nil {
+			return nil, err
+		}
+		stop := start
+		if isRange {
+			if stop, err = imap.ParseNumber(last); err != nil {
+				return nil, err
+			}
+		}
+		if start > stop {
+			start, stop = stop, start
+		}
+		if start == 0 {
+			return nil, fmt.Errorf("uid-set %q contains 0", text)
+		}
+		for uid := start; ; uid++ {
+			uids = append(uids, uid)
+			if uid == stop {
+				break
+			}
+		}
+	}
+	return uids, nil
+}
+
+// copyUIDHandler captures the COPYUID of an untagged OK response. UID MOVE
+// reports it that way (RFC 6851), because the tagged response comes after
+// the source messages have been expunged.
+type copyUIDHandler struct {
+	copyUID *CopyUID
+}
+
+func (handler *copyUIDHandler) Handle(resp imap.Resp) error {
+	if status, ok := resp.(*imap.StatusResp); ok && status.Type == imap.StatusRespOk {
+		if copyUID := parseCopyUID(status); copyUID != nil {
+			handler.copyUID = copyUID
+			return nil
+		}
+	}
+	return responses.ErrUnhandled
+}
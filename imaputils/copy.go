@@ -6,27 +6,31 @@ import (
 )
 
 // CopyMessages copies the given messages to destFolder (creating it if needed),
-// leaving the originals in sourceFolder. The source is opened read-only since
-// COPY does not modify it.
-func CopyMessages(dialer IMAPDialer, account Account, messages []*imap.Message, sourceFolder, destFolder string) error {
+// leaving the originals in sourceFolder, and returns where the copies ended
+// up. The source is opened read-only since COPY does not modify it.
+func CopyMessages(dialer IMAPDialer, account Account, messages []*imap.Message, sourceFolder, destFolder string) (*Transfer, error) {
+	transfer := newTransfer(sourceFolder, destFolder)
 	if len(messages) == 0 {
-		return nil
+		return transfer, nil
 	}
 
 	if err := EnsureFolder(dialer, account, destFolder); err != nil {
-		return err
+		return nil, err
 	}
 
-	imapClient, err := connectToMailbox(dialer, account, sourceFolder, true)
+	imapClient, status, err := selectMailbox(dialer, account, sourceFolder, true)
 	if err != nil {
-		return fmt.Errorf("failed to connect to mailbox: %w", err)
+		return nil, fmt.Errorf("failed to connect to mailbox: %w", err)
 	}
 	defer imapClient.Logout()
+	transfer.recordSource(status)
 
 	seqSet := createSeqSet(messages)
-	if err := imapClient.UidCopy(seqSet, destFolder); err != nil {
-		return fmt.Errorf("failed to copy messages to %s: %w", destFolder, err)
+	copyUID, err := imapClient.UidCopy(seqSet, destFolder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy messages to %s: %w", destFolder, err)
 	}
+	transfer.record(copyUID)
 
-	return nil
+	return transfer, nil
 }
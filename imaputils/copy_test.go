@@ -13,7 +13,7 @@ func TestCopyMessages(t *testing.T) {
 		client := &MockIMAPClientMove{}
 		dialer := &MockIMAPDialerMove{}
 
-		err := CopyMessages(dialer, Account{Server: "test.example.com"}, nil, "INBOX", "Archive")
+		_, err := CopyMessages(dialer, Account{Server: "test.example.com"}, nil, "INBOX", "Archive")
 
 		assert.NoError(t, err)
 		client.AssertExpectations(t)
@@ -42,7 +42,7 @@ func TestCopyMessages(t *testing.T) {
 		client.On("UidCopy", mock.Anything, "Archive").Return(nil)
 
 		messages := []*imap.Message{{Uid: 1}, {Uid: 2}}
-		err := CopyMessages(dialer, Account{Server: "test.example.com"}, messages, "INBOX", "Archive")
+		_, err := CopyMessages(dialer, Account{Server: "test.example.com"}, messages, "INBOX", "Archive")
 
 		assert.NoError(t, err)
 		client.AssertExpectations(t)
@@ -64,7 +64,7 @@ func TestCopyMessages(t *testing.T) {
 		client.On("Select", "INBOX", true).Return(&imap.MailboxStatus{}, nil)
 		client.On("UidCopy", mock.Anything, "Archive").Return(fmt.Errorf("copy failed"))
 
-		err := CopyMessages(dialer, Account{Server: "test.example.com"}, []*imap.Message{{Uid: 1}}, "INBOX", "Archive")
+		_, err := CopyMessages(dialer, Account{Server: "test.example.com"}, []*imap.Message{{Uid: 1}}, "INBOX", "Archive")
 
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "copy failed")
@@ -2,6 +2,7 @@ package imaputils
 
 import (
 	"github.com/emersion/go-imap"
+	"time"
 )
 
 // FindDuplicates returns the duplicate messages in the slice: every message
@@ -40,3 +41,63 @@ func FindDuplicates(messages []*imap.Message) []*imap.Message {
 
 	return duplicates
 }
+
+// DuplicateTracker finds duplicate messages incrementally, for folders too
+// large to collect and sort up front. Unlike FindDuplicates it does not depend
+// on input order: the oldest copy (by INTERNALDATE, then lowest UID) of each
+// Message-ID is always the one kept. Only the Message-ID, date and UID of each
+// kept message are retained, so memory grows with the number of distinct
+// Message-IDs rather than with message size.
+type DuplicateTracker struct {
+	originals  map[string]trackedMessage
+	duplicates []uint32
+}
+
+// trackedMessage is the slice of a kept message a DuplicateTracker needs to
+// decide which copy is older.
+type trackedMessage struct {
+	uid  uint32
+	date time.Time
+}
+
+// olderThan reports whether tracked should be kept in preference to other.
+func (tracked trackedMessage) olderThan(other trackedMessage) bool {
+	if !tracked.date.Equal(other.date) {
+		return tracked.date.Before(other.date)
+	}
+	return tracked.uid < other.uid
+}
+
+// NewDuplicateTracker returns an empty DuplicateTracker.
+func NewDuplicateTracker() *DuplicateTracker {
+	return &DuplicateTracker{originals: make(map[string]trackedMessage)}
+}
+
+// Add considers one message. Messages without a Message-ID are ignored.
+func (tracker *DuplicateTracker) Add(message *imap.Message) {
+	if message.Envelope == nil || message.Envelope.MessageId == "" {
+		return
+	}
+
+	messageID := message.Envelope.MessageId
+	candidate := trackedMessage{uid: message.Uid, date: message.InternalDate}
+	original, ok := tracker.originals[messageID]
+	switch {
+	case !ok:
+		tracker.originals[messageID] = candidate
+	case candidate.olderThan(original):
+		// The newcomer predates the copy we were keeping: keep it instead and
+		// demote the previous original to a duplicate.
+		log.Debug().Msgf("duplicate Message-ID %q: uid=%d superseded by older uid=%d", messageID, original.uid, candidate.uid)
+		tracker.originals[messageID] = candidate
+		tracker.duplicates = append(tracker.duplicates, original.uid)
+	default:
+		log.Debug().Msgf("duplicate Message-ID %q: uid=%d matches kept uid=%d", messageID, candidate.uid, original.uid)
+		tracker.duplicates = append(tracker.duplicates, candidate.uid)
+	}
+}
+
+// Duplicates returns the UIDs of every duplicate seen so far.
+func (tracker *DuplicateTracker) Duplicates() []uint32 {
+	return tracker.duplicates
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/emersion/go-imap"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestFindDuplicates(t *testing.T) {
@@ -40,3 +41,40 @@ func TestFindDuplicates(t *testing.T) {
 		assert.Empty(t, FindDuplicates(messages))
 	})
 }
+
+func TestDuplicateTracker(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	msg := func(uid uint32, messageID string, day int) *imap.Message {
+		return &imap.Message{
+			Uid:          uid,
+			InternalDate: base.AddDate(0, 0, day),
+			Envelope:     &imap.Envelope{MessageId: messageID},
+		}
+	}
+
+	t.Run("keeps the oldest copy regardless of input order", func(t *testing.T) {
+		tracker := NewDuplicateTracker()
+		// a: uid 3 is oldest, so 1 and 2 are duplicates; b: uid 4 kept, 5 dup.
+		for _, message := range []*imap.Message{
+			msg(1, "a", 5), msg(2, "a", 3), msg(3, "a", 1), msg(4, "b", 0), msg(5, "b", 2),
+		} {
+			tracker.Add(message)
+		}
+		assert.ElementsMatch(t, []uint32{1, 2, 5}, tracker.Duplicates())
+	})
+
+	t.Run("equal dates keep the lowest UID", func(t *testing.T) {
+		tracker := NewDuplicateTracker()
+		tracker.Add(msg(9, "a", 0))
+		tracker.Add(msg(4, "a", 0))
+		assert.Equal(t, []uint32{9}, tracker.Duplicates())
+	})
+
+	t.Run("messages without a Message-ID are ignored", func(t *testing.T) {
+		tracker := NewDuplicateTracker()
+		tracker.Add(msg(1, "", 0))
+		tracker.Add(msg(2, "", 0))
+		tracker.Add(&imap.Message{Uid: 3})
+		assert.Empty(t, tracker.Duplicates())
+	})
+}
@@ -7,6 +7,8 @@ import (
 
 type DeletionStrategy int
 
+// DeletedFolderNames are the usual names of trash folders, for servers that
+// do not mark theirs \Trash.
 var DeletedFolderNames = []string{
 	"Trash",
 	"[Gmail]/Trash",
@@ -14,69 +16,69 @@ var DeletedFolderNames = []string{
 	"Deleted Messages",
 }
 
-// DeleteMessages deletes the list of messages based on the account's deletion strategy
-func DeleteMessages(dialer IMAPDialer, account Account, messages []*imap.Message, folder string) error {
-	var err error
+// DeleteMessages deletes the list of messages based on the account's deletion
+// strategy, returning where they went: the trash folder, or, when they were
+// purged, a transfer with no destination.
+func DeleteMessages(dialer IMAPDialer, account Account, messages []*imap.Message, folder string) (*Transfer, error) {
+	var (
+		transfer *Transfer
+		err      error
+	)
 	if len(messages) == 0 {
-		return nil
+		return newTransfer(folder, ""), nil
 	}
 	if account.Purge {
 		log.Debug().Msgf("will purge messages from this folder")
-		err = purgeMessages(account, folder, messages, dialer)
+		transfer, err = purgeMessages(account, folder, messages, dialer)
 	} else {
-		err = moveToTrash(dialer, account, folder, messages)
+		transfer, err = moveToTrash(dialer, account, folder, messages)
 	}
 	if err != nil {
-		return fmt.Errorf("failed to delete messages: %w", err)
+		return nil, fmt.Errorf("failed to delete messages: %w", err)
 	}
-	return nil
+	return transfer, nil
 }
 
 // moveToTrash moves a list of messages to a trash/deleted folder
-func moveToTrash(dialer IMAPDialer, account Account, folder string, messages []*imap.Message) error {
+func moveToTrash(dialer IMAPDialer, account Account, folder string, messages []*imap.Message) (*Transfer, error) {
 	trashFolder, err := FindTrashFolder(dialer, account)
 	if err != nil {
-		return fmt.Errorf("failed to find trash folder: %w", err)
+		return nil, fmt.Errorf("failed to find trash folder: %w", err)
 	}
-	if err := MoveMessages(dialer, account, messages, folder, trashFolder, 10); err != nil {
-		return fmt.Errorf("failed to move messages from %s to %s: %w", folder, trashFolder, err)
-	}
-	return nil
-}
-
-// FindTrashFolder searches account folders for common trash folder names,
-// falling back to "Deleted Items" if none match.
-func FindTrashFolder(dialer IMAPDialer, account Account) (string, error) {
-	mailboxes, err := ListFolders(dialer, account)
+	transfer, err := moveMessages(dialer, account, messages, folder, trashFolder, 10, trashFolder)
 	if err != nil {
-		return "", fmt.Errorf("failed to list folders: %w", err)
-	}
-	for _, folder := range mailboxes {
-		for _, trashName := range DeletedFolderNames {
-			if folder == trashName {
-				return trashName, nil
-			}
-		}
+		return nil, fmt.Errorf("failed to move messages from %s to %s: %w", folder, trashFolder, err)
 	}
-	return "Deleted Items", nil
+	return transfer, nil
 }
 
 // purgeMessages permanently deletes a list of messages from a folder
-func purgeMessages(account Account, folder string, messages []*imap.Message, dialer IMAPDialer) error {
-	imapClient, err := connectToMailbox(dialer, account, folder, false)
+func purgeMessages(account Account, folder string, messages []*imap.Message, dialer IMAPDialer) (*Transfer, error) {
+	imapClient, status, err := selectMailbox(dialer, account, folder, false)
 	if err != nil {
-		return fmt.Errorf("failed to connect to mailbox: %w", err)
+		return nil, fmt.Errorf("failed to connect to mailbox: %w", err)
 	}
 	defer imapClient.Logout()
+	transfer := newTransfer(folder, "")
+	transfer.recordSource(status)
+
+	// Check what the expunge will take with it before flagging anything.
+	plan, err := planExpunge(imapClient, folder, messages)
+	if err != nil {
+		return nil, err
+	}
+	if err := plan.Check(account, "purge"); err != nil {
+		return nil, err
+	}
 
 	seqSet := createSeqSet(messages)
 	action := imap.FormatFlagsOp(imap.AddFlags, true)
 	flags := []interface{}{imap.DeletedFlag}
 	if err := imapClient.UidStore(seqSet, action, flags, nil); err != nil {
-		return fmt.Errorf("failed to mark messages as deleted: %w", err)
+		return nil, fmt.Errorf("failed to mark messages as deleted: %w", err)
 	}
-	if err := imapClient.Expunge(nil); err != nil {
-		return fmt.Errorf("failed to expunge messages: %w", err)
+	if err := plan.expunge(imapClient, seqSet); err != nil {
+		return nil, fmt.Errorf("failed to expunge messages: %w", err)
 	}
-	return nil
+	return transfer, nil
 }
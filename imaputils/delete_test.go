@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"testing"
+	"time"
 )
 
 // Mocking IMAPDialer
@@ -30,6 +31,11 @@ type MockIMAPClient struct {
 	mock.Mock
 }
 
+func (m *MockIMAPClient) Append(mbox string, flags []string, date time.Time, msg imap.Literal) error {
+	args := m.Called(mbox, flags, date, msg)
+	return args.Error(0)
+}
+
 func (m *MockIMAPClient) Capability() (map[string]bool, error) {
 	args := m.Called()
 	return args.Get(0).(map[string]bool), args.Error(1)
@@ -40,6 +46,11 @@ func (m *MockIMAPClient) Create(name string) error {
 	return args.Error(0)
 }
 
+func (m *MockIMAPClient) Delete(name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+
 func (m *MockIMAPClient) Expunge(ch chan uint32) error {
 	args := m.Called(ch)
 	return args.Error(0)
@@ -55,11 +66,35 @@ func (m *MockIMAPClient) GetClient() *client.Client {
 	return args.Get(0).(*client.Client)
 }
 
+func (m *MockIMAPClient) GetQuota(root string) (Quota, error) {
+	args := m.Called(root)
+	return args.Get(0).(Quota), args.Error(1)
+}
+
+func (m *MockIMAPClient) GetQuotaRoot(mailbox string) ([]string, []Quota, error) {
+	args := m.Called(mailbox)
+	return args.Get(0).([]string), args.Get(1).([]Quota), args.Error(2)
+}
+
+func (m *MockIMAPClient) Idle(stop <-chan struct{}, opts *client.IdleOptions) error {
+	args := m.Called(stop, opts)
+	return args.Error(0)
+}
+
+func (m *MockIMAPClient) Updates() <-chan client.Update {
+	return nil
+}
+
 func (m *MockIMAPClient) List(ref string, name string, ch chan *imap.MailboxInfo) error {
 	args := m.Called(ref, name, ch)
 	return args.Error(0)
 }
 
+func (m *MockIMAPClient) Lsub(ref string, name string, ch chan *imap.MailboxInfo) error {
+	args := m.Called(ref, name, ch)
+	return args.Error(0)
+}
+
 func (m *MockIMAPClient) Login(username, password string) error {
 	args := m.Called(username, password)
 	return args.Error(0)
@@ -69,6 +104,11 @@ func (m *MockIMAPClient) Logout() error {
 	return m.Called().Error(0)
 }
 
+func (m *MockIMAPClient) Rename(existingName, newName string) error {
+	args := m.Called(existingName, newName)
+	return args.Error(0)
+}
+
 func (m *MockIMAPClient) Select(name string, readOnly bool) (*imap.MailboxStatus, error) {
 	args := m.Called(name, readOnly)
 	return args.Get(0).(*imap.MailboxStatus), args.Error(1)
@@ -82,8 +122,18 @@ func (m *MockIMAPClient) Status(name string, items []imap.StatusItem) (*imap.Mai
 	return nil, args.Error(1)
 }
 
-func (m *MockIMAPClient) UidCopy(seqset *imap.SeqSet, mailbox string) error {
+func (m *MockIMAPClient) Subscribe(name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+
+func (m *MockIMAPClient) UidCopy(seqset *imap.SeqSet, mailbox string) (*CopyUID, error) {
 	args := m.Called(seqset, mailbox)
+	return mockCopyUID(args), args.Error(0)
+}
+
+func (m *MockIMAPClient) UidExpunge(seqSet *imap.SeqSet) error {
+	args := m.Called(seqSet)
 	return args.Error(0)
 }
 
@@ -92,9 +142,14 @@ func (m *MockIMAPClient) UidFetch(seqset *imap.SeqSet, items []imap.FetchItem, c
 	return args.Error(0)
 }
 
-func (m *MockIMAPClient) UidMove(seqset *imap.SeqSet, mailbox string) error {
+func (m *MockIMAPClient) UidFetchChangedSince(seqset *imap.SeqSet, items []imap.FetchItem, modSeq uint64, ch chan *imap.Message) error {
+	close(ch)
+	return nil
+}
+
+func (m *MockIMAPClient) UidMove(seqset *imap.SeqSet, mailbox string) (*CopyUID, error) {
 	args := m.Called(seqset, mailbox)
-	return args.Error(0)
+	return mockCopyUID(args), args.Error(0)
 }
 
 func (m *MockIMAPClient) UidSearch(criteria *imap.SearchCriteria) ([]uint32, error) {
@@ -107,6 +162,11 @@ func (m *MockIMAPClient) UidStore(seqset *imap.SeqSet, item imap.StoreItem, flag
 	return args.Error(0)
 }
 
+func (m *MockIMAPClient) Unsubscribe(name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+
 func TestDeleteMessages_NoMessages(t *testing.T) {
 	dialer := new(MockIMAPDialer)
 	account := Account{Purge: false}
@@ -119,7 +179,7 @@ func TestDeleteMessages_NoMessages(t *testing.T) {
 		close(ch) // Close the channel to simulate end of data
 	})
 
-	err := DeleteMessages(dialer, account, messages, "INBOX")
+	_, err := DeleteMessages(dialer, account, messages, "INBOX")
 	assert.NoError(t, err)
 }
 
@@ -165,6 +225,7 @@ func TestDeleteMessages_MoveToTrash(t *testing.T) {
 	// Move and verify
 	seqSet := new(imap.SeqSet)
 	seqSet.AddNum(messages[0].Uid)
+	client.On("Capability").Return(map[string]bool{"MOVE": true}, nil)
 	client.On("UidMove", seqSet, "Deleted Items").Return(nil)
 	client.On("UidFetch", mock.Anything, []imap.FetchItem{imap.FetchUid}, mock.Anything).Return(nil).
 		Run(func(args mock.Arguments) {
@@ -172,7 +233,7 @@ func TestDeleteMessages_MoveToTrash(t *testing.T) {
 			close(ch)
 		})
 
-	err := DeleteMessages(dialer, account, messages, "INBOX")
+	_, err := DeleteMessages(dialer, account, messages, "INBOX")
 	assert.NoError(t, err)
 	client.AssertExpectations(t)
 }
@@ -186,7 +247,6 @@ func TestDeleteMessages_NoExistingTrashFolder(t *testing.T) {
 		Uid:    123,
 	}}
 
-	// Basic setup
 	dialer.On("Dial", mock.Anything).Return(client, nil)
 	client.On("Login", mock.Anything, mock.Anything).Return(nil)
 	client.On("Logout").Return(nil)
@@ -195,46 +255,19 @@ func TestDeleteMessages_NoExistingTrashFolder(t *testing.T) {
 	client.On("List", "", "*", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
 		ch := args.Get(2).(chan *imap.MailboxInfo)
 		ch <- &imap.MailboxInfo{Name: "INBOX"}
-		// Don't include Deleted Items
-		close(ch)
-	})
-
-	// EnsureFolder hierarchy delimiter discovery
-	client.On("List", "", "", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
-		ch := args.Get(2).(chan *imap.MailboxInfo)
-		ch <- &imap.MailboxInfo{Delimiter: "/"}
-		close(ch)
-	})
-
-	// EnsureFolder verification
-	client.On("List", "", "Deleted Items", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
-		ch := args.Get(2).(chan *imap.MailboxInfo)
-		// Don't include Deleted Items - it doesn't exist yet
+		// No folder is marked \Trash or has a trash name
+		ch <- &imap.MailboxInfo{Name: "Papierkorb"}
 		close(ch)
 	})
 
-	// Folder creation
-	client.On("Create", "Deleted Items").Return(nil)
-
-	// Select operations - allow any select calls
-	client.On("Select", mock.Anything, mock.Anything).Return(&imap.MailboxStatus{}, nil)
-
-	// Move and verify
-	seqSet := new(imap.SeqSet)
-	seqSet.AddNum(messages[0].Uid)
-	client.On("UidMove", seqSet, "Deleted Items").Return(nil)
-	client.On("UidFetch", mock.Anything, []imap.FetchItem{imap.FetchUid}, mock.Anything).Return(nil).
-		Run(func(args mock.Arguments) {
-			ch := args.Get(2).(chan *imap.Message)
-			close(ch)
-		})
-
-	err := DeleteMessages(dialer, account, messages, "INBOX")
-	assert.NoError(t, err)
+	_, err := DeleteMessages(dialer, account, messages, "INBOX")
+	assert.ErrorIs(t, err, ErrNoTrashFolder)
 	client.AssertExpectations(t)
+	client.AssertNotCalled(t, "Create", mock.Anything)
+	client.AssertNotCalled(t, "UidMove", mock.Anything, mock.Anything)
 }
 
-func TestDeleteMessages_CreateTrashFolder(t *testing.T) {
+func TestDeleteMessages_SpecialUseTrashFolder(t *testing.T) {
 	dialer := new(MockIMAPDialer)
 	client := new(MockIMAPClient)
 	account := Account{Purge: false}
@@ -246,35 +279,45 @@ func TestDeleteMessages_CreateTrashFolder(t *testing.T) {
 	dialer.On("Dial", mock.Anything).Return(client, nil)
 	client.On("Login", mock.Anything, mock.Anything).Return(nil)
 
-	// Mock empty folder list
-	client.On("List", mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+	// The folder marked \Trash wins over one merely named like a trash folder
+	client.On("List", "", "*", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
 		ch := args.Get(2).(chan *imap.MailboxInfo)
+		ch <- &imap.MailboxInfo{Name: "INBOX"}
+		ch <- &imap.MailboxInfo{Name: "Trash"}
+		ch <- &imap.MailboxInfo{Name: "Papierkorb", Attributes: []string{imap.TrashAttr}}
+		close(ch)
+	})
+	client.On("List", "", "", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		ch := args.Get(2).(chan *imap.MailboxInfo)
+		ch <- &imap.MailboxInfo{Delimiter: "/"}
+		close(ch)
+	})
+	client.On("List", "", "Papierkorb", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		ch := args.Get(2).(chan *imap.MailboxInfo)
+		ch <- &imap.MailboxInfo{Name: "Papierkorb"}
 		close(ch)
 	})
 
-	// Mock mailbox operations with more flexible matching
 	client.On("Select", mock.Anything, mock.Anything).Return(&imap.MailboxStatus{}, nil)
-	client.On("Create", mock.Anything).Return(nil)
-
-	// Mock UidFetch for message verification
 	client.On("UidFetch", mock.Anything, []imap.FetchItem{imap.FetchUid}, mock.Anything).Return(nil).
 		Run(func(args mock.Arguments) {
 			ch := args.Get(2).(chan *imap.Message)
 			close(ch)
 		})
 
-	// Mock the move operation
 	seqSet := new(imap.SeqSet)
 	seqSet.AddNum(messages[0].Uid)
-	client.On("UidMove", mock.Anything, mock.Anything).Return(nil)
+	client.On("Capability").Return(map[string]bool{"MOVE": true}, nil)
+	client.On("UidMove", seqSet, "Papierkorb").Return(nil)
 
 	client.On("Logout").Return(nil)
 
-	err := DeleteMessages(dialer, account, messages, "INBOX")
+	_, err := DeleteMessages(dialer, account, messages, "INBOX")
 	assert.NoError(t, err)
 
 	dialer.AssertExpectations(t)
 	client.AssertExpectations(t)
+	client.AssertNotCalled(t, "Create", mock.Anything)
 }
 
 func TestDeleteMessages_PurgeMessages(t *testing.T) {
@@ -289,12 +332,16 @@ func TestDeleteMessages_PurgeMessages(t *testing.T) {
 	// Mock selecting the source folder
 	client.On("Select", mock.Anything, mock.Anything).Return(&imap.MailboxStatus{}, nil)
 
+	// Nothing else is flagged \Deleted, so a plain EXPUNGE is safe
+	client.On("Capability").Return(map[string]bool{}, nil)
+	client.On("UidSearch", mock.Anything).Return([]uint32{}, nil)
+
 	// Mock the message deletion
 	client.On("UidStore", mock.Anything, mock.Anything, []interface{}{imap.DeletedFlag}, mock.Anything).Return(nil)
 	client.On("Expunge", mock.Anything).Return(nil)
 	client.On("Logout").Return(nil)
 
-	err := DeleteMessages(dialer, account, messages, "INBOX")
+	_, err := DeleteMessages(dialer, account, messages, "INBOX")
 	assert.NoError(t, err)
 
 	dialer.AssertExpectations(t)
@@ -311,13 +358,14 @@ func TestDeleteMessages_ErrorHandling(t *testing.T) {
 	dialError := fmt.Errorf("connection failed")
 	dialer.On("Dial", mock.Anything).Return(client, dialError)
 
-	err := DeleteMessages(dialer, account, messages, "INBOX")
+	_, err := DeleteMessages(dialer, account, messages, "INBOX")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "connection failed")
 
-	// Test folder creation error
+	// Test a trash_folder setting naming a folder that does not exist
 	dialer = new(MockIMAPDialer)
 	client = new(MockIMAPClient)
+	account.TrashFolder = "Papierkorb"
 
 	dialer.On("Dial", mock.Anything).Return(client, nil)
 	client.On("Login", mock.Anything, mock.Anything).Return(nil)
@@ -325,14 +373,11 @@ func TestDeleteMessages_ErrorHandling(t *testing.T) {
 		ch := args.Get(2).(chan *imap.MailboxInfo)
 		close(ch)
 	})
-	client.On("Select", mock.Anything, mock.Anything).Return(&imap.MailboxStatus{}, nil)
-	createError := fmt.Errorf("failed to create folder")
-	client.On("Create", mock.Anything).Return(createError)
 	client.On("Logout").Return(nil)
 
-	err = DeleteMessages(dialer, account, messages, "INBOX")
+	_, err = DeleteMessages(dialer, account, messages, "INBOX")
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "failed to create folder")
+	assert.Contains(t, err.Error(), "trash_folder Papierkorb does not exist")
 
 	dialer.AssertExpectations(t)
 	client.AssertExpectations(t)
@@ -0,0 +1,394 @@
+package imaputils
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// DryRunReport collects what a dry run would have done. It is shared by every
+// connection a DryRunDialer opens, including concurrent ones.
+type DryRunReport struct {
+	lock sync.Mutex
+	// TrashFolder is the trash folder resolved during the run, if any.
+	TrashFolder string
+	created     []string
+	// folderChanges are the other changes to folders, e.g. "delete folder X".
+	folderChanges []string
+	operations    []*dryRunOperation
+	// deleted and removed track, per folder, the UIDs the run would have
+	// flagged \Deleted and the UIDs that would no longer be there, so that
+	// later reads see the folder as it would be.
+	deleted map[string]map[uint32]bool
+	removed map[string]map[uint32]bool
+}
+
+// dryRunOperation is one kind of mutation, with the number of messages it
+// would have touched in total; it reads as before, the count, then after.
+type dryRunOperation struct {
+	before string
+	after  string
+	count  int
+}
+
+// NewDryRunReport returns an empty report.
+func NewDryRunReport() *DryRunReport {
+	return &DryRunReport{
+		deleted: map[string]map[uint32]bool{},
+		removed: map[string]map[uint32]bool{},
+	}
+}
+
+// Lines describes, in the order they were first attempted, every mutation the
+// run would have made: folders created, other changes to folders, then each
+// action with its message count. Repeated operations (e.g. the batches of one move) are combined.
+func (report *DryRunReport) Lines() []string {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+
+	var lines []string
+	for _, folder := range report.created {
+		lines = append(lines, "create folder "+folder)
+	}
+	lines = append(lines, report.folderChanges...)
+	for _, operation := range report.operations {
+		lines = append(lines, fmt.Sprintf("%s%d%s", operation.before, operation.count, operation.after))
+	}
+	if report.TrashFolder != "" {
+		lines = append(lines, "trash folder: "+report.TrashFolder)
+	}
+	return lines
+}
+
+func (report *DryRunReport) noteTrash(folder string) {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	report.TrashFolder = folder
+}
+
+func (report *DryRunReport) create(folder string) {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	if !slices.Contains(report.created, folder) {
+		report.created = append(report.created, folder)
+	}
+}
+
+// changeFolder notes a change to a folder other than creating it.
+func (report *DryRunReport) changeFolder(change string) {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	if !slices.Contains(report.folderChanges, change) {
+		report.folderChanges = append(report.folderChanges, change)
+	}
+}
+
+func (report *DryRunReport) isCreated(folder string) bool {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	return slices.Contains(report.created, folder)
+}
+
+// record adds count messages to the operation described by before and after,
+// which surround the count, e.g. "move " and " messages from INBOX to Archive".
+func (report *DryRunReport) record(before, after string, count int) {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	for _, operation := range report.operations {
+		if operation.before == before && operation.after == after {
+			operation.count += count
+			return
+		}
+	}
+	report.operations = append(report.operations, &dryRunOperation{before: before, after: after, count: count})
+}
+
+// Record notes an operation the run skipped that does not go through an IMAP
+// client, such as writing an export, as record does.
+func (report *DryRunReport) Record(before, after string, count int) {
+	report.record(before, after, count)
+}
+
+// mark adds uids to one of the per-folder sets, deleted or removed.
+func (report *DryRunReport) mark(sets map[string]map[uint32]bool, folder string, uids []uint32) {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	if sets[folder] == nil {
+		sets[folder] = map[uint32]bool{}
+	}
+	for _, uid := range uids {
+		sets[folder][uid] = true
+	}
+}
+
+func (report *DryRunReport) unmarkDeleted(folder string, uids []uint32) {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	for _, uid := range uids {
+		delete(report.deleted[folder], uid)
+	}
+}
+
+// expunge moves the UIDs flagged \Deleted in folder to its removed set,
+// returning how many there were. With only, only those UIDs are expunged, as
+// by UID EXPUNGE.
+func (report *DryRunReport) expunge(folder string, only []uint32) int {
+	report.lock.Lock()
+	var uids []uint32
+	for uid := range report.deleted[folder] {
+		if only == nil || slices.Contains(only, uid) {
+			uids = append(uids, uid)
+			delete(report.deleted[folder], uid)
+		}
+	}
+	report.lock.Unlock()
+
+	report.mark(report.removed, folder, uids)
+	return len(uids)
+}
+
+// isRemoved reports whether the run would already have taken uid out of
+// folder.
+func (report *DryRunReport) isRemoved(folder string, uid uint32) bool {
+	report.lock.Lock()
+	defer report.lock.Unlock()
+	return report.removed[folder][uid]
+}
+
+// DryRunDialer dials through Dialer but hands out clients that never send a
+// mutating command (APPEND, CREATE, DELETE, RENAME, SUBSCRIBE, UNSUBSCRIBE,
+// STORE, COPY, MOVE, EXPUNGE or UID EXPUNGE). Instead, each is recorded in Report and reported as successful,
+// and reads are adjusted so the rest of the run sees the mailbox as it would
+// have been, e.g. moved messages are gone from their source folder.
+type DryRunDialer struct {
+	Dialer IMAPDialer
+	Report *DryRunReport
+}
+
+// Ensure DryRunDialer implements IMAPDialer interface
+var _ IMAPDialer = &DryRunDialer{}
+
+// NewDryRunDialer wraps dialer for a dry run reporting to report.
+func NewDryRunDialer(dialer IMAPDialer, report *DryRunReport) *DryRunDialer {
+	return &DryRunDialer{Dialer: dialer, Report: report}
+}
+
+func (d *DryRunDialer) Dial(address string) (IMAPClient, error) {
+	imapClient, err := d.Dialer.Dial(address)
+	if err != nil {
+		return nil, err
+	}
+	return &dryRunClient{IMAPClient: imapClient, report: d.Report}, nil
+}
+
+func (d *DryRunDialer) DialTLS(address string, config *tls.Config) (IMAPClient, error) {
+	imapClient, err := d.Dialer.DialTLS(address, config)
+	if err != nil {
+		return nil, err
+	}
+	return &dryRunClient{IMAPClient: imapClient, report: d.Report}, nil
+}
+
+// noteTrashFolder records the resolved trash folder if dialer is running dry.
+func noteTrashFolder(dialer IMAPDialer, folder string) {
+	if dryRun, ok := dialer.(*DryRunDialer); ok {
+		dryRun.Report.noteTrash(folder)
+	}
+}
+
+// dryRunClient is the read-only IMAPClient handed out by DryRunDialer.
+type dryRunClient struct {
+	IMAPClient
+	report *DryRunReport
+	// selected is the selected folder; virtual is true when it exists only
+	// because the run would have created it, so the server cannot select it.
+	selected string
+	virtual  bool
+}
+
+// Ensure dryRunClient implements IMAPClient interface
+var _ IMAPClient = &dryRunClient{}
+
+func (c *dryRunClient) Append(mbox string, flags []string, date time.Time, msg imap.Literal) error {
+	c.report.record("append ", " messages to "+mbox, 1)
+	return nil
+}
+
+func (c *dryRunClient) Create(name string) error {
+	c.report.create(name)
+	return nil
+}
+
+func (c *dryRunClient) Delete(name string) error {
+	c.report.changeFolder("delete folder " + name)
+	return nil
+}
+
+func (c *dryRunClient) Rename(existingName, newName string) error {
+	c.report.changeFolder(fmt.Sprintf("rename folder %s to %s", existingName, newName))
+	return nil
+}
+
+func (c *dryRunClient) Subscribe(name string) error {
+	c.report.changeFolder("subscribe to folder " + name)
+	return nil
+}
+
+func (c *dryRunClient) Unsubscribe(name string) error {
+	c.report.changeFolder("unsubscribe from folder " + name)
+	return nil
+}
+
+func (c *dryRunClient) Expunge(ch chan uint32) error {
+	if ch != nil {
+		close(ch)
+	}
+	if count := c.report.expunge(c.selected, nil); count > 0 {
+		c.report.record("expunge ", " messages from "+c.selected, count)
+	}
+	return nil
+}
+
+func (c *dryRunClient) UidExpunge(seqSet *imap.SeqSet) error {
+	uids := seqSetUIDs(seqSet)
+	if uids == nil {
+		uids = []uint32{}
+	}
+	if count := c.report.expunge(c.selected, uids); count > 0 {
+		c.report.record("expunge ", " messages from "+c.selected, count)
+	}
+	return nil
+}
+
+// GetClient returns nil: handing out the underlying client would let callers
+// bypass the dry run.
+func (c *dryRunClient) GetClient() *client.Client {
+	return nil
+}
+
+func (c *dryRunClient) Select(name string, readOnly bool) (*imap.MailboxStatus, error) {
+	c.selected = name
+	c.virtual = c.report.isCreated(name)
+	if c.virtual {
+		return &imap.MailboxStatus{Name: name}, nil
+	}
+	return c.IMAPClient.Select(name, readOnly)
+}
+
+func (c *dryRunClient) UidCopy(seqset *imap.SeqSet, dest string) (*CopyUID, error) {
+	uids := seqSetUIDs(seqset)
+	c.report.record("copy ", fmt.Sprintf(" messages from %s to %s", c.selected, dest), len(uids))
+	return dryRunCopyUID(uids), nil
+}
+
+func (c *dryRunClient) UidMove(seqSet *imap.SeqSet, mailbox string) (*CopyUID, error) {
+	uids := seqSetUIDs(seqSet)
+	c.report.record("move ", fmt.Sprintf(" messages from %s to %s", c.selected, mailbox), len(uids))
+	c.report.mark(c.report.removed, c.selected, uids)
+	return dryRunCopyUID(uids), nil
+}
+
+func (c *dryRunClient) UidStore(seqSet *imap.SeqSet, item imap.StoreItem, flags []interface{}, ch chan *imap.Message) error {
+	if ch != nil {
+		close(ch)
+	}
+	uids := seqSetUIDs(seqSet)
+	names := make([]string, len(flags))
+	for index, flag := range flags {
+		names[index] = fmt.Sprint(flag)
+	}
+
+	operation, _, _ := imap.ParseFlagsOp(item)
+	verb := map[imap.FlagsOp]string{imap.AddFlags: "add", imap.RemoveFlags: "remove", imap.SetFlags: "set"}[operation]
+	c.report.record(fmt.Sprintf("%s flags %s on ", verb, strings.Join(names, " ")), " messages in "+c.selected, len(uids))
+
+	if slices.Contains(names, imap.DeletedFlag) {
+		switch operation {
+		case imap.AddFlags, imap.SetFlags:
+			c.report.mark(c.report.deleted, c.selected, uids)
+		case imap.RemoveFlags:
+			c.report.unmarkDeleted(c.selected, uids)
+		}
+	}
+	return nil
+}
+
+func (c *dryRunClient) UidFetch(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error {
+	if c.virtual {
+		close(ch)
+		return nil
+	}
+	return c.filterFetch(ch, func(inner chan *imap.Message) error {
+		return c.IMAPClient.UidFetch(seqset, items, inner)
+	})
+}
+
+func (c *dryRunClient) UidFetchChangedSince(seqset *imap.SeqSet, items []imap.FetchItem, modSeq uint64, ch chan *imap.Message) error {
+	if c.virtual {
+		close(ch)
+		return nil
+	}
+	return c.filterFetch(ch, func(inner chan *imap.Message) error {
+		return c.IMAPClient.UidFetchChangedSince(seqset, items, modSeq, inner)
+	})
+}
+
+func (c *dryRunClient) UidSearch(criteria *imap.SearchCriteria) ([]uint32, error) {
+	if c.virtual {
+		return nil, nil
+	}
+	uids, err := c.IMAPClient.UidSearch(criteria)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(uids, func(uid uint32) bool {
+		return c.report.isRemoved(c.selected, uid)
+	}), nil
+}
+
+// filterFetch runs fetch into an intermediate channel and passes on to ch
+// only the messages the run has not removed from the selected folder.
+func (c *dryRunClient) filterFetch(ch chan *imap.Message, fetch func(chan *imap.Message) error) error {
+	inner := make(chan *imap.Message, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(ch)
+		for message := range inner {
+			if !c.report.isRemoved(c.selected, message.Uid) {
+				ch <- message
+			}
+		}
+	}()
+	err := fetch(inner)
+	<-done
+	return err
+}
+
+// dryRunCopyUID pretends the server kept each message's UID in the
+// destination, so later steps of a pipeline can follow them. The UIDs are
+// only ever used in commands the dry run does not send.
+func dryRunCopyUID(uids []uint32) *CopyUID {
+	return &CopyUID{Source: uids, Dest: uids}
+}
+
+// seqSetUIDs lists the UIDs of a set that has no "*" ranges.
+func seqSetUIDs(seqSet *imap.SeqSet) []uint32 {
+	var uids []uint32
+	for _, seq := range seqSet.Set {
+		if seq.Start == 0 || seq.Stop == 0 {
+			continue
+		}
+		start, stop := min(seq.Start, seq.Stop), max(seq.Start, seq.Stop)
+		for uid := start; uid <= stop; uid++ {
+			uids = append(uids, uid)
+		}
+	}
+	return uids
+}
@@ -0,0 +1,114 @@
+package imaputils
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDryRunPipelineSendsNothing(t *testing.T) {
+	client := &MockIMAPClientMove{}
+	inner := &MockIMAPDialerMove{}
+	inner.On("Dial", mock.Anything).Return(client, nil)
+	client.On("Login", mock.Anything, mock.Anything).Return(nil)
+	client.On("Logout").Return(nil)
+	client.On("Select", "INBOX", false).Return(&imap.MailboxStatus{Name: "INBOX"}, nil)
+	client.On("Capability").Return(map[string]bool{"MOVE": true}, nil)
+	client.On("List", "", "", mock.Anything).Return(
+		func(ch chan *imap.MailboxInfo) { ch <- &imap.MailboxInfo{Delimiter: "/"} }, nil)
+	client.On("List", "", mock.Anything, mock.Anything).Return(nil, nil)
+	// The server still has the messages; the dry run hides them from the
+	// verification fetch, as a real move would have.
+	client.On("UidFetch", mock.Anything, []imap.FetchItem{imap.FetchUid}, mock.Anything).Return(
+		func(ch chan *imap.Message) {
+			ch <- &imap.Message{Uid: 1}
+			ch <- &imap.Message{Uid: 2}
+		}, nil).Once()
+
+	report := NewDryRunReport()
+	dialer := NewDryRunDialer(inner, report)
+	actions := []Action{
+		{Kind: ActionFlags, Flags: FlagChange{Add: []string{imap.SeenFlag}}},
+		{Kind: ActionMove, Folder: "Archive/2024"},
+		{Kind: ActionFlags, Flags: FlagChange{Add: []string{imap.FlaggedFlag}}},
+	}
+	err := RunActions(dialer, Account{}, "INBOX", []*imap.Message{{Uid: 1}, {Uid: 2}}, actions, nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"create folder Archive",
+		"create folder Archive/2024",
+		`add flags \Seen on 2 messages in INBOX`,
+		"move 2 messages from INBOX to Archive/2024",
+		`add flags \Flagged on 2 messages in Archive/2024`,
+	}, report.Lines())
+	client.AssertNotCalled(t, "Create", mock.Anything)
+	client.AssertNotCalled(t, "UidStore", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	client.AssertNotCalled(t, "UidMove", mock.Anything, mock.Anything)
+	client.AssertNotCalled(t, "Select", "Archive/2024", mock.Anything)
+}
+
+func TestDryRunPurgeAndTrash(t *testing.T) {
+	client := &MockIMAPClientMove{}
+	inner := &MockIMAPDialerMove{}
+	inner.On("Dial", mock.Anything).Return(client, nil)
+	client.On("Login", mock.Anything, mock.Anything).Return(nil)
+	client.On("Logout").Return(nil)
+	client.On("Select", "INBOX", false).Return(&imap.MailboxStatus{Name: "INBOX"}, nil)
+	client.On("UidSearch", mock.Anything).Return([]uint32{3, 4, 5}, nil)
+	client.On("Capability").Return(map[string]bool{"UIDPLUS": true}, nil)
+
+	report := NewDryRunReport()
+	dialer := NewDryRunDialer(inner, report)
+	_, err := DeleteMessages(dialer, Account{Purge: true}, []*imap.Message{{Uid: 3}, {Uid: 4}}, "INBOX")
+	assert.NoError(t, err)
+	noteTrashFolder(dialer, "Trash")
+
+	// Reads made later in the run no longer see the purged messages.
+	imapClient, err := connectToMailbox(dialer, Account{}, "INBOX", false)
+	assert.NoError(t, err)
+	uids, err := imapClient.UidSearch(imap.NewSearchCriteria())
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{5}, uids)
+	assert.Nil(t, imapClient.GetClient(), "the underlying client is not handed out")
+
+	assert.Equal(t, []string{
+		`add flags \Deleted on 2 messages in INBOX`,
+		"expunge 2 messages from INBOX",
+		"trash folder: Trash",
+	}, report.Lines())
+	client.AssertNotCalled(t, "Expunge", mock.Anything)
+	client.AssertNotCalled(t, "UidStore", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDryRunFolderChanges(t *testing.T) {
+	client := &MockIMAPClientMove{}
+	inner := &MockIMAPDialerMove{}
+	folderServer(client, inner, map[string][]*imap.MailboxInfo{
+		"Old":         {{Name: "Old"}},
+		"Archive.Old": nil,
+		"Archive":     nil,
+	})
+	client.On("Lsub", "", "*", mock.Anything).Return(func(ch chan *imap.MailboxInfo) {
+		ch <- &imap.MailboxInfo{Name: "Old"}
+	}, nil)
+
+	report := NewDryRunReport()
+	dialer := NewDryRunDialer(inner, report)
+	assert.NoError(t, RenameFolder(dialer, Account{}, "Old", "Archive/Old"))
+	_, err := DeleteFolders(dialer, Account{}, []FolderRemoval{{Name: "Empty"}})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"create folder Archive",
+		"rename folder Old to Archive.Old",
+		"subscribe to folder Archive.Old",
+		"unsubscribe from folder Old",
+		"delete folder Empty",
+	}, report.Lines())
+	client.AssertNotCalled(t, "Rename", mock.Anything, mock.Anything)
+	client.AssertNotCalled(t, "Subscribe", mock.Anything)
+	client.AssertNotCalled(t, "Delete", mock.Anything)
+}
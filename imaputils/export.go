@@ -0,0 +1,83 @@
+package imaputils
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/emersion/go-imap"
+	"github.com/wryfi/shemail/mailstore"
+)
+
+// RawChunkSize is the number of messages fetched per UID FETCH when
+// downloading complete messages, which are far larger than envelopes.
+const RawChunkSize = 50
+
+// rawBodySection is BODY.PEEK[]: the whole message, fetched without setting
+// \Seen.
+var rawBodySection = &imap.BodySectionName{Peek: true}
+
+// rawFetchItems are the items fetched to download complete messages with
+// everything StoreMessage needs.
+func rawFetchItems() []imap.FetchItem {
+	return []imap.FetchItem{imap.FetchUid, imap.FetchFlags, imap.FetchInternalDate, imap.FetchEnvelope, rawBodySection.FetchItem()}
+}
+
+// StreamRawMessages streams the complete messages uids of mailbox, with their
+// flags, envelope and INTERNALDATE, chunkSize at a time. The mailbox is
+// selected read-only and bodies are fetched with BODY.PEEK[], so downloading
+// never marks messages as read. UIDs that no longer exist are silently absent
+// from the stream.
+func StreamRawMessages(dialer IMAPDialer, account Account, mailbox string, uids []uint32, chunkSize int) MessageStream {
+	return func(yield func(*imap.Message, error) bool) {
+		if len(uids) == 0 {
+			return
+		}
+
+		imapClient, err := connectToMailbox(dialer, account, mailbox, true)
+		if err != nil {
+			yield(nil, fmt.Errorf("failed to connect to mailbox: %w", err))
+			return
+		}
+		defer imapClient.Logout()
+
+		streamUIDItems(imapClient, "downloading "+mailbox, uids, rawFetchItems(), chunkSize, yield)
+	}
+}
+
+// StoreMessage converts a message from StreamRawMessages for mailstore.
+func StoreMessage(message *imap.Message) (mailstore.Message, error) {
+	literal := message.GetBody(rawBodySection)
+	if literal == nil {
+		return mailstore.Message{}, fmt.Errorf("server returned no body for message %d", message.Uid)
+	}
+	body, err := io.ReadAll(literal)
+	if err != nil {
+		return mailstore.Message{}, fmt.Errorf("failed to read message %d: %w", message.Uid, err)
+	}
+	stored := mailstore.Message{Body: body, Date: message.InternalDate, Flags: message.Flags}
+	if message.Envelope != nil && len(message.Envelope.From) > 0 {
+		stored.Sender = message.Envelope.From[0].Address()
+	}
+	return stored, nil
+}
+
+// ExportMessages downloads the messages uids of folder and writes them to
+// writer, returning how many were written. Messages expunged in the meantime
+// are skipped, so the count can be lower than len(uids).
+func ExportMessages(dialer IMAPDialer, account Account, folder string, uids []uint32, writer mailstore.Writer) (int, error) {
+	count := 0
+	for message, err := range StreamRawMessages(dialer, account, folder, uids, RawChunkSize) {
+		if err != nil {
+			return count, fmt.Errorf("failed to download messages from %s: %w", folder, err)
+		}
+		stored, err := StoreMessage(message)
+		if err != nil {
+			return count, err
+		}
+		if _, err := writer.Write(stored); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,69 @@
+package imaputils
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wryfi/shemail/mailstore"
+)
+
+// memoryWriter is a mailstore.Writer that keeps what it is given.
+type memoryWriter struct {
+	messages []mailstore.Message
+}
+
+func (writer *memoryWriter) Write(message mailstore.Message) (string, error) {
+	writer.messages = append(writer.messages, message)
+	return "", nil
+}
+
+func (writer *memoryWriter) Close() error { return nil }
+
+func TestExportMessages(t *testing.T) {
+	date := time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC)
+	bodySection, _ := imap.ParseBodySectionName("BODY[]")
+	rawMessage := func(uid uint32, body string) *imap.Message {
+		return &imap.Message{
+			Uid:          uid,
+			InternalDate: date,
+			Flags:        []string{imap.SeenFlag},
+			Envelope:     &imap.Envelope{From: []*imap.Address{{MailboxName: "alice", HostName: "example.com"}}},
+			Body:         map[*imap.BodySectionName]imap.Literal{bodySection: bytes.NewBufferString(body)},
+		}
+	}
+
+	client := &MockIMAPClientMove{}
+	dialer := &MockIMAPDialerMove{}
+	dialer.On("Dial", mock.Anything).Return(client, nil)
+	client.On("Login", mock.Anything, mock.Anything).Return(nil)
+	client.On("Logout").Return(nil)
+	client.On("Select", "INBOX", true).Return(&imap.MailboxStatus{}, nil)
+	peeksWholeMessage := mock.MatchedBy(func(items []imap.FetchItem) bool {
+		return slices.Contains(items, imap.FetchItem("BODY.PEEK[]")) && slices.Contains(items, imap.FetchInternalDate)
+	})
+	// Message 3 was expunged before it could be downloaded.
+	client.On("UidFetch", mock.Anything, peeksWholeMessage, mock.Anything).Return(
+		func(ch chan *imap.Message) {
+			ch <- rawMessage(1, "Subject: one\r\n\r\n")
+			ch <- rawMessage(2, "Subject: two\r\n\r\n")
+		}, nil)
+
+	writer := &memoryWriter{}
+	count, err := ExportMessages(dialer, Account{}, "INBOX", []uint32{1, 2, 3}, writer)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	if assert.Len(t, writer.messages, 2) {
+		assert.Equal(t, mailstore.Message{
+			Body:   []byte("Subject: one\r\n\r\n"),
+			Date:   date,
+			Flags:  []string{imap.SeenFlag},
+			Sender: "alice@example.com",
+		}, writer.messages[0])
+	}
+	client.AssertNotCalled(t, "Select", "INBOX", false)
+}
@@ -0,0 +1,105 @@
+package imaputils
+
+import (
+	"fmt"
+
+	"github.com/emersion/go-imap"
+)
+
+// ExpungePlan describes what permanently removing messages from a folder will
+// really remove. With UIDPLUS (RFC 4315) it is exactly the chosen messages;
+// without it, the only way to remove them is a plain EXPUNGE, which also
+// removes every other message already flagged \Deleted in the folder, e.g. by
+// another client with deletions pending.
+type ExpungePlan struct {
+	Folder string
+	// Targeted is true when the server supports UID EXPUNGE, so nothing but
+	// the chosen messages is removed.
+	Targeted bool
+	// Others are the UIDs of the other messages flagged \Deleted that a
+	// plain EXPUNGE would remove as well. It is always empty when Targeted.
+	Others []uint32
+}
+
+// Total returns how many messages are removed when count messages are
+// expunged according to the plan.
+func (plan ExpungePlan) Total(count int) int {
+	return count + len(plan.Others)
+}
+
+// Check refuses, naming operation (e.g. "purge"), an expunge that would
+// remove other messages, unless the account allows it.
+func (plan ExpungePlan) Check(account Account, operation string) error {
+	if len(plan.Others) == 0 || account.AllowUnsafeExpunge {
+		return nil
+	}
+	return fmt.Errorf("refusing to %s: the server does not support UIDPLUS, so this needs a plain EXPUNGE, "+
+		"which would also permanently remove %d other messages already flagged \\Deleted in %s "+
+		"(allow it with --allow-unsafe-expunge)", operation, len(plan.Others), plan.Folder)
+}
+
+// expunge removes the messages in seqSet, which must already be flagged
+// \Deleted, from the selected folder as planned.
+func (plan ExpungePlan) expunge(imapClient IMAPClient, seqSet *imap.SeqSet) error {
+	if plan.Targeted {
+		return imapClient.UidExpunge(seqSet)
+	}
+	if len(plan.Others) > 0 {
+		log.Warn().Msgf("this EXPUNGE will also PERMANENTLY REMOVE %d other messages already flagged \\Deleted in %s", len(plan.Others), plan.Folder)
+	}
+	return imapClient.Expunge(nil)
+}
+
+// PlanExpunge works out what permanently deleting messages from folder would
+// remove, so that callers can confirm the true number beforehand. messages
+// may be nil when they are not in folder yet (e.g. they are to be moved there
+// first), in which case every message flagged \Deleted counts as another.
+func PlanExpunge(dialer IMAPDialer, account Account, folder string, messages []*imap.Message) (ExpungePlan, error) {
+	imapClient, err := connectToMailbox(dialer, account, folder, true)
+	if err != nil {
+		return ExpungePlan{}, fmt.Errorf("failed to connect to mailbox: %w", err)
+	}
+	defer imapClient.Logout()
+	return planExpunge(imapClient, folder, messages)
+}
+
+// planExpunge is PlanExpunge on a client with folder selected.
+func planExpunge(imapClient IMAPClient, folder string, messages []*imap.Message) (ExpungePlan, error) {
+	plan := ExpungePlan{Folder: folder}
+	caps, err := imapClient.Capability()
+	if err != nil {
+		return plan, fmt.Errorf("failed to get capabilities: %w", err)
+	}
+	if caps["UIDPLUS"] {
+		plan.Targeted = true
+		return plan, nil
+	}
+	others, err := otherDeletedMessages(imapClient, messages)
+	if err != nil {
+		return plan, fmt.Errorf("failed to check %s for messages flagged \\Deleted: %w", folder, err)
+	}
+	plan.Others = others
+	return plan, nil
+}
+
+// otherDeletedMessages returns the UIDs of the messages in the selected
+// folder that are flagged \Deleted but are not among messages.
+func otherDeletedMessages(imapClient IMAPClient, messages []*imap.Message) ([]uint32, error) {
+	criteria := imap.NewSearchCriteria()
+	criteria.WithFlags = []string{imap.DeletedFlag}
+	deleted, err := imapClient.UidSearch(criteria)
+	if err != nil {
+		return nil, err
+	}
+	ours := make(map[uint32]bool, len(messages))
+	for _, message := range messages {
+		ours[message.Uid] = true
+	}
+	var others []uint32
+	for _, uid := range deleted {
+		if !ours[uid] {
+			others = append(others, uid)
+		}
+	}
+	return others, nil
+}
@@ -0,0 +1,75 @@
+package imaputils
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPurgeMessagesExpunge(t *testing.T) {
+	tests := []struct {
+		name          string
+		caps          map[string]bool
+		alreadyDelete []uint32
+		allowUnsafe   bool
+		wantExpunge   string // "uid", "plain" or "" when refused
+		wantError     string
+	}{
+		{"UIDPLUS expunges only the purged messages", map[string]bool{"UIDPLUS": true}, nil, false, "uid", ""},
+		{"plain EXPUNGE when nothing else is flagged", map[string]bool{}, []uint32{1}, false, "plain", ""},
+		{"refuses to expunge other deleted messages", map[string]bool{}, []uint32{1, 8, 9}, false, "", "2 other messages already flagged \\Deleted in INBOX"},
+		{"expunges other deleted messages when allowed", map[string]bool{}, []uint32{9}, true, "plain", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &MockIMAPClientMove{}
+			dialer := &MockIMAPDialerMove{}
+			dialer.On("Dial", mock.Anything).Return(client, nil)
+			client.On("Login", mock.Anything, mock.Anything).Return(nil)
+			client.On("Logout").Return(nil)
+			client.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+			client.On("Capability").Return(tt.caps, nil)
+			client.On("UidSearch", mock.Anything).Return(tt.alreadyDelete, nil)
+			client.On("UidStore", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+			client.On("UidExpunge", mock.Anything).Return(nil)
+			client.On("Expunge", (chan uint32)(nil)).Return(nil)
+
+			account := Account{Purge: true, AllowUnsafeExpunge: tt.allowUnsafe}
+			_, err := DeleteMessages(dialer, account, []*imap.Message{{Uid: 1}}, "INBOX")
+			switch tt.wantExpunge {
+			case "uid":
+				assert.NoError(t, err)
+				client.AssertCalled(t, "UidExpunge", mock.Anything)
+				client.AssertNotCalled(t, "Expunge", mock.Anything)
+			case "plain":
+				assert.NoError(t, err)
+				client.AssertCalled(t, "Expunge", (chan uint32)(nil))
+				client.AssertNotCalled(t, "UidExpunge", mock.Anything)
+			default:
+				assert.ErrorContains(t, err, tt.wantError)
+				client.AssertNotCalled(t, "UidStore", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestPlanExpunge(t *testing.T) {
+	client := &MockIMAPClientMove{}
+	dialer := &MockIMAPDialerMove{}
+	dialer.On("Dial", mock.Anything).Return(client, nil)
+	client.On("Login", mock.Anything, mock.Anything).Return(nil)
+	client.On("Logout").Return(nil)
+	client.On("Select", "INBOX", true).Return(&imap.MailboxStatus{}, nil)
+	client.On("Capability").Return(map[string]bool{}, nil)
+	client.On("UidSearch", mock.Anything).Return([]uint32{2, 7, 9}, nil)
+
+	plan, err := PlanExpunge(dialer, Account{}, "INBOX", []*imap.Message{{Uid: 1}, {Uid: 2}})
+	assert.NoError(t, err)
+	assert.False(t, plan.Targeted)
+	assert.Equal(t, []uint32{7, 9}, plan.Others)
+	assert.Equal(t, 4, plan.Total(2))
+	assert.ErrorContains(t, plan.Check(Account{}, "purge"), "refusing to purge")
+	assert.NoError(t, plan.Check(Account{AllowUnsafeExpunge: true}, "purge"))
+}
@@ -3,6 +3,7 @@ package imaputils
 import (
 	"fmt"
 	"github.com/emersion/go-imap"
+	"github.com/wryfi/shemail/progress"
 )
 
 // MessageFields represents the fields to fetch from IMAP messages. Body and
@@ -51,6 +52,9 @@ func FetchMessages(dialer IMAPDialer, account Account, mailbox string, fields Me
 	// Pre-allocate slice with known capacity
 	fetchedMessages := make([]*imap.Message, 0, mbox.Messages)
 
+	task := progress.Start("fetching "+mailbox, "messages", int(mbox.Messages))
+	defer task.Done()
+
 	// Use batch processing for large mailboxes
 	const batchSize = 1000
 	for i := uint32(1); i <= mbox.Messages; i += batchSize {
@@ -70,6 +74,7 @@ func FetchMessages(dialer IMAPDialer, account Account, mailbox string, fields Me
 
 		for msg := range messages {
 			fetchedMessages = append(fetchedMessages, msg)
+			task.Add(1)
 		}
 
 		if err := <-done; err != nil {
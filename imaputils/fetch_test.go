@@ -7,6 +7,7 @@ import (
 	"github.com/emersion/go-imap/client"
 	"reflect"
 	"testing"
+	"time"
 )
 
 type TestIMAPClient struct {
@@ -16,6 +17,13 @@ type TestIMAPClient struct {
 	client       *client.Client
 }
 
+func (m *TestIMAPClient) Append(mbox string, flags []string, date time.Time, msg imap.Literal) error {
+	if m.shouldError {
+		return errors.New("mock append error")
+	}
+	return nil
+}
+
 func (m *TestIMAPClient) Capability() (map[string]bool, error) {
 	if m.shouldError {
 		return nil, errors.New("mock capability error")
@@ -30,6 +38,10 @@ func (m *TestIMAPClient) Create(name string) error {
 	return nil
 }
 
+func (m *TestIMAPClient) Delete(name string) error {
+	return nil
+}
+
 func (m *TestIMAPClient) Expunge(ch chan uint32) error {
 	if m.shouldError {
 		return errors.New("mock expunge error")
@@ -60,6 +72,22 @@ func (m *TestIMAPClient) GetClient() *client.Client {
 	return m.client
 }
 
+func (m *TestIMAPClient) GetQuota(root string) (Quota, error) {
+	return Quota{}, ErrQuotaUnsupported
+}
+
+func (m *TestIMAPClient) GetQuotaRoot(mailbox string) ([]string, []Quota, error) {
+	return nil, nil, ErrQuotaUnsupported
+}
+
+func (m *TestIMAPClient) Idle(stop <-chan struct{}, opts *client.IdleOptions) error {
+	return nil
+}
+
+func (m *TestIMAPClient) Updates() <-chan client.Update {
+	return nil
+}
+
 func (m *TestIMAPClient) List(ref string, name string, ch chan *imap.MailboxInfo) error {
 	if m.shouldError {
 		return errors.New("mock list error")
@@ -69,6 +97,11 @@ func (m *TestIMAPClient) List(ref string, name string, ch chan *imap.MailboxInfo
 	return nil
 }
 
+func (m *TestIMAPClient) Lsub(ref string, name string, ch chan *imap.MailboxInfo) error {
+	close(ch)
+	return nil
+}
+
 func (m *TestIMAPClient) Login(username string, password string) error {
 	if m.shouldError {
 		return errors.New("mock login error")
@@ -80,6 +113,10 @@ func (m *TestIMAPClient) Logout() error {
 	return nil
 }
 
+func (m *TestIMAPClient) Rename(existingName, newName string) error {
+	return nil
+}
+
 func (m *TestIMAPClient) Select(name string, readOnly bool) (*imap.MailboxStatus, error) {
 	if m.shouldError {
 		return nil, errors.New("mock select error")
@@ -94,9 +131,20 @@ func (m *TestIMAPClient) Status(name string, items []imap.StatusItem) (*imap.Mai
 	return &imap.MailboxStatus{Messages: m.messages}, nil
 }
 
-func (m *TestIMAPClient) UidCopy(seqset *imap.SeqSet, dest string) error {
+func (m *TestIMAPClient) Subscribe(name string) error {
+	return nil
+}
+
+func (m *TestIMAPClient) UidCopy(seqset *imap.SeqSet, dest string) (*CopyUID, error) {
+	if m.shouldError {
+		return nil, errors.New("mock uid copy error")
+	}
+	return nil, nil
+}
+
+func (m *TestIMAPClient) UidExpunge(seqSet *imap.SeqSet) error {
 	if m.shouldError {
-		return errors.New("mock uid copy error")
+		return errors.New("mock uid expunge error")
 	}
 	return nil
 }
@@ -112,11 +160,16 @@ func (m *TestIMAPClient) UidFetch(seqset *imap.SeqSet, items []imap.FetchItem, c
 	return nil
 }
 
-func (m *TestIMAPClient) UidMove(seqSet *imap.SeqSet, mailbox string) error {
+func (m *TestIMAPClient) UidFetchChangedSince(seqset *imap.SeqSet, items []imap.FetchItem, modSeq uint64, ch chan *imap.Message) error {
+	close(ch)
+	return nil
+}
+
+func (m *TestIMAPClient) UidMove(seqSet *imap.SeqSet, mailbox string) (*CopyUID, error) {
 	if m.shouldError {
-		return errors.New("mock uid move error")
+		return nil, errors.New("mock uid move error")
 	}
-	return nil
+	return nil, nil
 }
 
 func (m *TestIMAPClient) UidSearch(criteria *imap.SearchCriteria) ([]uint32, error) {
@@ -134,6 +187,10 @@ func (m *TestIMAPClient) UidStore(seqSet *imap.SeqSet, item imap.StoreItem, flag
 	return nil
 }
 
+func (m *TestIMAPClient) Unsubscribe(name string) error {
+	return nil
+}
+
 type MockDialer struct {
 	client *TestIMAPClient
 	err    error
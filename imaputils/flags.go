@@ -2,33 +2,157 @@ package imaputils
 
 import (
 	"fmt"
+	"slices"
+	"strings"
+
 	"github.com/emersion/go-imap"
 )
 
-// MarkMessages adds or removes the \Seen flag on the given messages in the
-// specified folder: seen=true marks them read, seen=false marks them unread.
-func MarkMessages(dialer IMAPDialer, account Account, messages []*imap.Message, folder string, seen bool) error {
-	if len(messages) == 0 {
+// FlagChange is a set of flags to add to and remove from messages. Flags are
+// either system flags (e.g. imap.FlaggedFlag) or keywords, which are arbitrary
+// atoms such as "review" or "$Label1".
+type FlagChange struct {
+	Add    []string
+	Remove []string
+}
+
+// IsEmpty reports whether the change adds and removes nothing.
+func (change FlagChange) IsEmpty() bool {
+	return len(change.Add) == 0 && len(change.Remove) == 0
+}
+
+// flagLabels names the common system flag changes the way the find command's
+// options do.
+var flagLabels = map[imap.FlagsOp]map[string]string{
+	imap.AddFlags: {
+		imap.SeenFlag:     "mark as read",
+		imap.FlaggedFlag:  "flag",
+		imap.AnsweredFlag: "mark as answered",
+	},
+	imap.RemoveFlags: {
+		imap.SeenFlag:    "mark as unread",
+		imap.FlaggedFlag: "unflag",
+	},
+}
+
+// String describes the change, e.g. "mark as read, add keyword review".
+func (change FlagChange) String() string {
+	var labels []string
+	describe := func(operation imap.FlagsOp, verb string, flags []string) {
+		for _, flag := range flags {
+			switch {
+			case flagLabels[operation][flag] != "":
+				labels = append(labels, flagLabels[operation][flag])
+			case strings.HasPrefix(flag, "\\"):
+				labels = append(labels, verb+" flag "+flag)
+			default:
+				labels = append(labels, verb+" keyword "+flag)
+			}
+		}
+	}
+	describe(imap.AddFlags, "add", change.Add)
+	describe(imap.RemoveFlags, "remove", change.Remove)
+	return strings.Join(labels, ", ")
+}
+
+// ValidateKeyword checks that keyword can be sent as an IMAP flag keyword: a
+// non-empty atom that is not a system flag.
+func ValidateKeyword(keyword string) error {
+	if keyword == "" {
+		return fmt.Errorf("keyword must not be empty")
+	}
+	if strings.HasPrefix(keyword, "\\") {
+		return fmt.Errorf("keyword %q must not start with a backslash; system flags have their own options", keyword)
+	}
+	for _, char := range keyword {
+		if char <= ' ' || char >= 0x7f || strings.ContainsRune(`(){%*"\]`, char) {
+			return fmt.Errorf("keyword %q contains %q, which is not allowed in an IMAP keyword", keyword, char)
+		}
+	}
+	return nil
+}
+
+// checkPermanentFlags refuses flags that the mailbox will not store
+// permanently, according to the PERMANENTFLAGS reported on SELECT: a keyword
+// must be listed or the mailbox must allow new keywords (\*), and a system
+// flag must be listed. Servers that report no PERMANENTFLAGS at all allow
+// every flag.
+func checkPermanentFlags(status *imap.MailboxStatus, flags []string) error {
+	if status == nil || status.PermanentFlags == nil {
 		return nil
 	}
+	permanent := func(flag string) bool {
+		return slices.ContainsFunc(status.PermanentFlags, func(candidate string) bool {
+			return strings.EqualFold(candidate, flag)
+		})
+	}
+	for _, flag := range flags {
+		if permanent(flag) {
+			continue
+		}
+		if !strings.HasPrefix(flag, "\\") && permanent(imap.TryCreateFlag) {
+			continue
+		}
+		return fmt.Errorf("%s does not permanently store the flag %s (permanent flags: %s)",
+			status.Name, flag, strings.Join(status.PermanentFlags, " "))
+	}
+	return nil
+}
 
-	imapClient, err := connectToMailbox(dialer, account, folder, false)
+// StoreFlags applies change to the given messages in folder: one UID STORE
+// adding change.Add and one removing change.Remove. Before storing anything it
+// checks that the folder will keep every added flag, so a run never half
+// succeeds or silently loses a keyword at the end of the session.
+func StoreFlags(dialer IMAPDialer, account Account, messages []*imap.Message, folder string, change FlagChange) error {
+	if len(messages) == 0 || change.IsEmpty() {
+		return nil
+	}
+
+	imapClient, err := getImapClient(dialer, account)
 	if err != nil {
 		return fmt.Errorf("failed to connect to mailbox: %w", err)
 	}
 	defer imapClient.Logout()
 
-	var operation imap.FlagsOp = imap.RemoveFlags
-	if seen {
-		operation = imap.AddFlags
+	status, err := imapClient.Select(folder, false)
+	if err != nil {
+		return fmt.Errorf("failed to select folder %s: %w", folder, err)
+	}
+	if err := checkPermanentFlags(status, change.Add); err != nil {
+		return err
 	}
-	item := imap.FormatFlagsOp(operation, true)
-	flags := []interface{}{imap.SeenFlag}
 
 	seqSet := createSeqSet(messages)
-	if err := imapClient.UidStore(seqSet, item, flags, nil); err != nil {
-		return fmt.Errorf("failed to update \\Seen flag: %w", err)
+	operations := []struct {
+		operation imap.FlagsOp
+		flags     []string
+	}{
+		{imap.AddFlags, change.Add},
+		{imap.RemoveFlags, change.Remove},
+	}
+	for _, op := range operations {
+		if len(op.flags) == 0 {
+			continue
+		}
+		flags := make([]interface{}, len(op.flags))
+		for index, flag := range op.flags {
+			flags[index] = flag
+		}
+		item := imap.FormatFlagsOp(op.operation, true)
+		if err := imapClient.UidStore(seqSet, item, flags, nil); err != nil {
+			return fmt.Errorf("failed to update flags %s: %w", strings.Join(op.flags, " "), err)
+		}
 	}
 
 	return nil
 }
+
+// MarkMessages adds or removes the \Seen flag on the given messages in the
+// specified folder: seen=true marks them read, seen=false marks them unread.
+func MarkMessages(dialer IMAPDialer, account Account, messages []*imap.Message, folder string, seen bool) error {
+	change := FlagChange{Remove: []string{imap.SeenFlag}}
+	if seen {
+		change = FlagChange{Add: []string{imap.SeenFlag}}
+	}
+	return StoreFlags(dialer, account, messages, folder, change)
+}
@@ -61,3 +61,76 @@ func TestMarkMessages(t *testing.T) {
 		})
 	}
 }
+
+func TestStoreFlags(t *testing.T) {
+	client := &MockIMAPClientMove{}
+	dialer := &MockIMAPDialerMove{}
+	dialer.On("Dial", mock.Anything).Return(client, nil)
+	client.On("Login", mock.Anything, mock.Anything).Return(nil)
+	client.On("Select", "INBOX", false).Return(&imap.MailboxStatus{
+		Name:           "INBOX",
+		PermanentFlags: []string{imap.FlaggedFlag, imap.SeenFlag, imap.TryCreateFlag},
+	}, nil)
+	client.On("UidStore", mock.Anything, imap.FormatFlagsOp(imap.AddFlags, true),
+		[]interface{}{imap.FlaggedFlag, "review"}, (chan *imap.Message)(nil)).Return(nil, nil).Once()
+	client.On("UidStore", mock.Anything, imap.FormatFlagsOp(imap.RemoveFlags, true),
+		[]interface{}{imap.SeenFlag}, (chan *imap.Message)(nil)).Return(nil, nil).Once()
+	client.On("Logout").Return(nil)
+
+	change := FlagChange{Add: []string{imap.FlaggedFlag, "review"}, Remove: []string{imap.SeenFlag}}
+	err := StoreFlags(dialer, Account{}, []*imap.Message{{Uid: 1}}, "INBOX", change)
+	assert.NoError(t, err)
+	client.AssertExpectations(t)
+}
+
+func TestStoreFlagsRefusesNonPermanentKeyword(t *testing.T) {
+	client := &MockIMAPClientMove{}
+	dialer := &MockIMAPDialerMove{}
+	dialer.On("Dial", mock.Anything).Return(client, nil)
+	client.On("Login", mock.Anything, mock.Anything).Return(nil)
+	client.On("Select", "INBOX", false).Return(&imap.MailboxStatus{
+		Name:           "INBOX",
+		PermanentFlags: []string{imap.FlaggedFlag, imap.SeenFlag},
+	}, nil)
+	client.On("Logout").Return(nil)
+
+	change := FlagChange{Add: []string{imap.FlaggedFlag, "review"}}
+	err := StoreFlags(dialer, Account{}, []*imap.Message{{Uid: 1}}, "INBOX", change)
+	assert.ErrorContains(t, err, "does not permanently store the flag review")
+	client.AssertNotCalled(t, "UidStore", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCheckPermanentFlags(t *testing.T) {
+	tests := []struct {
+		name      string
+		permanent []string
+		flags     []string
+		wantErr   bool
+	}{
+		{"not reported allows anything", nil, []string{"review", imap.DraftFlag}, false},
+		{"listed keyword, any case", []string{"Review"}, []string{"review"}, false},
+		{"new keywords allowed", []string{imap.TryCreateFlag}, []string{"review"}, false},
+		{"unlisted keyword", []string{imap.SeenFlag}, []string{"review"}, true},
+		{"system flags must be listed", []string{imap.TryCreateFlag}, []string{imap.AnsweredFlag}, true},
+		{"nothing permanent", []string{}, []string{imap.SeenFlag}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPermanentFlags(&imap.MailboxStatus{Name: "INBOX", PermanentFlags: tt.permanent}, tt.flags)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateKeyword(t *testing.T) {
+	assert.NoError(t, ValidateKeyword("review"))
+	assert.NoError(t, ValidateKeyword("$Label1"))
+	assert.Error(t, ValidateKeyword(""))
+	assert.Error(t, ValidateKeyword("\\Flagged"), "system flags are not keywords")
+	assert.Error(t, ValidateKeyword("to review"), "no spaces")
+	assert.Error(t, ValidateKeyword("a(b"))
+}
@@ -3,15 +3,19 @@ package imaputils
 import (
 	"fmt"
 	"github.com/emersion/go-imap"
+	"github.com/wryfi/shemail/progress"
+	"slices"
 	"strings"
 	"time"
 )
 
 // FolderStatus describes a mailbox and its message counts.
 type FolderStatus struct {
-	Name     string
-	Messages uint32
-	Unseen   uint32
+	Name string
+	// Delimiter is the server's hierarchy delimiter within Name.
+	Delimiter string
+	Messages  uint32
+	Unseen    uint32
 	// Selectable is false for \Noselect container folders (or folders STATUS
 	// failed on), for which message counts are not available.
 	Selectable bool
@@ -19,6 +23,13 @@ type FolderStatus struct {
 	// the folder (zero when empty or unavailable).
 	Oldest time.Time
 	Newest time.Time
+	// SpecialUse is the use the folder is set aside for, if any.
+	SpecialUse SpecialUse
+	// Size is the total size of the messages in bytes, when requested.
+	Size uint64
+	// Subfolders is the number of folders below this one whose counts, size
+	// and dates RollUpFolders included in its own.
+	Subfolders int
 }
 
 // FolderMessageCount returns the number of messages in the given folder via
@@ -71,8 +82,11 @@ func ListFolders(dialer IMAPDialer, account Account) ([]string, error) {
 // failing the whole listing.
 // When withDates is true, each folder's message date range (Oldest/Newest) is
 // computed by scanning every message's internal date — accurate but slower, so
-// it is opt-in. When false, only the message and unread counts are populated.
-func ListFoldersWithStatus(dialer IMAPDialer, account Account, withDates bool) ([]FolderStatus, error) {
+// it is opt-in. When withSizes is true, each folder's Size comes from STATUS
+// SIZE (RFC 8438) if the server advertises it, and otherwise from summing the
+// RFC822.SIZE of every message in the same scan. When both are false, only
+// the message and unread counts are populated.
+func ListFoldersWithStatus(dialer IMAPDialer, account Account, withDates, withSizes bool) ([]FolderStatus, error) {
 	imapClient, err := getImapClient(dialer, account)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize imap client: %w", err)
@@ -94,10 +108,31 @@ func ListFoldersWithStatus(dialer IMAPDialer, account Account, withDates bool) (
 		return nil, fmt.Errorf("failed to list folders: %w", err)
 	}
 
+	uses, settingErrs := resolveSpecialUse(infos, account)
+	if err := joinSettingErrors(settingErrs); err != nil {
+		log.Warn().Msgf("%v", err)
+	}
+	folderUses := FoldersByUse(uses)
+
 	statusItems := []imap.StatusItem{imap.StatusMessages, imap.StatusUnseen}
+	var scanItems []imap.FetchItem
+	if withDates {
+		scanItems = append(scanItems, imap.FetchInternalDate)
+	}
+	if withSizes {
+		capabilities, err := imapClient.Capability()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get server capabilities: %w", err)
+		}
+		if capabilities[statusSizeCapability] {
+			statusItems = append(statusItems, statusSize)
+		} else {
+			scanItems = append(scanItems, imap.FetchRFC822Size)
+		}
+	}
 	folders := make([]FolderStatus, 0, len(infos))
 	for _, info := range infos {
-		folder := FolderStatus{Name: info.Name}
+		folder := FolderStatus{Name: info.Name, Delimiter: info.Delimiter, SpecialUse: folderUses[info.Name]}
 
 		if hasAttribute(info.Attributes, imap.NoSelectAttr) {
 			folders = append(folders, folder)
@@ -114,14 +149,22 @@ func ListFoldersWithStatus(dialer IMAPDialer, account Account, withDates bool) (
 		folder.Selectable = true
 		folder.Messages = status.Messages
 		folder.Unseen = status.Unseen
+		if size, ok := status.Items[statusSize]; ok {
+			if folder.Size, err = parseNumber64(size); err != nil {
+				log.Debug().Msgf("invalid size for folder %q: %v", info.Name, err)
+			}
+		}
 
-		if withDates && status.Messages > 0 {
-			oldest, newest, err := folderDateRange(imapClient, info.Name)
+		if len(scanItems) > 0 && status.Messages > 0 {
+			scan, err := scanFolder(imapClient, info.Name, scanItems)
 			if err != nil {
-				log.Debug().Msgf("failed to get date range for folder %q: %v", info.Name, err)
+				log.Debug().Msgf("failed to scan folder %q: %v", info.Name, err)
 			} else {
-				folder.Oldest = oldest
-				folder.Newest = newest
+				folder.Oldest = scan.Oldest
+				folder.Newest = scan.Newest
+				if !slices.Contains(statusItems, statusSize) {
+					folder.Size = scan.Size
+				}
 			}
 		}
 
@@ -131,17 +174,33 @@ func ListFoldersWithStatus(dialer IMAPDialer, account Account, withDates bool) (
 	return folders, nil
 }
 
-// folderDateRange selects the given folder read-only and returns the earliest
-// and latest message delivery (INTERNALDATE) dates. It returns zero times for
-// an empty mailbox. Note this fetches the internal date of every message in the
-// folder, so it is the expensive part of a status listing.
-func folderDateRange(imapClient IMAPClient, folder string) (time.Time, time.Time, error) {
+// statusSizeCapability advertises the SIZE item of STATUS (RFC 8438), the
+// total size of a folder's messages.
+const statusSizeCapability = "STATUS=SIZE"
+
+// statusSize is the STATUS item of RFC 8438, which go-imap leaves in
+// MailboxStatus.Items.
+const statusSize imap.StatusItem = "SIZE"
+
+// folderScan is what scanFolder found.
+type folderScan struct {
+	Oldest, Newest time.Time
+	Size           uint64
+}
+
+// scanFolder selects the given folder read-only and fetches items, the
+// delivery date (INTERNALDATE) and/or size (RFC822.SIZE), of every message,
+// returning the earliest and latest dates and the total size. It returns zero
+// values for an empty mailbox. This is the expensive part of a status
+// listing.
+func scanFolder(imapClient IMAPClient, folder string, items []imap.FetchItem) (folderScan, error) {
+	var scan folderScan
 	mbox, err := imapClient.Select(folder, true)
 	if err != nil {
-		return time.Time{}, time.Time{}, err
+		return scan, err
 	}
 	if mbox.Messages == 0 {
-		return time.Time{}, time.Time{}, nil
+		return scan, nil
 	}
 
 	seqSet := new(imap.SeqSet)
@@ -150,28 +209,59 @@ func folderDateRange(imapClient IMAPClient, folder string) (time.Time, time.Time
 	messages := make(chan *imap.Message, 100)
 	done := make(chan error, 1)
 	go func() {
-		done <- imapClient.Fetch(seqSet, []imap.FetchItem{imap.FetchInternalDate}, messages)
+		done <- imapClient.Fetch(seqSet, items, messages)
 	}()
 
-	var oldest, newest time.Time
+	task := progress.Start("scanning "+folder, "messages", int(mbox.Messages))
+	defer task.Done()
+
 	for message := range messages {
+		task.Add(1)
+		scan.Size += uint64(message.Size)
 		date := message.InternalDate
 		if date.IsZero() {
 			continue
 		}
-		if oldest.IsZero() || date.Before(oldest) {
-			oldest = date
+		if scan.Oldest.IsZero() || date.Before(scan.Oldest) {
+			scan.Oldest = date
 		}
-		if newest.IsZero() || date.After(newest) {
-			newest = date
+		if scan.Newest.IsZero() || date.After(scan.Newest) {
+			scan.Newest = date
 		}
 	}
 
 	if err := <-done; err != nil {
-		return time.Time{}, time.Time{}, err
+		return folderScan{}, err
 	}
 
-	return oldest, newest, nil
+	return scan, nil
+}
+
+// RollUpFolders returns a copy of folders in which each folder's counts and
+// size include those of every folder below it, its date range spans theirs,
+// and Subfolders says how many folders that is. \Noselect container folders
+// thus show the totals of their subtree.
+func RollUpFolders(folders []FolderStatus) []FolderStatus {
+	rolled := slices.Clone(folders)
+	for index := range rolled {
+		parent := &rolled[index]
+		for _, child := range folders {
+			if child.Delimiter == "" || !strings.HasPrefix(child.Name, parent.Name+child.Delimiter) {
+				continue
+			}
+			parent.Subfolders++
+			parent.Messages += child.Messages
+			parent.Unseen += child.Unseen
+			parent.Size += child.Size
+			if !child.Oldest.IsZero() && (parent.Oldest.IsZero() || child.Oldest.Before(parent.Oldest)) {
+				parent.Oldest = child.Oldest
+			}
+			if child.Newest.After(parent.Newest) {
+				parent.Newest = child.Newest
+			}
+		}
+	}
+	return rolled
 }
 
 // hasAttribute reports whether the mailbox attribute list contains target
@@ -6,19 +6,29 @@ import (
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
 	"github.com/stretchr/testify/assert"
+	"slices"
+	"strconv"
 	"testing"
 	"time"
 )
 
 // MockIMAPClientListFolders implements IMAPClient interface
 type MockIMAPClientListFolders struct {
-	listFunc    func(ref string, name string, ch chan *imap.MailboxInfo) error
-	statusFunc  func(name string, items []imap.StatusItem) (*imap.MailboxStatus, error)
-	selectFunc  func(name string, readOnly bool) (*imap.MailboxStatus, error)
-	fetchFunc   func(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error
-	logoutCalls int
+	listFunc       func(ref string, name string, ch chan *imap.MailboxInfo) error
+	statusFunc     func(name string, items []imap.StatusItem) (*imap.MailboxStatus, error)
+	selectFunc     func(name string, readOnly bool) (*imap.MailboxStatus, error)
+	fetchFunc      func(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error
+	capabilityFunc func() (map[string]bool, error)
+	logoutCalls    int
 }
 
+func (m *MockIMAPClientListFolders) Idle(stop <-chan struct{}, opts *client.IdleOptions) error {
+	return nil
+}
+
+func (m *MockIMAPClientListFolders) Updates() <-chan client.Update {
+	return nil
+}
 func (m *MockIMAPClientListFolders) List(ref string, name string, ch chan *imap.MailboxInfo) error {
 	return m.listFunc(ref, name, ch)
 }
@@ -29,9 +39,26 @@ func (m *MockIMAPClientListFolders) Logout() error {
 }
 
 // Implement remaining IMAPClient interface methods
-func (m *MockIMAPClientListFolders) Capability() (map[string]bool, error) { return nil, nil }
-func (m *MockIMAPClientListFolders) Create(name string) error             { return nil }
-func (m *MockIMAPClientListFolders) Expunge(ch chan uint32) error         { return nil }
+func (m *MockIMAPClientListFolders) Append(mbox string, flags []string, date time.Time, msg imap.Literal) error {
+	return nil
+}
+
+func (m *MockIMAPClientListFolders) Capability() (map[string]bool, error) {
+	if m.capabilityFunc != nil {
+		return m.capabilityFunc()
+	}
+	return nil, nil
+}
+func (m *MockIMAPClientListFolders) Create(name string) error     { return nil }
+func (m *MockIMAPClientListFolders) Delete(name string) error     { return nil }
+func (m *MockIMAPClientListFolders) Expunge(ch chan uint32) error { return nil }
+func (m *MockIMAPClientListFolders) Lsub(ref string, name string, ch chan *imap.MailboxInfo) error {
+	close(ch)
+	return nil
+}
+func (m *MockIMAPClientListFolders) Rename(existingName, newName string) error { return nil }
+func (m *MockIMAPClientListFolders) Subscribe(name string) error               { return nil }
+func (m *MockIMAPClientListFolders) Unsubscribe(name string) error             { return nil }
 func (m *MockIMAPClientListFolders) Fetch(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error {
 	if m.fetchFunc != nil {
 		return m.fetchFunc(seqset, items, ch)
@@ -39,7 +66,13 @@ func (m *MockIMAPClientListFolders) Fetch(seqset *imap.SeqSet, items []imap.Fetc
 	close(ch)
 	return nil
 }
-func (m *MockIMAPClientListFolders) GetClient() *client.Client                    { return nil }
+func (m *MockIMAPClientListFolders) GetClient() *client.Client { return nil }
+func (m *MockIMAPClientListFolders) GetQuota(root string) (Quota, error) {
+	return Quota{}, ErrQuotaUnsupported
+}
+func (m *MockIMAPClientListFolders) GetQuotaRoot(mailbox string) ([]string, []Quota, error) {
+	return nil, nil, ErrQuotaUnsupported
+}
 func (m *MockIMAPClientListFolders) Login(username string, password string) error { return nil }
 func (m *MockIMAPClientListFolders) Select(name string, readOnly bool) (*imap.MailboxStatus, error) {
 	if m.selectFunc != nil {
@@ -53,11 +86,19 @@ func (m *MockIMAPClientListFolders) Status(name string, items []imap.StatusItem)
 	}
 	return &imap.MailboxStatus{}, nil
 }
-func (m *MockIMAPClientListFolders) UidCopy(seqSet *imap.SeqSet, mailbox string) error { return nil }
+func (m *MockIMAPClientListFolders) UidCopy(seqSet *imap.SeqSet, mailbox string) (*CopyUID, error) {
+	return nil, nil
+}
+func (m *MockIMAPClientListFolders) UidExpunge(seqSet *imap.SeqSet) error { return nil }
 func (m *MockIMAPClientListFolders) UidFetch(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error {
 	return nil
 }
-func (m *MockIMAPClientListFolders) UidMove(seqSet *imap.SeqSet, mailbox string) error { return nil }
+func (m *MockIMAPClientListFolders) UidFetchChangedSince(seqset *imap.SeqSet, items []imap.FetchItem, modSeq uint64, ch chan *imap.Message) error {
+	return nil
+}
+func (m *MockIMAPClientListFolders) UidMove(seqSet *imap.SeqSet, mailbox string) (*CopyUID, error) {
+	return nil, nil
+}
 func (m *MockIMAPClientListFolders) UidSearch(criteria *imap.SearchCriteria) ([]uint32, error) {
 	return nil, nil
 }
@@ -212,7 +253,7 @@ func TestListFoldersWithStatus(t *testing.T) {
 	}
 	dialer := &MockDialerListFolders{client: mockClient}
 
-	folders, err := ListFoldersWithStatus(dialer, Account{}, true)
+	folders, err := ListFoldersWithStatus(dialer, Account{}, true, false)
 	assert.NoError(t, err)
 	assert.Equal(t, []FolderStatus{
 		{Name: "INBOX", Messages: 10, Unseen: 3, Selectable: true, Oldest: inboxOldest, Newest: inboxNewest},
@@ -262,10 +303,91 @@ func TestListFoldersWithStatusWithoutDates(t *testing.T) {
 	}
 	dialer := &MockDialerListFolders{client: mockClient}
 
-	folders, err := ListFoldersWithStatus(dialer, Account{}, false)
+	folders, err := ListFoldersWithStatus(dialer, Account{}, false, false)
 	assert.NoError(t, err)
 	// Counts are present; date range is left zero (no scan performed).
 	assert.Equal(t, []FolderStatus{
 		{Name: "INBOX", Messages: 5000, Unseen: 12, Selectable: true},
 	}, folders)
 }
+
+func TestListFoldersWithStatusSizes(t *testing.T) {
+	sizes := map[string][]uint32{
+		"INBOX":   {1000, 2500},
+		"Archive": {4096},
+	}
+	newMock := func(statusSize bool) *MockIMAPClientListFolders {
+		var selected string
+		return &MockIMAPClientListFolders{
+			listFunc: func(ref string, name string, ch chan *imap.MailboxInfo) error {
+				go func() {
+					ch <- &imap.MailboxInfo{Name: "INBOX"}
+					ch <- &imap.MailboxInfo{Name: "Archive"}
+					close(ch)
+				}()
+				return nil
+			},
+			capabilityFunc: func() (map[string]bool, error) {
+				return map[string]bool{"IMAP4rev1": true, "STATUS=SIZE": statusSize}, nil
+			},
+			statusFunc: func(name string, items []imap.StatusItem) (*imap.MailboxStatus, error) {
+				status := &imap.MailboxStatus{Messages: uint32(len(sizes[name])), Items: map[imap.StatusItem]interface{}{}}
+				if slices.Contains(items, "SIZE") {
+					var total uint32
+					for _, size := range sizes[name] {
+						total += size
+					}
+					status.Items["SIZE"] = strconv.Itoa(int(total))
+				}
+				return status, nil
+			},
+			selectFunc: func(name string, readOnly bool) (*imap.MailboxStatus, error) {
+				if statusSize {
+					t.Errorf("Select should not be called when the server reports STATUS=SIZE")
+				}
+				selected = name
+				return &imap.MailboxStatus{Messages: uint32(len(sizes[name]))}, nil
+			},
+			fetchFunc: func(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error {
+				assert.Equal(t, []imap.FetchItem{imap.FetchRFC822Size}, items)
+				for _, size := range sizes[selected] {
+					ch <- &imap.Message{Size: size}
+				}
+				close(ch)
+				return nil
+			},
+		}
+	}
+	want := []FolderStatus{
+		{Name: "INBOX", Messages: 2, Selectable: true, Size: 3500},
+		{Name: "Archive", Messages: 1, Selectable: true, Size: 4096},
+	}
+
+	for _, statusSize := range []bool{true, false} {
+		folders, err := ListFoldersWithStatus(&MockDialerListFolders{client: newMock(statusSize)}, Account{}, false, true)
+		assert.NoError(t, err)
+		assert.Equal(t, want, folders, "STATUS=SIZE %v", statusSize)
+	}
+}
+
+func TestRollUpFolders(t *testing.T) {
+	oldest := time.Date(2019, 6, 15, 9, 0, 0, 0, time.UTC)
+	newest := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	folders := []FolderStatus{
+		{Name: "INBOX", Delimiter: "/", Selectable: true, Messages: 10, Unseen: 3, Size: 100},
+		{Name: "Lists", Delimiter: "/"},
+		{Name: "Lists/go", Delimiter: "/", Selectable: true, Messages: 5, Unseen: 1, Size: 50, Oldest: oldest},
+		{Name: "Lists/go/announce", Delimiter: "/", Selectable: true, Messages: 2, Size: 20, Newest: newest},
+		{Name: "ListsOld", Delimiter: "/", Selectable: true, Messages: 7, Size: 70},
+	}
+
+	rolled := RollUpFolders(folders)
+	assert.Equal(t, []FolderStatus{
+		{Name: "INBOX", Delimiter: "/", Selectable: true, Messages: 10, Unseen: 3, Size: 100},
+		{Name: "Lists", Delimiter: "/", Messages: 7, Unseen: 1, Size: 70, Oldest: oldest, Newest: newest, Subfolders: 2},
+		{Name: "Lists/go", Delimiter: "/", Selectable: true, Messages: 7, Unseen: 1, Size: 70, Oldest: oldest, Newest: newest, Subfolders: 1},
+		{Name: "Lists/go/announce", Delimiter: "/", Selectable: true, Messages: 2, Size: 20, Newest: newest},
+		{Name: "ListsOld", Delimiter: "/", Selectable: true, Messages: 7, Size: 70},
+	}, rolled)
+	assert.Equal(t, uint32(0), folders[1].Messages, "the input is left alone")
+}
@@ -0,0 +1,360 @@
+package imaputils
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// specialUseAttributes are the mailbox attributes that mark a folder as
+// having a special use (RFC 6154), which folder management leaves alone.
+var specialUseAttributes = []string{
+	imap.AllAttr,
+	imap.ArchiveAttr,
+	imap.DraftsAttr,
+	imap.FlaggedAttr,
+	imap.JunkAttr,
+	imap.SentAttr,
+	imap.TrashAttr,
+}
+
+// FolderRemoval is a folder DeleteFolders is to delete, by its server name,
+// with the number of messages it holds.
+type FolderRemoval struct {
+	Name     string
+	Messages uint32
+}
+
+// RenameFolder renames the folder from to to, both "/"-separated paths that
+// are translated to the server's hierarchy delimiter, creating the parents of
+// to that do not exist yet, as EnsureFolder does. The server renames the
+// folder's subfolders along with it; subscriptions to any of them follow.
+func RenameFolder(dialer IMAPDialer, account Account, from, to string) error {
+	if strings.EqualFold(from, "INBOX") {
+		// Renaming INBOX moves its messages out and leaves it empty (RFC 3501).
+		return fmt.Errorf("INBOX cannot be renamed; move its messages with find --move instead")
+	}
+	imapClient, err := getImapClient(dialer, account)
+	if err != nil {
+		return fmt.Errorf("failed to init imap client: %w", err)
+	}
+	defer imapClient.Logout()
+
+	delimiter, err := folderDelimiter(imapClient)
+	if err != nil {
+		return err
+	}
+	source := strings.ReplaceAll(from, "/", delimiter)
+	dest := strings.ReplaceAll(to, "/", delimiter)
+
+	exists, err := mailboxExists(imapClient, source)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("folder %s does not exist", from)
+	}
+	exists, err = mailboxExists(imapClient, dest)
+	if err != nil {
+		return err
+	}
+	if exists || strings.EqualFold(to, "INBOX") {
+		return fmt.Errorf("folder %s already exists", to)
+	}
+
+	if segments := strings.Split(to, "/"); len(segments) > 1 {
+		if err := createPath(imapClient, segments[:len(segments)-1], delimiter); err != nil {
+			return fmt.Errorf("failed to create the parents of %s: %w", to, err)
+		}
+	}
+	subscribed, err := subscribedFolders(imapClient)
+	if err != nil {
+		return err
+	}
+	if err := imapClient.Rename(source, dest); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", from, to, err)
+	}
+
+	// Some servers carry subscriptions over to the new names, others leave
+	// them on the old ones.
+	after, err := subscribedFolders(imapClient)
+	if err != nil {
+		log.Warn().Msgf("cannot move the subscriptions of %s: %v", from, err)
+		return nil
+	}
+	for _, name := range subscribed {
+		rest, ok := strings.CutPrefix(name, source)
+		if !ok || (rest != "" && !strings.HasPrefix(rest, delimiter)) {
+			continue
+		}
+		if !slices.Contains(after, dest+rest) {
+			if err := imapClient.Subscribe(dest + rest); err != nil {
+				log.Warn().Msgf("failed to subscribe to %s: %v", dest+rest, err)
+				continue
+			}
+		}
+		if slices.Contains(after, name) {
+			if err := imapClient.Unsubscribe(name); err != nil {
+				log.Warn().Msgf("failed to unsubscribe from %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// PlanDeleteFolder returns the folders that deleting the "/"-separated folder
+// path would delete, deepest first: the folder itself and, with recursive,
+// its subfolders. It refuses INBOX, the trash folder and folders with a
+// special use, and, without recursive, folders that have subfolders.
+func PlanDeleteFolder(dialer IMAPDialer, account Account, folder string, recursive bool) ([]FolderRemoval, error) {
+	if strings.EqualFold(folder, "INBOX") {
+		return nil, fmt.Errorf("INBOX cannot be deleted")
+	}
+	imapClient, err := getImapClient(dialer, account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init imap client: %w", err)
+	}
+	defer imapClient.Logout()
+
+	delimiter, err := folderDelimiter(imapClient)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ReplaceAll(folder, "/", delimiter)
+	infos, err := listMailboxes(imapClient, name)
+	if err != nil {
+		return nil, err
+	}
+	infos = slices.DeleteFunc(infos, func(info *imap.MailboxInfo) bool { return info.Name != name })
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("folder %s does not exist", folder)
+	}
+	children, err := listMailboxes(imapClient, name+delimiter+"*")
+	if err != nil {
+		return nil, err
+	}
+	if len(children) > 0 && !recursive {
+		return nil, fmt.Errorf("folder %s has %d subfolders; use --recursive to delete them too", folder, len(children))
+	}
+	infos = append(infos, children...)
+
+	for _, info := range infos {
+		if reason := protectedFolder(info, account); reason != "" {
+			return nil, fmt.Errorf("refusing to delete %s: %s", info.Name, reason)
+		}
+	}
+
+	removals := make([]FolderRemoval, 0, len(infos))
+	for _, info := range infos {
+		removal := FolderRemoval{Name: info.Name}
+		if !hasAttribute(info.Attributes, imap.NoSelectAttr) {
+			status, err := imapClient.Status(info.Name, []imap.StatusItem{imap.StatusMessages})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get status for folder %s: %w", info.Name, err)
+			}
+			removal.Messages = status.Messages
+		}
+		removals = append(removals, removal)
+	}
+	slices.SortFunc(removals, func(a, b FolderRemoval) int {
+		if depth := strings.Count(b.Name, delimiter) - strings.Count(a.Name, delimiter); depth != 0 {
+			return depth
+		}
+		return strings.Compare(b.Name, a.Name)
+	})
+	return removals, nil
+}
+
+// protectedFolder returns why the folder must not be deleted, or "" if it
+// may be: INBOX, folders with a special use, including those the account
+// sets as its trash_folder or archive_folder, and folders with a usual trash
+// name.
+func protectedFolder(info *imap.MailboxInfo, account Account) string {
+	if strings.EqualFold(info.Name, "INBOX") {
+		return "it is the INBOX"
+	}
+	for _, attribute := range specialUseAttributes {
+		if hasAttribute(info.Attributes, attribute) {
+			return fmt.Sprintf("it is the %s folder", strings.TrimPrefix(attribute, "\\"))
+		}
+	}
+	if account.TrashFolder != "" && folderNamed(info, account.TrashFolder) {
+		return "it is the trash folder"
+	}
+	if account.ArchiveFolder != "" && folderNamed(info, account.ArchiveFolder) {
+		return "it is the archive folder"
+	}
+	if slices.Contains(DeletedFolderNames, info.Name) {
+		return "it is the trash folder"
+	}
+	return ""
+}
+
+// DeleteFolders deletes folders, in order, with all their messages, then
+// unsubscribes from those the server left subscribed. It returns how many
+// were deleted, which on error is those before the one that failed.
+func DeleteFolders(dialer IMAPDialer, account Account, folders []FolderRemoval) (int, error) {
+	imapClient, err := getImapClient(dialer, account)
+	if err != nil {
+		return 0, fmt.Errorf("failed to init imap client: %w", err)
+	}
+	defer imapClient.Logout()
+
+	deleted := 0
+	var deleteErr error
+	for _, folder := range folders {
+		if err := imapClient.Delete(folder.Name); err != nil {
+			deleteErr = fmt.Errorf("failed to delete %s: %w", folder.Name, err)
+			break
+		}
+		deleted++
+	}
+
+	// Subscriptions can outlive their folders (RFC 3501).
+	subscribed, err := subscribedFolders(imapClient)
+	if err != nil {
+		log.Warn().Msgf("cannot remove the subscriptions of deleted folders: %v", err)
+		return deleted, deleteErr
+	}
+	for _, folder := range folders[:deleted] {
+		if slices.Contains(subscribed, folder.Name) {
+			if err := imapClient.Unsubscribe(folder.Name); err != nil {
+				log.Warn().Msgf("failed to unsubscribe from %s: %v", folder.Name, err)
+			}
+		}
+	}
+	return deleted, deleteErr
+}
+
+// SubscribeFolder subscribes to the "/"-separated folder path, which must
+// exist. It returns false if the folder was already subscribed.
+func SubscribeFolder(dialer IMAPDialer, account Account, folder string) (bool, error) {
+	imapClient, err := getImapClient(dialer, account)
+	if err != nil {
+		return false, fmt.Errorf("failed to init imap client: %w", err)
+	}
+	defer imapClient.Logout()
+
+	name, err := serverFolderName(imapClient, folder)
+	if err != nil {
+		return false, err
+	}
+	exists, err := mailboxExists(imapClient, name)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, fmt.Errorf("folder %s does not exist", folder)
+	}
+	subscribed, err := subscribedFolders(imapClient)
+	if err != nil {
+		return false, err
+	}
+	if slices.Contains(subscribed, name) {
+		return false, nil
+	}
+	if err := imapClient.Subscribe(name); err != nil {
+		return false, fmt.Errorf("failed to subscribe to %s: %w", folder, err)
+	}
+	return true, nil
+}
+
+// UnsubscribeFolder unsubscribes from the "/"-separated folder path, which
+// need not exist any more. It returns false if it was not subscribed.
+func UnsubscribeFolder(dialer IMAPDialer, account Account, folder string) (bool, error) {
+	imapClient, err := getImapClient(dialer, account)
+	if err != nil {
+		return false, fmt.Errorf("failed to init imap client: %w", err)
+	}
+	defer imapClient.Logout()
+
+	name, err := serverFolderName(imapClient, folder)
+	if err != nil {
+		return false, err
+	}
+	subscribed, err := subscribedFolders(imapClient)
+	if err != nil {
+		return false, err
+	}
+	if !slices.Contains(subscribed, name) {
+		return false, nil
+	}
+	if err := imapClient.Unsubscribe(name); err != nil {
+		return false, fmt.Errorf("failed to unsubscribe from %s: %w", folder, err)
+	}
+	return true, nil
+}
+
+// ListSubscribedFolders lists the folders the account is subscribed to.
+func ListSubscribedFolders(dialer IMAPDialer, account Account) ([]string, error) {
+	imapClient, err := getImapClient(dialer, account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize imap client: %w", err)
+	}
+	defer imapClient.Logout()
+	return subscribedFolders(imapClient)
+}
+
+// subscribedFolders lists the server names of the subscribed folders, with
+// LSUB.
+func subscribedFolders(imapClient IMAPClient) ([]string, error) {
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- imapClient.Lsub("", "*", mailboxes)
+	}()
+
+	var folders []string
+	for mailbox := range mailboxes {
+		folders = append(folders, mailbox.Name)
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to list subscribed folders: %w", err)
+	}
+	return folders, nil
+}
+
+// listMailboxes returns the mailboxes matching the LIST pattern.
+func listMailboxes(imapClient IMAPClient, pattern string) ([]*imap.MailboxInfo, error) {
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- imapClient.List("", pattern, mailboxes)
+	}()
+
+	var infos []*imap.MailboxInfo
+	for mailbox := range mailboxes {
+		infos = append(infos, mailbox)
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to list folders: %w", err)
+	}
+	return infos, nil
+}
+
+// FolderDelimiter returns the server's hierarchy delimiter, or "/" if it has
+// none, for translating the names ListFolders returns into "/"-separated
+// paths.
+func FolderDelimiter(dialer IMAPDialer, account Account) (string, error) {
+	imapClient, err := getImapClient(dialer, account)
+	if err != nil {
+		return "", fmt.Errorf("failed to init imap client: %w", err)
+	}
+	defer imapClient.Logout()
+	return folderDelimiter(imapClient)
+}
+
+// folderDelimiter returns the server's hierarchy delimiter, or "/" if it has
+// none.
+func folderDelimiter(imapClient IMAPClient) (string, error) {
+	delimiter, err := getHierarchyDelimiter(imapClient)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine hierarchy delimiter: %w", err)
+	}
+	if delimiter == "" {
+		delimiter = "/"
+	}
+	return delimiter, nil
+}
@@ -0,0 +1,155 @@
+package imaputils
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// folderServer sets up client as a server with "." as its hierarchy
+// delimiter and the given LIST responses, by pattern.
+func folderServer(client *MockIMAPClientMove, dialer *MockIMAPDialerMove, lists map[string][]*imap.MailboxInfo) {
+	dialer.On("Dial", mock.Anything).Return(client, nil)
+	client.On("Login", mock.Anything, mock.Anything).Return(nil)
+	client.On("Logout").Return(nil)
+	client.On("List", "", "", mock.Anything).Return(func(ch chan *imap.MailboxInfo) {
+		ch <- &imap.MailboxInfo{Delimiter: "."}
+	}, nil)
+	for pattern, infos := range lists {
+		client.On("List", "", pattern, mock.Anything).Return(func(ch chan *imap.MailboxInfo) {
+			for _, info := range infos {
+				ch <- info
+			}
+		}, nil)
+	}
+}
+
+func TestRenameFolder(t *testing.T) {
+	client := &MockIMAPClientMove{}
+	dialer := &MockIMAPDialerMove{}
+	folderServer(client, dialer, map[string][]*imap.MailboxInfo{
+		"Projects.Old":          {{Name: "Projects.Old"}},
+		"Archive.2023.Projects": nil,
+		"Archive":               {{Name: "Archive"}},
+		"Archive.2023":          nil,
+		"Missing":               nil,
+	})
+	client.On("Lsub", "", "*", mock.Anything).Return(func(ch chan *imap.MailboxInfo) {
+		ch <- &imap.MailboxInfo{Name: "Projects.Old"}
+		ch <- &imap.MailboxInfo{Name: "Projects.Old.Notes"}
+		ch <- &imap.MailboxInfo{Name: "Projects.Older"}
+	}, nil)
+	client.On("Create", "Archive.2023").Return(nil)
+	client.On("Rename", "Projects.Old", "Archive.2023.Projects").Return(nil)
+	client.On("Subscribe", "Archive.2023.Projects").Return(nil)
+	client.On("Unsubscribe", "Projects.Old").Return(nil)
+	client.On("Subscribe", "Archive.2023.Projects.Notes").Return(nil)
+	client.On("Unsubscribe", "Projects.Old.Notes").Return(nil)
+
+	assert.NoError(t, RenameFolder(dialer, Account{}, "Projects/Old", "Archive/2023/Projects"))
+	client.AssertNotCalled(t, "Unsubscribe", "Projects.Older")
+
+	assert.ErrorContains(t, RenameFolder(dialer, Account{}, "Inbox", "Old"), "INBOX cannot be renamed")
+	assert.ErrorContains(t, RenameFolder(dialer, Account{}, "Missing", "Other"), "folder Missing does not exist")
+	assert.ErrorContains(t, RenameFolder(dialer, Account{}, "Projects/Old", "Archive"), "folder Archive already exists")
+	client.AssertExpectations(t)
+}
+
+func TestPlanDeleteFolder(t *testing.T) {
+	client := &MockIMAPClientMove{}
+	dialer := &MockIMAPDialerMove{}
+	folderServer(client, dialer, map[string][]*imap.MailboxInfo{
+		"Lists":   {{Name: "Lists", Attributes: []string{imap.NoSelectAttr}}},
+		"Lists.*": {{Name: "Lists.go"}, {Name: "Lists.go.announce"}, {Name: "Lists.rust"}},
+		"Old":     {{Name: "Old"}, {Name: "Older"}},
+		"Old.*":   nil,
+		"Trash":   {{Name: "Trash"}},
+		"Trash.*": nil,
+		"Mail":    {{Name: "Mail"}},
+		"Mail.*":  {{Name: "Mail.Sent", Attributes: []string{imap.SentAttr}}},
+		"Missing": nil,
+	})
+	client.On("Status", "Old", mock.Anything).Return(&imap.MailboxStatus{Messages: 0}, nil)
+	client.On("Status", "Lists.go", mock.Anything).Return(&imap.MailboxStatus{Messages: 3}, nil)
+	client.On("Status", "Lists.go.announce", mock.Anything).Return(&imap.MailboxStatus{Messages: 0}, nil)
+	client.On("Status", "Lists.rust", mock.Anything).Return(&imap.MailboxStatus{Messages: 5}, nil)
+
+	removals, err := PlanDeleteFolder(dialer, Account{}, "Old", false)
+	assert.NoError(t, err)
+	assert.Equal(t, []FolderRemoval{{Name: "Old"}}, removals)
+
+	removals, err = PlanDeleteFolder(dialer, Account{}, "Lists", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []FolderRemoval{
+		{Name: "Lists.go.announce"},
+		{Name: "Lists.rust", Messages: 5},
+		{Name: "Lists.go", Messages: 3},
+		{Name: "Lists"},
+	}, removals)
+
+	_, err = PlanDeleteFolder(dialer, Account{}, "Lists", false)
+	assert.ErrorContains(t, err, "has 3 subfolders")
+	_, err = PlanDeleteFolder(dialer, Account{}, "inbox", true)
+	assert.ErrorContains(t, err, "INBOX cannot be deleted")
+	_, err = PlanDeleteFolder(dialer, Account{}, "Trash", false)
+	assert.ErrorContains(t, err, "it is the trash folder")
+	_, err = PlanDeleteFolder(dialer, Account{}, "Mail", true)
+	assert.ErrorContains(t, err, "refusing to delete Mail.Sent: it is the Sent folder")
+	_, err = PlanDeleteFolder(dialer, Account{}, "Missing", true)
+	assert.ErrorContains(t, err, "folder Missing does not exist")
+}
+
+func TestDeleteFolders(t *testing.T) {
+	client := &MockIMAPClientMove{}
+	dialer := &MockIMAPDialerMove{}
+	folderServer(client, dialer, nil)
+	client.On("Lsub", "", "*", mock.Anything).Return(func(ch chan *imap.MailboxInfo) {
+		ch <- &imap.MailboxInfo{Name: "Lists"}
+	}, nil)
+	client.On("Delete", "Lists.go").Return(nil)
+	client.On("Delete", "Lists").Return(nil)
+	client.On("Unsubscribe", "Lists").Return(nil)
+	client.On("Delete", "Old").Return(assert.AnError)
+
+	deleted, err := DeleteFolders(dialer, Account{}, []FolderRemoval{{Name: "Lists.go"}, {Name: "Lists"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	client.AssertNotCalled(t, "Unsubscribe", "Lists.go")
+
+	deleted, err = DeleteFolders(dialer, Account{}, []FolderRemoval{{Name: "Lists"}, {Name: "Old"}})
+	assert.ErrorContains(t, err, "failed to delete Old")
+	assert.Equal(t, 1, deleted)
+}
+
+func TestSubscribeFolder(t *testing.T) {
+	client := &MockIMAPClientMove{}
+	dialer := &MockIMAPDialerMove{}
+	folderServer(client, dialer, map[string][]*imap.MailboxInfo{
+		"Lists.go":   {{Name: "Lists.go"}},
+		"Lists.rust": {{Name: "Lists.rust"}},
+		"Missing":    nil,
+	})
+	client.On("Lsub", "", "*", mock.Anything).Return(func(ch chan *imap.MailboxInfo) {
+		ch <- &imap.MailboxInfo{Name: "Lists.go"}
+	}, nil)
+	client.On("Subscribe", "Lists.rust").Return(nil)
+	client.On("Unsubscribe", "Lists.go").Return(nil)
+
+	subscribed, err := SubscribeFolder(dialer, Account{}, "Lists/rust")
+	assert.NoError(t, err)
+	assert.True(t, subscribed)
+	subscribed, err = SubscribeFolder(dialer, Account{}, "Lists/go")
+	assert.NoError(t, err)
+	assert.False(t, subscribed)
+	_, err = SubscribeFolder(dialer, Account{}, "Missing")
+	assert.ErrorContains(t, err, "folder Missing does not exist")
+
+	unsubscribed, err := UnsubscribeFolder(dialer, Account{}, "Lists/go")
+	assert.NoError(t, err)
+	assert.True(t, unsubscribed)
+	unsubscribed, err = UnsubscribeFolder(dialer, Account{}, "Lists/rust")
+	assert.NoError(t, err)
+	assert.False(t, unsubscribed)
+}
@@ -0,0 +1,132 @@
+package imaputils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/wryfi/shemail/mailstore"
+	"github.com/wryfi/shemail/progress"
+)
+
+// ImportBatchSize is the number of messages ImportMessages appends between
+// saves of its progress.
+const ImportBatchSize = 100
+
+// ImportProgress records how far an import has got, so that an interrupted
+// import can resume instead of starting over.
+type ImportProgress struct {
+	// Position is the mailstore.Reader position after the last message that
+	// was appended or skipped.
+	Position   string    `json:"position"`
+	Imported   int       `json:"imported"`
+	Duplicates int       `json:"duplicates"`
+	Updated    time.Time `json:"updated"`
+}
+
+// ImportKey builds the store key for importing source, which should be an
+// absolute path, into folder.
+func ImportKey(account Account, source, folder string) string {
+	return account.Name + "/" + folder + "<" + source
+}
+
+// folderMessageIDs returns the set of Message-IDs of the messages in folder.
+func folderMessageIDs(dialer IMAPDialer, account Account, folder string) (map[string]bool, error) {
+	ids := map[string]bool{}
+	for message, err := range StreamMessages(dialer, account, folder, BuildSearchCriteria(SearchOptions{}), DefaultChunkSize) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list messages in %s: %w", folder, err)
+		}
+		if message.Envelope != nil {
+			if id := strings.TrimSpace(message.Envelope.MessageId); id != "" {
+				ids[id] = true
+			}
+		}
+	}
+	return ids, nil
+}
+
+// importFlags returns the flags to APPEND a message with. \Recent cannot be
+// set by clients, and a message flagged \Deleted in the source is imported
+// without the flag rather than being left for the next expunge.
+func importFlags(flags []string) []string {
+	return slices.DeleteFunc(slices.Clone(flags), func(flag string) bool {
+		return flag == imap.RecentFlag || flag == imap.DeletedFlag
+	})
+}
+
+// ImportMessages APPENDs the messages reader yields to folder, which is
+// created if it does not exist, with their original dates as INTERNALDATE and
+// their flags. Messages whose Message-ID is already in folder, or appeared
+// earlier in the source, are skipped as duplicates, so re-importing a source
+// never duplicates anything.
+//
+// Counting starts from state, whose Position reader should already have been
+// opened at. After every ImportBatchSize messages, and at the end, save is
+// called with the progress so far; if it fails, the import stops. The
+// progress reached is returned even on error.
+func ImportMessages(dialer IMAPDialer, account Account, reader mailstore.Reader, folder string, state ImportProgress, save func(ImportProgress) error) (ImportProgress, error) {
+	if err := EnsureFolder(dialer, account, folder); err != nil {
+		return state, fmt.Errorf("failed to ensure folder %s exists: %w", folder, err)
+	}
+
+	imapClient, err := getImapClient(dialer, account)
+	if err != nil {
+		return state, fmt.Errorf("failed to initialize imap client: %w", err)
+	}
+	defer imapClient.Logout()
+
+	mailbox, err := serverFolderName(imapClient, folder)
+	if err != nil {
+		return state, err
+	}
+	existing, err := folderMessageIDs(dialer, account, mailbox)
+	if err != nil {
+		return state, err
+	}
+
+	task := progress.Start("importing to "+folder, "messages", 0)
+	defer task.Done()
+
+	checkpoint := func() error {
+		state.Position = reader.Position()
+		state.Updated = time.Now()
+		return save(state)
+	}
+	for handled := 1; ; handled++ {
+		message, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return state, err
+		}
+
+		id := mailstore.MessageID(message.Body)
+		if id != "" && existing[id] {
+			log.Debug().Msgf("skipping %s: already in %s", id, folder)
+			state.Duplicates++
+		} else {
+			literal := bytes.NewBuffer(mailstore.CRLF(message.Body))
+			if err := imapClient.Append(mailbox, importFlags(message.Flags), message.Date, literal); err != nil {
+				return state, fmt.Errorf("failed to append message to %s: %w", folder, err)
+			}
+			if id != "" {
+				existing[id] = true
+			}
+			state.Imported++
+		}
+		task.Add(1)
+
+		if handled%ImportBatchSize == 0 {
+			if err := checkpoint(); err != nil {
+				return state, err
+			}
+		}
+	}
+	return state, checkpoint()
+}
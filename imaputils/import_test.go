@@ -0,0 +1,83 @@
+package imaputils
+
+import (
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wryfi/shemail/mailstore"
+)
+
+// sliceReader is a mailstore.Reader over messages in memory, positioned by
+// index.
+type sliceReader struct {
+	messages []mailstore.Message
+	next     int
+}
+
+func (reader *sliceReader) Next() (mailstore.Message, error) {
+	if reader.next >= len(reader.messages) {
+		return mailstore.Message{}, io.EOF
+	}
+	reader.next++
+	return reader.messages[reader.next-1], nil
+}
+
+func (reader *sliceReader) Position() string {
+	return strconv.Itoa(reader.next)
+}
+
+func (reader *sliceReader) Close() error {
+	return nil
+}
+
+func TestImportMessages(t *testing.T) {
+	date := time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC)
+	client := &MockIMAPClientMove{}
+	dialer := &MockIMAPDialerMove{}
+	dialer.On("Dial", mock.Anything).Return(client, nil)
+	client.On("Login", mock.Anything, mock.Anything).Return(nil)
+	client.On("Logout").Return(nil)
+	client.On("List", "", "", mock.Anything).Return(
+		func(ch chan *imap.MailboxInfo) { ch <- &imap.MailboxInfo{Delimiter: "/"} }, nil)
+	client.On("List", "", "Imported", mock.Anything).Return(
+		func(ch chan *imap.MailboxInfo) { ch <- &imap.MailboxInfo{Name: "Imported"} }, nil)
+	client.On("Select", "Imported", true).Return(&imap.MailboxStatus{}, nil)
+	client.On("Capability").Return(map[string]bool{}, nil)
+	client.On("UidSearch", mock.Anything).Return([]uint32{7}, nil)
+	client.On("UidFetch", mock.Anything, mock.Anything, mock.Anything).Return(func(ch chan *imap.Message) {
+		ch <- &imap.Message{Uid: 7, Envelope: &imap.Envelope{MessageId: "<old@example.com>"}}
+	}, nil)
+
+	var appended []string
+	client.On("Append", "Imported", mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		body, _ := io.ReadAll(args.Get(3).(imap.Literal))
+		appended = append(appended, string(body))
+	}).Return(nil)
+
+	reader := &sliceReader{messages: []mailstore.Message{
+		{Body: []byte("Message-ID: <old@example.com>\n\nalready there\n")},
+		{Body: []byte("Message-ID: <new@example.com>\n\nnew\n"), Date: date, Flags: []string{imap.SeenFlag, imap.DeletedFlag}},
+		{Body: []byte("Message-ID: <new@example.com>\n\nnew again\n")},
+		{Body: []byte("Subject: no id\n\n")},
+	}}
+	var saved []ImportProgress
+	save := func(state ImportProgress) error {
+		saved = append(saved, state)
+		return nil
+	}
+
+	state, err := ImportMessages(dialer, Account{}, reader, "Imported", ImportProgress{Imported: 5}, save)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, state.Imported, "counting continues from the saved progress")
+	assert.Equal(t, 2, state.Duplicates, "messages already in the folder or earlier in the source are skipped")
+	assert.Equal(t, []string{"Message-ID: <new@example.com>\r\n\r\nnew\r\n", "Subject: no id\r\n\r\n"}, appended)
+	client.AssertCalled(t, "Append", "Imported", []string{imap.SeenFlag}, date, mock.Anything)
+	if assert.Len(t, saved, 1) {
+		assert.Equal(t, "4", saved[0].Position)
+	}
+}
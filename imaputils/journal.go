@@ -0,0 +1,276 @@
+package imaputils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// JournalOperation names what a journaled operation did to its messages.
+type JournalOperation string
+
+const (
+	JournalCopy   JournalOperation = "copy"
+	JournalMove   JournalOperation = "move"
+	JournalDelete JournalOperation = "delete" // moved to the trash folder
+	JournalPurge  JournalOperation = "purge"  // expunged; cannot be undone
+	JournalUndo   JournalOperation = "undo"
+)
+
+// JournalMessage identifies one message of a journaled operation, in both
+// folders where possible. DestUID comes from the server's COPYUID and is zero
+// without UIDPLUS; MessageID is the fallback for finding the message again.
+type JournalMessage struct {
+	SourceUID uint32 `json:"source_uid"`
+	DestUID   uint32 `json:"dest_uid,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+// JournalEntry is one operation in the journal. UIDs are only meaningful
+// under the UIDVALIDITY recorded with them.
+type JournalEntry struct {
+	ID                int              `json:"id"`
+	Time              time.Time        `json:"time"`
+	Account           string           `json:"account"`
+	Operation         JournalOperation `json:"operation"`
+	Source            string           `json:"source"`
+	Dest              string           `json:"dest,omitempty"`
+	SourceUidValidity uint32           `json:"source_uid_validity,omitempty"`
+	DestUidValidity   uint32           `json:"dest_uid_validity,omitempty"`
+	// Count is the number of messages the operation acted on. Messages may be
+	// empty when the individual messages are not known (e.g. emptying a
+	// folder).
+	Count    int              `json:"count"`
+	Messages []JournalMessage `json:"messages,omitempty"`
+	// Undoes is the ID of the entry an undo reverted.
+	Undoes int `json:"undoes,omitempty"`
+}
+
+// NewJournalEntry describes an operation that took messages out of (or copied
+// them from) transfer.Source, pairing each with its UID in transfer.Dest where
+// the server reported one.
+func NewJournalEntry(account Account, operation JournalOperation, transfer *Transfer, messages []*imap.Message) JournalEntry {
+	entry := JournalEntry{
+		Account:           account.Name,
+		Operation:         operation,
+		Source:            transfer.Source,
+		Dest:              transfer.Dest,
+		SourceUidValidity: transfer.SourceUidValidity,
+		DestUidValidity:   transfer.DestUidValidity,
+		Count:             len(messages),
+	}
+	for _, message := range messages {
+		journaled := JournalMessage{SourceUID: message.Uid, DestUID: transfer.UIDs[message.Uid]}
+		if message.Envelope != nil {
+			journaled.MessageID = message.Envelope.MessageId
+		}
+		entry.Messages = append(entry.Messages, journaled)
+	}
+	return entry
+}
+
+// DeleteOperation is the journal operation for a delete that produced
+// transfer: a purge when the messages were expunged rather than moved to the
+// trash.
+func DeleteOperation(transfer *Transfer) JournalOperation {
+	if transfer.Dest == "" {
+		return JournalPurge
+	}
+	return JournalDelete
+}
+
+// Describe summarizes the entry, e.g. "move 3 messages from INBOX to Archive".
+func (entry JournalEntry) Describe() string {
+	description := fmt.Sprintf("%s %d messages from %s", entry.Operation, entry.Count, entry.Source)
+	if entry.Operation == JournalUndo {
+		description = fmt.Sprintf("undo %d: %d messages from %s", entry.Undoes, entry.Count, entry.Source)
+	}
+	if entry.Dest != "" {
+		description += " to " + entry.Dest
+	}
+	return description
+}
+
+// Journal is the local, append-only record of operations that moved, copied
+// or deleted messages, one JSON entry per line. Entries are never rewritten:
+// an undo is recorded as a new entry referring to the one it reverted.
+type Journal struct {
+	path     string
+	readOnly bool
+}
+
+// NewJournal returns the journal stored at path. The file is created on the
+// first Append.
+func NewJournal(path string) *Journal {
+	return &Journal{path: path}
+}
+
+// NewReadOnlyJournal returns the journal stored at path for reading only:
+// Record does nothing, as befits a dry run.
+func NewReadOnlyJournal(path string) *Journal {
+	return &Journal{path: path, readOnly: true}
+}
+
+// Entries reads every entry in the journal, oldest first. A missing journal
+// is empty. A malformed line (e.g. the tail of a write cut short) is skipped
+// with a warning rather than making the whole journal unreadable.
+func (journal *Journal) Entries() ([]JournalEntry, error) {
+	file, err := os.Open(journal.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal %s: %w", journal.path, err)
+	}
+	defer file.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Warn().Msgf("skipping malformed journal entry on line %d of %s: %v", line, journal.path, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read journal %s: %w", journal.path, err)
+	}
+	return entries, nil
+}
+
+// Append assigns entry the next ID (and the current time, if unset) and adds
+// it to the end of the journal, returning it as recorded. The journal is
+// locked from reading the last ID to writing the entry, so that concurrent
+// runs (cron, watch, rules run) cannot hand out the same ID.
+func (journal *Journal) Append(entry JournalEntry) (JournalEntry, error) {
+	if err := os.MkdirAll(filepath.Dir(journal.path), 0o700); err != nil {
+		return entry, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	file, err := os.OpenFile(journal.path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return entry, fmt.Errorf("failed to open journal %s: %w", journal.path, err)
+	}
+	defer file.Close()
+	if err := lockFile(file); err != nil {
+		return entry, fmt.Errorf("failed to lock journal %s: %w", journal.path, err)
+	}
+	defer unlockFile(file)
+
+	lastID, complete, err := lastJournalID(file)
+	if err != nil {
+		return entry, fmt.Errorf("failed to read journal %s: %w", journal.path, err)
+	}
+	entry.ID = lastID + 1
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return entry, fmt.Errorf("failed to encode journal entry: %w", err)
+	}
+	line := append(data, '\n')
+	if !complete {
+		// Keep the entry off the end of a write that was cut short.
+		line = append([]byte{'\n'}, line...)
+	}
+	if _, err := file.Write(line); err != nil {
+		return entry, fmt.Errorf("failed to write journal %s: %w", journal.path, err)
+	}
+	return entry, nil
+}
+
+// lastJournalID returns the ID of the last well-formed entry in file, or 0 if
+// there is none, reading back from the end so that an append does not cost a
+// read of the whole journal. complete is false if the file does not end with
+// a newline.
+func lastJournalID(file *os.File) (id int, complete bool, err error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+	const chunk = 4096
+	var tail []byte
+	for end := info.Size(); end > 0; {
+		start := max(0, end-chunk)
+		buf := make([]byte, end-start)
+		if _, err := file.ReadAt(buf, start); err != nil {
+			return 0, false, err
+		}
+		if end == info.Size() {
+			complete = buf[len(buf)-1] == '\n'
+		}
+		tail = append(buf, tail...)
+		end = start
+
+		// The first line may continue before start, unless that is the
+		// beginning of the file.
+		lines := bytes.Split(bytes.TrimRight(tail, "\n"), []byte("\n"))
+		first := 1
+		if start == 0 {
+			first = 0
+		}
+		for index := len(lines) - 1; index >= first; index-- {
+			var entry JournalEntry
+			if json.Unmarshal(lines[index], &entry) == nil {
+				return entry.ID, complete, nil
+			}
+		}
+		tail = lines[0]
+	}
+	return 0, info.Size() == 0 || complete, nil
+}
+
+// Record appends entry, logging rather than returning a failure: by the time
+// an operation is journaled it has already happened, and failing to note it
+// must not turn a successful run into an error.
+func (journal *Journal) Record(entry JournalEntry) {
+	if journal == nil {
+		return
+	}
+	if journal.readOnly {
+		log.Debug().Msgf("not recording %s of %d messages in the read-only journal", entry.Operation, entry.Count)
+		return
+	}
+	if _, err := journal.Append(entry); err != nil {
+		log.Warn().Msgf("failed to record %s of %d messages in the journal: %v", entry.Operation, entry.Count, err)
+	}
+}
+
+// UndoneBy maps the ID of every undone entry to the ID of the undo that
+// reverted it.
+func UndoneBy(entries []JournalEntry) map[int]int {
+	undone := map[int]int{}
+	for _, entry := range entries {
+		if entry.Operation == JournalUndo && entry.Undoes != 0 {
+			undone[entry.Undoes] = entry.ID
+		}
+	}
+	return undone
+}
+
+// Undoable reports whether entry can still be undone given the undos recorded
+// in undone (see UndoneBy), and if not, why.
+func (entry JournalEntry) Undoable(undone map[int]int) (bool, string) {
+	if by, ok := undone[entry.ID]; ok {
+		return false, fmt.Sprintf("undone by %d", by)
+	}
+	switch entry.Operation {
+	case JournalCopy, JournalMove, JournalDelete:
+		return true, ""
+	case JournalPurge:
+		return false, "purged"
+	default:
+		return false, "not undoable"
+	}
+}
@@ -0,0 +1,152 @@
+package imaputils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalAppendAndEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "journal.jsonl")
+	journal := NewJournal(path)
+
+	entries, err := journal.Entries()
+	assert.NoError(t, err, "a missing journal is empty")
+	assert.Empty(t, entries)
+
+	first, err := journal.Append(JournalEntry{Account: "work", Operation: JournalMove, Source: "INBOX", Dest: "Archive", Count: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, first.ID)
+	assert.False(t, first.Time.IsZero())
+
+	// A line cut short by a crash is skipped, and IDs carry on.
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	_, err = file.WriteString(`{"id": 2, "opera` + "\n")
+	assert.NoError(t, err)
+	file.Close()
+
+	second, err := journal.Append(JournalEntry{Account: "work", Operation: JournalPurge, Source: "Trash", Count: 4})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, second.ID)
+
+	entries, err = journal.Entries()
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "Archive", entries[0].Dest)
+		assert.Equal(t, JournalPurge, entries[1].Operation)
+	}
+}
+
+func TestJournalAppendCutShort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	journal := NewJournal(path)
+	_, err := journal.Append(JournalEntry{Operation: JournalMove, Count: 1})
+	assert.NoError(t, err)
+
+	// A write cut short before its newline must not swallow the next entry.
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	_, err = file.WriteString(`{"id": 2, "opera`)
+	assert.NoError(t, err)
+	file.Close()
+
+	second, err := journal.Append(JournalEntry{Operation: JournalCopy, Count: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, second.ID)
+	entries, err := journal.Entries()
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, JournalCopy, entries[1].Operation)
+	}
+}
+
+func TestJournalAppendConcurrently(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	// Long entries, so that finding the last ID reads back over several
+	// chunks.
+	padding := strings.Repeat("x", 3000)
+
+	const appends = 50
+	start := make(chan struct{})
+	var wait sync.WaitGroup
+	for range appends {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			<-start
+			// A journal per goroutine, as separate runs would have.
+			_, err := NewJournal(path).Append(JournalEntry{Operation: JournalMove, Source: padding, Count: 1})
+			assert.NoError(t, err)
+		}()
+	}
+	close(start)
+	wait.Wait()
+
+	entries, err := NewJournal(path).Entries()
+	assert.NoError(t, err)
+	ids := make([]int, len(entries))
+	for index, entry := range entries {
+		ids[index] = entry.ID
+	}
+	want := make([]int, appends)
+	for index := range want {
+		want[index] = index + 1
+	}
+	assert.Equal(t, want, ids, "every append gets its own ID, in order")
+}
+
+func TestNewJournalEntry(t *testing.T) {
+	transfer := newTransfer("INBOX", "Archive")
+	transfer.SourceUidValidity = 3
+	transfer.record(&CopyUID{UidValidity: 9, Source: []uint32{10}, Dest: []uint32{501}})
+
+	messages := []*imap.Message{
+		{Uid: 10, Envelope: &imap.Envelope{MessageId: "<a@example.com>"}},
+		{Uid: 11},
+	}
+	entry := NewJournalEntry(Account{Name: "work"}, JournalMove, transfer, messages)
+	assert.Equal(t, "work", entry.Account)
+	assert.Equal(t, uint32(3), entry.SourceUidValidity)
+	assert.Equal(t, uint32(9), entry.DestUidValidity)
+	assert.Equal(t, 2, entry.Count)
+	assert.Equal(t, []JournalMessage{
+		{SourceUID: 10, DestUID: 501, MessageID: "<a@example.com>"},
+		{SourceUID: 11},
+	}, entry.Messages)
+	assert.Equal(t, "move 2 messages from INBOX to Archive", entry.Describe())
+
+	assert.Equal(t, JournalPurge, DeleteOperation(newTransfer("INBOX", "")))
+	assert.Equal(t, JournalDelete, DeleteOperation(transfer))
+}
+
+func TestFindUndoTarget(t *testing.T) {
+	account := Account{Name: "work"}
+	entries := []JournalEntry{
+		{ID: 1, Account: "work", Operation: JournalMove},
+		{ID: 2, Account: "work", Operation: JournalDelete},
+		{ID: 3, Account: "home", Operation: JournalCopy},
+		{ID: 4, Account: "work", Operation: JournalPurge},
+		{ID: 5, Account: "work", Operation: JournalUndo, Undoes: 2},
+	}
+
+	target, err := FindUndoTarget(account, entries, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, target.ID, "skips purges, undos and what was already undone")
+
+	_, err = FindUndoTarget(account, entries, 2)
+	assert.ErrorContains(t, err, "undone by 5")
+	_, err = FindUndoTarget(account, entries, 4)
+	assert.ErrorContains(t, err, "purged")
+	_, err = FindUndoTarget(account, entries, 3)
+	assert.ErrorContains(t, err, "belongs to account home")
+	_, err = FindUndoTarget(account, entries, 9)
+	assert.ErrorContains(t, err, "no operation 9")
+	_, err = FindUndoTarget(Account{Name: "other"}, entries, 0)
+	assert.Error(t, err)
+}
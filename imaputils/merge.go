@@ -0,0 +1,235 @@
+package imaputils
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// MergeStep is one folder to merge into another.
+type MergeStep struct {
+	// Source is the server name of the folder to empty.
+	Source string
+	// Dest is the "/"-separated path of the folder to move its messages to.
+	Dest     string
+	Messages uint32
+	// Selectable is false for \Noselect container folders, which hold no
+	// messages and are only deleted.
+	Selectable bool
+	// Subfolders counts the folders below Source that are not part of the
+	// merge, which keep Source from being deleted.
+	Subfolders int
+}
+
+// MergeResult is what MergeFolder did.
+type MergeResult struct {
+	// Moved are the messages moved to the destination, and Transfer where
+	// they went.
+	Moved    []*imap.Message
+	Transfer *Transfer
+	// Duplicates are the messages already in the destination by Message-ID,
+	// deleted rather than moved, and DuplicateTransfer where they went.
+	Duplicates        []*imap.Message
+	DuplicateTransfer *Transfer
+}
+
+// PlanMerge returns the folders merging the "/"-separated folder paths
+// sources into dest would empty, deepest first: each source and, with
+// recursive, every folder below it, which goes to the corresponding path
+// below dest. It refuses to merge INBOX, the trash folder and folders with a
+// special use, since merged folders are deleted, and, with recursive, to
+// merge a folder into its own subtree.
+func PlanMerge(dialer IMAPDialer, account Account, sources []string, dest string, recursive bool) ([]MergeStep, error) {
+	imapClient, err := getImapClient(dialer, account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init imap client: %w", err)
+	}
+	defer imapClient.Logout()
+
+	delimiter, err := folderDelimiter(imapClient)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := listMailboxes(imapClient, "*")
+	if err != nil {
+		return nil, err
+	}
+	destName := strings.ReplaceAll(dest, "/", delimiter)
+
+	var steps []MergeStep
+	for _, source := range sources {
+		name := strings.ReplaceAll(source, "/", delimiter)
+		switch {
+		case name == destName:
+			return nil, fmt.Errorf("cannot merge %s into itself", source)
+		case recursive && strings.HasPrefix(destName, name+delimiter):
+			return nil, fmt.Errorf("cannot merge %s into %s, which is below it, with --recursive", source, dest)
+		case !slices.ContainsFunc(infos, func(info *imap.MailboxInfo) bool { return info.Name == name }):
+			return nil, fmt.Errorf("folder %s does not exist", source)
+		}
+
+		for _, info := range infos {
+			target, ok := MigrationTarget(FolderStatus{Name: info.Name, Delimiter: delimiter}, name, dest)
+			if !ok || (!recursive && info.Name != name) {
+				continue
+			}
+			if info.Name == destName || slices.ContainsFunc(steps, func(step MergeStep) bool { return step.Source == info.Name }) {
+				continue
+			}
+			if reason := protectedFolder(info, account); reason != "" {
+				return nil, fmt.Errorf("refusing to merge %s, which would then be deleted: %s", info.Name, reason)
+			}
+			step := MergeStep{Source: info.Name, Dest: target, Selectable: !hasAttribute(info.Attributes, imap.NoSelectAttr)}
+			if !recursive {
+				for _, other := range infos {
+					if strings.HasPrefix(other.Name, name+delimiter) {
+						step.Subfolders++
+					}
+				}
+			}
+			steps = append(steps, step)
+		}
+	}
+
+	for index := range steps {
+		if !steps[index].Selectable {
+			continue
+		}
+		status, err := imapClient.Status(steps[index].Source, []imap.StatusItem{imap.StatusMessages})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get status for folder %s: %w", steps[index].Source, err)
+		}
+		steps[index].Messages = status.Messages
+	}
+	slices.SortStableFunc(steps, func(a, b MergeStep) int {
+		return strings.Count(b.Source, delimiter) - strings.Count(a.Source, delimiter)
+	})
+	return steps, nil
+}
+
+// MergeFolder moves every message of step's source folder to its
+// destination, creating it if needed. With dedupe, messages whose Message-ID
+// is already in the destination, or on an older message of the source, are
+// deleted instead, following the account's deletion strategy. It then
+// verifies that the source is empty and that the destination gained the
+// messages moved, so that the caller can delete the source. The result so
+// far is returned even on error.
+func MergeFolder(dialer IMAPDialer, account Account, step MergeStep, dedupe bool) (MergeResult, error) {
+	var result MergeResult
+	if err := EnsureFolder(dialer, account, step.Dest); err != nil {
+		return result, fmt.Errorf("failed to create %s: %w", step.Dest, err)
+	}
+	if !step.Selectable {
+		return result, nil
+	}
+	dest, err := ServerFolderName(dialer, account, step.Dest)
+	if err != nil {
+		return result, err
+	}
+	_, dryRun := dialer.(*DryRunDialer)
+	destBefore := 0
+	if !dryRun {
+		// A dry run's destination may not exist yet.
+		if destBefore, err = FolderMessageCount(dialer, account, dest); err != nil {
+			return result, err
+		}
+	}
+
+	messages, err := CollectMessages(StreamMessages(dialer, account, step.Source, BuildSearchCriteria(SearchOptions{}), DefaultChunkSize))
+	if err != nil {
+		return result, fmt.Errorf("failed to read %s: %w", step.Source, err)
+	}
+	if dedupe {
+		messages, result.Duplicates, err = splitMergeDuplicates(dialer, account, dest, messages)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if len(messages) > 0 {
+		transfer, err := MoveMessages(dialer, account, messages, step.Source, dest, DefaultChunkSize)
+		if err != nil {
+			return result, fmt.Errorf("failed to move messages from %s to %s: %w", step.Source, dest, err)
+		}
+		result.Moved, result.Transfer = messages, transfer
+	}
+	if len(result.Duplicates) > 0 {
+		transfer, err := DeleteMessages(dialer, account, result.Duplicates, step.Source)
+		if err != nil {
+			return result, fmt.Errorf("failed to delete duplicates from %s: %w", step.Source, err)
+		}
+		result.DuplicateTransfer = transfer
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	left, err := FolderMessageCount(dialer, account, step.Source)
+	if err != nil {
+		return result, err
+	}
+	if left > 0 {
+		return result, fmt.Errorf("%d messages are still in %s after the merge", left, step.Source)
+	}
+	destAfter, err := FolderMessageCount(dialer, account, dest)
+	if err != nil {
+		return result, err
+	}
+	// New mail may arrive in the destination meanwhile, but none may be lost.
+	if expected := destBefore + len(result.Moved); destAfter < expected {
+		return result, fmt.Errorf("%s holds %d messages after the merge, expected at least %d", dest, destAfter, expected)
+	}
+	return result, nil
+}
+
+// splitMergeDuplicates separates the messages of a source folder that are
+// not yet in the folder dest from those whose Message-ID is, or is on an
+// older message of the source.
+func splitMergeDuplicates(dialer IMAPDialer, account Account, dest string, messages []*imap.Message) (unique, duplicates []*imap.Message, err error) {
+	imapClient, err := getImapClient(dialer, account)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to init imap client: %w", err)
+	}
+	exists, err := mailboxExists(imapClient, dest)
+	imapClient.Logout()
+	if err != nil {
+		return nil, nil, err
+	}
+	var existing []*imap.Message
+	if exists {
+		existing, err = CollectMessages(StreamMessages(dialer, account, dest, BuildSearchCriteria(SearchOptions{}), DefaultChunkSize))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", dest, err)
+		}
+	}
+	unique, duplicates = mergeDuplicates(existing, messages)
+	return unique, duplicates, nil
+}
+
+// mergeDuplicates returns the messages that are not duplicates of existing
+// ones or of older ones among messages, and those that are.
+func mergeDuplicates(existing, messages []*imap.Message) (unique, duplicates []*imap.Message) {
+	// Everything in the destination comes first, so that it is kept, then
+	// the source, oldest first.
+	ordered := slices.Clone(messages)
+	SortMessages(ordered, SortDate, true)
+	isSource := map[*imap.Message]bool{}
+	for _, message := range ordered {
+		isSource[message] = true
+	}
+	isDuplicate := map[*imap.Message]bool{}
+	for _, duplicate := range FindDuplicates(append(slices.Clone(existing), ordered...)) {
+		if isSource[duplicate] {
+			duplicates = append(duplicates, duplicate)
+			isDuplicate[duplicate] = true
+		}
+	}
+	for _, message := range messages {
+		if !isDuplicate[message] {
+			unique = append(unique, message)
+		}
+	}
+	return unique, duplicates
+}
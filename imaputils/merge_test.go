@@ -0,0 +1,75 @@
+package imaputils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPlanMerge(t *testing.T) {
+	client := &MockIMAPClientMove{}
+	dialer := &MockIMAPDialerMove{}
+	folderServer(client, dialer, map[string][]*imap.MailboxInfo{
+		"*": {
+			{Name: "INBOX"},
+			{Name: "Receipts"},
+			{Name: "receipts"},
+			{Name: "Shopping", Attributes: []string{imap.NoSelectAttr}},
+			{Name: "Shopping.Receipts"},
+			{Name: "Shopping.Receipts.2023"},
+			{Name: "Sent", Attributes: []string{imap.SentAttr}},
+		},
+	})
+	client.On("Status", "receipts", mock.Anything).Return(&imap.MailboxStatus{Messages: 4}, nil)
+	client.On("Status", "Shopping.Receipts", mock.Anything).Return(&imap.MailboxStatus{Messages: 7}, nil)
+	client.On("Status", "Shopping.Receipts.2023", mock.Anything).Return(&imap.MailboxStatus{Messages: 2}, nil)
+
+	steps, err := PlanMerge(dialer, Account{}, []string{"receipts", "Shopping/Receipts"}, "Receipts", false)
+	assert.NoError(t, err)
+	assert.Equal(t, []MergeStep{
+		{Source: "Shopping.Receipts", Dest: "Receipts", Messages: 7, Selectable: true, Subfolders: 1},
+		{Source: "receipts", Dest: "Receipts", Messages: 4, Selectable: true},
+	}, steps)
+
+	steps, err = PlanMerge(dialer, Account{}, []string{"Shopping"}, "Archive/Shopping", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []MergeStep{
+		{Source: "Shopping.Receipts.2023", Dest: "Archive/Shopping/Receipts/2023", Messages: 2, Selectable: true},
+		{Source: "Shopping.Receipts", Dest: "Archive/Shopping/Receipts", Messages: 7, Selectable: true},
+		{Source: "Shopping", Dest: "Archive/Shopping"},
+	}, steps)
+
+	_, err = PlanMerge(dialer, Account{}, []string{"Receipts"}, "Receipts", false)
+	assert.ErrorContains(t, err, "into itself")
+	_, err = PlanMerge(dialer, Account{}, []string{"Shopping"}, "Shopping/Receipts", true)
+	assert.ErrorContains(t, err, "which is below it")
+	_, err = PlanMerge(dialer, Account{}, []string{"Orders"}, "Receipts", false)
+	assert.ErrorContains(t, err, "folder Orders does not exist")
+	_, err = PlanMerge(dialer, Account{}, []string{"INBOX"}, "Receipts", false)
+	assert.ErrorContains(t, err, "refusing to merge INBOX")
+	_, err = PlanMerge(dialer, Account{}, []string{"Sent"}, "Receipts", false)
+	assert.ErrorContains(t, err, "it is the Sent folder")
+}
+
+func TestMergeDuplicates(t *testing.T) {
+	message := func(uid uint32, messageID string, day int) *imap.Message {
+		return &imap.Message{
+			Uid:          uid,
+			InternalDate: time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC),
+			Envelope:     &imap.Envelope{MessageId: messageID},
+		}
+	}
+	existing := []*imap.Message{message(1, "<a>", 1), message(2, "<a>", 2)}
+	newer, older := message(12, "<b>", 9), message(11, "<b>", 8)
+	source := []*imap.Message{message(10, "<a>", 3), newer, older, message(13, "", 1)}
+
+	unique, duplicates := mergeDuplicates(existing, source)
+	assert.Equal(t, []*imap.Message{older, source[3]}, unique)
+	assert.Equal(t, []*imap.Message{source[0], newer}, duplicates)
+	// The caller's order is left alone.
+	assert.Equal(t, uint32(10), source[0].Uid)
+	assert.Len(t, existing, 2)
+}
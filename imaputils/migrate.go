@@ -0,0 +1,238 @@
+package imaputils
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/wryfi/shemail/progress"
+)
+
+// Location is a folder in an account, as migrate's --from and --to name it:
+// "account:folder".
+type Location struct {
+	Account Account
+	// Folder is the folder's name on the server for a source, and a
+	// "/"-separated path (translated to the server's delimiter) for a
+	// destination.
+	Folder string
+}
+
+func (location Location) String() string {
+	return location.Account.Name + ":" + location.Folder
+}
+
+// MigrationProgress records how far the migration of one folder has got, so
+// that an interrupted migration can resume instead of starting over.
+type MigrationProgress struct {
+	// UidValidity is the source folder's UIDVALIDITY that LastUID belongs
+	// to.
+	UidValidity uint32 `json:"uid_validity"`
+	// LastUID is the highest source UID that has been copied or skipped as a
+	// duplicate; only messages above it remain.
+	LastUID    uint32    `json:"last_uid"`
+	Copied     int       `json:"copied"`
+	Duplicates int       `json:"duplicates"`
+	Updated    time.Time `json:"updated"`
+}
+
+// MigrationKey builds the progress store key for migrating source to
+// destination.
+func MigrationKey(source, destination Location) string {
+	return source.String() + ">" + destination.String()
+}
+
+// MigrationTarget returns the destination path for folder when the tree under
+// sourcePrefix (a server folder name, or "" for the whole account) is
+// migrated under destinationPrefix (a "/"-separated path, or "" for the top
+// level). The hierarchy below the prefix is re-expressed with "/", so that
+// EnsureFolder recreates it with the destination server's own delimiter. It
+// returns false if folder is not in the tree.
+func MigrationTarget(folder FolderStatus, sourcePrefix, destinationPrefix string) (string, bool) {
+	relative := folder.Name
+	if sourcePrefix != "" {
+		switch {
+		case folder.Name == sourcePrefix:
+			relative = ""
+		case folder.Delimiter != "" && strings.HasPrefix(folder.Name, sourcePrefix+folder.Delimiter):
+			relative = strings.TrimPrefix(folder.Name, sourcePrefix+folder.Delimiter)
+		default:
+			return "", false
+		}
+	}
+	if folder.Delimiter != "" && folder.Delimiter != "/" {
+		// A "/" within a level cannot be kept; it would become a new level.
+		relative = strings.ReplaceAll(relative, "/", "_")
+		relative = strings.ReplaceAll(relative, folder.Delimiter, "/")
+	}
+	switch {
+	case destinationPrefix == "":
+		return relative, relative != ""
+	case relative == "":
+		return destinationPrefix, true
+	}
+	return destinationPrefix + "/" + relative, true
+}
+
+// MigrateFolder copies the messages of the source folder to the destination
+// folder, which may be in another account on another server, creating it if
+// needed. Complete messages are fetched from the source (read-only, with
+// BODY.PEEK[]) and APPENDed to the destination with their flags and
+// INTERNALDATE. Messages whose Message-ID is already in the destination are
+// skipped, so running a migration again never duplicates anything.
+//
+// Only source messages above state.LastUID are copied, unless the source's
+// UIDVALIDITY has changed since, in which case every message is considered
+// again. After every RawChunkSize messages, and at the end, save is called
+// with the progress so far; if it fails, the migration stops. The progress
+// reached is returned even on error.
+func MigrateFolder(dialer IMAPDialer, source, destination Location, state MigrationProgress, save func(MigrationProgress) error) (MigrationProgress, error) {
+	sourceClient, status, err := selectMailbox(dialer, source.Account, source.Folder, true)
+	if err != nil {
+		return state, fmt.Errorf("failed to select %s: %w", source, err)
+	}
+	defer sourceClient.Logout()
+
+	if state.UidValidity != status.UidValidity {
+		if state.UidValidity != 0 {
+			log.Warn().Msgf("UIDVALIDITY of %s changed (%d -> %d); checking every message again", source, state.UidValidity, status.UidValidity)
+		}
+		state.UidValidity = status.UidValidity
+		state.LastUID = 0
+	}
+	uids, err := uidsAbove(sourceClient, state.LastUID)
+	if err != nil {
+		return state, err
+	}
+
+	if err := EnsureFolder(dialer, destination.Account, destination.Folder); err != nil {
+		return state, fmt.Errorf("failed to ensure folder %s exists: %w", destination, err)
+	}
+	destinationClient, err := getImapClient(dialer, destination.Account)
+	if err != nil {
+		return state, fmt.Errorf("failed to initialize imap client: %w", err)
+	}
+	defer destinationClient.Logout()
+	mailbox, err := serverFolderName(destinationClient, destination.Folder)
+	if err != nil {
+		return state, err
+	}
+	existing, err := folderMessageIDs(dialer, destination.Account, mailbox)
+	if err != nil {
+		return state, err
+	}
+
+	task := progress.Start("migrating "+source.String(), "messages", len(uids))
+	defer task.Done()
+
+	// Work chunk by chunk, so that LastUID only passes messages that have
+	// all been dealt with.
+	for start := 0; start < len(uids); start += RawChunkSize {
+		chunk := uids[start:min(start+RawChunkSize, len(uids))]
+		messages, err := fetchItemsByUID(sourceClient, chunk, rawFetchItems(), task)
+		if err != nil {
+			return state, fmt.Errorf("failed to download messages from %s: %w", source, err)
+		}
+		for _, message := range messages {
+			if err := migrateMessage(destinationClient, mailbox, message, existing, &state); err != nil {
+				return state, fmt.Errorf("failed to copy message %d of %s to %s: %w", message.Uid, source, destination, err)
+			}
+		}
+
+		state.LastUID = chunk[len(chunk)-1]
+		state.Updated = time.Now()
+		if err := save(state); err != nil {
+			return state, err
+		}
+	}
+	state.Updated = time.Now()
+	return state, save(state)
+}
+
+// uidsAbove returns the UIDs above uid in the selected folder, in order.
+func uidsAbove(imapClient IMAPClient, uid uint32) ([]uint32, error) {
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(uid+1, 0)
+	uids, err := findMessageUIDs(imapClient, criteria)
+	if err != nil {
+		return nil, err
+	}
+	// "n:*" always matches the highest UID, even when it is below n.
+	uids = slices.DeleteFunc(uids, func(candidate uint32) bool { return candidate <= uid })
+	slices.Sort(uids)
+	return uids, nil
+}
+
+// migrateMessage APPENDs one downloaded message to mailbox unless its
+// Message-ID is in existing, counting it in state either way.
+func migrateMessage(imapClient IMAPClient, mailbox string, message *imap.Message, existing map[string]bool, state *MigrationProgress) error {
+	id := ""
+	if message.Envelope != nil {
+		id = strings.TrimSpace(message.Envelope.MessageId)
+	}
+	if id != "" && existing[id] {
+		log.Debug().Msgf("skipping %s: already in %s", id, mailbox)
+		state.Duplicates++
+		return nil
+	}
+
+	stored, err := StoreMessage(message)
+	if err != nil {
+		return err
+	}
+	if err := imapClient.Append(mailbox, importFlags(stored.Flags), stored.Date, bytes.NewBuffer(stored.Body)); err != nil {
+		return err
+	}
+	if id != "" {
+		existing[id] = true
+	}
+	state.Copied++
+	return nil
+}
+
+// VerifyMigration checks which messages of the source folder, up to and
+// including UID lastUID, made it to the destination folder, by Message-ID. It
+// returns those that did, which are safe to delete from the source, and the
+// number that could not be verified: those missing from the destination and
+// those without a Message-ID.
+func VerifyMigration(dialer IMAPDialer, source, destination Location, lastUID uint32) ([]*imap.Message, int, error) {
+	if lastUID == 0 {
+		return nil, 0, nil
+	}
+	destinationClient, err := getImapClient(dialer, destination.Account)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to initialize imap client: %w", err)
+	}
+	mailbox, err := serverFolderName(destinationClient, destination.Folder)
+	destinationClient.Logout()
+	if err != nil {
+		return nil, 0, err
+	}
+	copied, err := folderMessageIDs(dialer, destination.Account, mailbox)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(1, lastUID)
+	var (
+		verified   []*imap.Message
+		unverified int
+	)
+	for message, err := range StreamMessages(dialer, source.Account, source.Folder, criteria, DefaultChunkSize) {
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to list messages in %s: %w", source, err)
+		}
+		if message.Envelope != nil && copied[strings.TrimSpace(message.Envelope.MessageId)] {
+			verified = append(verified, message)
+		} else {
+			unverified++
+		}
+	}
+	return verified, unverified, nil
+}
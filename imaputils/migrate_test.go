@@ -0,0 +1,101 @@
+package imaputils
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMigrationTarget(t *testing.T) {
+	tests := []struct {
+		name              string
+		folder            FolderStatus
+		sourcePrefix      string
+		destinationPrefix string
+		want              string
+		wantOK            bool
+	}{
+		{"single folder", FolderStatus{Name: "Archive", Delimiter: "/"}, "Archive", "Old", "Old", true},
+		{"subfolder, translating the delimiter", FolderStatus{Name: "Archive.2020.Q1", Delimiter: "."}, "Archive", "Old", "Old/2020/Q1", true},
+		{"slash within a level", FolderStatus{Name: "INBOX.a/b", Delimiter: "."}, "", "", "INBOX/a_b", true},
+		{"whole account to the top level", FolderStatus{Name: "Sent", Delimiter: "/"}, "", "", "Sent", true},
+		{"whole account under a folder", FolderStatus{Name: "Sent", Delimiter: "/"}, "", "icloud", "icloud/Sent", true},
+		{"outside the tree", FolderStatus{Name: "Archived", Delimiter: "/"}, "Archive", "Old", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := MigrationTarget(tt.folder, tt.sourcePrefix, tt.destinationPrefix)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMigrateFolder(t *testing.T) {
+	date := time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC)
+	bodySection, _ := imap.ParseBodySectionName("BODY[]")
+	rawMessage := func(uid uint32, id, body string) *imap.Message {
+		return &imap.Message{
+			Uid:          uid,
+			InternalDate: date,
+			Flags:        []string{imap.SeenFlag, imap.RecentFlag},
+			Envelope:     &imap.Envelope{MessageId: id},
+			Body:         map[*imap.BodySectionName]imap.Literal{bodySection: bytes.NewBufferString(body)},
+		}
+	}
+
+	// One mock server plays both accounts: INBOX is the source and Archive
+	// the destination.
+	client := &MockIMAPClientMove{}
+	dialer := &MockIMAPDialerMove{}
+	dialer.On("Dial", mock.Anything).Return(client, nil)
+	client.On("Login", mock.Anything, mock.Anything).Return(nil)
+	client.On("Logout").Return(nil)
+	client.On("Capability").Return(map[string]bool{}, nil)
+	client.On("Select", "INBOX", true).Return(&imap.MailboxStatus{UidValidity: 5}, nil)
+	client.On("Select", "Archive", true).Return(&imap.MailboxStatus{}, nil)
+	client.On("List", "", "", mock.Anything).Return(
+		func(ch chan *imap.MailboxInfo) { ch <- &imap.MailboxInfo{Delimiter: "/"} }, nil)
+	client.On("List", "", "Archive", mock.Anything).Return(
+		func(ch chan *imap.MailboxInfo) { ch <- &imap.MailboxInfo{Name: "Archive"} }, nil)
+
+	hasUIDs := func(hasUIDs bool) interface{} {
+		return mock.MatchedBy(func(criteria *imap.SearchCriteria) bool { return (criteria.Uid != nil) == hasUIDs })
+	}
+	// "3:*" matches UID 2 when it is the highest; it is still filtered out.
+	client.On("UidSearch", hasUIDs(true)).Return([]uint32{2, 3, 4, 5}, nil)
+	client.On("UidSearch", hasUIDs(false)).Return([]uint32{9}, nil)
+	client.On("UidFetch", mock.Anything, getFetchItems(), mock.Anything).Return(
+		func(ch chan *imap.Message) {
+			ch <- &imap.Message{Uid: 9, Envelope: &imap.Envelope{MessageId: "<old@example.com>"}}
+		}, nil)
+	client.On("UidFetch", mock.Anything, rawFetchItems(), mock.Anything).Return(
+		func(ch chan *imap.Message) {
+			ch <- rawMessage(3, "<old@example.com>", "Subject: old\r\n\r\n")
+			ch <- rawMessage(4, "<new@example.com>", "Subject: new\r\n\r\n")
+			ch <- rawMessage(5, "", "Subject: no id\r\n\r\n")
+		}, nil)
+	client.On("Append", "Archive", []string{imap.SeenFlag}, date, mock.Anything).Return(nil)
+
+	var saved []MigrationProgress
+	save := func(state MigrationProgress) error {
+		saved = append(saved, state)
+		return nil
+	}
+	source := Location{Account: Account{Name: "icloud"}, Folder: "INBOX"}
+	destination := Location{Account: Account{Name: "fastmail"}, Folder: "Archive"}
+	state, err := MigrateFolder(dialer, source, destination, MigrationProgress{UidValidity: 5, LastUID: 2}, save)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(5), state.LastUID)
+	assert.Equal(t, 2, state.Copied)
+	assert.Equal(t, 1, state.Duplicates, "the message already in the destination is skipped")
+	client.AssertNumberOfCalls(t, "Append", 2)
+	if assert.NotEmpty(t, saved) {
+		assert.Equal(t, state, saved[len(saved)-1])
+	}
+	assert.Equal(t, "icloud:INBOX>fastmail:Archive", MigrationKey(source, destination))
+}
@@ -3,35 +3,61 @@ package imaputils
 import (
 	"fmt"
 	"github.com/emersion/go-imap"
+	"github.com/wryfi/shemail/progress"
 	"golang.org/x/sync/errgroup"
 	"strings"
 )
 
-// MoveMessages moves a slice of messages to the specified destination folder.
-// It uses concurrent operations to optimize performance for large message sets.
-func MoveMessages(dialer IMAPDialer, account Account, messages []*imap.Message, sourceFolder, destFolder string, batchSize int) error {
+// MoveMessages moves a slice of messages to the specified destination folder
+// and returns where they ended up. It uses concurrent operations to optimize
+// performance for large message sets.
+func MoveMessages(dialer IMAPDialer, account Account, messages []*imap.Message, sourceFolder, destFolder string, batchSize int) (*Transfer, error) {
+	return moveMessages(dialer, account, messages, sourceFolder, destFolder, batchSize, "")
+}
+
+// moveMessages is MoveMessages, given the account's trash folder when the
+// caller has resolved it (as deletes do), or "". Moves into a known trash
+// folder on Gmail take the copy-and-expunge path of moveToGmailTrash.
+func moveMessages(dialer IMAPDialer, account Account, messages []*imap.Message, sourceFolder, destFolder string, batchSize int, trashFolder string) (*Transfer, error) {
+	transfer := newTransfer(sourceFolder, destFolder)
 	if len(messages) == 0 {
-		return nil
+		return transfer, nil
 	}
 
 	// Special case for Gmail trash
-	if strings.Contains(account.Server, "gmail.com") && destFolder == "[Gmail]/Trash" {
-		return moveToGmailTrash(dialer, account, sourceFolder, messages)
+	if isGmail(account) && trashFolder != "" && destFolder == trashFolder {
+		if err := moveToGmailTrash(dialer, account, sourceFolder, trashFolder, messages, transfer); err != nil {
+			return nil, err
+		}
+		return transfer, nil
 	}
 
 	// Validate connectivity and that the source folder is selectable before we
 	// mutate anything (EnsureFolder below creates the destination). This fails
 	// fast on bad credentials or a missing source folder, so we don't leave a
 	// stray destination folder behind on an otherwise-doomed move.
-	precheckClient, err := connectToMailbox(dialer, account, sourceFolder, false)
+	precheckClient, sourceStatus, err := selectMailbox(dialer, account, sourceFolder, false)
 	if err != nil {
-		return fmt.Errorf("failed to connect to server: %w", err)
+		return nil, fmt.Errorf("failed to connect to server: %w", err)
 	}
 	defer precheckClient.Logout()
+	transfer.recordSource(sourceStatus)
+
+	// Servers without MOVE need COPY, STORE \Deleted and an expunge; choose
+	// how before creating anything, and refuse a plain EXPUNGE that would
+	// take unrelated messages with it.
+	method, err := chooseMoveMethod(precheckClient, account, sourceFolder, messages)
+	if err != nil {
+		return nil, err
+	}
+	transfer.Method = method
+	if method != MoveCommand {
+		log.Warn().Msgf("server does not support MOVE; moving %d messages from %s to %s with %s", len(messages), sourceFolder, destFolder, method)
+	}
 
 	// Ensure destination folder exists
 	if err := EnsureFolder(dialer, account, destFolder); err != nil {
-		return err
+		return nil, err
 	}
 
 	if batchSize <= 0 {
@@ -48,6 +74,9 @@ func MoveMessages(dialer IMAPDialer, account Account, messages []*imap.Message,
 		batches = append(batches, messages[i:end])
 	}
 
+	task := progress.Start("moving to "+destFolder, "batches", len(batches))
+	defer task.Done()
+
 	// Process batches concurrently with separate connections
 	g := new(errgroup.Group)
 	for _, batch := range batches {
@@ -65,21 +94,24 @@ func MoveMessages(dialer IMAPDialer, account Account, messages []*imap.Message,
 				seqSet.AddNum(msg.Uid)
 			}
 
-			if err := client.UidMove(seqSet, destFolder); err != nil {
+			copyUID, err := moveBatch(client, method, seqSet, destFolder)
+			if err != nil {
 				return fmt.Errorf("failed to move batch: %w", err)
 			}
+			transfer.record(copyUID)
+			task.Add(1)
 			return nil
 		})
 	}
 
 	if err := g.Wait(); err != nil {
-		return fmt.Errorf("error moving messages: %w", err)
+		return nil, fmt.Errorf("error moving messages: %w", err)
 	}
 
 	// Verification could also use a fresh connection
 	verifyClient, err := connectToMailbox(dialer, account, sourceFolder, false)
 	if err != nil {
-		return fmt.Errorf("failed to connect for verification: %w", err)
+		return nil, fmt.Errorf("failed to connect for verification: %w", err)
 	}
 	defer verifyClient.Logout()
 
@@ -102,10 +134,79 @@ func MoveMessages(dialer IMAPDialer, account Account, messages []*imap.Message,
 	// As before, only treat this as a failure when the fetch itself succeeded;
 	// a verification fetch error is not taken as proof the move failed.
 	if err := <-done; err == nil && len(stillPresent) > 0 {
-		return fmt.Errorf("%d message(s) still found in source folder after move (e.g. UID %d)", len(stillPresent), stillPresent[0])
+		return nil, fmt.Errorf("%d message(s) still found in source folder after move (e.g. UID %d)", len(stillPresent), stillPresent[0])
 	}
 
-	return nil
+	return transfer, nil
+}
+
+// MoveMethod is how MoveMessages moves messages on a given server.
+type MoveMethod string
+
+const (
+	// MoveCommand is the MOVE extension (RFC 6851): atomic, and nothing else
+	// in the source folder is touched.
+	MoveCommand MoveMethod = "MOVE"
+	// MoveCopyUIDExpunge copies, flags the originals \Deleted and removes
+	// exactly those with UID EXPUNGE (UIDPLUS, RFC 4315).
+	MoveCopyUIDExpunge MoveMethod = "COPY, STORE \\Deleted and UID EXPUNGE"
+	// MoveCopyExpunge is the last resort: a plain EXPUNGE removes every
+	// message flagged \Deleted in the source folder, not only the moved ones.
+	MoveCopyExpunge MoveMethod = "COPY, STORE \\Deleted and EXPUNGE"
+)
+
+// chooseMoveMethod picks the best MoveMethod the server supports. Without
+// either MOVE or UIDPLUS, it checks the source folder for other messages
+// already flagged \Deleted, which the plain EXPUNGE would permanently remove
+// too, and refuses unless the account allows it.
+func chooseMoveMethod(imapClient IMAPClient, account Account, folder string, messages []*imap.Message) (MoveMethod, error) {
+	caps, err := imapClient.Capability()
+	if err != nil {
+		return "", fmt.Errorf("failed to get capabilities: %w", err)
+	}
+	if caps["MOVE"] {
+		return MoveCommand, nil
+	}
+
+	plan, err := planExpunge(imapClient, folder, messages)
+	if err != nil {
+		return "", err
+	}
+	if plan.Targeted {
+		return MoveCopyUIDExpunge, nil
+	}
+	if err := plan.Check(account, "move"); err != nil {
+		return "", err
+	}
+	if len(plan.Others) > 0 {
+		log.Warn().Msgf("the EXPUNGE that completes this move will also PERMANENTLY REMOVE %d other messages already flagged \\Deleted in %s", len(plan.Others), folder)
+	}
+	return MoveCopyExpunge, nil
+}
+
+// moveBatch moves the messages in seqSet to destFolder by method.
+func moveBatch(imapClient IMAPClient, method MoveMethod, seqSet *imap.SeqSet, destFolder string) (*CopyUID, error) {
+	if method == MoveCommand {
+		return imapClient.UidMove(seqSet, destFolder)
+	}
+
+	copyUID, err := imapClient.UidCopy(seqSet, destFolder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy messages: %w", err)
+	}
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	if err := imapClient.UidStore(seqSet, item, []interface{}{imap.DeletedFlag}, nil); err != nil {
+		return nil, fmt.Errorf("failed to flag copied messages as deleted: %w", err)
+	}
+	if method == MoveCopyUIDExpunge {
+		err = imapClient.UidExpunge(seqSet)
+	} else {
+		err = imapClient.Expunge(nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to expunge copied messages: %w", err)
+	}
+	return copyUID, nil
 }
 
 // EnsureFolder checks if a folder exists and creates it if it doesn't.
@@ -150,6 +251,12 @@ func EnsureFolder(dialer IMAPDialer, account Account, folderName string) error {
 	}
 
 	// Folder doesn't exist; create it and any necessary parent folders.
+	return createPath(imapClient, segments, delimiter)
+}
+
+// createPath creates each level of the folder path segments, joined with the
+// server's hierarchy delimiter, that does not exist yet.
+func createPath(imapClient IMAPClient, segments []string, delimiter string) error {
 	currentPath := ""
 	for index, segment := range segments {
 		if index > 0 {
@@ -172,6 +279,34 @@ func EnsureFolder(dialer IMAPDialer, account Account, folderName string) error {
 	return nil
 }
 
+// ServerFolderName returns the server's name for a "/"-separated folder
+// path, which differs when the server's hierarchy delimiter is not "/".
+func ServerFolderName(dialer IMAPDialer, account Account, folder string) (string, error) {
+	imapClient, err := getImapClient(dialer, account)
+	if err != nil {
+		return "", fmt.Errorf("failed to init imap client: %w", err)
+	}
+	defer imapClient.Logout()
+	return serverFolderName(imapClient, folder)
+}
+
+// serverFolderName translates a "/"-separated folder path (the shemail
+// convention) into the server's name for the folder, as EnsureFolder does
+// when it creates it.
+func serverFolderName(imapClient IMAPClient, folder string) (string, error) {
+	if !strings.Contains(folder, "/") {
+		return folder, nil
+	}
+	delimiter, err := getHierarchyDelimiter(imapClient)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine hierarchy delimiter: %w", err)
+	}
+	if delimiter == "" {
+		return folder, nil
+	}
+	return strings.ReplaceAll(folder, "/", delimiter), nil
+}
+
 // getHierarchyDelimiter returns the server's mailbox hierarchy delimiter,
 // discovered via a LIST with empty reference and mailbox name (RFC 3501).
 // Returns an empty string if the server reports no delimiter.
@@ -218,19 +353,36 @@ func mailboxExists(imapClient IMAPClient, name string) (bool, error) {
 	return exists, nil
 }
 
-func moveToGmailTrash(dialer IMAPDialer, account Account, folder string, messages []*imap.Message) error {
-	imapClient, err := connectToMailbox(dialer, account, folder, false)
+// isGmail reports whether account is on Gmail's IMAP server.
+func isGmail(account Account) bool {
+	return strings.Contains(account.Server, "gmail.com") || strings.Contains(account.Server, "googlemail.com")
+}
+
+// moveToGmailTrash moves messages from folder to Gmail's trash folder, trash.
+func moveToGmailTrash(dialer IMAPDialer, account Account, folder, trash string, messages []*imap.Message, transfer *Transfer) error {
+	imapClient, status, err := selectMailbox(dialer, account, folder, false)
 	if err != nil {
 		return fmt.Errorf("failed to connect to mailbox: %w", err)
 	}
 	defer imapClient.Logout()
+	transfer.recordSource(status)
+
+	plan, err := planExpunge(imapClient, folder, messages)
+	if err != nil {
+		return err
+	}
+	if err := plan.Check(account, "move to trash"); err != nil {
+		return err
+	}
 
 	seqSet := createSeqSet(messages)
 
 	// First copy to Trash using UID
-	if err := imapClient.UidCopy(seqSet, "[Gmail]/Trash"); err != nil {
+	copyUID, err := imapClient.UidCopy(seqSet, trash)
+	if err != nil {
 		return fmt.Errorf("failed to copy messages to trash: %w", err)
 	}
+	transfer.record(copyUID)
 
 	// Then use UID STORE to remove the original folder's label
 	item := imap.FormatFlagsOp(imap.AddFlags, true)
@@ -239,8 +391,8 @@ func moveToGmailTrash(dialer IMAPDialer, account Account, folder string, message
 		return fmt.Errorf("failed to flag messages as deleted: %w", err)
 	}
 
-	// Use EXPUNGE to remove messages from original folder
-	if err := imapClient.Expunge(nil); err != nil {
+	// Remove them from the original folder, and only them where possible
+	if err := plan.expunge(imapClient, seqSet); err != nil {
 		return fmt.Errorf("failed to expunge messages: %w", err)
 	}
 
@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"testing"
+	"time"
 )
 
 // MockIMAPClientMove implements IMAPClient interface for testing
@@ -15,6 +16,11 @@ type MockIMAPClientMove struct {
 	mock.Mock
 }
 
+func (m *MockIMAPClientMove) Append(mbox string, flags []string, date time.Time, msg imap.Literal) error {
+	args := m.Called(mbox, flags, date, msg)
+	return args.Error(0)
+}
+
 func (m *MockIMAPClientMove) Capability() (map[string]bool, error) {
 	args := m.Called()
 	return args.Get(0).(map[string]bool), args.Error(1)
@@ -25,6 +31,11 @@ func (m *MockIMAPClientMove) Create(name string) error {
 	return args.Error(0)
 }
 
+func (m *MockIMAPClientMove) Delete(name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+
 func (m *MockIMAPClientMove) Expunge(ch chan uint32) error {
 	args := m.Called(ch)
 	// Only close the channel if it's not nil
@@ -49,6 +60,35 @@ func (m *MockIMAPClientMove) GetClient() *client.Client {
 	return nil
 }
 
+func (m *MockIMAPClientMove) GetQuota(root string) (Quota, error) {
+	args := m.Called(root)
+	return args.Get(0).(Quota), args.Error(1)
+}
+
+func (m *MockIMAPClientMove) GetQuotaRoot(mailbox string) ([]string, []Quota, error) {
+	args := m.Called(mailbox)
+	var (
+		roots  []string
+		quotas []Quota
+	)
+	if ret := args.Get(0); ret != nil {
+		roots = ret.([]string)
+	}
+	if ret := args.Get(1); ret != nil {
+		quotas = ret.([]Quota)
+	}
+	return roots, quotas, args.Error(2)
+}
+
+func (m *MockIMAPClientMove) Idle(stop <-chan struct{}, opts *client.IdleOptions) error {
+	args := m.Called(stop, opts)
+	return args.Error(0)
+}
+
+func (m *MockIMAPClientMove) Updates() <-chan client.Update {
+	return nil
+}
+
 func (m *MockIMAPClientMove) List(ref string, name string, ch chan *imap.MailboxInfo) error {
 	args := m.Called(ref, name, ch)
 	if fn, ok := args.Get(0).(func(chan *imap.MailboxInfo)); ok {
@@ -58,6 +98,15 @@ func (m *MockIMAPClientMove) List(ref string, name string, ch chan *imap.Mailbox
 	return args.Error(1)
 }
 
+func (m *MockIMAPClientMove) Lsub(ref string, name string, ch chan *imap.MailboxInfo) error {
+	args := m.Called(ref, name, ch)
+	if fn, ok := args.Get(0).(func(chan *imap.MailboxInfo)); ok {
+		fn(ch)
+	}
+	close(ch)
+	return args.Error(1)
+}
+
 func (m *MockIMAPClientMove) Login(username string, password string) error {
 	args := m.Called(username, password)
 	return args.Error(0)
@@ -68,6 +117,11 @@ func (m *MockIMAPClientMove) Logout() error {
 	return args.Error(0)
 }
 
+func (m *MockIMAPClientMove) Rename(existingName, newName string) error {
+	args := m.Called(existingName, newName)
+	return args.Error(0)
+}
+
 func (m *MockIMAPClientMove) Select(name string, readOnly bool) (*imap.MailboxStatus, error) {
 	args := m.Called(name, readOnly)
 	if ret := args.Get(0); ret != nil {
@@ -84,8 +138,18 @@ func (m *MockIMAPClientMove) Status(name string, items []imap.StatusItem) (*imap
 	return nil, args.Error(1)
 }
 
-func (m *MockIMAPClientMove) UidCopy(seqset *imap.SeqSet, mailbox string) error {
+func (m *MockIMAPClientMove) Subscribe(name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+
+func (m *MockIMAPClientMove) UidCopy(seqset *imap.SeqSet, mailbox string) (*CopyUID, error) {
 	args := m.Called(seqset, mailbox)
+	return mockCopyUID(args), args.Error(0)
+}
+
+func (m *MockIMAPClientMove) UidExpunge(seqSet *imap.SeqSet) error {
+	args := m.Called(seqSet)
 	return args.Error(0)
 }
 
@@ -98,9 +162,24 @@ func (m *MockIMAPClientMove) UidFetch(seqset *imap.SeqSet, items []imap.FetchIte
 	return args.Error(1)
 }
 
-func (m *MockIMAPClientMove) UidMove(seqSet *imap.SeqSet, mailbox string) error {
+func (m *MockIMAPClientMove) UidFetchChangedSince(seqset *imap.SeqSet, items []imap.FetchItem, modSeq uint64, ch chan *imap.Message) error {
+	close(ch)
+	return nil
+}
+
+func (m *MockIMAPClientMove) UidMove(seqSet *imap.SeqSet, mailbox string) (*CopyUID, error) {
 	args := m.Called(seqSet, mailbox)
-	return args.Error(0)
+	return mockCopyUID(args), args.Error(0)
+}
+
+// mockCopyUID returns the COPYUID a copy or move expectation was set up with
+// as its optional second return value, e.g. Return(nil, &CopyUID{...}).
+func mockCopyUID(args mock.Arguments) *CopyUID {
+	if len(args) < 2 {
+		return nil
+	}
+	copyUID, _ := args.Get(1).(*CopyUID)
+	return copyUID
 }
 
 func (m *MockIMAPClientMove) UidSearch(criteria *imap.SearchCriteria) ([]uint32, error) {
@@ -125,6 +204,11 @@ func (m *MockIMAPClientMove) UidStore(seqSet *imap.SeqSet, item imap.StoreItem,
 	return args.Error(1)
 }
 
+func (m *MockIMAPClientMove) Unsubscribe(name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+
 // MockIMAPDialerMove implements IMAPDialer interface for testing
 type MockIMAPDialerMove struct {
 	mock.Mock
@@ -143,10 +227,12 @@ func (m *MockIMAPDialerMove) DialTLS(address string, config *tls.Config) (IMAPCl
 // Test cases
 func TestMoveMessages(t *testing.T) {
 	tests := []struct {
-		name          string
-		messages      []*imap.Message
-		sourceFolder  string
-		destFolder    string
+		name         string
+		messages     []*imap.Message
+		sourceFolder string
+		destFolder   string
+		// trashFolder is the trash folder a delete would have resolved.
+		trashFolder   string
 		batchSize     int
 		account       Account
 		setupMocks    func(*MockIMAPClientMove, *MockIMAPDialerMove)
@@ -200,6 +286,7 @@ func TestMoveMessages(t *testing.T) {
 				)
 
 				// Move operations for each batch (one message per batch)
+				client.On("Capability").Return(map[string]bool{"MOVE": true}, nil)
 				client.On("UidMove", mock.MatchedBy(func(seqSet *imap.SeqSet) bool {
 					return true // Add more specific validation if needed
 				}), "Archive").Return(nil).Times(2)
@@ -225,6 +312,7 @@ func TestMoveMessages(t *testing.T) {
 			},
 			sourceFolder: "INBOX",
 			destFolder:   "[Gmail]/Trash",
+			trashFolder:  "[Gmail]/Trash",
 			batchSize:    1,
 			account: Account{
 				Server:   "imap.gmail.com",
@@ -236,6 +324,8 @@ func TestMoveMessages(t *testing.T) {
 				dialer.On("Dial", mock.Anything).Return(client, nil)
 				client.On("Login", mock.Anything, mock.Anything).Return(nil)
 				client.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+				client.On("Capability").Return(map[string]bool{}, nil)
+				client.On("UidSearch", mock.Anything).Return([]uint32(nil), nil)
 				client.On("UidCopy", mock.MatchedBy(func(s *imap.SeqSet) bool {
 					return true
 				}), "[Gmail]/Trash").Return(nil)
@@ -250,6 +340,42 @@ func TestMoveMessages(t *testing.T) {
 			},
 			expectedError: "",
 		},
+		{
+			name: "move to localized gmail trash",
+			messages: []*imap.Message{
+				{Uid: 1},
+				{Uid: 2},
+			},
+			sourceFolder: "INBOX",
+			destFolder:   "[Google Mail]/Bin",
+			trashFolder:  "[Google Mail]/Bin",
+			batchSize:    1,
+			account: Account{
+				Server:   "imap.googlemail.com",
+				User:     "test@gmail.com",
+				Password: "password",
+			},
+			setupMocks: func(client *MockIMAPClientMove, dialer *MockIMAPDialerMove) {
+				// For Gmail trash moves, we only need one connection
+				dialer.On("Dial", mock.Anything).Return(client, nil)
+				client.On("Login", mock.Anything, mock.Anything).Return(nil)
+				client.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+				client.On("Capability").Return(map[string]bool{}, nil)
+				client.On("UidSearch", mock.Anything).Return([]uint32(nil), nil)
+				client.On("UidCopy", mock.MatchedBy(func(s *imap.SeqSet) bool {
+					return true
+				}), "[Google Mail]/Bin").Return(nil)
+				client.On("UidStore",
+					mock.MatchedBy(func(s *imap.SeqSet) bool { return true }),
+					imap.FormatFlagsOp(imap.AddFlags, true),
+					[]interface{}{imap.DeletedFlag},
+					(chan *imap.Message)(nil),
+				).Return(nil, nil)
+				client.On("Expunge", (chan uint32)(nil)).Return(nil)
+				client.On("Logout").Return(nil).Once()
+			},
+			expectedError: "",
+		},
 		{
 			name: "gmail trash copy failure",
 			messages: []*imap.Message{
@@ -257,6 +383,7 @@ func TestMoveMessages(t *testing.T) {
 			},
 			sourceFolder: "INBOX",
 			destFolder:   "[Gmail]/Trash",
+			trashFolder:  "[Gmail]/Trash",
 			batchSize:    1,
 			account: Account{
 				Server:   "imap.gmail.com",
@@ -267,6 +394,8 @@ func TestMoveMessages(t *testing.T) {
 				dialer.On("Dial", mock.Anything).Return(client, nil)
 				client.On("Login", mock.Anything, mock.Anything).Return(nil)
 				client.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+				client.On("Capability").Return(map[string]bool{}, nil)
+				client.On("UidSearch", mock.Anything).Return([]uint32(nil), nil)
 				client.On("UidCopy", mock.Anything, "[Gmail]/Trash").Return(fmt.Errorf("copy failed"))
 				// Even in failure case, we should expect a logout
 				client.On("Logout").Return(nil).Once()
@@ -280,6 +409,7 @@ func TestMoveMessages(t *testing.T) {
 			},
 			sourceFolder: "INBOX",
 			destFolder:   "[Gmail]/Trash",
+			trashFolder:  "[Gmail]/Trash",
 			batchSize:    1,
 			account: Account{
 				Server:   "imap.gmail.com",
@@ -290,6 +420,8 @@ func TestMoveMessages(t *testing.T) {
 				dialer.On("Dial", mock.Anything).Return(client, nil)
 				client.On("Login", mock.Anything, mock.Anything).Return(nil)
 				client.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+				client.On("Capability").Return(map[string]bool{}, nil)
+				client.On("UidSearch", mock.Anything).Return([]uint32(nil), nil)
 				client.On("UidCopy", mock.Anything, "[Gmail]/Trash").Return(nil)
 				client.On("UidStore",
 					mock.Anything,
@@ -309,6 +441,7 @@ func TestMoveMessages(t *testing.T) {
 			},
 			sourceFolder: "INBOX",
 			destFolder:   "[Gmail]/Trash",
+			trashFolder:  "[Gmail]/Trash",
 			batchSize:    1,
 			account: Account{
 				Server:   "imap.gmail.com",
@@ -319,6 +452,8 @@ func TestMoveMessages(t *testing.T) {
 				dialer.On("Dial", mock.Anything).Return(client, nil)
 				client.On("Login", mock.Anything, mock.Anything).Return(nil)
 				client.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+				client.On("Capability").Return(map[string]bool{}, nil)
+				client.On("UidSearch", mock.Anything).Return([]uint32(nil), nil)
 				client.On("UidCopy", mock.Anything, "[Gmail]/Trash").Return(nil)
 				client.On("UidStore",
 					mock.Anything,
@@ -359,7 +494,7 @@ func TestMoveMessages(t *testing.T) {
 			mockDialer := &MockIMAPDialerMove{}
 			tt.setupMocks(mockClient, mockDialer)
 
-			err := MoveMessages(mockDialer, tt.account, tt.messages, tt.sourceFolder, tt.destFolder, tt.batchSize)
+			_, err := moveMessages(mockDialer, tt.account, tt.messages, tt.sourceFolder, tt.destFolder, tt.batchSize, tt.trashFolder)
 
 			if tt.expectedError != "" {
 				assert.Error(t, err)
@@ -379,6 +514,70 @@ func TestMoveMessages(t *testing.T) {
 		})
 	}
 }
+func TestMoveMessagesWithoutMove(t *testing.T) {
+	isDeletedSearch := mock.MatchedBy(func(criteria *imap.SearchCriteria) bool {
+		return len(criteria.WithFlags) == 1 && criteria.WithFlags[0] == imap.DeletedFlag
+	})
+	tests := []struct {
+		name          string
+		caps          map[string]bool
+		alreadyDelete []uint32
+		allowUnsafe   bool
+		wantMethod    MoveMethod
+		wantExpunge   string // "uid", "plain" or "" for none
+		wantError     string
+	}{
+		{"UIDPLUS expunges only the moved messages", map[string]bool{"UIDPLUS": true}, nil, false, MoveCopyUIDExpunge, "uid", ""},
+		{"plain EXPUNGE when nothing else is flagged", map[string]bool{}, []uint32{1}, false, MoveCopyExpunge, "plain", ""},
+		{"refuses to expunge other deleted messages", map[string]bool{}, []uint32{1, 9}, false, "", "", "1 other messages already flagged"},
+		{"expunges other deleted messages when allowed", map[string]bool{}, []uint32{9}, true, MoveCopyExpunge, "plain", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &MockIMAPClientMove{}
+			dialer := &MockIMAPDialerMove{}
+			dialer.On("Dial", mock.Anything).Return(client, nil)
+			client.On("Login", mock.Anything, mock.Anything).Return(nil)
+			client.On("Logout").Return(nil)
+			client.On("Select", "INBOX", false).Return(&imap.MailboxStatus{}, nil)
+			client.On("Capability").Return(tt.caps, nil)
+			client.On("UidSearch", isDeletedSearch).Return(tt.alreadyDelete, nil)
+			client.On("List", "", "", mock.Anything).Return(
+				func(ch chan *imap.MailboxInfo) { ch <- &imap.MailboxInfo{Delimiter: "/"} }, nil)
+			client.On("List", "", "Archive", mock.Anything).Return(
+				func(ch chan *imap.MailboxInfo) { ch <- &imap.MailboxInfo{Name: "Archive"} }, nil)
+			client.On("UidCopy", mock.Anything, "Archive").Return(nil)
+			client.On("UidStore", mock.Anything, imap.FormatFlagsOp(imap.AddFlags, true),
+				[]interface{}{imap.DeletedFlag}, (chan *imap.Message)(nil)).Return(nil, nil)
+			client.On("UidExpunge", mock.Anything).Return(nil)
+			client.On("Expunge", (chan uint32)(nil)).Return(nil)
+			client.On("UidFetch", mock.Anything, []imap.FetchItem{imap.FetchUid}, mock.Anything).Return(
+				func(ch chan *imap.Message) {}, nil)
+
+			account := Account{AllowUnsafeExpunge: tt.allowUnsafe}
+			transfer, err := MoveMessages(dialer, account, []*imap.Message{{Uid: 1}}, "INBOX", "Archive", 10)
+			if tt.wantError != "" {
+				assert.ErrorContains(t, err, tt.wantError)
+				client.AssertNotCalled(t, "UidCopy", mock.Anything, mock.Anything)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantMethod, transfer.Method)
+			client.AssertNotCalled(t, "UidMove", mock.Anything, mock.Anything)
+			client.AssertCalled(t, "UidCopy", mock.Anything, "Archive")
+			switch tt.wantExpunge {
+			case "uid":
+				client.AssertCalled(t, "UidExpunge", mock.Anything)
+				client.AssertNotCalled(t, "Expunge", mock.Anything)
+				client.AssertNotCalled(t, "UidSearch", isDeletedSearch)
+			case "plain":
+				client.AssertCalled(t, "Expunge", (chan uint32)(nil))
+				client.AssertNotCalled(t, "UidExpunge", mock.Anything)
+			}
+		})
+	}
+}
+
 func TestEnsureFolder(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -0,0 +1,168 @@
+package imaputils
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+)
+
+// The resources of a quota root (RFC 9208) that shemail reports on.
+const (
+	// QuotaStorage is the size of the messages, in units of 1024 octets.
+	QuotaStorage = "STORAGE"
+	// QuotaMessage is the number of messages.
+	QuotaMessage = "MESSAGE"
+)
+
+// ErrQuotaUnsupported is returned by GetQuotaRoot and GetQuota on servers
+// without the QUOTA extension.
+var ErrQuotaUnsupported = errors.New("server does not support QUOTA")
+
+// QuotaResource is the usage and limit of one resource of a quota root.
+type QuotaResource struct {
+	Name  string
+	Usage uint64
+	Limit uint64
+}
+
+// Percent returns the usage as a percentage of the limit, or 0 if there is
+// no limit.
+func (resource QuotaResource) Percent() float64 {
+	if resource.Limit == 0 {
+		return 0
+	}
+	return float64(resource.Usage) * 100 / float64(resource.Limit)
+}
+
+// Quota is the resources of a quota root, which limits a set of folders; ""
+// is a valid root name.
+type Quota struct {
+	Root      string
+	Resources []QuotaResource
+}
+
+// Resource returns the named resource of the quota, if it is limited.
+func (quota Quota) Resource(name string) (QuotaResource, bool) {
+	index := slices.IndexFunc(quota.Resources, func(resource QuotaResource) bool {
+		return strings.EqualFold(resource.Name, name)
+	})
+	if index < 0 {
+		return QuotaResource{}, false
+	}
+	return quota.Resources[index], true
+}
+
+// AccountQuota returns the quotas of the roots that limit the account's
+// INBOX, which is where incoming mail bounces once they are full.
+func AccountQuota(dialer IMAPDialer, account Account) ([]Quota, error) {
+	imapClient, err := getImapClient(dialer, account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init imap client: %w", err)
+	}
+	defer imapClient.Logout()
+
+	roots, quotas, err := imapClient.GetQuotaRoot("INBOX")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the quota roots of INBOX: %w", err)
+	}
+	// Servers should send the QUOTA of every root along with QUOTAROOT, but
+	// are not required to.
+	for _, root := range roots {
+		if slices.ContainsFunc(quotas, func(quota Quota) bool { return quota.Root == root }) {
+			continue
+		}
+		quota, err := imapClient.GetQuota(root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get quota %q: %w", root, err)
+		}
+		quotas = append(quotas, quota)
+	}
+	return quotas, nil
+}
+
+// getQuotaRoot is the QUOTA GETQUOTAROOT command, which asks for the quota
+// roots of a mailbox and their quotas.
+type getQuotaRoot struct {
+	mailbox string
+}
+
+func (cmd *getQuotaRoot) Command() *imap.Command {
+	return &imap.Command{Name: "GETQUOTAROOT", Arguments: []interface{}{imap.FormatMailboxName(cmd.mailbox)}}
+}
+
+// getQuota is the QUOTA GETQUOTA command, which asks for the quota of a
+// quota root.
+type getQuota struct {
+	root string
+}
+
+func (cmd *getQuota) Command() *imap.Command {
+	return &imap.Command{Name: "GETQUOTA", Arguments: []interface{}{cmd.root}}
+}
+
+// quotaHandler collects the untagged QUOTAROOT and QUOTA responses to
+// GETQUOTAROOT and GETQUOTA.
+type quotaHandler struct {
+	roots  []string
+	quotas []Quota
+}
+
+func (handler *quotaHandler) Handle(resp imap.Resp) error {
+	data, ok := resp.(*imap.DataResp)
+	if !ok || len(data.Fields) == 0 {
+		return responses.ErrUnhandled
+	}
+	name, _ := data.Fields[0].(string)
+	switch strings.ToUpper(name) {
+	case "QUOTAROOT":
+		// QUOTAROOT mailbox root...
+		for _, field := range data.Fields[min(2, len(data.Fields)):] {
+			root, err := imap.ParseString(field)
+			if err != nil {
+				return fmt.Errorf("invalid QUOTAROOT response: %w", err)
+			}
+			handler.roots = append(handler.roots, root)
+		}
+		return nil
+	case "QUOTA":
+		quota, err := parseQuota(data.Fields[1:])
+		if err != nil {
+			return err
+		}
+		handler.quotas = append(handler.quotas, quota)
+		return nil
+	}
+	return responses.ErrUnhandled
+}
+
+// parseQuota parses the fields of a QUOTA response after its name: the root
+// and a list of resource name, usage and limit triples, as in
+// `"" (STORAGE 10240 512000 MESSAGE 900 10000)`.
+func parseQuota(fields []interface{}) (Quota, error) {
+	if len(fields) != 2 {
+		return Quota{}, fmt.Errorf("invalid QUOTA response: expected a root and a list of resources")
+	}
+	root, err := imap.ParseString(fields[0])
+	if err != nil {
+		return Quota{}, fmt.Errorf("invalid QUOTA response: %w", err)
+	}
+	list, ok := fields[1].([]interface{})
+	if !ok || len(list)%3 != 0 {
+		return Quota{}, fmt.Errorf("invalid QUOTA response for %q: malformed resource list", root)
+	}
+	quota := Quota{Root: root}
+	for index := 0; index < len(list); index += 3 {
+		name, _ := list[index].(string)
+		usage, usageErr := parseNumber64(list[index+1])
+		limit, limitErr := parseNumber64(list[index+2])
+		if name == "" || usageErr != nil || limitErr != nil {
+			return Quota{}, fmt.Errorf("invalid QUOTA response for %q: malformed resource %v", root, list[index:index+3])
+		}
+		quota.Resources = append(quota.Resources, QuotaResource{Name: strings.ToUpper(name), Usage: usage, Limit: limit})
+	}
+	return quota, nil
+}
@@ -0,0 +1,61 @@
+package imaputils
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/responses"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestQuotaHandler(t *testing.T) {
+	handler := &quotaHandler{}
+	assert.NoError(t, handler.Handle(&imap.DataResp{Fields: []interface{}{"QUOTAROOT", "INBOX", "", "shared"}}))
+	assert.NoError(t, handler.Handle(&imap.DataResp{Fields: []interface{}{
+		"QUOTA", "", []interface{}{"STORAGE", "10240", "512000", "message", "900", "10000"},
+	}}))
+	assert.Equal(t, []string{"", "shared"}, handler.roots)
+	assert.Equal(t, []Quota{{Root: "", Resources: []QuotaResource{
+		{Name: QuotaStorage, Usage: 10240, Limit: 512000},
+		{Name: QuotaMessage, Usage: 900, Limit: 10000},
+	}}}, handler.quotas)
+
+	assert.ErrorIs(t, handler.Handle(&imap.DataResp{Fields: []interface{}{"FLAGS", []interface{}{}}}), responses.ErrUnhandled)
+	assert.ErrorIs(t, handler.Handle(&imap.StatusResp{Type: imap.StatusRespOk}), responses.ErrUnhandled)
+	assert.ErrorContains(t, handler.Handle(&imap.DataResp{Fields: []interface{}{
+		"QUOTA", "", []interface{}{"STORAGE", "lots", "512000"},
+	}}), "malformed resource")
+	assert.ErrorContains(t, handler.Handle(&imap.DataResp{Fields: []interface{}{"QUOTA", ""}}), "expected a root")
+}
+
+func TestQuotaResource(t *testing.T) {
+	quota := Quota{Resources: []QuotaResource{{Name: QuotaStorage, Usage: 450, Limit: 500}}}
+	storage, ok := quota.Resource("storage")
+	assert.True(t, ok)
+	assert.Equal(t, 90.0, storage.Percent())
+	_, ok = quota.Resource(QuotaMessage)
+	assert.False(t, ok)
+	assert.Equal(t, 0.0, QuotaResource{Usage: 10}.Percent())
+}
+
+func TestAccountQuota(t *testing.T) {
+	client := &MockIMAPClientMove{}
+	dialer := &MockIMAPDialerMove{}
+	dialer.On("Dial", mock.Anything).Return(client, nil)
+	client.On("Login", mock.Anything, mock.Anything).Return(nil)
+	client.On("Logout").Return(nil)
+	user := Quota{Root: "", Resources: []QuotaResource{{Name: QuotaStorage, Usage: 10, Limit: 100}}}
+	shared := Quota{Root: "shared", Resources: []QuotaResource{{Name: QuotaMessage, Usage: 1, Limit: 10}}}
+	client.On("GetQuotaRoot", "INBOX").Return([]string{"", "shared"}, []Quota{user}, nil).Once()
+	client.On("GetQuota", "shared").Return(shared, nil)
+
+	quotas, err := AccountQuota(dialer, Account{})
+	assert.NoError(t, err)
+	assert.Equal(t, []Quota{user, shared}, quotas)
+	client.AssertNotCalled(t, "GetQuota", "")
+
+	client.On("GetQuotaRoot", "INBOX").Return(nil, nil, ErrQuotaUnsupported)
+	_, err = AccountQuota(dialer, Account{})
+	assert.ErrorIs(t, err, ErrQuotaUnsupported)
+}
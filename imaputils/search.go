@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/emersion/go-imap"
+	"github.com/wryfi/shemail/progress"
 	"time"
 )
 
@@ -35,35 +36,14 @@ func (opts SearchOptions) Serialize() string {
 	return string(jsonBytes)
 }
 
-// SearchMessages performs a search for messages in the specified mailbox using given criteria
+// SearchMessages performs a search for messages in the specified mailbox using
+// given criteria and returns every match at once. It is a collect-all wrapper
+// around StreamMessages; prefer streaming when the messages can be consumed
+// one at a time.
 func SearchMessages(dialer IMAPDialer, account Account, mailbox string, criteria *imap.SearchCriteria) ([]*imap.Message, error) {
-	imapClient, err := connectToMailbox(dialer, account, mailbox, true)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to mailbox: %w", err)
-	}
-	defer imapClient.Logout()
-
-	if err := logServerCapabilities(imapClient); err != nil {
-		return nil, fmt.Errorf("failed to log server capabilities: %w", err)
-	}
-
-	uids, err := findMessageUIDs(imapClient, criteria)
-	if err != nil {
-		return nil, err
-	}
-
-	if len(uids) == 0 {
-		return []*imap.Message{}, nil
-	}
-
-	messages, err := fetchMessagesByUID(imapClient, uids)
-	if err != nil {
-		return nil, err
-	}
-
 	// Ordering is applied by the caller via SortMessages (the find command
 	// exposes --sort); leave the fetched order untouched here.
-	return messages, nil
+	return CollectMessages(StreamMessages(dialer, account, mailbox, criteria, DefaultChunkSize))
 }
 
 // logServerCapabilities retrieves and logs server capabilities
@@ -89,16 +69,22 @@ func findMessageUIDs(client IMAPClient, criteria *imap.SearchCriteria) ([]uint32
 	return uids, nil
 }
 
-// fetchMessagesByUID fetches full message data for the given UIDs
-func fetchMessagesByUID(client IMAPClient, uids []uint32) ([]*imap.Message, error) {
+// fetchMessagesByUID fetches full message data for the given UIDs in a single
+// UID FETCH. Callers with an unbounded number of UIDs should go through
+// streamUIDs, which calls this one chunk at a time. Each distinct message
+// received is counted on task.
+func fetchMessagesByUID(client IMAPClient, uids []uint32, task progress.Task) ([]*imap.Message, error) {
+	return fetchItemsByUID(client, uids, getFetchItems(), task)
+}
+
+// fetchItemsByUID is fetchMessagesByUID for the given fetch items.
+func fetchItemsByUID(client IMAPClient, uids []uint32, items []imap.FetchItem, task progress.Task) ([]*imap.Message, error) {
 	seqSet := new(imap.SeqSet)
 	seqSet.AddNum(uids...)
 
 	messages := make(chan *imap.Message)
 	done := make(chan error, 1)
 
-	items := getFetchItems()
-
 	go func() {
 		done <- client.UidFetch(seqSet, items, messages)
 	}()
@@ -116,6 +102,7 @@ func fetchMessagesByUID(client IMAPClient, uids []uint32) ([]*imap.Message, erro
 		}
 		seen[msg.Uid] = msg
 		result = append(result, msg)
+		task.Add(1)
 	}
 
 	if err := <-done; err != nil {
@@ -140,6 +127,12 @@ func mergeMessage(dst, src *imap.Message) {
 	if len(dst.Flags) == 0 {
 		dst.Flags = src.Flags
 	}
+	if dst.BodyStructure == nil {
+		dst.BodyStructure = src.BodyStructure
+	}
+	if len(dst.Body) == 0 {
+		dst.Body = src.Body
+	}
 }
 
 // getFetchItems returns the list of items to fetch for each message
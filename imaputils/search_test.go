@@ -15,6 +15,10 @@ type MockIMAPClientSearch struct {
 	mock.Mock
 }
 
+func (m *MockIMAPClientSearch) Append(mbox string, flags []string, date time.Time, msg imap.Literal) error {
+	return nil
+}
+
 func (m *MockIMAPClientSearch) Capability() (map[string]bool, error) {
 	args := m.Called()
 	return args.Get(0).(map[string]bool), args.Error(1)
@@ -39,6 +43,18 @@ func (m *MockIMAPClientSearch) UidFetch(seqset *imap.SeqSet, items []imap.FetchI
 	return args.Error(1)
 }
 
+func (m *MockIMAPClientSearch) UidFetchChangedSince(seqset *imap.SeqSet, items []imap.FetchItem, modSeq uint64, ch chan *imap.Message) error {
+	args := m.Called(seqset, items, modSeq, ch)
+	msgs, _ := args.Get(0).([]*imap.Message)
+	go func() {
+		for _, msg := range msgs {
+			ch <- msg
+		}
+		close(ch)
+	}()
+	return args.Error(1)
+}
+
 func (m *MockIMAPClientSearch) Logout() error {
 	args := m.Called()
 	return args.Error(0)
@@ -46,11 +62,32 @@ func (m *MockIMAPClientSearch) Logout() error {
 
 // Other interface methods...
 func (m *MockIMAPClientSearch) Create(name string) error     { return nil }
+func (m *MockIMAPClientSearch) Delete(name string) error     { return nil }
 func (m *MockIMAPClientSearch) Expunge(ch chan uint32) error { return nil }
+func (m *MockIMAPClientSearch) Lsub(ref string, name string, ch chan *imap.MailboxInfo) error {
+	close(ch)
+	return nil
+}
+func (m *MockIMAPClientSearch) Rename(existingName, newName string) error { return nil }
+func (m *MockIMAPClientSearch) Subscribe(name string) error               { return nil }
+func (m *MockIMAPClientSearch) Unsubscribe(name string) error             { return nil }
 func (m *MockIMAPClientSearch) Fetch(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error {
 	return nil
 }
 func (m *MockIMAPClientSearch) GetClient() *client.Client { return nil }
+func (m *MockIMAPClientSearch) GetQuota(root string) (Quota, error) {
+	return Quota{}, ErrQuotaUnsupported
+}
+func (m *MockIMAPClientSearch) GetQuotaRoot(mailbox string) ([]string, []Quota, error) {
+	return nil, nil, ErrQuotaUnsupported
+}
+func (m *MockIMAPClientSearch) Idle(stop <-chan struct{}, opts *client.IdleOptions) error {
+	return nil
+}
+
+func (m *MockIMAPClientSearch) Updates() <-chan client.Update {
+	return nil
+}
 func (m *MockIMAPClientSearch) List(ref string, name string, ch chan *imap.MailboxInfo) error {
 	return nil
 }
@@ -61,12 +98,17 @@ func (m *MockIMAPClientSearch) Select(name string, readOnly bool) (*imap.Mailbox
 func (m *MockIMAPClientSearch) Status(name string, items []imap.StatusItem) (*imap.MailboxStatus, error) {
 	return nil, nil
 }
-func (m *MockIMAPClientSearch) UidCopy(seqSet *imap.SeqSet, dest string) error { return nil }
-func (m *MockIMAPClientSearch) UidDelete(seqSet *imap.SeqSet) error            { return nil }
+func (m *MockIMAPClientSearch) UidCopy(seqSet *imap.SeqSet, dest string) (*CopyUID, error) {
+	return nil, nil
+}
+func (m *MockIMAPClientSearch) UidExpunge(seqSet *imap.SeqSet) error { return nil }
+func (m *MockIMAPClientSearch) UidDelete(seqSet *imap.SeqSet) error  { return nil }
 func (m *MockIMAPClientSearch) UidFetchMetadata(seqSet *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error {
 	return nil
 }
-func (m *MockIMAPClientSearch) UidMove(seqSet *imap.SeqSet, mailbox string) error { return nil }
+func (m *MockIMAPClientSearch) UidMove(seqSet *imap.SeqSet, mailbox string) (*CopyUID, error) {
+	return nil, nil
+}
 func (m *MockIMAPClientSearch) UidStore(seqSet *imap.SeqSet, item imap.StoreItem, flags []interface{}, ch chan *imap.Message) error {
 	return nil
 }
@@ -21,13 +21,24 @@ type SenderCount struct {
 // search as the find command.
 func CountMessagesBySender(dialer IMAPDialer, account Account, folder string, threshold int, startDate, endDate *time.Time) ([][]string, error) {
 	criteria := BuildSearchCriteria(SearchOptions{StartDate: startDate, EndDate: endDate})
-	messages, err := SearchMessages(dialer, account, folder, criteria)
+	tableData, err := CountSenders(StreamMessages(dialer, account, folder, criteria, DefaultChunkSize), threshold)
 	if err != nil {
 		return nil, fmt.Errorf("error searching folder %s: %w", folder, err)
 	}
+	return tableData, nil
+}
 
-	senderCounts := make(map[string]int, len(messages)/2)
-	for _, message := range messages {
+// CountSenders tallies the messages of stream by sender, as
+// CountMessagesBySender does for a folder search, returning the stream's error
+// if it fails.
+func CountSenders(stream MessageStream, threshold int) ([][]string, error) {
+	// Tally as the messages stream in; only the per-sender counts are kept, so
+	// memory does not grow with the size of the folder.
+	senderCounts := make(map[string]int)
+	for message, err := range stream {
+		if err != nil {
+			return nil, err
+		}
 		if message.Envelope == nil || len(message.Envelope.From) == 0 {
 			continue
 		}
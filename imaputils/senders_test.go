@@ -16,6 +16,10 @@ type MockIMAPClientSenders struct {
 	mock.Mock
 }
 
+func (m *MockIMAPClientSenders) Append(mbox string, flags []string, date time.Time, msg imap.Literal) error {
+	return nil
+}
+
 func (m *MockIMAPClientSenders) Capability() (map[string]bool, error) {
 	args := m.Called()
 	return args.Get(0).(map[string]bool), args.Error(1)
@@ -47,11 +51,32 @@ func (m *MockIMAPClientSenders) Logout() error {
 
 // Implement other required interface methods with empty returns
 func (m *MockIMAPClientSenders) Create(name string) error     { return nil }
+func (m *MockIMAPClientSenders) Delete(name string) error     { return nil }
 func (m *MockIMAPClientSenders) Expunge(ch chan uint32) error { return nil }
+func (m *MockIMAPClientSenders) Lsub(ref string, name string, ch chan *imap.MailboxInfo) error {
+	close(ch)
+	return nil
+}
+func (m *MockIMAPClientSenders) Rename(existingName, newName string) error { return nil }
+func (m *MockIMAPClientSenders) Subscribe(name string) error               { return nil }
+func (m *MockIMAPClientSenders) Unsubscribe(name string) error             { return nil }
 func (m *MockIMAPClientSenders) Fetch(seqset *imap.SeqSet, items []imap.FetchItem, ch chan *imap.Message) error {
 	return nil
 }
 func (m *MockIMAPClientSenders) GetClient() *client.Client { return nil }
+func (m *MockIMAPClientSenders) GetQuota(root string) (Quota, error) {
+	return Quota{}, ErrQuotaUnsupported
+}
+func (m *MockIMAPClientSenders) GetQuotaRoot(mailbox string) ([]string, []Quota, error) {
+	return nil, nil, ErrQuotaUnsupported
+}
+func (m *MockIMAPClientSenders) Idle(stop <-chan struct{}, opts *client.IdleOptions) error {
+	return nil
+}
+
+func (m *MockIMAPClientSenders) Updates() <-chan client.Update {
+	return nil
+}
 func (m *MockIMAPClientSenders) List(ref string, name string, ch chan *imap.MailboxInfo) error {
 	return nil
 }
@@ -62,8 +87,16 @@ func (m *MockIMAPClientSenders) Select(name string, readOnly bool) (*imap.Mailbo
 func (m *MockIMAPClientSenders) Status(name string, items []imap.StatusItem) (*imap.MailboxStatus, error) {
 	return nil, nil
 }
-func (m *MockIMAPClientSenders) UidCopy(seqSet *imap.SeqSet, mailbox string) error { return nil }
-func (m *MockIMAPClientSenders) UidMove(seqSet *imap.SeqSet, mailbox string) error { return nil }
+func (m *MockIMAPClientSenders) UidCopy(seqSet *imap.SeqSet, mailbox string) (*CopyUID, error) {
+	return nil, nil
+}
+func (m *MockIMAPClientSenders) UidExpunge(seqSet *imap.SeqSet) error { return nil }
+func (m *MockIMAPClientSenders) UidFetchChangedSince(seqset *imap.SeqSet, items []imap.FetchItem, modSeq uint64, ch chan *imap.Message) error {
+	return nil
+}
+func (m *MockIMAPClientSenders) UidMove(seqSet *imap.SeqSet, mailbox string) (*CopyUID, error) {
+	return nil, nil
+}
 func (m *MockIMAPClientSenders) UidStore(seqSet *imap.SeqSet, item imap.StoreItem, flags []interface{}, ch chan *imap.Message) error {
 	return nil
 }
@@ -94,3 +94,47 @@ func messageUnread(message *imap.Message) bool {
 	}
 	return true
 }
+
+// FolderSortField identifies the folder attribute to sort a folder listing by.
+type FolderSortField string
+
+const (
+	FolderSortName     FolderSortField = "name"
+	FolderSortMessages FolderSortField = "messages"
+	FolderSortUnread   FolderSortField = "unread"
+	FolderSortSize     FolderSortField = "size"
+)
+
+// ParseFolderSortField validates and normalizes a folder sort field name.
+func ParseFolderSortField(name string) (FolderSortField, error) {
+	field := FolderSortField(strings.ToLower(name))
+	switch field {
+	case FolderSortName, FolderSortMessages, FolderSortUnread, FolderSortSize:
+		return field, nil
+	default:
+		return "", fmt.Errorf("unknown sort field %q (valid: name, messages, unread, size)", name)
+	}
+}
+
+// SortFolders sorts folders in place by the given field: name alphabetically,
+// the counts and size largest first. Ties fall back to the name.
+func SortFolders(folders []FolderStatus, field FolderSortField) {
+	sort.SliceStable(folders, func(i, j int) bool {
+		a, b := folders[i], folders[j]
+		switch field {
+		case FolderSortMessages:
+			if a.Messages != b.Messages {
+				return a.Messages > b.Messages
+			}
+		case FolderSortUnread:
+			if a.Unseen != b.Unseen {
+				return a.Unseen > b.Unseen
+			}
+		case FolderSortSize:
+			if a.Size != b.Size {
+				return a.Size > b.Size
+			}
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	})
+}
@@ -73,3 +73,33 @@ func TestSortMessages(t *testing.T) {
 		})
 	}
 }
+
+func TestSortFolders(t *testing.T) {
+	if _, err := ParseFolderSortField("bogus"); err == nil {
+		t.Fatal("expected an error for an unknown folder sort field")
+	}
+	field, err := ParseFolderSortField("Size")
+	assert.NoError(t, err)
+	assert.Equal(t, FolderSortSize, field)
+
+	folders := []FolderStatus{
+		{Name: "Lists", Messages: 50, Unseen: 0, Size: 900},
+		{Name: "archive", Messages: 500, Unseen: 2, Size: 300},
+		{Name: "INBOX", Messages: 50, Unseen: 9, Size: 100},
+	}
+	names := func() []string {
+		var names []string
+		for _, folder := range folders {
+			names = append(names, folder.Name)
+		}
+		return names
+	}
+	SortFolders(folders, FolderSortName)
+	assert.Equal(t, []string{"archive", "INBOX", "Lists"}, names())
+	SortFolders(folders, FolderSortSize)
+	assert.Equal(t, []string{"Lists", "archive", "INBOX"}, names())
+	SortFolders(folders, FolderSortMessages)
+	assert.Equal(t, []string{"archive", "INBOX", "Lists"}, names(), "ties fall back to the name")
+	SortFolders(folders, FolderSortUnread)
+	assert.Equal(t, []string{"INBOX", "archive", "Lists"}, names())
+}
@@ -0,0 +1,189 @@
+package imaputils
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// SpecialUse is the use a folder is set aside for (RFC 6154), named after the
+// LIST attribute that marks it, e.g. "trash" for \Trash.
+type SpecialUse string
+
+const (
+	UseAll     SpecialUse = "all"
+	UseArchive SpecialUse = "archive"
+	UseDrafts  SpecialUse = "drafts"
+	UseFlagged SpecialUse = "flagged"
+	UseJunk    SpecialUse = "junk"
+	UseSent    SpecialUse = "sent"
+	UseTrash   SpecialUse = "trash"
+)
+
+// specialUses lists the special uses in the order FoldersByUse prefers them
+// when a folder has several.
+var specialUses = []SpecialUse{UseTrash, UseArchive, UseJunk, UseSent, UseDrafts, UseAll, UseFlagged}
+
+// ErrNoTrashFolder is returned by FindTrashFolder when the account has no
+// trash folder, rather than creating one the user never asked for.
+var ErrNoTrashFolder = errors.New(`no trash folder: no folder is marked \Trash or has a usual trash name; set trash_folder for the account, create one with "shemail mkdir", or purge instead`)
+
+// ErrNoArchiveFolder is returned by FindArchiveFolder when the account has no
+// archive folder.
+var ErrNoArchiveFolder = errors.New(`no archive folder: no folder is marked \Archive; set archive_folder for the account`)
+
+// SpecialUseFolders returns the server name of the account's folder for each
+// special use it has one for. A trash_folder or archive_folder setting naming
+// a folder that does not exist is an error, returned along with the uses that
+// did resolve.
+func SpecialUseFolders(dialer IMAPDialer, account Account) (map[SpecialUse]string, error) {
+	uses, settingErrs, err := listSpecialUse(dialer, account)
+	if err != nil {
+		return nil, err
+	}
+	return uses, joinSettingErrors(settingErrs)
+}
+
+// listSpecialUse lists the account's folders and resolves their special uses,
+// with the error of each setting that names a missing folder, by use.
+func listSpecialUse(dialer IMAPDialer, account Account) (map[SpecialUse]string, map[SpecialUse]error, error) {
+	imapClient, err := getImapClient(dialer, account)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to init imap client: %w", err)
+	}
+	defer imapClient.Logout()
+
+	infos, err := listMailboxes(imapClient, "*")
+	if err != nil {
+		return nil, nil, err
+	}
+	uses, settingErrs := resolveSpecialUse(infos, account)
+	return uses, settingErrs, nil
+}
+
+// joinSettingErrors combines the errors resolveSpecialUse found, in a stable
+// order.
+func joinSettingErrors(settingErrs map[SpecialUse]error) error {
+	return errors.Join(settingErrs[UseTrash], settingErrs[UseArchive])
+}
+
+// resolveSpecialUse maps each special use to a folder of infos: the account's
+// trash_folder and archive_folder settings win, then the SPECIAL-USE
+// attributes the server lists, then, for the trash only, the first folder
+// named in DeletedFolderNames. A setting naming a folder that does not exist
+// is an error, returned by use so that it only affects lookups of that use;
+// the use then resolves as if the setting were not there.
+func resolveSpecialUse(infos []*imap.MailboxInfo, account Account) (map[SpecialUse]string, map[SpecialUse]error) {
+	uses := make(map[SpecialUse]string)
+	for _, info := range infos {
+		if use := attributeUse(info); use != "" {
+			if _, ok := uses[use]; !ok {
+				uses[use] = info.Name
+			}
+		}
+	}
+	if _, ok := uses[UseTrash]; !ok {
+		for _, info := range infos {
+			if slices.Contains(DeletedFolderNames, info.Name) {
+				uses[UseTrash] = info.Name
+				break
+			}
+		}
+	}
+
+	settingErrs := make(map[SpecialUse]error)
+	for _, setting := range []struct {
+		use    SpecialUse
+		folder string
+	}{{UseTrash, account.TrashFolder}, {UseArchive, account.ArchiveFolder}} {
+		use, folder := setting.use, setting.folder
+		if folder == "" {
+			continue
+		}
+		index := slices.IndexFunc(infos, func(info *imap.MailboxInfo) bool { return folderNamed(info, folder) })
+		if index < 0 {
+			settingErrs[use] = fmt.Errorf("%s_folder %s does not exist", use, folder)
+			continue
+		}
+		uses[use] = infos[index].Name
+	}
+	return uses, settingErrs
+}
+
+// FoldersByUse inverts the map SpecialUseFolders returns, giving the special
+// use of each folder that has one.
+func FoldersByUse(uses map[SpecialUse]string) map[string]SpecialUse {
+	folders := make(map[string]SpecialUse, len(uses))
+	for _, use := range specialUses {
+		if folder, ok := uses[use]; ok {
+			if _, taken := folders[folder]; !taken {
+				folders[folder] = use
+			}
+		}
+	}
+	return folders
+}
+
+// attributeUse returns the special use the server marks the folder for, if
+// any.
+func attributeUse(info *imap.MailboxInfo) SpecialUse {
+	for _, attribute := range specialUseAttributes {
+		if hasAttribute(info.Attributes, attribute) {
+			return SpecialUse(strings.ToLower(strings.TrimPrefix(attribute, "\\")))
+		}
+	}
+	return ""
+}
+
+// folderNamed reports whether info is the "/"-separated folder path.
+func folderNamed(info *imap.MailboxInfo, folder string) bool {
+	if info.Delimiter != "" {
+		folder = strings.ReplaceAll(folder, "/", info.Delimiter)
+	}
+	if strings.EqualFold(folder, "INBOX") {
+		return strings.EqualFold(info.Name, "INBOX")
+	}
+	return info.Name == folder
+}
+
+// FindTrashFolder returns the account's trash folder: the trash_folder
+// setting, else the folder marked \Trash, else one with a common trash name.
+// It returns ErrNoTrashFolder if there is none, and fails if trash_folder
+// names a folder that does not exist; other settings do not matter.
+func FindTrashFolder(dialer IMAPDialer, account Account) (string, error) {
+	uses, settingErrs, err := listSpecialUse(dialer, account)
+	if err != nil {
+		return "", err
+	}
+	if err := settingErrs[UseTrash]; err != nil {
+		return "", err
+	}
+	trashFolder, ok := uses[UseTrash]
+	if !ok {
+		return "", ErrNoTrashFolder
+	}
+	noteTrashFolder(dialer, trashFolder)
+	return trashFolder, nil
+}
+
+// FindArchiveFolder returns the account's archive folder: the archive_folder
+// setting, else the folder marked \Archive. It returns ErrNoArchiveFolder if
+// there is none, and fails if archive_folder names a folder that does not
+// exist.
+func FindArchiveFolder(dialer IMAPDialer, account Account) (string, error) {
+	uses, settingErrs, err := listSpecialUse(dialer, account)
+	if err != nil {
+		return "", err
+	}
+	if err := settingErrs[UseArchive]; err != nil {
+		return "", err
+	}
+	archiveFolder, ok := uses[UseArchive]
+	if !ok {
+		return "", ErrNoArchiveFolder
+	}
+	return archiveFolder, nil
+}
@@ -0,0 +1,70 @@
+package imaputils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ProgressStore is an on-disk collection of progress records of long-running
+// jobs, such as imports and migrations, keyed by a string that identifies
+// the job. Like CheckpointStore, it is a single small JSON file, rewritten
+// atomically on Save.
+type ProgressStore[T any] struct {
+	path    string
+	Entries map[string]T `json:"entries"`
+}
+
+// LoadProgressStore reads the progress store at path. A missing file yields
+// an empty store that will be created on the first Save.
+func LoadProgressStore[T any](path string) (*ProgressStore[T], error) {
+	store := &ProgressStore[T]{path: path, Entries: map[string]T{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	if store.Entries == nil {
+		store.Entries = map[string]T{}
+	}
+	return store, nil
+}
+
+// Get returns the progress stored under key, if any.
+func (store *ProgressStore[T]) Get(key string) (T, bool) {
+	entry, ok := store.Entries[key]
+	return entry, ok
+}
+
+// Set records progress under key. It is not persisted until Save.
+func (store *ProgressStore[T]) Set(key string, entry T) {
+	store.Entries[key] = entry
+}
+
+// Delete forgets the job under key, once it has finished. It is not
+// persisted until Save.
+func (store *ProgressStore[T]) Delete(key string) {
+	delete(store.Entries, key)
+}
+
+// Save writes the store back to disk via a temporary file, so that an
+// interrupted write cannot lose the progress of every job.
+func (store *ProgressStore[T]) Save() error {
+	if err := os.MkdirAll(filepath.Dir(store.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	return replaceFile(store.path, data)
+}
@@ -0,0 +1,116 @@
+package imaputils
+
+import (
+	"fmt"
+	"github.com/emersion/go-imap"
+	"github.com/wryfi/shemail/progress"
+	"iter"
+)
+
+// DefaultChunkSize is the number of messages fetched per UID FETCH when
+// streaming. It bounds both memory use and the length of each FETCH command,
+// which some servers limit.
+const DefaultChunkSize = 500
+
+// MessageStream yields messages one at a time, with a non-nil error as the
+// final element if the stream failed part way through.
+type MessageStream = iter.Seq2[*imap.Message, error]
+
+// StreamMessages searches mailbox for criteria and streams the matching
+// messages. The connection is opened when iteration starts and closed when it
+// ends (including when the consumer stops early). Messages are fetched
+// chunkSize UIDs at a time, and the next chunk is only requested once the
+// consumer has taken every message of the current one, so at most one chunk is
+// ever held in memory however large the folder is.
+func StreamMessages(dialer IMAPDialer, account Account, mailbox string, criteria *imap.SearchCriteria, chunkSize int) MessageStream {
+	return func(yield func(*imap.Message, error) bool) {
+		imapClient, err := connectToMailbox(dialer, account, mailbox, true)
+		if err != nil {
+			yield(nil, fmt.Errorf("failed to connect to mailbox: %w", err))
+			return
+		}
+		defer imapClient.Logout()
+
+		if err := logServerCapabilities(imapClient); err != nil {
+			yield(nil, fmt.Errorf("failed to log server capabilities: %w", err))
+			return
+		}
+
+		uids, err := findMessageUIDs(imapClient, criteria)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		streamUIDs(imapClient, "fetching "+mailbox, uids, chunkSize, yield)
+	}
+}
+
+// StreamMessagesByUID streams the given messages of mailbox by UID, in chunks,
+// as StreamMessages does for search results. UIDs that no longer exist are
+// silently absent from the stream.
+func StreamMessagesByUID(dialer IMAPDialer, account Account, mailbox string, uids []uint32, chunkSize int) MessageStream {
+	return func(yield func(*imap.Message, error) bool) {
+		if len(uids) == 0 {
+			return
+		}
+
+		imapClient, err := connectToMailbox(dialer, account, mailbox, true)
+		if err != nil {
+			yield(nil, fmt.Errorf("failed to connect to mailbox: %w", err))
+			return
+		}
+		defer imapClient.Logout()
+
+		streamUIDs(imapClient, "fetching "+mailbox, uids, chunkSize, yield)
+	}
+}
+
+// streamUIDs fetches uids chunk by chunk on an already-selected client and
+// passes each message to yield, stopping early if yield returns false.
+// Progress is reported under label as messages arrive.
+func streamUIDs(imapClient IMAPClient, label string, uids []uint32, chunkSize int, yield func(*imap.Message, error) bool) {
+	streamUIDItems(imapClient, label, uids, getFetchItems(), chunkSize, yield)
+}
+
+// streamUIDItems is streamUIDs for the given fetch items.
+func streamUIDItems(imapClient IMAPClient, label string, uids []uint32, items []imap.FetchItem, chunkSize int, yield func(*imap.Message, error) bool) {
+	if len(uids) == 0 {
+		return
+	}
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	task := progress.Start(label, "messages", len(uids))
+	defer task.Done()
+
+	for start := 0; start < len(uids); start += chunkSize {
+		end := min(start+chunkSize, len(uids))
+
+		messages, err := fetchItemsByUID(imapClient, uids[start:end], items, task)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		for _, message := range messages {
+			if !yield(message, nil) {
+				return
+			}
+		}
+	}
+}
+
+// CollectMessages drains a stream into a slice, for consumers (such as the
+// interactive picker, or sorting) that need every message at once.
+func CollectMessages(stream MessageStream) ([]*imap.Message, error) {
+	messages := []*imap.Message{}
+	for message, err := range stream {
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
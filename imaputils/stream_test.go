@@ -0,0 +1,94 @@
+package imaputils
+
+import (
+	"fmt"
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+// seqSetIs matches a *imap.SeqSet argument by its string form.
+func seqSetIs(expected string) interface{} {
+	return mock.MatchedBy(func(seqSet *imap.SeqSet) bool {
+		return seqSet.String() == expected
+	})
+}
+
+func TestStreamMessagesChunksFetches(t *testing.T) {
+	client := &MockIMAPClientSearch{}
+	dialer := &MockIMAPDialerSearch{}
+	dialer.On("Dial", mock.Anything).Return(client, nil)
+	client.On("Capability").Return(map[string]bool{"IMAP4rev1": true}, nil)
+	client.On("UidSearch", mock.Anything).Return([]uint32{1, 2, 3, 4, 5}, nil)
+	client.On("UidFetch", seqSetIs("1:2"), mock.Anything, mock.Anything).Return([]*imap.Message{{Uid: 1}, {Uid: 2}}, nil).Once()
+	client.On("UidFetch", seqSetIs("3:4"), mock.Anything, mock.Anything).Return([]*imap.Message{{Uid: 3}, {Uid: 4}}, nil).Once()
+	client.On("UidFetch", seqSetIs("5"), mock.Anything, mock.Anything).Return([]*imap.Message{{Uid: 5}}, nil).Once()
+	client.On("Logout").Return(nil)
+
+	var uids []uint32
+	for message, err := range StreamMessages(dialer, Account{}, "INBOX", &imap.SearchCriteria{}, 2) {
+		assert.NoError(t, err)
+		uids = append(uids, message.Uid)
+	}
+
+	assert.Equal(t, []uint32{1, 2, 3, 4, 5}, uids)
+	client.AssertExpectations(t)
+}
+
+func TestStreamMessagesStopsEarly(t *testing.T) {
+	client := &MockIMAPClientSearch{}
+	dialer := &MockIMAPDialerSearch{}
+	dialer.On("Dial", mock.Anything).Return(client, nil)
+	client.On("Capability").Return(map[string]bool{"IMAP4rev1": true}, nil)
+	client.On("UidSearch", mock.Anything).Return([]uint32{1, 2, 3, 4}, nil)
+	client.On("UidFetch", seqSetIs("1:2"), mock.Anything, mock.Anything).Return([]*imap.Message{{Uid: 1}, {Uid: 2}}, nil).Once()
+	client.On("Logout").Return(nil)
+
+	for message := range StreamMessages(dialer, Account{}, "INBOX", &imap.SearchCriteria{}, 2) {
+		if message.Uid == 1 {
+			break
+		}
+	}
+
+	// The second chunk must never be requested, and the connection must still
+	// be closed.
+	client.AssertNumberOfCalls(t, "UidFetch", 1)
+	client.AssertCalled(t, "Logout")
+}
+
+func TestStreamMessagesByUID(t *testing.T) {
+	t.Run("no UIDs does not connect", func(t *testing.T) {
+		dialer := &MockIMAPDialerSearch{}
+		messages, err := CollectMessages(StreamMessagesByUID(dialer, Account{}, "INBOX", nil, 10))
+		assert.NoError(t, err)
+		assert.Empty(t, messages)
+		dialer.AssertNotCalled(t, "Dial", mock.Anything)
+	})
+
+	t.Run("fetches the requested UIDs", func(t *testing.T) {
+		client := &MockIMAPClientSearch{}
+		dialer := &MockIMAPDialerSearch{}
+		dialer.On("Dial", mock.Anything).Return(client, nil)
+		client.On("UidFetch", seqSetIs("7,9"), mock.Anything, mock.Anything).Return([]*imap.Message{{Uid: 7}, {Uid: 9}}, nil)
+		client.On("Logout").Return(nil)
+
+		messages, err := CollectMessages(StreamMessagesByUID(dialer, Account{}, "INBOX", []uint32{7, 9}, 10))
+		assert.NoError(t, err)
+		assert.Len(t, messages, 2)
+		client.AssertExpectations(t)
+	})
+}
+
+func TestCollectMessagesPropagatesErrors(t *testing.T) {
+	stream := func(yield func(*imap.Message, error) bool) {
+		if !yield(&imap.Message{Uid: 1}, nil) {
+			return
+		}
+		yield(nil, fmt.Errorf("connection reset"))
+	}
+
+	messages, err := CollectMessages(stream)
+	assert.EqualError(t, err, "connection reset")
+	assert.Nil(t, messages)
+}
@@ -0,0 +1,332 @@
+package imaputils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/textproto"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/wryfi/shemail/progress"
+)
+
+// StripPlan is a message and the attachments to strip from it.
+type StripPlan struct {
+	Message     *imap.Message
+	Attachments []Attachment
+}
+
+// Reclaim estimates the space stripping saves: the size of the attachments as
+// stored, ignoring the few hundred bytes of each stub that replaces them.
+func (plan StripPlan) Reclaim() int64 {
+	var size int64
+	for _, attachment := range plan.Attachments {
+		size += int64(attachment.Size)
+	}
+	return size
+}
+
+// DecodedSize estimates the size of attachment once decoded, from its size
+// as stored.
+func (attachment Attachment) DecodedSize() int64 {
+	if attachment.Encoding == "base64" {
+		// 57 bytes become 76 characters and a line break.
+		return int64(attachment.Size) * 57 / 78
+	}
+	return int64(attachment.Size)
+}
+
+// PlanStrip reads the BODYSTRUCTURE of the messages uids of folder and returns,
+// in order of UID, those with attachments filter accepts, together with those
+// attachments. The messages carry their envelope, flags, INTERNALDATE and
+// size.
+func PlanStrip(dialer IMAPDialer, account Account, folder string, uids []uint32, filter AttachmentFilter) ([]StripPlan, error) {
+	if len(uids) == 0 {
+		return nil, nil
+	}
+	imapClient, err := connectToMailbox(dialer, account, folder, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to mailbox: %w", err)
+	}
+	defer imapClient.Logout()
+
+	items := append(getFetchItems(), imap.FetchBodyStructure)
+	var plans []StripPlan
+	var fetchErr error
+	streamUIDItems(imapClient, "reading the structure of messages in "+folder, uids, items, DefaultChunkSize, func(message *imap.Message, err error) bool {
+		if err != nil {
+			fetchErr = err
+			return false
+		}
+		plan := StripPlan{Message: message}
+		for _, attachment := range FindAttachments(message.BodyStructure) {
+			if filter.Matches(attachment) {
+				plan.Attachments = append(plan.Attachments, attachment)
+			}
+		}
+		if len(plan.Attachments) > 0 {
+			plans = append(plans, plan)
+		}
+		return true
+	})
+	if fetchErr != nil {
+		return nil, fmt.Errorf("failed to fetch message structure from %s: %w", folder, fetchErr)
+	}
+	return plans, nil
+}
+
+// StripResult is what StripAttachments did.
+type StripResult struct {
+	// Replaced are the original messages that now have a stripped copy in
+	// the folder, and are to be deleted.
+	Replaced    []*imap.Message
+	Attachments int
+	// Before and After are the total sizes of the replaced messages and of
+	// their stripped copies.
+	Before, After int64
+	// Skipped counts messages that were gone or could not be rewritten, and
+	// were left as they are.
+	Skipped int
+}
+
+// StripAttachments downloads each planned message of folder, rebuilds it with
+// its planned attachments replaced by text stub renders for each, and APPENDs
+// the result to folder with the original flags and INTERNALDATE. The
+// originals are left in place, for the caller to delete once it has the
+// result; the result so far is returned even on error.
+func StripAttachments(dialer IMAPDialer, account Account, folder string, plans []StripPlan, stub func(message *imap.Message, attachment Attachment) string) (StripResult, error) {
+	var result StripResult
+	if len(plans) == 0 {
+		return result, nil
+	}
+	imapClient, err := connectToMailbox(dialer, account, folder, true)
+	if err != nil {
+		return result, fmt.Errorf("failed to connect to mailbox: %w", err)
+	}
+	defer imapClient.Logout()
+
+	task := progress.Start("stripping attachments in "+folder, "messages", len(plans))
+	defer task.Done()
+	for _, plan := range plans {
+		message := plan.Message
+		raw, err := fetchRawMessage(imapClient, message.Uid)
+		if err != nil {
+			return result, err
+		}
+		if raw == nil {
+			log.Warn().Msgf("message %d is no longer in %s; skipped", message.Uid, folder)
+			result.Skipped++
+			continue
+		}
+		stripped, err := StripMessage(raw, plan.Attachments, func(attachment Attachment) string {
+			return stub(message, attachment)
+		})
+		if err != nil {
+			log.Warn().Msgf("cannot strip message %d in %s (%v); left as it is", message.Uid, folder, err)
+			result.Skipped++
+			continue
+		}
+		if err := imapClient.Append(folder, importFlags(message.Flags), message.InternalDate, bytes.NewReader(stripped)); err != nil {
+			return result, fmt.Errorf("failed to append the stripped copy of message %d to %s: %w", message.Uid, folder, err)
+		}
+		result.Replaced = append(result.Replaced, message)
+		result.Attachments += len(plan.Attachments)
+		result.Before += int64(len(raw))
+		result.After += int64(len(stripped))
+		task.Add(1)
+	}
+	return result, nil
+}
+
+// fetchRawMessage downloads the whole message uid of the selected folder with
+// BODY.PEEK[], or returns nil if there is no such message.
+func fetchRawMessage(imapClient IMAPClient, uid uint32) ([]byte, error) {
+	section := &imap.BodySectionName{Peek: true}
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uid)
+
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- imapClient.UidFetch(seqSet, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, messages)
+	}()
+	var raw []byte
+	var readErr error
+	for message := range messages {
+		if literal := message.GetBody(section); literal != nil && raw == nil {
+			var buffer bytes.Buffer
+			_, readErr = buffer.ReadFrom(literal)
+			raw = buffer.Bytes()
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to fetch message %d: %w", uid, err)
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("failed to read message %d: %w", uid, readErr)
+	}
+	return raw, nil
+}
+
+// strippedHeaders are the header fields of a part that describe its content,
+// which are replaced along with it.
+var strippedHeaders = []string{"Content-Type", "Content-Transfer-Encoding", "Content-Disposition", "Content-Length", "Content-MD5"}
+
+// StripMessage rebuilds the raw message with each of attachments replaced by
+// a plain text part reading stub(attachment). Everything else, including
+// the other headers of replaced parts, is kept byte for byte. It fails unless
+// every one of attachments was found and replaced.
+func StripMessage(raw []byte, attachments []Attachment, stub func(attachment Attachment) string) ([]byte, error) {
+	strip := map[string]Attachment{}
+	for _, attachment := range attachments {
+		strip[attachment.PartName()] = attachment
+	}
+	replaced := 0
+	stripped := rewriteEntity(raw, nil, func(part []int, header []byte) ([]byte, bool) {
+		attachment, ok := strip[Attachment{Part: part}.PartName()]
+		if !ok {
+			return nil, false
+		}
+		replaced++
+		return stubEntity(header, stub(attachment)), true
+	})
+	if replaced != len(strip) {
+		return nil, fmt.Errorf("found %d of the %d attachments to strip", replaced, len(strip))
+	}
+	return stripped, nil
+}
+
+// rewriteEntity walks the MIME entity at path (nil for the message itself),
+// numbering parts as IMAP does, and returns it with every leaf part for which
+// replace returns true replaced by what it returns. replace is given the
+// part's path and its raw header.
+func rewriteEntity(entity []byte, path []int, replace func(part []int, header []byte) ([]byte, bool)) []byte {
+	header, body := splitEntity(entity)
+	// A header that cannot be parsed leaves the entity as a single part,
+	// whose attachments then are not found.
+	fields, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(header))).ReadMIMEHeader()
+	mediaType, params, _ := mime.ParseMediaType(fields.Get("Content-Type"))
+	boundary := params["boundary"]
+	if !strings.HasPrefix(mediaType, "multipart/") || boundary == "" {
+		part := path
+		if part == nil {
+			// A message that is not multipart has just part 1.
+			part = []int{1}
+		}
+		if replacement, ok := replace(part, header); ok {
+			return replacement
+		}
+		return entity
+	}
+
+	var rewritten bytes.Buffer
+	rewritten.Write(header)
+	previous := 0
+	for index, span := range multipartSpans(body, boundary) {
+		part := rewriteEntity(body[span[0]:span[1]], append(slices.Clone(path), index+1), replace)
+		rewritten.Write(body[previous:span[0]])
+		rewritten.Write(part)
+		previous = span[1]
+	}
+	rewritten.Write(body[previous:])
+	return rewritten.Bytes()
+}
+
+// splitEntity splits a MIME entity into its header, including the blank line
+// that ends it, and its body.
+func splitEntity(entity []byte) (header, body []byte) {
+	if bytes.HasPrefix(entity, []byte("\r\n")) {
+		return entity[:2], entity[2:]
+	}
+	if bytes.HasPrefix(entity, []byte("\n")) {
+		return entity[:1], entity[1:]
+	}
+	end := len(entity)
+	for _, separator := range []string{"\r\n\r\n", "\n\n"} {
+		if index := bytes.Index(entity, []byte(separator)); index >= 0 && index+len(separator) < end {
+			end = index + len(separator)
+		}
+	}
+	return entity[:end], entity[end:]
+}
+
+// multipartSpans returns the start and end offsets in body of each part of a
+// multipart body with the given boundary. The line break before a boundary
+// line belongs to the boundary, not to the part (RFC 2046).
+func multipartSpans(body []byte, boundary string) [][2]int {
+	delimiter := []byte("--" + boundary)
+	var spans [][2]int
+	start := -1
+	for offset := 0; offset < len(body); {
+		next := len(body)
+		if index := bytes.IndexByte(body[offset:], '\n'); index >= 0 {
+			next = offset + index + 1
+		}
+		line := bytes.TrimRight(body[offset:next], " \t\r\n")
+		if rest, ok := bytes.CutPrefix(line, delimiter); ok && (len(rest) == 0 || string(rest) == "--") {
+			if start >= 0 {
+				end := offset
+				if end > start && body[end-1] == '\n' {
+					end--
+					if end > start && body[end-1] == '\r' {
+						end--
+					}
+				}
+				spans = append(spans, [2]int{start, end})
+			}
+			if len(rest) > 0 {
+				return spans
+			}
+			start = next
+		}
+		offset = next
+	}
+	// A body cut short of its closing boundary ends with its last part.
+	if start >= 0 && start < len(body) {
+		spans = append(spans, [2]int{start, len(body)})
+	}
+	return spans
+}
+
+// stubEntity returns a part with the header fields of header other than
+// those describing its content, and text as its content.
+func stubEntity(header []byte, text string) []byte {
+	newline := "\r\n"
+	if !bytes.Contains(header, []byte("\r\n")) && bytes.Contains(header, []byte("\n")) {
+		newline = "\n"
+	}
+
+	var stub bytes.Buffer
+	dropping := false
+	for _, line := range strings.SplitAfter(string(header), "\n") {
+		if strings.TrimRight(line, "\r\n") == "" {
+			// The blank line that ends the header, or what follows it.
+			continue
+		}
+		// Folded lines continue the field before them.
+		if line[0] != ' ' && line[0] != '\t' {
+			name, _, _ := strings.Cut(line, ":")
+			dropping = slices.ContainsFunc(strippedHeaders, func(field string) bool {
+				return strings.EqualFold(strings.TrimSpace(name), field)
+			})
+		}
+		if !dropping {
+			stub.WriteString(line)
+		}
+	}
+	stub.WriteString("Content-Type: text/plain; charset=utf-8" + newline)
+	stub.WriteString("Content-Transfer-Encoding: quoted-printable" + newline)
+	stub.WriteString("Content-Disposition: inline" + newline)
+	stub.WriteString(newline)
+
+	var encoded bytes.Buffer
+	writer := quotedprintable.NewWriter(&encoded)
+	writer.Write([]byte(strings.ReplaceAll(text, "\n", "\r\n")))
+	writer.Close()
+	stub.WriteString(strings.ReplaceAll(encoded.String(), "\r\n", newline))
+	return stub.Bytes()
+}
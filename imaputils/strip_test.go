@@ -0,0 +1,116 @@
+package imaputils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// crlf turns the line breaks of a message written in a Go string into CRLF.
+func crlf(text string) string {
+	return strings.ReplaceAll(text, "\n", "\r\n")
+}
+
+const strippableMessage = `From: someone@example.com
+Subject: photos
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+This is a multi-part message in MIME format.
+--outer
+Content-Type: multipart/alternative; boundary=inner
+
+--inner
+Content-Type: text/plain
+
+Here they are.
+--inner
+Content-Type: text/html
+
+<p>Here they are.</p>
+--inner--
+--outer
+Content-Type: image/jpeg;
+ name="beach.jpg"
+Content-Disposition: attachment; filename="beach.jpg"
+Content-Transfer-Encoding: base64
+Content-ID: <beach>
+
+/9j/4AAQSkZJRgABAQ==
+--outer
+Content-Type: application/pdf; name="notes.pdf"
+Content-Transfer-Encoding: base64
+
+JVBERi0=
+--outer--
+epilogue
+`
+
+func TestStripMessage(t *testing.T) {
+	stub := func(attachment Attachment) string { return "removed " + attachment.Filename }
+	raw := []byte(crlf(strippableMessage))
+
+	stripped, err := StripMessage(raw, []Attachment{{Part: []int{2}, Filename: "beach.jpg"}}, stub)
+	assert.NoError(t, err)
+	want := crlf(strings.Replace(strippableMessage, `Content-Type: image/jpeg;
+ name="beach.jpg"
+Content-Disposition: attachment; filename="beach.jpg"
+Content-Transfer-Encoding: base64
+Content-ID: <beach>
+
+/9j/4AAQSkZJRgABAQ==
+`, `Content-ID: <beach>
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+Content-Disposition: inline
+
+removed beach.jpg
+`, 1))
+	assert.Equal(t, want, string(stripped))
+
+	// Both attachments, with LF line breaks.
+	stripped, err = StripMessage([]byte(strippableMessage), []Attachment{{Part: []int{2}}, {Part: []int{3}}}, stub)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(stripped), "/9j/")
+	assert.NotContains(t, string(stripped), "JVBERi0=")
+	assert.NotContains(t, string(stripped), "\r")
+	assert.Contains(t, string(stripped), "<p>Here they are.</p>\n--inner--\n--outer\n")
+	assert.True(t, strings.HasSuffix(string(stripped), "--outer--\nepilogue\n"))
+
+	// Part 4 does not exist, so the message is left alone.
+	_, err = StripMessage(raw, []Attachment{{Part: []int{4}}}, stub)
+	assert.ErrorContains(t, err, "found 0 of the 1 attachments")
+}
+
+func TestStripMessageSinglePart(t *testing.T) {
+	raw := crlf(`From: scanner@example.com
+Subject: scan
+Content-Type: application/pdf; name="scan.pdf"
+Content-Transfer-Encoding: base64
+
+JVBERi0=
+`)
+	stripped, err := StripMessage([]byte(raw), []Attachment{{Part: []int{1}}}, func(Attachment) string { return "gone" })
+	assert.NoError(t, err)
+	assert.Equal(t, crlf(`From: scanner@example.com
+Subject: scan
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+Content-Disposition: inline
+
+gone`), string(stripped))
+}
+
+func TestMultipartSpans(t *testing.T) {
+	body := "preamble\r\n--b\r\none\r\n--b \r\n\r\n--b\r\nthree\r\n--b--\r\nepilogue"
+	var parts []string
+	for _, span := range multipartSpans([]byte(body), "b") {
+		parts = append(parts, body[span[0]:span[1]])
+	}
+	assert.Equal(t, []string{"one", "", "three"}, parts)
+
+	// A body cut short ends with its last part.
+	spans := multipartSpans([]byte("--b\r\none\r\n--b\r\ntwo"), "b")
+	assert.Equal(t, [][2]int{{5, 8}, {15, 18}}, spans)
+}
@@ -27,6 +27,25 @@ func FilterBySubject(messages []*imap.Message, opts SearchOptions) ([]*imap.Mess
 		return messages, nil
 	}
 
+	matches, err := SubjectMatcher(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]*imap.Message, 0, len(messages))
+	for _, message := range messages {
+		if matches(message) {
+			filtered = append(filtered, message)
+		}
+	}
+
+	return filtered, nil
+}
+
+// SubjectMatcher compiles the subject options into a predicate reporting
+// whether a single message passes them, with the same semantics as
+// FilterBySubject. It lets streaming consumers filter one message at a time.
+func SubjectMatcher(opts SearchOptions) (func(*imap.Message) bool, error) {
 	includes, err := compileSubjectMatchers(opts.Subject, opts.SubjectRegex)
 	if err != nil {
 		return nil, fmt.Errorf("invalid subject pattern: %w", err)
@@ -36,23 +55,17 @@ func FilterBySubject(messages []*imap.Message, opts SearchOptions) ([]*imap.Mess
 		return nil, fmt.Errorf("invalid not-subject pattern: %w", err)
 	}
 
-	filtered := make([]*imap.Message, 0, len(messages))
-	for _, message := range messages {
+	return func(message *imap.Message) bool {
 		subject := ""
 		if message.Envelope != nil {
 			subject = message.Envelope.Subject
 		}
 
 		if len(includes) > 0 && !matchesAny(includes, subject) {
-			continue
+			return false
 		}
-		if matchesAny(excludes, subject) {
-			continue
-		}
-		filtered = append(filtered, message)
-	}
-
-	return filtered, nil
+		return !matchesAny(excludes, subject)
+	}, nil
 }
 
 // matchesAny reports whether the subject satisfies at least one matcher.
@@ -0,0 +1,126 @@
+package imaputils
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/emersion/go-imap"
+)
+
+// Transfer records where messages copied or moved out of a folder ended up,
+// so that later steps (or an undo) can find them in their new folder.
+type Transfer struct {
+	Source string
+	// Dest is empty when the messages were purged rather than moved.
+	Dest string
+	// SourceUidValidity is the source folder's UIDVALIDITY when the messages
+	// left it, if the server reported it.
+	SourceUidValidity uint32
+	// DestUidValidity is the destination's UIDVALIDITY as reported with
+	// COPYUID; zero when the server does not support UIDPLUS.
+	DestUidValidity uint32
+	// UIDs maps source UIDs to destination UIDs for every message the server
+	// reported via COPYUID. It is empty without UIDPLUS.
+	UIDs map[uint32]uint32
+	// Method is how a move was carried out; empty for copies and purges.
+	Method MoveMethod
+
+	lock sync.Mutex
+}
+
+func newTransfer(source, dest string) *Transfer {
+	return &Transfer{Source: source, Dest: dest, UIDs: map[uint32]uint32{}}
+}
+
+// recordSource notes the source folder's UIDVALIDITY from its SELECT status,
+// which may be nil.
+func (transfer *Transfer) recordSource(status *imap.MailboxStatus) {
+	if status != nil {
+		transfer.SourceUidValidity = status.UidValidity
+	}
+}
+
+// record adds a COPYUID response to the transfer. It is safe to call from
+// concurrent batches.
+func (transfer *Transfer) record(copyUID *CopyUID) {
+	if copyUID == nil {
+		return
+	}
+	transfer.lock.Lock()
+	defer transfer.lock.Unlock()
+	transfer.DestUidValidity = copyUID.UidValidity
+	for index, source := range copyUID.Source {
+		transfer.UIDs[source] = copyUID.Dest[index]
+	}
+}
+
+// Follow returns the messages as they are in the destination folder: copies
+// with their destination UIDs, taken from COPYUID where the server reported
+// it and otherwise looked up by Message-ID. Messages that cannot be found in
+// the destination are left out and counted in lost.
+func (transfer *Transfer) Follow(dialer IMAPDialer, account Account, messages []*imap.Message) (followed []*imap.Message, lost int, err error) {
+	var unmapped []*imap.Message
+	for _, message := range messages {
+		uid, ok := transfer.UIDs[message.Uid]
+		if !ok {
+			unmapped = append(unmapped, message)
+			continue
+		}
+		moved := *message
+		moved.Uid = uid
+		followed = append(followed, &moved)
+	}
+	if len(unmapped) == 0 {
+		return followed, 0, nil
+	}
+
+	log.Debug().Msgf("no COPYUID for %d messages in %s; locating them by Message-ID", len(unmapped), transfer.Dest)
+	located, err := LocateMessages(dialer, account, transfer.Dest, unmapped)
+	if err != nil {
+		return nil, 0, err
+	}
+	return append(followed, located...), len(unmapped) - len(located), nil
+}
+
+// LocateMessages finds the given messages in folder by their Message-ID
+// header, returning copies carrying the UIDs they have there. It is the
+// fallback for servers without UIDPLUS. Messages without a Message-ID cannot
+// be located and are left out; when several messages in folder share a
+// Message-ID, the most recently added (highest UID) is taken.
+func LocateMessages(dialer IMAPDialer, account Account, folder string, messages []*imap.Message) ([]*imap.Message, error) {
+	if len(messages) == 0 {
+		return nil, nil
+	}
+
+	imapClient, err := connectToMailbox(dialer, account, folder, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to mailbox: %w", err)
+	}
+	defer imapClient.Logout()
+
+	return locateByMessageID(imapClient, folder, messages)
+}
+
+// locateByMessageID is LocateMessages on a client that has folder selected.
+func locateByMessageID(imapClient IMAPClient, folder string, messages []*imap.Message) ([]*imap.Message, error) {
+	var located []*imap.Message
+	for _, message := range messages {
+		if message.Envelope == nil || message.Envelope.MessageId == "" {
+			continue
+		}
+		criteria := imap.NewSearchCriteria()
+		criteria.Header.Add("Message-Id", message.Envelope.MessageId)
+		uids, err := imapClient.UidSearch(criteria)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search %s for %s: %w", folder, message.Envelope.MessageId, err)
+		}
+		if len(uids) == 0 {
+			continue
+		}
+		found := *message
+		found.Uid = slices.Max(uids)
+		located = append(located, &found)
+	}
+	return located, nil
+}
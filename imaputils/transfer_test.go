@@ -0,0 +1,73 @@
+package imaputils
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestParseCopyUID(t *testing.T) {
+	status := &imap.StatusResp{
+		Type:      imap.StatusRespOk,
+		Code:      codeCopyUID,
+		Arguments: []interface{}{"38505", "304,319:320", "3956:3958"},
+	}
+	assert.Equal(t, &CopyUID{
+		UidValidity: 38505,
+		Source:      []uint32{304, 319, 320},
+		Dest:        []uint32{3956, 3957, 3958},
+	}, parseCopyUID(status))
+
+	assert.Nil(t, parseCopyUID(&imap.StatusResp{Type: imap.StatusRespOk}))
+	assert.Nil(t, parseCopyUID(nil))
+	// Source and destination sets must pair up.
+	status.Arguments = []interface{}{"38505", "304", "3956:3957"}
+	assert.Nil(t, parseCopyUID(status))
+}
+
+func TestParseUIDSet(t *testing.T) {
+	uids, err := parseUIDSet("9,3:5")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{9, 3, 4, 5}, uids)
+
+	_, err = parseUIDSet("0:2")
+	assert.Error(t, err)
+	_, err = parseUIDSet("1,x")
+	assert.Error(t, err)
+	_, err = parseUIDSet(42)
+	assert.Error(t, err)
+}
+
+func TestTransferFollow(t *testing.T) {
+	client := &MockIMAPClientMove{}
+	dialer := &MockIMAPDialerMove{}
+	dialer.On("Dial", mock.Anything).Return(client, nil)
+	client.On("Login", mock.Anything, mock.Anything).Return(nil)
+	client.On("Select", "Archive", true).Return(&imap.MailboxStatus{Name: "Archive"}, nil)
+	client.On("Logout").Return(nil)
+	client.On("UidSearch", mock.MatchedBy(func(criteria *imap.SearchCriteria) bool {
+		return criteria.Header.Get("Message-Id") == "<two@example.com>"
+	})).Return([]uint32{40, 42}, nil)
+	client.On("UidSearch", mock.Anything).Return([]uint32{}, nil)
+
+	transfer := newTransfer("INBOX", "Archive")
+	transfer.record(&CopyUID{UidValidity: 7, Source: []uint32{1}, Dest: []uint32{101}})
+
+	messages := []*imap.Message{
+		{Uid: 1, Envelope: &imap.Envelope{MessageId: "<one@example.com>"}},
+		{Uid: 2, Envelope: &imap.Envelope{MessageId: "<two@example.com>"}},
+		{Uid: 3, Envelope: &imap.Envelope{MessageId: "<three@example.com>"}},
+		{Uid: 4},
+	}
+	followed, lost, err := transfer.Follow(dialer, Account{}, messages)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, lost)
+	if assert.Len(t, followed, 2) {
+		assert.Equal(t, uint32(101), followed[0].Uid)
+		assert.Equal(t, uint32(42), followed[1].Uid)
+	}
+	// The originals are left untouched.
+	assert.Equal(t, uint32(1), messages[0].Uid)
+}
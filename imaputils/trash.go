@@ -25,6 +25,20 @@ func EmptyFolder(dialer IMAPDialer, account Account, folder string) (int, error)
 		return 0, nil
 	}
 
+	// Everything found is flagged below, so only messages flagged \Deleted
+	// after the search count as others.
+	messages := make([]*imap.Message, len(uids))
+	for index, uid := range uids {
+		messages[index] = &imap.Message{Uid: uid}
+	}
+	plan, err := planExpunge(imapClient, folder, messages)
+	if err != nil {
+		return 0, err
+	}
+	if err := plan.Check(account, "empty "+folder); err != nil {
+		return 0, err
+	}
+
 	seqSet := new(imap.SeqSet)
 	seqSet.AddNum(uids...)
 
@@ -33,7 +47,7 @@ func EmptyFolder(dialer IMAPDialer, account Account, folder string) (int, error)
 	if err := imapClient.UidStore(seqSet, action, flags, nil); err != nil {
 		return 0, fmt.Errorf("failed to mark messages as deleted: %w", err)
 	}
-	if err := imapClient.Expunge(nil); err != nil {
+	if err := plan.expunge(imapClient, seqSet); err != nil {
 		return 0, fmt.Errorf("failed to expunge folder %s: %w", folder, err)
 	}
 
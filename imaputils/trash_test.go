@@ -8,22 +8,20 @@ import (
 )
 
 func TestFindTrashFolder(t *testing.T) {
-	listing := func(names ...string) func(chan *imap.MailboxInfo) {
-		return func(ch chan *imap.MailboxInfo) {
-			for _, name := range names {
-				ch <- &imap.MailboxInfo{Name: name}
-			}
-		}
-	}
-
 	cases := []struct {
 		name    string
-		folders []string
+		folders []*imap.MailboxInfo
+		account Account
 		want    string
+		wantErr string
 	}{
-		{"matches Trash", []string{"INBOX", "Trash", "Sent"}, "Trash"},
-		{"matches gmail trash", []string{"INBOX", "[Gmail]/Trash"}, "[Gmail]/Trash"},
-		{"falls back to default when none match", []string{"INBOX", "Sent"}, "Deleted Items"},
+		{"matches Trash", []*imap.MailboxInfo{{Name: "INBOX"}, {Name: "Trash"}, {Name: "Sent"}}, Account{}, "Trash", ""},
+		{"matches gmail trash", []*imap.MailboxInfo{{Name: "INBOX"}, {Name: "[Gmail]/Trash"}}, Account{}, "[Gmail]/Trash", ""},
+		{"prefers the \\Trash attribute", []*imap.MailboxInfo{{Name: "Trash"}, {Name: "Corbeille", Attributes: []string{imap.TrashAttr}}}, Account{}, "Corbeille", ""},
+		{"prefers the trash_folder setting", []*imap.MailboxInfo{{Name: "Corbeille", Attributes: []string{imap.TrashAttr}}, {Name: "INBOX.Bin", Delimiter: "."}}, Account{TrashFolder: "INBOX/Bin"}, "INBOX.Bin", ""},
+		{"fails when the trash_folder setting does not exist", []*imap.MailboxInfo{{Name: "Trash"}}, Account{TrashFolder: "Bin"}, "", "trash_folder Bin does not exist"},
+		{"ignores a bad archive_folder setting", []*imap.MailboxInfo{{Name: "Trash"}}, Account{ArchiveFolder: "Archiv"}, "Trash", ""},
+		{"fails rather than creating one when none match", []*imap.MailboxInfo{{Name: "INBOX"}, {Name: "Sent"}}, Account{}, "", ErrNoTrashFolder.Error()},
 	}
 
 	for _, tt := range cases {
@@ -33,15 +31,47 @@ func TestFindTrashFolder(t *testing.T) {
 			dialer.On("Dial", mock.Anything).Return(client, nil)
 			client.On("Login", mock.Anything, mock.Anything).Return(nil)
 			client.On("Logout").Return(nil)
-			client.On("List", "", "*", mock.Anything).Return(listing(tt.folders...), nil)
+			client.On("List", "", "*", mock.Anything).Return(func(ch chan *imap.MailboxInfo) {
+				for _, info := range tt.folders {
+					ch <- info
+				}
+			}, nil)
 
-			folder, err := FindTrashFolder(dialer, Account{Server: "test.example.com"})
+			tt.account.Server = "test.example.com"
+			folder, err := FindTrashFolder(dialer, tt.account)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
 			assert.NoError(t, err)
 			assert.Equal(t, tt.want, folder)
 		})
 	}
 }
 
+func TestSpecialUseFolders(t *testing.T) {
+	infos := []*imap.MailboxInfo{
+		{Name: "INBOX"},
+		{Name: "Gesendet", Attributes: []string{imap.SentAttr}},
+		{Name: "Entwürfe", Attributes: []string{imap.DraftsAttr}},
+		{Name: "Spam", Attributes: []string{imap.JunkAttr}},
+		{Name: "Alle", Attributes: []string{imap.NoSelectAttr, imap.AllAttr}},
+		{Name: "Papierkorb", Attributes: []string{imap.TrashAttr}},
+		{Name: "Ablage"},
+	}
+	uses, settingErrs := resolveSpecialUse(infos, Account{ArchiveFolder: "Ablage"})
+	assert.Empty(t, settingErrs)
+	assert.Equal(t, map[SpecialUse]string{
+		UseSent: "Gesendet", UseDrafts: "Entwürfe", UseJunk: "Spam",
+		UseAll: "Alle", UseTrash: "Papierkorb", UseArchive: "Ablage",
+	}, uses)
+
+	uses, settingErrs = resolveSpecialUse(infos, Account{ArchiveFolder: "Archiv"})
+	assert.ErrorContains(t, settingErrs[UseArchive], "archive_folder Archiv does not exist")
+	assert.NoError(t, settingErrs[UseTrash])
+	assert.Equal(t, "Papierkorb", uses[UseTrash], "the other uses still resolve")
+}
+
 func TestEmptyFolder(t *testing.T) {
 	t.Run("permanently deletes every message in the folder", func(t *testing.T) {
 		client := &MockIMAPClientMove{}
@@ -51,6 +81,7 @@ func TestEmptyFolder(t *testing.T) {
 		client.On("Login", mock.Anything, mock.Anything).Return(nil)
 		client.On("Logout").Return(nil)
 		client.On("Select", "Trash", false).Return(&imap.MailboxStatus{}, nil)
+		client.On("Capability").Return(map[string]bool{}, nil)
 		client.On("UidSearch", mock.Anything).Return([]uint32{10, 11, 12}, nil)
 		client.On("UidStore",
 			mock.Anything,
@@ -66,6 +97,28 @@ func TestEmptyFolder(t *testing.T) {
 		client.AssertExpectations(t)
 	})
 
+	t.Run("expunges only what it flagged with UIDPLUS", func(t *testing.T) {
+		client := &MockIMAPClientMove{}
+		dialer := &MockIMAPDialerMove{}
+
+		dialer.On("Dial", mock.Anything).Return(client, nil)
+		client.On("Login", mock.Anything, mock.Anything).Return(nil)
+		client.On("Logout").Return(nil)
+		client.On("Select", "Trash", false).Return(&imap.MailboxStatus{}, nil)
+		client.On("Capability").Return(map[string]bool{"UIDPLUS": true}, nil)
+		client.On("UidSearch", mock.Anything).Return([]uint32{10, 11}, nil)
+		client.On("UidStore", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+		client.On("UidExpunge", mock.MatchedBy(func(seqSet *imap.SeqSet) bool {
+			return seqSet.String() == "10:11"
+		})).Return(nil)
+
+		count, err := EmptyFolder(dialer, Account{Server: "test.example.com"}, "Trash")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+		client.AssertExpectations(t)
+		client.AssertNotCalled(t, "Expunge", mock.Anything)
+	})
+
 	t.Run("empty folder does not store or expunge", func(t *testing.T) {
 		client := &MockIMAPClientMove{}
 		dialer := &MockIMAPDialerMove{}
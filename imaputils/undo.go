@@ -0,0 +1,156 @@
+package imaputils
+
+import (
+	"fmt"
+
+	"github.com/emersion/go-imap"
+)
+
+// UndoResult reports what UndoOperation did.
+type UndoResult struct {
+	// Undone is the journal entry that was reverted.
+	Undone JournalEntry
+	// Restored is the number of messages moved back (or, for a copy, the
+	// number of copies deleted).
+	Restored int
+	// Lost is the number of messages that could no longer be found in the
+	// operation's destination, e.g. because the trash has since been emptied.
+	Lost int
+}
+
+// UndoOperation reverts the journal entry with the given ID, or, when id is 0,
+// the account's most recent operation that can still be undone. Moved and
+// deleted messages are moved back from where they went to the folder they came
+// from; the copies made by a copy are deleted. Messages are found by the UIDs
+// recorded from COPYUID while the destination's UIDVALIDITY is unchanged, and
+// by Message-ID otherwise. The undo is itself recorded in the journal.
+func UndoOperation(dialer IMAPDialer, account Account, journal *Journal, id int) (UndoResult, error) {
+	entries, err := journal.Entries()
+	if err != nil {
+		return UndoResult{}, err
+	}
+	target, err := FindUndoTarget(account, entries, id)
+	if err != nil {
+		return UndoResult{}, err
+	}
+
+	located, err := locateJournalMessages(dialer, account, target)
+	if err != nil {
+		return UndoResult{}, fmt.Errorf("failed to find the messages of operation %d in %s: %w", target.ID, target.Dest, err)
+	}
+	result := UndoResult{Undone: target, Restored: len(located), Lost: target.Count - len(located)}
+	if len(located) == 0 {
+		return result, fmt.Errorf("none of the %d messages of operation %d are left in %s", target.Count, target.ID, target.Dest)
+	}
+
+	var transfer *Transfer
+	switch target.Operation {
+	case JournalCopy:
+		transfer, err = DeleteMessages(dialer, account, located, target.Dest)
+		if err != nil {
+			return result, fmt.Errorf("failed to delete copies from %s: %w", target.Dest, err)
+		}
+	default:
+		transfer, err = MoveMessages(dialer, account, located, target.Dest, target.Source, 100)
+		if err != nil {
+			return result, fmt.Errorf("failed to move messages back to %s: %w", target.Source, err)
+		}
+	}
+
+	entry := NewJournalEntry(account, JournalUndo, transfer, located)
+	entry.Undoes = target.ID
+	journal.Record(entry)
+	return result, nil
+}
+
+// FindUndoTarget picks the entry among entries that UndoOperation would
+// revert for id, and checks that it can be undone.
+func FindUndoTarget(account Account, entries []JournalEntry, id int) (JournalEntry, error) {
+	undone := UndoneBy(entries)
+	if id == 0 {
+		for index := len(entries) - 1; index >= 0; index-- {
+			entry := entries[index]
+			if ok, _ := entry.Undoable(undone); ok && entry.Account == account.Name {
+				return entry, nil
+			}
+		}
+		return JournalEntry{}, fmt.Errorf("no operation to undo for account %s", account.Name)
+	}
+
+	for _, entry := range entries {
+		if entry.ID != id {
+			continue
+		}
+		if entry.Account != account.Name {
+			return JournalEntry{}, fmt.Errorf("operation %d belongs to account %s, not %s", id, entry.Account, account.Name)
+		}
+		if ok, reason := entry.Undoable(undone); !ok {
+			return JournalEntry{}, fmt.Errorf("operation %d cannot be undone: %s", id, reason)
+		}
+		return entry, nil
+	}
+	return JournalEntry{}, fmt.Errorf("no operation %d in the journal", id)
+}
+
+// locateJournalMessages finds the messages of entry in its destination
+// folder, returning them with their current UIDs there. Recorded destination
+// UIDs are trusted only while the folder's UIDVALIDITY matches the one
+// recorded with them; every message not found that way is looked up by its
+// Message-ID.
+func locateJournalMessages(dialer IMAPDialer, account Account, entry JournalEntry) ([]*imap.Message, error) {
+	imapClient, status, err := selectMailbox(dialer, account, entry.Dest, true)
+	if err != nil {
+		return nil, err
+	}
+	defer imapClient.Logout()
+
+	present := map[uint32]bool{}
+	uidsValid := status != nil && entry.DestUidValidity != 0 && status.UidValidity == entry.DestUidValidity
+	if status != nil && entry.DestUidValidity != 0 && !uidsValid {
+		log.Warn().Msgf("UIDVALIDITY of %s changed since operation %d; locating its messages by Message-ID", entry.Dest, entry.ID)
+	}
+	if uidsValid {
+		uids := new(imap.SeqSet)
+		for _, message := range entry.Messages {
+			if message.DestUID != 0 {
+				uids.AddNum(message.DestUID)
+			}
+		}
+		if !uids.Empty() {
+			criteria := imap.NewSearchCriteria()
+			criteria.Uid = uids
+			found, err := imapClient.UidSearch(criteria)
+			if err != nil {
+				return nil, fmt.Errorf("failed to search %s: %w", entry.Dest, err)
+			}
+			for _, uid := range found {
+				present[uid] = true
+			}
+		}
+	}
+
+	var located, pending []*imap.Message
+	for _, message := range entry.Messages {
+		envelope := &imap.Envelope{MessageId: message.MessageID}
+		switch {
+		case present[message.DestUID]:
+			located = append(located, &imap.Message{Uid: message.DestUID, Envelope: envelope})
+		case message.MessageID != "":
+			pending = append(pending, &imap.Message{Uid: message.SourceUID, Envelope: envelope})
+		}
+	}
+
+	found, err := locateByMessageID(imapClient, entry.Dest, pending)
+	if err != nil {
+		return nil, err
+	}
+	// Several journaled messages may share a Message-ID and so be located as
+	// the same message; act on it once.
+	for _, message := range found {
+		if !present[message.Uid] {
+			present[message.Uid] = true
+			located = append(located, message)
+		}
+	}
+	return located, nil
+}
@@ -0,0 +1,99 @@
+package imaputils
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestUndoOperationMovesMessagesBack(t *testing.T) {
+	journal := NewJournal(filepath.Join(t.TempDir(), "journal.jsonl"))
+	_, err := journal.Append(JournalEntry{
+		Account: "work", Operation: JournalMove, Source: "INBOX", Dest: "Archive",
+		DestUidValidity: 9, Count: 2,
+		Messages: []JournalMessage{
+			{SourceUID: 1, DestUID: 501, MessageID: "<a@example.com>"},
+			{SourceUID: 2, DestUID: 502, MessageID: "<b@example.com>"},
+		},
+	})
+	assert.NoError(t, err)
+
+	client := &MockIMAPClientMove{}
+	dialer := &MockIMAPDialerMove{}
+	dialer.On("Dial", mock.Anything).Return(client, nil)
+	client.On("Login", mock.Anything, mock.Anything).Return(nil)
+	client.On("Logout").Return(nil)
+	client.On("Select", "Archive", true).Return(&imap.MailboxStatus{Name: "Archive", UidValidity: 9}, nil)
+	client.On("Select", "Archive", false).Return(&imap.MailboxStatus{Name: "Archive", UidValidity: 9}, nil)
+	// 502 has since been removed from Archive, and no message with its
+	// Message-ID is left either.
+	client.On("UidSearch", mock.MatchedBy(func(criteria *imap.SearchCriteria) bool {
+		return criteria.Uid != nil && criteria.Uid.String() == "501:502"
+	})).Return([]uint32{501}, nil).Once()
+	client.On("UidSearch", mock.MatchedBy(func(criteria *imap.SearchCriteria) bool {
+		return criteria.Header.Get("Message-Id") == "<b@example.com>"
+	})).Return([]uint32{}, nil).Once()
+	client.On("Capability").Return(map[string]bool{"MOVE": true}, nil)
+	client.On("UidMove", mock.MatchedBy(func(seqSet *imap.SeqSet) bool {
+		return seqSet.String() == "501"
+	}), "INBOX").Return(nil, &CopyUID{UidValidity: 3, Source: []uint32{501}, Dest: []uint32{77}}).Once()
+	client.On("UidFetch", mock.Anything, []imap.FetchItem{imap.FetchUid}, mock.Anything).Return(
+		func(ch chan *imap.Message) {}, nil).Once()
+
+	result, err := UndoOperation(dialer, Account{Name: "work"}, journal, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Undone.ID)
+	assert.Equal(t, 1, result.Restored)
+	assert.Equal(t, 1, result.Lost)
+	client.AssertExpectations(t)
+
+	entries, err := journal.Entries()
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		undo := entries[1]
+		assert.Equal(t, JournalUndo, undo.Operation)
+		assert.Equal(t, 1, undo.Undoes)
+		assert.Equal(t, "Archive", undo.Source)
+		assert.Equal(t, "INBOX", undo.Dest)
+		assert.Equal(t, []JournalMessage{{SourceUID: 501, DestUID: 77, MessageID: "<a@example.com>"}}, undo.Messages)
+	}
+
+	_, err = UndoOperation(dialer, Account{Name: "work"}, journal, 1)
+	assert.ErrorContains(t, err, "undone by 2")
+}
+
+func TestUndoOperationLocatesByMessageIDAfterUidValidityChange(t *testing.T) {
+	journal := NewJournal(filepath.Join(t.TempDir(), "journal.jsonl"))
+	_, err := journal.Append(JournalEntry{
+		Account: "work", Operation: JournalDelete, Source: "INBOX", Dest: "Trash",
+		DestUidValidity: 9, Count: 1,
+		Messages: []JournalMessage{{SourceUID: 1, DestUID: 501, MessageID: "<a@example.com>"}},
+	})
+	assert.NoError(t, err)
+
+	client := &MockIMAPClientMove{}
+	dialer := &MockIMAPDialerMove{}
+	dialer.On("Dial", mock.Anything).Return(client, nil)
+	client.On("Login", mock.Anything, mock.Anything).Return(nil)
+	client.On("Logout").Return(nil)
+	client.On("Select", "Trash", true).Return(&imap.MailboxStatus{Name: "Trash", UidValidity: 10}, nil)
+	client.On("Select", "Trash", false).Return(&imap.MailboxStatus{Name: "Trash", UidValidity: 10}, nil)
+	client.On("UidSearch", mock.MatchedBy(func(criteria *imap.SearchCriteria) bool {
+		return criteria.Header.Get("Message-Id") == "<a@example.com>"
+	})).Return([]uint32{12}, nil).Once()
+	client.On("Capability").Return(map[string]bool{"MOVE": true}, nil)
+	client.On("UidMove", mock.MatchedBy(func(seqSet *imap.SeqSet) bool {
+		return seqSet.String() == "12"
+	}), "INBOX").Return(nil).Once()
+	client.On("UidFetch", mock.Anything, []imap.FetchItem{imap.FetchUid}, mock.Anything).Return(
+		func(ch chan *imap.Message) {}, nil).Once()
+
+	result, err := UndoOperation(dialer, Account{Name: "work"}, journal, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Restored)
+	assert.Equal(t, 0, result.Lost)
+	client.AssertExpectations(t)
+}
@@ -0,0 +1,214 @@
+package imaputils
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// IdleRestart is how often WatchFolder re-issues IDLE, well within the 29
+// minutes after which a server may drop an idle client (RFC 2177).
+const IdleRestart = 25 * time.Minute
+
+// WatchOptions tune WatchFolder.
+type WatchOptions struct {
+	// PollInterval is how often the folder is polled with NOOP on servers
+	// without IDLE.
+	PollInterval time.Duration
+	// Backoff is the wait before the first reconnection attempt after the
+	// connection is lost; it doubles with each failed attempt, up to
+	// MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Retries is how many times a batch of new messages that could not be
+	// handled is tried again, after waiting Backoff (doubling each time, up
+	// to MaxBackoff), before it is given up.
+	Retries int
+}
+
+// DefaultWatchOptions are the WatchOptions the watch command starts from.
+func DefaultWatchOptions() WatchOptions {
+	return WatchOptions{PollInterval: time.Minute, Backoff: 5 * time.Second, MaxBackoff: 5 * time.Minute, Retries: 3}
+}
+
+// WatchFolder waits for messages to arrive in folder and calls handle with
+// the UIDs of each batch of new messages, in order. Messages already in the
+// folder when watching starts are left alone. It holds a read-only connection
+// in IDLE, or polls if the server lacks IDLE, and reconnects with backoff
+// whenever the connection is lost; messages that arrived in the meantime are
+// handled after reconnecting. If handle fails, the same batch is tried again
+// up to options.Retries times; after that the failure is logged and the batch
+// is skipped, so that one message that cannot be handled does not hold up the
+// ones arriving after it.
+//
+// WatchFolder returns nil when ctx is cancelled, once the current batch is
+// handled. It returns an error only if the first connection fails, so that a
+// misconfiguration is reported rather than retried forever.
+func WatchFolder(ctx context.Context, dialer IMAPDialer, account Account, folder string, options WatchOptions, handle func(uids []uint32) error) error {
+	watcher := &folderWatcher{dialer: dialer, account: account, folder: folder, options: options, handle: handle}
+	backoff := options.Backoff
+	for {
+		err := watcher.session(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !watcher.started {
+			return err
+		}
+		if watcher.connected {
+			backoff = options.Backoff
+		}
+		log.Warn().Msgf("watch of %s interrupted (%v); reconnecting in %s", folder, err, backoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, options.MaxBackoff)
+	}
+}
+
+// folderWatcher is the state WatchFolder keeps across connections.
+type folderWatcher struct {
+	dialer  IMAPDialer
+	account Account
+	folder  string
+	options WatchOptions
+	handle  func(uids []uint32) error
+
+	// started is set once the first connection succeeded; connected reports
+	// whether the latest session got as far as selecting the folder.
+	started, connected bool
+	uidValidity        uint32
+	// lastUID is the highest UID that has been handled, or that was already
+	// in the folder when watching started.
+	lastUID uint32
+}
+
+// session connects, handles whatever is new, and then waits for and handles
+// new messages until the connection fails or ctx is cancelled.
+func (watcher *folderWatcher) session(ctx context.Context) error {
+	watcher.connected = false
+	imapClient, status, err := selectMailbox(watcher.dialer, watcher.account, watcher.folder, true)
+	if err != nil {
+		return err
+	}
+	defer imapClient.Logout()
+	if status == nil {
+		status = &imap.MailboxStatus{}
+	}
+
+	if !watcher.started || status.UidValidity != watcher.uidValidity {
+		if watcher.started {
+			log.Warn().Msgf("UIDVALIDITY of %s changed (%d -> %d); only messages arriving from now on will be handled",
+				watcher.folder, watcher.uidValidity, status.UidValidity)
+		}
+		watcher.uidValidity = status.UidValidity
+		if watcher.lastUID, err = highestUID(imapClient, status); err != nil {
+			return err
+		}
+	}
+	watcher.started, watcher.connected = true, true
+	if capabilities, err := imapClient.Capability(); err == nil && !capabilities["IDLE"] {
+		log.Info().Msgf("server does not support IDLE; polling %s every %s", watcher.folder, watcher.options.PollInterval)
+	}
+
+	for {
+		uids, err := uidsAbove(imapClient, watcher.lastUID)
+		if err != nil {
+			return err
+		}
+		if len(uids) > 0 {
+			watcher.handleBatch(ctx, uids)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := waitForNewMessages(ctx, imapClient, watcher.options.PollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// handleBatch calls handle on the new messages uids, trying again with backoff
+// if it fails, and then moves past them, whether they were handled or given
+// up. It leaves them to be handled again after reconnecting only if ctx is
+// cancelled while waiting to retry.
+func (watcher *folderWatcher) handleBatch(ctx context.Context, uids []uint32) {
+	backoff := watcher.options.Backoff
+	for attempt := 1; ; attempt++ {
+		err := watcher.handle(uids)
+		if err == nil {
+			break
+		}
+		if attempt > watcher.options.Retries {
+			log.Error().Msgf("giving up on %d new messages in %s (UIDs %d-%d) after %d attempts: %v",
+				len(uids), watcher.folder, uids[0], uids[len(uids)-1], attempt, err)
+			break
+		}
+		log.Warn().Msgf("failed to handle %d new messages in %s (%v); trying again in %s", len(uids), watcher.folder, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, watcher.options.MaxBackoff)
+	}
+	watcher.lastUID = uids[len(uids)-1]
+}
+
+// highestUID returns the highest UID in the selected folder, or 0 if it is
+// empty.
+func highestUID(imapClient IMAPClient, status *imap.MailboxStatus) (uint32, error) {
+	if status.UidNext > 0 {
+		return status.UidNext - 1, nil
+	}
+	// "n:*" always matches the message with the highest UID.
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(math.MaxUint32, 0)
+	uids, err := findMessageUIDs(imapClient, criteria)
+	if err != nil {
+		return 0, err
+	}
+	highest := uint32(0)
+	for _, uid := range uids {
+		highest = max(highest, uid)
+	}
+	return highest, nil
+}
+
+// waitForNewMessages idles until the server reports that the number of
+// messages in the selected folder changed, or ctx is cancelled.
+func waitForNewMessages(ctx context.Context, imapClient IMAPClient, pollInterval time.Duration) error {
+	updates := imapClient.Updates()
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- imapClient.Idle(stop, &client.IdleOptions{LogoutTimeout: IdleRestart, PollInterval: pollInterval})
+	}()
+
+	stopIdle := func() error {
+		close(stop)
+		return <-done
+	}
+	for {
+		select {
+		case update := <-updates:
+			if _, ok := update.(*client.MailboxUpdate); ok {
+				return stopIdle()
+			}
+		case err := <-done:
+			if err == nil {
+				err = errors.New("IDLE ended unexpectedly")
+			}
+			return err
+		case <-ctx.Done():
+			return stopIdle()
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package imaputils
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// watchClient is an IMAPClient for WatchFolder: Select reports status,
+// UidSearch returns searches in turn (nothing once they run out) and each
+// Idle runs the next of idles, which send their updates to Updates.
+type watchClient struct {
+	IMAPClient
+	status   *imap.MailboxStatus
+	searches [][]uint32
+	idles    []func(stop <-chan struct{}, updates chan<- client.Update) error
+	updates  chan client.Update
+}
+
+func (c *watchClient) Login(username, password string) error { return nil }
+func (c *watchClient) Logout() error                         { return nil }
+
+func (c *watchClient) Capability() (map[string]bool, error) {
+	return map[string]bool{"IDLE": true}, nil
+}
+
+func (c *watchClient) Select(name string, readOnly bool) (*imap.MailboxStatus, error) {
+	return c.status, nil
+}
+
+func (c *watchClient) UidSearch(criteria *imap.SearchCriteria) ([]uint32, error) {
+	if len(c.searches) == 0 {
+		return nil, nil
+	}
+	uids := c.searches[0]
+	c.searches = c.searches[1:]
+	return uids, nil
+}
+
+func (c *watchClient) Idle(stop <-chan struct{}, opts *client.IdleOptions) error {
+	idle := c.idles[0]
+	c.idles = c.idles[1:]
+	return idle(stop, c.updates)
+}
+
+func (c *watchClient) Updates() <-chan client.Update {
+	if c.updates == nil {
+		c.updates = make(chan client.Update, 16)
+	}
+	return c.updates
+}
+
+// watchDialer hands out clients in turn, then refuses to connect.
+type watchDialer struct {
+	clients []*watchClient
+}
+
+func (d *watchDialer) Dial(address string) (IMAPClient, error) {
+	if len(d.clients) == 0 {
+		return nil, errors.New("connection refused")
+	}
+	next := d.clients[0]
+	d.clients = d.clients[1:]
+	return next, nil
+}
+
+func (d *watchDialer) DialTLS(address string, config *tls.Config) (IMAPClient, error) {
+	return d.Dial(address)
+}
+
+// newMail reports new messages, then idles until stopped.
+func newMail(stop <-chan struct{}, updates chan<- client.Update) error {
+	updates <- &client.MailboxUpdate{Mailbox: &imap.MailboxStatus{Messages: 3}}
+	<-stop
+	return nil
+}
+
+func TestWatchFolder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dialer := &watchDialer{clients: []*watchClient{
+		{
+			status: &imap.MailboxStatus{UidValidity: 7, UidNext: 10},
+			// "10:*" matches the existing message 9, which is not new.
+			searches: [][]uint32{{9}, {10, 11}},
+			idles: []func(<-chan struct{}, chan<- client.Update) error{
+				newMail,
+				func(stop <-chan struct{}, updates chan<- client.Update) error {
+					return errors.New("connection reset")
+				},
+			},
+		},
+		{
+			// Message 12 arrived while disconnected.
+			status:   &imap.MailboxStatus{UidValidity: 7, UidNext: 13},
+			searches: [][]uint32{{12}},
+			idles: []func(<-chan struct{}, chan<- client.Update) error{
+				func(stop <-chan struct{}, updates chan<- client.Update) error {
+					cancel()
+					<-stop
+					return nil
+				},
+			},
+		},
+	}}
+
+	var handled [][]uint32
+	options := WatchOptions{PollInterval: time.Minute, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	err := WatchFolder(ctx, dialer, Account{Server: "imap.example.com", Port: 143}, "INBOX", options, func(uids []uint32) error {
+		handled = append(handled, uids)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]uint32{{10, 11}, {12}}, handled)
+}
+
+func TestWatchFolderFirstConnectionFails(t *testing.T) {
+	err := WatchFolder(context.Background(), &watchDialer{}, Account{}, "INBOX", DefaultWatchOptions(), func(uids []uint32) error {
+		return nil
+	})
+	assert.ErrorContains(t, err, "connection refused")
+}
+
+func TestHighestUID(t *testing.T) {
+	highest, err := highestUID(&watchClient{}, &imap.MailboxStatus{UidNext: 50})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(49), highest)
+
+	// Without UIDNEXT, ask the server for the highest UID.
+	highest, err = highestUID(&watchClient{searches: [][]uint32{{42}}}, &imap.MailboxStatus{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(42), highest)
+}
+
+func TestWatchFolderHandlerKeepsFailing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dialer := &watchDialer{clients: []*watchClient{
+		{
+			status: &imap.MailboxStatus{UidValidity: 7, UidNext: 10},
+			// Message 10 cannot be handled; 11 arrives after it is given up.
+			searches: [][]uint32{{10}, {11}},
+			idles: []func(<-chan struct{}, chan<- client.Update) error{
+				newMail,
+				func(stop <-chan struct{}, updates chan<- client.Update) error {
+					cancel()
+					<-stop
+					return nil
+				},
+			},
+		},
+	}}
+
+	var handled [][]uint32
+	options := WatchOptions{PollInterval: time.Minute, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond, Retries: 2}
+	err := WatchFolder(ctx, dialer, Account{Server: "imap.example.com", Port: 143}, "INBOX", options, func(uids []uint32) error {
+		handled = append(handled, uids)
+		if uids[0] == 10 {
+			return errors.New("rule failed")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	// Tried three times without reconnecting, then skipped.
+	assert.Equal(t, [][]uint32{{10}, {10}, {10}, {11}}, handled)
+}
@@ -0,0 +1,95 @@
+package mailstore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// emlWriter writes each message to its own .eml file, named after its date,
+// in a directory. The file's modification time is set to the date; flags are
+// not kept.
+type emlWriter struct {
+	path string
+}
+
+func newEMLWriter(path string) (*emlWriter, error) {
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", path, err)
+	}
+	return &emlWriter{path: path}, nil
+}
+
+func (writer *emlWriter) Write(message Message) (string, error) {
+	date := message.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	stem := date.UTC().Format("20060102-150405")
+
+	// Messages from the same second get a counter; never overwrite.
+	for attempt := 0; ; attempt++ {
+		name := stem + ".eml"
+		if attempt > 0 {
+			name = fmt.Sprintf("%s-%d.eml", stem, attempt)
+		}
+		path := filepath.Join(writer.path, name)
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to create %s: %w", path, err)
+		}
+		_, err = file.Write(message.Body)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		if err := os.Chtimes(path, date, date); err != nil {
+			return "", fmt.Errorf("failed to set the date of %s: %w", path, err)
+		}
+		return name, nil
+	}
+}
+
+func (writer *emlWriter) Close() error {
+	return nil
+}
+
+// openEMLReader reads the .eml files in the directory at path, in name order,
+// or the single .eml file at path. A message's date is taken from its Date
+// header, or failing that from the file's modification time.
+func openEMLReader(path, position string) (Reader, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var files []storedFile
+	if info.IsDir() {
+		names, err := listFiles(path, func(name string) bool {
+			return strings.EqualFold(filepath.Ext(name), ".eml")
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			files = append(files, storedFile{path: filepath.Join(path, name), key: name})
+		}
+	} else {
+		files = []storedFile{{path: path, key: filepath.Base(path)}}
+	}
+	return newFileReader(files, position, func(file storedFile, body []byte, info os.FileInfo) Message {
+		date := headerDate(body)
+		if date.IsZero() {
+			date = info.ModTime()
+		}
+		return Message{Body: body, Date: date}
+	}), nil
+}
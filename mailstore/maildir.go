@@ -0,0 +1,162 @@
+package mailstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// maildirFlags maps IMAP flags to the letters of a Maildir info suffix
+// (":2,<letters>"), which must appear in ASCII order.
+var maildirFlags = []struct {
+	letter byte
+	flag   string
+}{
+	{'D', imap.DraftFlag},
+	{'F', imap.FlaggedFlag},
+	{'R', imap.AnsweredFlag},
+	{'S', imap.SeenFlag},
+	{'T', imap.DeletedFlag},
+}
+
+// maildirSequence makes the names of messages delivered within the same
+// second unique.
+var maildirSequence atomic.Int64
+
+// maildirWriter delivers messages into a Maildir: each is written to tmp and
+// then renamed into cur, with its flags in the info suffix, so readers never
+// see a partial message.
+type maildirWriter struct {
+	path string
+}
+
+func newMaildirWriter(path string) (*maildirWriter, error) {
+	if err := CreateMaildir(path); err != nil {
+		return nil, err
+	}
+	return &maildirWriter{path: path}, nil
+}
+
+// CreateMaildir creates a Maildir at path, with its cur, new and tmp
+// subdirectories, if it does not exist yet.
+func CreateMaildir(path string) error {
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(path, sub), 0o700); err != nil {
+			return fmt.Errorf("failed to create maildir %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func (writer *maildirWriter) Write(message Message) (string, error) {
+	unique := maildirUniqueName()
+	temporary := filepath.Join(writer.path, "tmp", unique)
+	if err := os.WriteFile(temporary, normalizeNewlines(message.Body), 0o600); err != nil {
+		return "", fmt.Errorf("failed to write message to %s: %w", writer.path, err)
+	}
+	if !message.Date.IsZero() {
+		// The modification time is how Maildir readers learn the date.
+		if err := os.Chtimes(temporary, message.Date, message.Date); err != nil {
+			return "", fmt.Errorf("failed to set the date of %s: %w", temporary, err)
+		}
+	}
+	name := unique + MaildirInfo(message.Flags)
+	if err := os.Rename(temporary, filepath.Join(writer.path, "cur", name)); err != nil {
+		return "", fmt.Errorf("failed to deliver message to %s: %w", writer.path, err)
+	}
+	return name, nil
+}
+
+func (writer *maildirWriter) Close() error {
+	return nil
+}
+
+// maildirUniqueName returns a new unique file name in the conventional
+// "<seconds>.P<pid>Q<sequence>.<hostname>" form.
+func maildirUniqueName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	// "/" and ":" cannot appear in the name; the latter starts the info.
+	host = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(host)
+	return fmt.Sprintf("%d.P%dQ%d.%s", time.Now().Unix(), os.Getpid(), maildirSequence.Add(1), host)
+}
+
+// MaildirInfo renders IMAP flags as a Maildir info suffix, e.g. ":2,FS".
+// Keywords have no standard letter and are not kept.
+func MaildirInfo(flags []string) string {
+	var letters []byte
+	for _, mapping := range maildirFlags {
+		if slices.Contains(flags, mapping.flag) {
+			letters = append(letters, mapping.letter)
+		}
+	}
+	return ":2," + string(letters)
+}
+
+// openMaildirReader reads the messages in the cur and new subdirectories of
+// the Maildir at path, ordered by their unique names, which start with the
+// delivery time.
+func openMaildirReader(path, position string) (Reader, error) {
+	var files []storedFile
+	for _, sub := range []string{"cur", "new"} {
+		names, err := listFiles(filepath.Join(path, sub), func(name string) bool {
+			return !strings.HasPrefix(name, ".")
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			unique, _, _ := strings.Cut(name, ":")
+			files = append(files, storedFile{path: filepath.Join(path, sub, name), key: unique})
+		}
+	}
+	return newFileReader(files, position, func(file storedFile, body []byte, info os.FileInfo) Message {
+		// The modification time is the delivery date.
+		return Message{Body: body, Date: info.ModTime(), Flags: maildirInfoFlags(filepath.Base(file.path))}
+	}), nil
+}
+
+// maildirInfoFlags returns the IMAP flags in the info suffix of a Maildir
+// file name. P (passed, i.e. forwarded) becomes the $Forwarded keyword.
+func maildirInfoFlags(name string) []string {
+	_, info, found := strings.Cut(name, ":2,")
+	if !found {
+		return nil
+	}
+	var flags []string
+	for _, letter := range []byte(info) {
+		if letter == 'P' {
+			flags = append(flags, "$Forwarded")
+			continue
+		}
+		for _, mapping := range maildirFlags {
+			if mapping.letter == letter {
+				flags = append(flags, mapping.flag)
+			}
+		}
+	}
+	return flags
+}
+
+// SetMaildirFlags renames the message name in the cur directory of the
+// Maildir at path so that its info suffix holds flags, and returns the new
+// name. Nothing is renamed if the flags are unchanged.
+func SetMaildirFlags(path, name string, flags []string) (string, error) {
+	unique, _, _ := strings.Cut(name, ":")
+	renamed := unique + MaildirInfo(flags)
+	if renamed == name {
+		return name, nil
+	}
+	if err := os.Rename(filepath.Join(path, "cur", name), filepath.Join(path, "cur", renamed)); err != nil {
+		return "", fmt.Errorf("failed to update the flags of %s: %w", name, err)
+	}
+	return renamed, nil
+}
@@ -0,0 +1,232 @@
+// Package mailstore reads and writes messages in the local mail formats
+// shemail exports to and imports from: mbox files, Maildir directories and
+// directories of .eml files.
+package mailstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Format is a local mail storage format.
+type Format string
+
+const (
+	// FormatMbox is a single mbox file (the mboxrd variant), with IMAP flags
+	// kept in Status and X-Status headers.
+	FormatMbox Format = "mbox"
+	// FormatMaildir is a Maildir directory, with IMAP flags kept in each
+	// file name's info suffix.
+	FormatMaildir Format = "maildir"
+	// FormatEML is a directory of one .eml file per message. It keeps no
+	// flags.
+	FormatEML Format = "eml"
+)
+
+// Formats lists the supported formats, for help text.
+var Formats = []Format{FormatMbox, FormatMaildir, FormatEML}
+
+// ParseFormat parses the name of a format.
+func ParseFormat(name string) (Format, error) {
+	for _, format := range Formats {
+		if strings.EqualFold(name, string(format)) {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown format %q (expected one of mbox, maildir, eml)", name)
+}
+
+// Message is a complete RFC 822 message together with the IMAP metadata the
+// local formats can keep.
+type Message struct {
+	// Body is the raw message, headers included.
+	Body []byte
+	// Date is the IMAP INTERNALDATE: when the message arrived.
+	Date time.Time
+	// Flags are IMAP flags, e.g. \Seen.
+	Flags []string
+	// Sender is the envelope sender for an mbox "From " line; if empty,
+	// MAILER-DAEMON is used.
+	Sender string
+}
+
+// Writer stores messages in one of the local formats.
+type Writer interface {
+	// Write stores message and returns the name it was stored under: the
+	// file name within a Maildir or .eml directory, or the mbox path.
+	Write(message Message) (string, error)
+	Close() error
+}
+
+// NewWriter opens path for writing messages in format. An mbox file is
+// appended to; Maildir and .eml directories are created as needed, and new
+// messages are added alongside any already there.
+func NewWriter(format Format, path string) (Writer, error) {
+	switch format {
+	case FormatMbox:
+		return newMboxWriter(path)
+	case FormatMaildir:
+		return newMaildirWriter(path)
+	case FormatEML:
+		return newEMLWriter(path)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// Reader reads the messages stored in one of the local formats, in order.
+type Reader interface {
+	// Next returns the next message, or io.EOF after the last one.
+	Next() (Message, error)
+	// Position returns a token for the point just after the last message
+	// returned, from which OpenReader can resume.
+	Position() string
+	Close() error
+}
+
+// DetectFormat guesses the format of path: a directory with a cur
+// subdirectory is a Maildir, any other directory holds .eml files, a file
+// named *.eml is a single message and any other file is an mbox.
+func DetectFormat(path string) (Format, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if !info.IsDir() {
+		if strings.EqualFold(filepath.Ext(path), ".eml") {
+			return FormatEML, nil
+		}
+		return FormatMbox, nil
+	}
+	if info, err := os.Stat(filepath.Join(path, "cur")); err == nil && info.IsDir() {
+		return FormatMaildir, nil
+	}
+	return FormatEML, nil
+}
+
+// OpenReader opens path, in format, for reading. position, if not empty, is
+// a Position returned by an earlier reader of the same path, and reading
+// resumes after the messages that reader had returned.
+func OpenReader(format Format, path, position string) (Reader, error) {
+	switch format {
+	case FormatMbox:
+		return openMboxReader(path, position)
+	case FormatMaildir:
+		return openMaildirReader(path, position)
+	case FormatEML:
+		return openEMLReader(path, position)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// MessageID returns the Message-ID header of a raw message, or "" if it has
+// none or its header cannot be parsed.
+func MessageID(body []byte) string {
+	header, err := readHeader(body)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(header.Get("Message-Id"))
+}
+
+// headerDate returns the date in the Date header of a raw message, or the
+// zero time.
+func headerDate(body []byte) time.Time {
+	header, err := readHeader(body)
+	if err != nil {
+		return time.Time{}
+	}
+	date, err := header.Date()
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
+
+func readHeader(body []byte) (mail.Header, error) {
+	message, err := mail.ReadMessage(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return message.Header, nil
+}
+
+// CRLF converts a message to the CRLF line endings IMAP requires, whatever
+// endings it was stored with.
+func CRLF(body []byte) []byte {
+	return bytes.ReplaceAll(normalizeNewlines(body), []byte("\n"), []byte("\r\n"))
+}
+
+// listFiles returns the names of the regular files in directory that keep
+// returns true for.
+func listFiles(directory string, keep func(name string) bool) ([]string, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", directory, err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && keep(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// storedFile is a file holding one message, and the key it is ordered and
+// resumed by.
+type storedFile struct {
+	path string
+	key  string
+}
+
+// fileReader reads formats that keep one message per file, in key order. Its
+// position is the key of the last file read.
+type fileReader struct {
+	files    []storedFile
+	next     int
+	position string
+	parse    func(file storedFile, body []byte, info os.FileInfo) Message
+}
+
+// newFileReader reads files, sorted by key, skipping those up to and
+// including position.
+func newFileReader(files []storedFile, position string, parse func(storedFile, []byte, os.FileInfo) Message) *fileReader {
+	slices.SortFunc(files, func(a, b storedFile) int { return strings.Compare(a.key, b.key) })
+	if position != "" {
+		files = slices.DeleteFunc(files, func(file storedFile) bool { return file.key <= position })
+	}
+	return &fileReader{files: files, position: position, parse: parse}
+}
+
+func (reader *fileReader) Next() (Message, error) {
+	if reader.next >= len(reader.files) {
+		return Message{}, io.EOF
+	}
+	file := reader.files[reader.next]
+	body, err := os.ReadFile(file.path)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to read %s: %w", file.path, err)
+	}
+	info, err := os.Stat(file.path)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to read %s: %w", file.path, err)
+	}
+	reader.next++
+	reader.position = file.key
+	return reader.parse(file, body, info), nil
+}
+
+func (reader *fileReader) Position() string {
+	return reader.position
+}
+
+func (reader *fileReader) Close() error {
+	return nil
+}
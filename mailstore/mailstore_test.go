@@ -0,0 +1,232 @@
+package mailstore
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/stretchr/testify/assert"
+)
+
+var testDate = time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC)
+
+func TestParseFormat(t *testing.T) {
+	format, err := ParseFormat("Maildir")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatMaildir, format)
+
+	_, err = ParseFormat("pst")
+	assert.ErrorContains(t, err, `unknown format "pst"`)
+}
+
+func TestMboxWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.mbox")
+	writer, err := NewWriter(FormatMbox, path)
+	assert.NoError(t, err)
+
+	_, err = writer.Write(Message{
+		Body:   []byte("Subject: hi\r\nStatus: O\r\n\r\nFrom here on\r\n>From there\r\nbye\r\n"),
+		Date:   testDate,
+		Flags:  []string{imap.SeenFlag, imap.FlaggedFlag},
+		Sender: "alice@example.com",
+	})
+	assert.NoError(t, err)
+	_, err = writer.Write(Message{Body: []byte("Subject: two\r\n\r\nbody\r\n"), Date: testDate})
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	contents, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "From alice@example.com Tue Mar  5 14:30:00 2024\n"+
+		"Subject: hi\n"+
+		"Status: RO\n"+
+		"X-Status: F\n"+
+		"\n"+
+		">From here on\n"+
+		">>From there\n"+
+		"bye\n"+
+		"\n"+
+		"From MAILER-DAEMON Tue Mar  5 14:30:00 2024\n"+
+		"Subject: two\n"+
+		"Status: O\n"+
+		"\n"+
+		"body\n"+
+		"\n", string(contents))
+}
+
+func TestMaildirWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup")
+	writer, err := NewWriter(FormatMaildir, path)
+	assert.NoError(t, err)
+
+	name, err := writer.Write(Message{
+		Body:  []byte("Subject: hi\r\n\r\nbody\r\n"),
+		Date:  testDate,
+		Flags: []string{imap.SeenFlag, imap.AnsweredFlag, "review"},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	assert.Regexp(t, `^\d+\.P\d+Q\d+\..+:2,RS$`, name)
+	info, err := os.Stat(filepath.Join(path, "cur", name))
+	assert.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(testDate), "the modification time is the message date")
+	contents, err := os.ReadFile(filepath.Join(path, "cur", name))
+	assert.NoError(t, err)
+	assert.Equal(t, "Subject: hi\n\nbody\n", string(contents))
+
+	for _, sub := range []string{"new", "tmp"} {
+		entries, err := os.ReadDir(filepath.Join(path, sub))
+		assert.NoError(t, err)
+		assert.Empty(t, entries)
+	}
+}
+
+func TestMaildirInfo(t *testing.T) {
+	assert.Equal(t, ":2,", MaildirInfo(nil))
+	assert.Equal(t, ":2,DFST", MaildirInfo([]string{imap.DeletedFlag, imap.SeenFlag, imap.FlaggedFlag, imap.DraftFlag}))
+}
+
+func TestEMLWriter(t *testing.T) {
+	path := t.TempDir()
+	writer, err := NewWriter(FormatEML, path)
+	assert.NoError(t, err)
+
+	first, err := writer.Write(Message{Body: []byte("Subject: one\r\n\r\n"), Date: testDate})
+	assert.NoError(t, err)
+	second, err := writer.Write(Message{Body: []byte("Subject: two\r\n\r\n"), Date: testDate})
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	assert.Equal(t, "20240305-143000.eml", first)
+	assert.Equal(t, "20240305-143000-1.eml", second, "messages from the same second are not overwritten")
+	contents, err := os.ReadFile(filepath.Join(path, second))
+	assert.NoError(t, err)
+	assert.Equal(t, "Subject: two\r\n\r\n", string(contents), ".eml files keep the message as sent")
+}
+
+// readAll returns every message reader yields.
+func readAll(t *testing.T, reader Reader) []Message {
+	var messages []Message
+	for {
+		message, err := reader.Next()
+		if err == io.EOF {
+			return messages
+		}
+		if !assert.NoError(t, err) {
+			return messages
+		}
+		messages = append(messages, message)
+	}
+}
+
+func TestMboxReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.mbox")
+	writer, err := NewWriter(FormatMbox, path)
+	assert.NoError(t, err)
+	for _, message := range []Message{
+		{Body: []byte("Subject: hi\r\n\r\nFrom here on\r\n>From there\r\n"), Date: testDate, Flags: []string{imap.SeenFlag, imap.FlaggedFlag}, Sender: "alice@example.com"},
+		{Body: []byte("Subject: two\r\n\r\nbody\r\n"), Date: testDate.Add(time.Hour)},
+		{Body: []byte("Subject: three\r\n\r\n"), Date: testDate.Add(2 * time.Hour), Flags: []string{imap.AnsweredFlag}},
+	} {
+		_, err := writer.Write(message)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Close())
+
+	reader, err := OpenReader(FormatMbox, path, "")
+	assert.NoError(t, err)
+	first, err := reader.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "Subject: hi\n\nFrom here on\n>From there\n", string(first.Body), "status headers are removed and From lines unescaped")
+	assert.True(t, first.Date.Equal(testDate))
+	assert.ElementsMatch(t, []string{imap.SeenFlag, imap.FlaggedFlag}, first.Flags)
+	assert.Equal(t, "alice@example.com", first.Sender)
+	position := reader.Position()
+	assert.NoError(t, reader.Close())
+
+	resumed, err := OpenReader(FormatMbox, path, position)
+	assert.NoError(t, err)
+	rest := readAll(t, resumed)
+	assert.NoError(t, resumed.Close())
+	if assert.Len(t, rest, 2, "a resumed reader continues after the messages already read") {
+		assert.Equal(t, "Subject: two\n\nbody\n", string(rest[0].Body))
+		assert.Empty(t, rest[0].Flags)
+		assert.Equal(t, []string{imap.AnsweredFlag}, rest[1].Flags)
+	}
+}
+
+func TestMboxReaderMozillaStatus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Inbox")
+	contents := "From - Tue Mar  5 14:30:00 2024\r\nX-Mozilla-Status: 0005\r\nSubject: hi\r\n\r\nbody\r\n"
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+
+	reader, err := OpenReader(FormatMbox, path, "")
+	assert.NoError(t, err)
+	messages := readAll(t, reader)
+	assert.NoError(t, reader.Close())
+	if assert.Len(t, messages, 1) {
+		assert.ElementsMatch(t, []string{imap.SeenFlag, imap.FlaggedFlag}, messages[0].Flags)
+		assert.True(t, messages[0].Date.Equal(testDate))
+	}
+}
+
+func TestMaildirReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup")
+	writer, err := NewWriter(FormatMaildir, path)
+	assert.NoError(t, err)
+	_, err = writer.Write(Message{Body: []byte("Subject: one\r\n\r\n"), Date: testDate, Flags: []string{imap.SeenFlag, imap.DraftFlag}})
+	assert.NoError(t, err)
+	_, err = writer.Write(Message{Body: []byte("Subject: two\r\n\r\n"), Date: testDate})
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(path, "new", "9999999999.new.host"), []byte("Subject: new\n\n"), 0o600))
+
+	format, err := DetectFormat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, FormatMaildir, format)
+
+	reader, err := OpenReader(FormatMaildir, path, "")
+	assert.NoError(t, err)
+	first, err := reader.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "Subject: one\n\n", string(first.Body))
+	assert.ElementsMatch(t, []string{imap.SeenFlag, imap.DraftFlag}, first.Flags)
+	assert.True(t, first.Date.Equal(testDate))
+
+	resumed, err := OpenReader(FormatMaildir, path, reader.Position())
+	assert.NoError(t, err)
+	rest := readAll(t, resumed)
+	if assert.Len(t, rest, 2) {
+		assert.Equal(t, "Subject: two\n\n", string(rest[0].Body))
+		assert.Equal(t, "Subject: new\n\n", string(rest[1].Body), "messages in new are read too")
+	}
+}
+
+func TestEMLReader(t *testing.T) {
+	path := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(path, "b.eml"), []byte("Subject: b\r\nDate: Tue, 05 Mar 2024 14:30:00 +0000\r\n\r\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(path, "a.eml"), []byte("Subject: a\r\n\r\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(path, "notes.txt"), []byte("not a message"), 0o600))
+
+	format, err := DetectFormat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, FormatEML, format)
+
+	reader, err := OpenReader(FormatEML, path, "")
+	assert.NoError(t, err)
+	messages := readAll(t, reader)
+	if assert.Len(t, messages, 2) {
+		assert.Equal(t, "Subject: a\r\n\r\n", string(messages[0].Body))
+		assert.False(t, messages[0].Date.IsZero(), "a message without a Date header gets the file's date")
+		assert.True(t, messages[1].Date.Equal(testDate))
+	}
+	assert.Equal(t, "b.eml", reader.Position())
+}
+
+func TestMessageID(t *testing.T) {
+	assert.Equal(t, "<1@example.com>", MessageID([]byte("Message-ID:  <1@example.com>\r\nSubject: hi\r\n\r\n")))
+	assert.Equal(t, "", MessageID([]byte("Subject: hi\n\n")))
+}
@@ -0,0 +1,348 @@
+package mailstore
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// mboxDateLayout is the asctime(3) layout of the date on an mbox "From " line.
+const mboxDateLayout = "Mon Jan _2 15:04:05 2006"
+
+// mboxWriter appends messages to an mbox file in the mboxrd variant: body
+// lines starting with any number of ">" followed by "From " get one more ">",
+// which readers strip again, so no message can be split by accident.
+type mboxWriter struct {
+	path   string
+	file   *os.File
+	buffer *bufio.Writer
+}
+
+func newMboxWriter(path string) (*mboxWriter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mbox %s: %w", path, err)
+	}
+	return &mboxWriter{path: path, file: file, buffer: bufio.NewWriter(file)}, nil
+}
+
+func (writer *mboxWriter) Write(message Message) (string, error) {
+	sender := message.Sender
+	if sender == "" {
+		sender = "MAILER-DAEMON"
+	}
+	fmt.Fprintf(writer.buffer, "From %s %s\n", sender, message.Date.UTC().Format(mboxDateLayout))
+
+	header, body := splitMessage(normalizeNewlines(message.Body))
+	for _, line := range header {
+		writer.buffer.WriteString(line)
+		writer.buffer.WriteByte('\n')
+	}
+	status, xStatus := mboxStatus(message.Flags)
+	fmt.Fprintf(writer.buffer, "Status: %s\n", status)
+	if xStatus != "" {
+		fmt.Fprintf(writer.buffer, "X-Status: %s\n", xStatus)
+	}
+	writer.buffer.WriteByte('\n')
+
+	for _, line := range body {
+		if isFromLine(strings.TrimLeft(line, ">")) {
+			writer.buffer.WriteByte('>')
+		}
+		writer.buffer.WriteString(line)
+		writer.buffer.WriteByte('\n')
+	}
+	// A blank line separates each message from the next "From " line.
+	if err := writer.buffer.WriteByte('\n'); err != nil {
+		return "", fmt.Errorf("failed to write to %s: %w", writer.path, err)
+	}
+	return writer.path, nil
+}
+
+func (writer *mboxWriter) Close() error {
+	if err := writer.buffer.Flush(); err != nil {
+		writer.file.Close()
+		return fmt.Errorf("failed to write to %s: %w", writer.path, err)
+	}
+	return writer.file.Close()
+}
+
+// normalizeNewlines converts CRLF line endings, as IMAP sends them, to the
+// LF the local formats use.
+func normalizeNewlines(raw []byte) []byte {
+	return bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+}
+
+// splitMessage splits an LF-terminated message into its header lines, without
+// the Status and X-Status headers that mbox keeps flags in, and its body
+// lines. A final newline does not produce an empty last body line.
+func splitMessage(raw []byte) (header, body []string) {
+	head, rest, found := strings.Cut(string(raw), "\n\n")
+	if !found {
+		head = strings.TrimSuffix(head, "\n")
+	}
+	skipping := false
+	for _, line := range strings.Split(head, "\n") {
+		// Folded continuation lines belong to the header before them.
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			if !skipping {
+				header = append(header, line)
+			}
+			continue
+		}
+		name, _, _ := strings.Cut(line, ":")
+		skipping = strings.EqualFold(name, "Status") || strings.EqualFold(name, "X-Status")
+		if !skipping {
+			header = append(header, line)
+		}
+	}
+	if found && rest != "" {
+		body = strings.Split(strings.TrimSuffix(rest, "\n"), "\n")
+	}
+	return header, body
+}
+
+// isFromLine reports whether line would be taken for the start of a new
+// message in an mbox.
+func isFromLine(line string) bool {
+	return strings.HasPrefix(line, "From ")
+}
+
+// mboxStatus renders IMAP flags as the Status and X-Status headers mail
+// clients such as mutt and Thunderbird use: R (read) and O (old, i.e. no
+// longer new) in Status; A (answered), F (flagged), T (draft) and D
+// (deleted) in X-Status.
+func mboxStatus(flags []string) (status, xStatus string) {
+	status = "O"
+	if slices.Contains(flags, imap.SeenFlag) {
+		status = "RO"
+	}
+	for _, mapping := range xStatusFlags {
+		if slices.Contains(flags, mapping.flag) {
+			xStatus += mapping.letter
+		}
+	}
+	return status, xStatus
+}
+
+// mboxReader reads an mbox file one message at a time. A message starts at a
+// "From " line at the beginning of the file or after a blank line, so that
+// unescaped "From " lines inside messages (mboxo) are mostly tolerated, and
+// escaped ">From " lines are unescaped (mboxrd). Its position is the byte
+// offset at which the next message starts, so a resumed read seeks straight
+// there however large the file is.
+type mboxReader struct {
+	path   string
+	file   *os.File
+	reader *bufio.Reader
+	// offset is how many bytes of the file have been read; pending is the
+	// "From " line of the next message, if it has been read already.
+	offset   int64
+	pending  string
+	position int64
+}
+
+func openMboxReader(path, position string) (Reader, error) {
+	start := int64(0)
+	if position != "" {
+		var err error
+		if start, err = strconv.ParseInt(position, 10, 64); err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid mbox position %q", position)
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mbox %s: %w", path, err)
+	}
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to seek in mbox %s: %w", path, err)
+	}
+	return &mboxReader{path: path, file: file, reader: bufio.NewReader(file), offset: start, position: start}, nil
+}
+
+// readLine returns the next line, with its line ending, or io.EOF.
+func (reader *mboxReader) readLine() (string, error) {
+	line, err := reader.reader.ReadString('\n')
+	reader.offset += int64(len(line))
+	if err == io.EOF && line != "" {
+		// The last line has no line ending.
+		return line, nil
+	}
+	return line, err
+}
+
+func (reader *mboxReader) Next() (Message, error) {
+	for reader.pending == "" {
+		// Skip anything before the first "From " line.
+		line, err := reader.readLine()
+		if err != nil {
+			return Message{}, reader.wrap(err)
+		}
+		if isFromLine(line) {
+			reader.pending = line
+		}
+	}
+	fromLine := reader.pending
+	reader.pending = ""
+
+	var lines []string
+	for {
+		start := reader.offset
+		line, err := reader.readLine()
+		if err == io.EOF {
+			reader.position = reader.offset
+			break
+		}
+		if err != nil {
+			return Message{}, reader.wrap(err)
+		}
+		if isFromLine(line) && (len(lines) == 0 || isBlank(lines[len(lines)-1])) {
+			reader.pending = line
+			reader.position = start
+			break
+		}
+		lines = append(lines, line)
+	}
+	// Drop the blank line that separates the message from the next.
+	if count := len(lines); count > 0 && isBlank(lines[count-1]) {
+		lines = lines[:count-1]
+	}
+	return parseMboxMessage(fromLine, lines), nil
+}
+
+func (reader *mboxReader) wrap(err error) error {
+	if err == io.EOF {
+		return err
+	}
+	return fmt.Errorf("failed to read mbox %s: %w", reader.path, err)
+}
+
+func (reader *mboxReader) Position() string {
+	return strconv.FormatInt(reader.position, 10)
+}
+
+func (reader *mboxReader) Close() error {
+	return reader.file.Close()
+}
+
+func isBlank(line string) bool {
+	return strings.TrimRight(line, "\r\n") == ""
+}
+
+// parseMboxMessage builds a message from its "From " line and the lines that
+// follow it. Flags are read from the Status, X-Status and (Thunderbird's)
+// X-Mozilla-Status headers, and the first two are removed, as they are not
+// part of the message; the date comes from the "From " line, or failing that
+// from the Date header.
+func parseMboxMessage(fromLine string, lines []string) Message {
+	var (
+		body   strings.Builder
+		flags  []string
+		header = true
+	)
+	addFlag := func(flag string) {
+		if !slices.Contains(flags, flag) {
+			flags = append(flags, flag)
+		}
+	}
+	for _, line := range lines {
+		if header {
+			if isBlank(line) {
+				header = false
+				body.WriteString(line)
+				continue
+			}
+			name, value, _ := strings.Cut(line, ":")
+			value = strings.TrimSpace(value)
+			switch strings.ToLower(name) {
+			case "status":
+				if strings.Contains(value, "R") {
+					addFlag(imap.SeenFlag)
+				}
+				continue
+			case "x-status":
+				for _, mapping := range xStatusFlags {
+					if strings.Contains(value, mapping.letter) {
+						addFlag(mapping.flag)
+					}
+				}
+				continue
+			case "x-mozilla-status":
+				if bits, err := strconv.ParseUint(value, 16, 16); err == nil {
+					for _, mapping := range mozillaStatusFlags {
+						if bits&mapping.bit != 0 {
+							addFlag(mapping.flag)
+						}
+					}
+				}
+			}
+		} else if unescaped := strings.TrimPrefix(line, ">"); unescaped != line && isFromLine(strings.TrimLeft(unescaped, ">")) {
+			line = unescaped
+		}
+		body.WriteString(line)
+	}
+
+	raw := []byte(body.String())
+	date := parseMboxDate(fromLine)
+	if date.IsZero() {
+		date = headerDate(raw)
+	}
+	return Message{Body: raw, Date: date, Flags: flags, Sender: mboxSender(fromLine)}
+}
+
+// xStatusFlags maps the letters of an X-Status header to IMAP flags.
+var xStatusFlags = []struct {
+	letter string
+	flag   string
+}{
+	{"A", imap.AnsweredFlag},
+	{"F", imap.FlaggedFlag},
+	{"T", imap.DraftFlag},
+	{"D", imap.DeletedFlag},
+}
+
+// mozillaStatusFlags maps the bits of an X-Mozilla-Status header to IMAP
+// flags.
+var mozillaStatusFlags = []struct {
+	bit  uint64
+	flag string
+}{
+	{0x0001, imap.SeenFlag},
+	{0x0002, imap.AnsweredFlag},
+	{0x0004, imap.FlaggedFlag},
+	{0x0008, imap.DeletedFlag},
+}
+
+// mboxSender returns the sender on an mbox "From " line.
+func mboxSender(line string) string {
+	fields := strings.Fields(strings.TrimPrefix(line, "From "))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// parseMboxDate parses the date on an mbox "From " line, returning the zero
+// time if there is none or it cannot be parsed.
+func parseMboxDate(line string) time.Time {
+	fields := strings.Fields(strings.TrimPrefix(line, "From "))
+	// The sender comes first; the asctime date is the next five fields,
+	// possibly followed by a time zone.
+	if len(fields) < 6 {
+		return time.Time{}
+	}
+	date, err := time.Parse(mboxDateLayout, strings.Join(fields[1:6], " "))
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
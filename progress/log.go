@@ -0,0 +1,81 @@
+package progress
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// LogReporter reports progress as structured log lines, for non-interactive
+// runs (cron, pipes) where a redrawn progress bar would be garbage. Each task
+// logs at most once per interval while running, and once when it finishes if
+// it logged progress or took longer than the interval; tasks that finish
+// sooner say nothing, so that short unattended runs stay quiet.
+// Lines are logged without a level, like watch's decisions, so that they
+// appear at the default log.level of warn.
+type LogReporter struct {
+	logger   *zerolog.Logger
+	interval time.Duration
+}
+
+// NewLogReporter returns a LogReporter writing to logger every interval.
+func NewLogReporter(logger *zerolog.Logger, interval time.Duration) *LogReporter {
+	return &LogReporter{logger: logger, interval: interval}
+}
+
+func (reporter *LogReporter) Start(label, unit string, total int) Task {
+	return &logTask{counter: newCounter(label, unit, total), reporter: reporter, logged: time.Now()}
+}
+
+type logTask struct {
+	*counter
+	reporter *LogReporter
+	lock     sync.Mutex
+	logged   time.Time
+	// reported is set once progress has been logged.
+	reported bool
+}
+
+func (task *logTask) Add(count int) {
+	task.done.Add(int64(count))
+
+	now := time.Now()
+	task.lock.Lock()
+	due := now.Sub(task.logged) >= task.reporter.interval
+	if due {
+		task.logged = now
+		task.reported = true
+	}
+	task.lock.Unlock()
+
+	if due {
+		task.log(task.snapshot(now), "progress")
+	}
+}
+
+func (task *logTask) Done() {
+	snapshot := task.snapshot(time.Now())
+	task.lock.Lock()
+	reported := task.reported
+	task.lock.Unlock()
+	if reported || snapshot.Elapsed >= task.reporter.interval {
+		task.log(snapshot, "finished")
+	}
+}
+
+func (task *logTask) log(snapshot Snapshot, message string) {
+	event := task.reporter.logger.Log().
+		Str("task", snapshot.Label).
+		Str("unit", snapshot.Unit).
+		Int("done", snapshot.Done).
+		Dur("elapsed", snapshot.Elapsed).
+		Float64("rate", snapshot.Rate)
+	if snapshot.Total > 0 {
+		event = event.Int("total", snapshot.Total)
+	}
+	if snapshot.ETA > 0 {
+		event = event.Dur("eta", snapshot.ETA)
+	}
+	event.Msg(message)
+}
@@ -0,0 +1,126 @@
+package progress
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reporter displays the progress of long-running operations. Start is called
+// once per operation and may be called concurrently from several goroutines.
+type Reporter interface {
+	Start(label, unit string, total int) Task
+}
+
+// Task is one operation being reported on. Add may be called concurrently;
+// Done must be called exactly once when the operation ends, successfully or
+// not.
+type Task interface {
+	Add(count int)
+	Done()
+}
+
+var (
+	reporterLock sync.RWMutex
+	reporter     Reporter = discard{}
+)
+
+// SetReporter installs the reporter used by Start. Until it is called,
+// progress is silently discarded, which keeps library code and tests quiet.
+func SetReporter(next Reporter) {
+	reporterLock.Lock()
+	defer reporterLock.Unlock()
+	reporter = next
+}
+
+// Start begins reporting an operation of total units (e.g. "messages") on the
+// installed reporter. A total of zero or less means the size is unknown.
+func Start(label, unit string, total int) Task {
+	reporterLock.RLock()
+	defer reporterLock.RUnlock()
+	return reporter.Start(label, unit, total)
+}
+
+// discard is the default reporter, which ignores everything.
+type discard struct{}
+
+func (discard) Start(string, string, int) Task { return discardTask{} }
+
+type discardTask struct{}
+
+func (discardTask) Add(int) {}
+func (discardTask) Done()   {}
+
+// counter is the state shared by the concrete task implementations: how much
+// of how much has been done, and since when.
+type counter struct {
+	label   string
+	unit    string
+	total   int
+	done    atomic.Int64
+	started time.Time
+}
+
+func newCounter(label, unit string, total int) *counter {
+	return &counter{label: label, unit: unit, total: total, started: time.Now()}
+}
+
+// Snapshot is a point-in-time view of a task's progress.
+type Snapshot struct {
+	Label   string
+	Unit    string
+	Done    int
+	Total   int
+	Elapsed time.Duration
+	// Rate is units per second so far; zero until something has been done.
+	Rate float64
+	// ETA is the estimated time remaining; zero when it cannot be estimated
+	// (unknown total, or nothing done yet).
+	ETA time.Duration
+}
+
+func (counter *counter) snapshot(now time.Time) Snapshot {
+	snapshot := Snapshot{
+		Label:   counter.label,
+		Unit:    counter.unit,
+		Done:    int(counter.done.Load()),
+		Total:   counter.total,
+		Elapsed: now.Sub(counter.started),
+	}
+	if snapshot.Elapsed > 0 && snapshot.Done > 0 {
+		snapshot.Rate = float64(snapshot.Done) / snapshot.Elapsed.Seconds()
+	}
+	if snapshot.Rate > 0 && snapshot.Total > snapshot.Done {
+		remaining := float64(snapshot.Total-snapshot.Done) / snapshot.Rate
+		snapshot.ETA = time.Duration(remaining * float64(time.Second))
+	}
+	return snapshot
+}
+
+// Fraction returns how much of the task is complete, in [0, 1]; zero when the
+// total is unknown.
+func (snapshot Snapshot) Fraction() float64 {
+	if snapshot.Total <= 0 {
+		return 0
+	}
+	fraction := float64(snapshot.Done) / float64(snapshot.Total)
+	return min(fraction, 1)
+}
+
+// String renders the snapshot as e.g. "fetching INBOX: 1200/5000 messages,
+// 410.2/s, ETA 9s".
+func (snapshot Snapshot) String() string {
+	text := fmt.Sprintf("%s: %d", snapshot.Label, snapshot.Done)
+	if snapshot.Total > 0 {
+		text += fmt.Sprintf("/%d", snapshot.Total)
+	}
+	text += " " + snapshot.Unit
+	if snapshot.Rate > 0 {
+		text += fmt.Sprintf(", %.1f/s", snapshot.Rate)
+	}
+	if snapshot.ETA > 0 {
+		text += fmt.Sprintf(", ETA %s", snapshot.ETA.Round(time.Second))
+	}
+	return text
+}
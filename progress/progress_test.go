@@ -0,0 +1,133 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	started := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	counter := &counter{label: "fetching INBOX", unit: "messages", total: 1000, started: started}
+	counter.done.Store(250)
+
+	snapshot := counter.snapshot(started.Add(10 * time.Second))
+	assert.Equal(t, 250, snapshot.Done)
+	assert.InDelta(t, 25.0, snapshot.Rate, 0.001)
+	assert.Equal(t, 30*time.Second, snapshot.ETA)
+	assert.InDelta(t, 0.25, snapshot.Fraction(), 0.001)
+	assert.Equal(t, "fetching INBOX: 250/1000 messages, 25.0/s, ETA 30s", snapshot.String())
+}
+
+func TestSnapshotUnknownTotal(t *testing.T) {
+	started := time.Now()
+	counter := &counter{label: "scanning", unit: "messages", started: started}
+
+	snapshot := counter.snapshot(started.Add(time.Second))
+	assert.Zero(t, snapshot.Rate, "no rate before anything is done")
+	assert.Zero(t, snapshot.ETA)
+	assert.Zero(t, snapshot.Fraction())
+	assert.Equal(t, "scanning: 0 messages", snapshot.String())
+}
+
+func TestDefaultReporterDiscards(t *testing.T) {
+	task := Start("anything", "messages", 10)
+	task.Add(5)
+	task.Done()
+	assert.IsType(t, discardTask{}, task)
+}
+
+func TestLogReporter(t *testing.T) {
+	var output bytes.Buffer
+	logger := zerolog.New(&output)
+	reporter := NewLogReporter(&logger, 0)
+
+	task := reporter.Start("moving to Archive", "batches", 4)
+	task.Add(1)
+	task.Done()
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.Len(t, lines, 2)
+
+	var finished map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &finished))
+	assert.Equal(t, "finished", finished["message"])
+	assert.Equal(t, "moving to Archive", finished["task"])
+	assert.Equal(t, float64(1), finished["done"])
+	assert.Equal(t, float64(4), finished["total"])
+}
+
+func TestLogReporterIgnoresLevel(t *testing.T) {
+	var output bytes.Buffer
+	logger := zerolog.New(&output).Level(zerolog.WarnLevel)
+	reporter := NewLogReporter(&logger, 0)
+
+	reporter.Start("fetching INBOX", "messages", 10).Done()
+	assert.Contains(t, output.String(), `"message":"finished"`, "progress shows at the default warn level")
+}
+
+func TestLogReporterThrottles(t *testing.T) {
+	var output bytes.Buffer
+	logger := zerolog.New(&output)
+	reporter := NewLogReporter(&logger, time.Hour)
+
+	task := reporter.Start("fetching INBOX", "messages", 100)
+	for range 100 {
+		task.Add(1)
+	}
+	assert.Empty(t, output.String(), "nothing is logged before the interval elapses")
+	task.Done()
+	assert.Empty(t, output.String(), "a task shorter than the interval does not log that it finished")
+}
+
+// syncBuffer is a bytes.Buffer safe for the program goroutine to write while
+// the test reads it.
+type syncBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (buffer *syncBuffer) Write(data []byte) (int, error) {
+	buffer.lock.Lock()
+	defer buffer.lock.Unlock()
+	return buffer.buffer.Write(data)
+}
+
+func TestTerminalReporterStopsWithLastTask(t *testing.T) {
+	reporter := NewTerminalReporter(&syncBuffer{})
+
+	first := reporter.Start("fetching INBOX", "messages", 10)
+	second := reporter.Start("dating INBOX", "messages", 10)
+	first.Add(3)
+
+	first.Done()
+	assert.NotNil(t, reporter.program, "display stays up while a task is running")
+
+	finished := make(chan struct{})
+	go func() {
+		second.Done()
+		second.Done() // idempotent
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done did not return after the last task finished")
+	}
+	assert.Nil(t, reporter.program)
+}
+
+func TestRenderSnapshot(t *testing.T) {
+	rendered := renderSnapshot(Snapshot{Label: "fetching INBOX", Unit: "messages", Done: 5, Total: 10})
+	assert.Contains(t, rendered, "fetching INBOX: 5/10 messages")
+	assert.Contains(t, rendered, "█")
+
+	unknown := renderSnapshot(Snapshot{Label: "scanning", Unit: "messages", Done: 5})
+	assert.NotContains(t, unknown, "█")
+}
@@ -0,0 +1,176 @@
+package progress
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// barWidth is the width, in cells, of each task's progress bar.
+const barWidth = 30
+
+// refreshInterval is how often the terminal display is redrawn.
+const refreshInterval = 100 * time.Millisecond
+
+var (
+	barFilled = lipgloss.NewStyle().Foreground(lipgloss.AdaptiveColor{Light: "63", Dark: "111"})
+	barEmpty  = lipgloss.NewStyle().Faint(true)
+	taskText  = lipgloss.NewStyle().Faint(true)
+)
+
+// TerminalReporter draws a live progress bar per running task on a terminal
+// (normally stderr, so stdout stays clean for output). A Bubble Tea program is
+// started when the first task begins and stopped, with its lines erased, as
+// soon as the last one finishes, so the display never competes with the
+// command's own output or with the interactive picker.
+type TerminalReporter struct {
+	output io.Writer
+
+	// lifecycle serializes starting and stopping the program; lock guards the
+	// task list, which the program reads on every frame.
+	lifecycle sync.Mutex
+	program   *tea.Program
+	stopped   chan struct{}
+
+	lock  sync.Mutex
+	tasks []*terminalTask
+}
+
+// NewTerminalReporter returns a TerminalReporter drawing to output.
+func NewTerminalReporter(output io.Writer) *TerminalReporter {
+	return &TerminalReporter{output: output}
+}
+
+func (reporter *TerminalReporter) Start(label, unit string, total int) Task {
+	task := &terminalTask{counter: newCounter(label, unit, total), reporter: reporter}
+
+	reporter.lifecycle.Lock()
+	defer reporter.lifecycle.Unlock()
+
+	reporter.lock.Lock()
+	reporter.tasks = append(reporter.tasks, task)
+	reporter.lock.Unlock()
+
+	if reporter.program == nil {
+		reporter.startProgram()
+	}
+	return task
+}
+
+// startProgram launches the display. The caller must hold lifecycle.
+func (reporter *TerminalReporter) startProgram() {
+	// No input (the terminal stays in cooked mode, so Ctrl+C still interrupts
+	// the command as usual) and no signal handler of our own.
+	program := tea.NewProgram(terminalModel{reporter: reporter},
+		tea.WithOutput(reporter.output),
+		tea.WithInput(nil),
+		tea.WithoutSignalHandler(),
+	)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		_, _ = program.Run()
+	}()
+	reporter.program = program
+	reporter.stopped = stopped
+}
+
+// finish removes task from the display, stopping the program (and waiting for
+// it to erase its lines) if it was the last one, so that whatever the command
+// prints next starts on a clean line.
+func (reporter *TerminalReporter) finish(task *terminalTask) {
+	reporter.lifecycle.Lock()
+	defer reporter.lifecycle.Unlock()
+
+	reporter.lock.Lock()
+	for index, candidate := range reporter.tasks {
+		if candidate == task {
+			reporter.tasks = append(reporter.tasks[:index], reporter.tasks[index+1:]...)
+			break
+		}
+	}
+	remaining := len(reporter.tasks)
+	reporter.lock.Unlock()
+
+	if remaining > 0 || reporter.program == nil {
+		return
+	}
+
+	// The program renders one last frame while quitting, which needs lock, so
+	// it must not be held here.
+	reporter.program.Quit()
+	<-reporter.stopped
+	reporter.program = nil
+}
+
+// render draws every running task, one per line.
+func (reporter *TerminalReporter) render() string {
+	reporter.lock.Lock()
+	defer reporter.lock.Unlock()
+
+	if len(reporter.tasks) == 0 {
+		// Bubble Tea leaves the previous frame on screen if the final one is
+		// empty; a blank line makes it erase the bars on exit.
+		return " "
+	}
+
+	now := time.Now()
+	lines := make([]string, 0, len(reporter.tasks))
+	for _, task := range reporter.tasks {
+		lines = append(lines, renderSnapshot(task.snapshot(now)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderSnapshot draws one task as "[bar] label: n/total unit, rate, ETA".
+// Tasks of unknown size get no bar.
+func renderSnapshot(snapshot Snapshot) string {
+	if snapshot.Total <= 0 {
+		return taskText.Render(snapshot.String())
+	}
+	filled := int(snapshot.Fraction() * barWidth)
+	bar := barFilled.Render(strings.Repeat("█", filled)) + barEmpty.Render(strings.Repeat("░", barWidth-filled))
+	return bar + " " + taskText.Render(snapshot.String())
+}
+
+type terminalTask struct {
+	*counter
+	reporter *TerminalReporter
+	once     sync.Once
+}
+
+func (task *terminalTask) Add(count int) {
+	task.done.Add(int64(count))
+}
+
+func (task *terminalTask) Done() {
+	task.once.Do(func() { task.reporter.finish(task) })
+}
+
+// terminalModel is the Bubble Tea model behind TerminalReporter. It holds no
+// state of its own: every frame is drawn from the reporter's running tasks,
+// and a tick redraws them at refreshInterval.
+type terminalModel struct {
+	reporter *TerminalReporter
+}
+
+type tickMsg time.Time
+
+func tick() tea.Cmd {
+	return tea.Tick(refreshInterval, func(now time.Time) tea.Msg { return tickMsg(now) })
+}
+
+func (model terminalModel) Init() tea.Cmd { return tick() }
+
+func (model terminalModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
+	if _, ok := message.(tickMsg); ok {
+		return model, tick()
+	}
+	return model, nil
+}
+
+func (model terminalModel) View() string { return model.reporter.render() }
@@ -39,6 +39,7 @@ type messagePicker struct {
 	selected        []bool
 	action          string
 	confirmRequired bool // state-changing actions get a final confirm screen
+	alsoExpunged    int  // other messages already flagged \Deleted that the action expunges too
 	mode            int
 	cursor          int
 	top             int // index of the first visible row
@@ -223,8 +224,8 @@ func (picker messagePicker) View() string {
 
 	if picker.mode == modeConfirming {
 		title := fmt.Sprintf("Confirm — %s", picker.action)
-		prompt := pickerConfirm.Render(fmt.Sprintf("really %s %d messages?  enter: yes · esc: back",
-			picker.action, picker.selectedCount()))
+		prompt := pickerConfirm.Render(fmt.Sprintf("really %s %s?  enter: yes · esc: back",
+			picker.action, picker.countLabel()))
 		return strings.Join([]string{title, table.String(), prompt}, "\n")
 	}
 
@@ -234,6 +235,17 @@ func (picker messagePicker) View() string {
 	return strings.Join([]string{title, table.String(), help}, "\n")
 }
 
+// countLabel counts the messages the action will affect for the confirm
+// prompt: the selection, plus any others it expunges along with it.
+func (picker messagePicker) countLabel() string {
+	selected := picker.selectedCount()
+	if picker.alsoExpunged == 0 {
+		return fmt.Sprintf("%d messages", selected)
+	}
+	return fmt.Sprintf("%d messages and %d other messages already flagged \\Deleted (%d in all)",
+		selected, picker.alsoExpunged, selected+picker.alsoExpunged)
+}
+
 // padCell truncates (defensively) and right-pads a cell to an exact display
 // width so every cell in a column is the same width.
 func padCell(value string, width int) string {
@@ -259,7 +271,10 @@ func padCells(cells []string) []string {
 // operation in the picker header (e.g. "delete", "move to Archive").
 // confirmRequired adds a final confirmation screen for state-changing actions
 // (copy/move/delete); pass false for trivially reversible ones (mark read).
-func SelectMessages(messages []*imap.Message, action string, confirmRequired bool) (kept []*imap.Message, committed bool, err error) {
+// alsoExpunged is the number of other messages, already flagged \Deleted, that
+// a purge on a server without UIDPLUS removes as well, so that the confirm
+// screen can report the true number.
+func SelectMessages(messages []*imap.Message, action string, confirmRequired bool, alsoExpunged int) (kept []*imap.Message, committed bool, err error) {
 	if len(messages) == 0 {
 		return nil, false, nil
 	}
@@ -268,12 +283,14 @@ func SelectMessages(messages []*imap.Message, action string, confirmRequired boo
 		return nil, false, err
 	}
 
-	final, err := tea.NewProgram(newMessagePicker(messages, rows, action, confirmRequired), tea.WithAltScreen()).Run()
+	picker := newMessagePicker(messages, rows, action, confirmRequired)
+	picker.alsoExpunged = alsoExpunged
+	final, err := tea.NewProgram(picker, tea.WithAltScreen()).Run()
 	if err != nil {
 		return nil, false, fmt.Errorf("interactive selection failed: %w", err)
 	}
 
-	picker := final.(messagePicker)
+	picker = final.(messagePicker)
 	if !picker.committed {
 		return nil, false, nil
 	}
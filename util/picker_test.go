@@ -106,6 +106,16 @@ func TestMessagePicker(t *testing.T) {
 		}
 	})
 
+	t.Run("confirm prompt counts other messages an expunge removes", func(t *testing.T) {
+		picker := newMessagePicker(messages, rows, "permanently delete", true)
+		assert.Equal(t, "3 messages", picker.countLabel())
+
+		picker.alsoExpunged = 2
+		assert.Equal(t, "3 messages and 2 other messages already flagged \\Deleted (5 in all)", picker.countLabel())
+		next, _ := picker.Update(tea.KeyMsg{Type: tea.KeyEnter})
+		assert.Contains(t, next.View(), "5 in all")
+	})
+
 	t.Run("confirm-required action with empty selection skips the confirm", func(t *testing.T) {
 		var model tea.Model = newMessagePicker(messages, rows, "delete", true)
 		model = send(model, runes("a")) // deselect everything
@@ -9,6 +9,7 @@ import (
 	"github.com/spf13/viper"
 	"github.com/wryfi/shemail/imaputils"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -88,9 +89,34 @@ func ParseSize(text string) (uint32, error) {
 	return uint32(bytes), nil
 }
 
+// AgeCutoff parses an age such as "180d" and returns the date that long
+// before now. The unit is d (days), w (weeks), m (months) or y (years);
+// months and years are calendar months and years.
+func AgeCutoff(text string, now time.Time) (time.Time, error) {
+	trimmed := strings.TrimSpace(strings.ToLower(text))
+	if len(trimmed) < 2 {
+		return time.Time{}, fmt.Errorf("invalid age %q (expected e.g. 180d, 26w, 6m or 2y)", text)
+	}
+	count, err := strconv.Atoi(trimmed[:len(trimmed)-1])
+	if err != nil || count < 0 {
+		return time.Time{}, fmt.Errorf("invalid age %q (expected e.g. 180d, 26w, 6m or 2y)", text)
+	}
+	switch trimmed[len(trimmed)-1] {
+	case 'd':
+		return now.AddDate(0, 0, -count), nil
+	case 'w':
+		return now.AddDate(0, 0, -7*count), nil
+	case 'm':
+		return now.AddDate(0, -count, 0), nil
+	case 'y':
+		return now.AddDate(-count, 0, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid age %q (expected e.g. 180d, 26w, 6m or 2y)", text)
+}
+
 // FormatSize renders a byte count as a short human-readable string using binary
 // units, e.g. 1572864 -> "1.5M".
-func FormatSize(size uint32) string {
+func FormatSize[T ~int | ~int64 | ~uint32 | ~uint64](size T) string {
 	const unit = 1024
 	if size < unit {
 		return fmt.Sprintf("%dB", size)
@@ -287,17 +313,22 @@ func RenderMessages(messages []*imap.Message) (string, error) {
 
 // RenderFolders renders a folder listing with message and unread counts as a
 // table string in the shared style. When withDates is true it also includes the
-// date range of each folder's messages. Non-selectable container folders (and
-// empty folders, for dates) show "-". The numeric count columns are
-// right-aligned.
-func RenderFolders(folders []imaputils.FolderStatus, withDates bool) string {
+// date range of each folder's messages, and when withSizes is true their total
+// size. Non-selectable container folders (and empty folders, for dates) show
+// "-", unless the listing was rolled up and they have subfolders. The numeric
+// columns are right-aligned.
+func RenderFolders(folders []imaputils.FolderStatus, withDates, withSizes bool) string {
 	headers := []string{"Folder", "Messages", "Unread"}
+	if withSizes {
+		headers = append(headers, "Size")
+	}
 	if withDates {
 		headers = append(headers, "Oldest", "Newest")
 	}
 
-	// Messages (col 1) and Unread (col 2) are numeric; right-align them.
-	numeric := func(col int) bool { return col == 1 || col == 2 }
+	// Messages (col 1), Unread (col 2) and Size (col 3) are numeric;
+	// right-align them.
+	numeric := func(col int) bool { return col == 1 || col == 2 || (withSizes && col == 3) }
 	table := styledTable(headers, func(row, col int) lipgloss.Style {
 		style := tableBaseStyle
 		if row == ltable.HeaderRow {
@@ -310,12 +341,16 @@ func RenderFolders(folders []imaputils.FolderStatus, withDates bool) string {
 	})
 
 	for _, folder := range folders {
-		messages, unread := "-", "-"
-		if folder.Selectable {
+		messages, unread, size := "-", "-", "-"
+		if folder.Selectable || folder.Subfolders > 0 {
 			messages = strconv.Itoa(int(folder.Messages))
 			unread = strconv.Itoa(int(folder.Unseen))
+			size = FormatSize(folder.Size)
+		}
+		row := []string{FolderLabel(folder.Name, folder.SpecialUse), messages, unread}
+		if withSizes {
+			row = append(row, size)
 		}
-		row := []string{folder.Name, messages, unread}
 		if withDates {
 			row = append(row, formatDate(folder.Oldest), formatDate(folder.Newest))
 		}
@@ -325,6 +360,80 @@ func RenderFolders(folders []imaputils.FolderStatus, withDates bool) string {
 	return table.String()
 }
 
+// FolderLabel marks a folder name with its special use, if any, e.g.
+// "Papierkorb (trash)".
+func FolderLabel(name string, use imaputils.SpecialUse) string {
+	if use == "" {
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", name, use)
+}
+
+// RenderCacheStats renders the envelope cache's per-folder statistics as a
+// table string in the shared style, with the numeric columns right-aligned.
+func RenderCacheStats(stats []imaputils.CacheStats) string {
+	headers := []string{"Account", "Folder", "Messages", "Size", "Updated"}
+	numeric := func(col int) bool { return col == 2 || col == 3 }
+	table := styledTable(headers, func(row, col int) lipgloss.Style {
+		style := tableBaseStyle
+		if row == ltable.HeaderRow {
+			style = tableBoldStyle
+		}
+		if numeric(col) {
+			style = style.Align(lipgloss.Right)
+		}
+		return style
+	})
+
+	for _, stat := range stats {
+		size := uint32(min(stat.Bytes, int64(^uint32(0))))
+		updated := "-"
+		if !stat.Updated.IsZero() {
+			updated = stat.Updated.Format("2006-01-02 15:04")
+		}
+		table.Row(stat.Account, stat.Folder, strconv.Itoa(stat.Messages), FormatSize(size), updated)
+	}
+
+	return table.String()
+}
+
+// RenderHistory renders journal entries as a table string in the shared
+// style. The Undo column says whether each entry can still be undone, given
+// the undos in undone (see imaputils.UndoneBy).
+func RenderHistory(entries []imaputils.JournalEntry, undone map[int]int) string {
+	headers := []string{"ID", "Time", "Operation", "Messages", "From", "To", "Undo"}
+	numeric := func(col int) bool { return col == 0 || col == 3 }
+	table := styledTable(headers, func(row, col int) lipgloss.Style {
+		style := tableBaseStyle
+		if row == ltable.HeaderRow {
+			style = tableBoldStyle
+		}
+		if numeric(col) {
+			style = style.Align(lipgloss.Right)
+		}
+		return style
+	})
+
+	for _, entry := range entries {
+		operation := string(entry.Operation)
+		if entry.Operation == imaputils.JournalUndo {
+			operation = fmt.Sprintf("undo %d", entry.Undoes)
+		}
+		dest := entry.Dest
+		if dest == "" {
+			dest = "-"
+		}
+		status := "yes"
+		if ok, reason := entry.Undoable(undone); !ok {
+			status = reason
+		}
+		table.Row(strconv.Itoa(entry.ID), entry.Time.Local().Format("2006-01-02 15:04"), operation,
+			strconv.Itoa(entry.Count), entry.Source, dest, status)
+	}
+
+	return table.String()
+}
+
 // formatDate renders a date as YYYY-MM-DD, or "-" for the zero value.
 func formatDate(date time.Time) string {
 	if date.IsZero() {
@@ -360,6 +469,25 @@ func RenderSenders(data [][]string) string {
 	return table.String()
 }
 
+// RenderTable renders rows under headers as a table string in the shared
+// style, with the rightAligned columns (e.g. counts) aligned right.
+func RenderTable(headers []string, rows [][]string, rightAligned ...int) string {
+	table := styledTable(headers, func(row, col int) lipgloss.Style {
+		style := tableBaseStyle
+		if row == ltable.HeaderRow {
+			style = tableBoldStyle
+		}
+		if slices.Contains(rightAligned, col) {
+			style = style.Align(lipgloss.Right)
+		}
+		return style
+	})
+	for _, row := range rows {
+		table.Row(row...)
+	}
+	return table.String()
+}
+
 // GetConfirmation prompts the user for confirmation before proceeding
 func GetConfirmation(prompt string) bool {
 	reader := bufio.NewReader(os.Stdin)
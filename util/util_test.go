@@ -334,15 +334,83 @@ func TestRenderSenders(t *testing.T) {
 	assert.Empty(t, RenderSenders(nil), "no data renders nothing")
 }
 
+func TestRenderCacheStats(t *testing.T) {
+	stats := []imaputils.CacheStats{
+		{Account: "work", Folder: "INBOX", Messages: 1200, Bytes: 1572864},
+	}
+
+	rendered := RenderCacheStats(stats)
+	assert.Contains(t, rendered, "Account", "includes header")
+	assert.Contains(t, rendered, "INBOX")
+	assert.Contains(t, rendered, "1200")
+	assert.Contains(t, rendered, "1.5M", "file size is human-readable")
+	assert.Contains(t, rendered, "-", "never-updated folders show a dash")
+}
+
+func TestRenderHistory(t *testing.T) {
+	entries := []imaputils.JournalEntry{
+		{ID: 1, Operation: imaputils.JournalMove, Source: "INBOX", Dest: "Archive", Count: 12},
+		{ID: 2, Operation: imaputils.JournalPurge, Source: "Trash", Count: 3},
+		{ID: 3, Operation: imaputils.JournalUndo, Source: "Archive", Dest: "INBOX", Count: 12, Undoes: 1},
+	}
+
+	rendered := RenderHistory(entries, imaputils.UndoneBy(entries))
+	assert.Contains(t, rendered, "Operation", "includes header")
+	assert.Contains(t, rendered, "undone by 3")
+	assert.Contains(t, rendered, "purged")
+	assert.Contains(t, rendered, "undo 1")
+}
+
 func TestRenderFolders(t *testing.T) {
 	folders := []imaputils.FolderStatus{
 		{Name: "INBOX", Selectable: true, Messages: 128, Unseen: 3},
 		{Name: "Archive", Selectable: false}, // container folder shows "-"
+		{Name: "Papierkorb", Selectable: true, SpecialUse: imaputils.UseTrash},
 	}
 
-	rendered := RenderFolders(folders, false)
+	rendered := RenderFolders(folders, false, false)
+	assert.Contains(t, rendered, "Papierkorb (trash)", "marks special-use folders")
 	assert.Contains(t, rendered, "Folder", "includes header")
 	assert.Contains(t, rendered, "INBOX")
 	assert.Contains(t, rendered, "128")
 	assert.Contains(t, rendered, "-", "non-selectable folder shows a dash for counts")
 }
+
+func TestRenderFoldersWithSizes(t *testing.T) {
+	folders := []imaputils.FolderStatus{
+		{Name: "INBOX", Selectable: true, Messages: 128, Unseen: 3, Size: 5 * 1024 * 1024},
+		{Name: "Archive", Messages: 40, Size: 2048, Subfolders: 2}, // rolled-up container
+	}
+
+	rendered := RenderFolders(folders, false, true)
+	assert.Contains(t, rendered, "Size", "includes size header")
+	assert.Contains(t, rendered, "5.0M")
+	assert.Contains(t, rendered, "2.0K", "rolled-up container shows its subtree total")
+	assert.NotContains(t, rendered, "Oldest")
+}
+
+func TestAgeCutoff(t *testing.T) {
+	now := time.Date(2024, 8, 31, 12, 0, 0, 0, time.UTC)
+	valid := map[string]time.Time{
+		"180d": time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC),
+		"2w":   time.Date(2024, 8, 17, 12, 0, 0, 0, time.UTC),
+		"6M":   time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC), // February has no 31st
+		"1y":   time.Date(2023, 8, 31, 12, 0, 0, 0, time.UTC),
+	}
+	for input, want := range valid {
+		got, err := AgeCutoff(input, now)
+		if err != nil {
+			t.Errorf("AgeCutoff(%q) unexpected error: %v", input, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("AgeCutoff(%q) = %v, want %v", input, got, want)
+		}
+	}
+
+	for _, input := range []string{"", "d", "180", "10h", "-5d", "1.5y"} {
+		if _, err := AgeCutoff(input, now); err == nil {
+			t.Errorf("AgeCutoff(%q) expected an error, got none", input)
+		}
+	}
+}